@@ -0,0 +1,148 @@
+package stats
+
+import "time"
+
+func newAliasedCounter(scope Scope, names []string) Counter {
+	if len(names) == 0 {
+		return nullCounter{}
+	}
+	c := make(aliasedCounter, len(names))
+	for i, name := range names {
+		c[i] = scope.NewCounter(name)
+	}
+	return c
+}
+
+func newAliasedGauge(scope Scope, names []string) Gauge {
+	if len(names) == 0 {
+		return nullGauge{}
+	}
+	g := make(aliasedGauge, len(names))
+	for i, name := range names {
+		g[i] = scope.NewGauge(name)
+	}
+	return g
+}
+
+func newAliasedTimer(scope Scope, names []string) Timer {
+	if len(names) == 0 {
+		return nullTimer{}
+	}
+	t := make(aliasedTimer, len(names))
+	for i, name := range names {
+		t[i] = scope.NewTimer(name)
+	}
+	return t
+}
+
+// aliasedCounter updates each of its Counters, its value is the value of
+// the first Counter.
+type aliasedCounter []Counter
+
+func (c aliasedCounter) Add(delta uint64) {
+	for _, v := range c {
+		v.Add(delta)
+	}
+}
+
+func (c aliasedCounter) Inc() {
+	c.Add(1)
+}
+
+func (c aliasedCounter) Set(value uint64) {
+	for _, v := range c {
+		v.Set(value)
+	}
+}
+
+func (c aliasedCounter) String() string {
+	return c[0].String()
+}
+
+func (c aliasedCounter) Value() uint64 {
+	return c[0].Value()
+}
+
+// aliasedGauge updates each of its Gauges, its value is the value of the
+// first Gauge.
+type aliasedGauge []Gauge
+
+func (g aliasedGauge) Add(delta uint64) {
+	for _, v := range g {
+		v.Add(delta)
+	}
+}
+
+func (g aliasedGauge) Sub(delta uint64) {
+	for _, v := range g {
+		v.Sub(delta)
+	}
+}
+
+func (g aliasedGauge) Inc() {
+	g.Add(1)
+}
+
+func (g aliasedGauge) Dec() {
+	g.Sub(1)
+}
+
+func (g aliasedGauge) Set(value uint64) {
+	for _, v := range g {
+		v.Set(value)
+	}
+}
+
+func (g aliasedGauge) String() string {
+	return g[0].String()
+}
+
+func (g aliasedGauge) Value() uint64 {
+	return g[0].Value()
+}
+
+// aliasedTimer records each value with all of its Timers, durations are
+// measured once with the clock of the first Timer.
+type aliasedTimer []Timer
+
+func (t aliasedTimer) AddValue(value float64) {
+	for _, v := range t {
+		v.AddValue(value)
+	}
+}
+
+func (t aliasedTimer) AllocateSpan() Timespan {
+	return &aliasedTimespan{timer: t, ctx: t.Start()}
+}
+
+func (t aliasedTimer) RecordDuration(d time.Duration) {
+	for _, v := range t {
+		v.RecordDuration(d)
+	}
+}
+
+func (t aliasedTimer) Time(f func()) Timer {
+	c := t.Start()
+	f()
+	c.Stop()
+	return t
+}
+
+func (t aliasedTimer) Start() TimerContext {
+	c := t[0].Start()
+	c.timer = t
+	return c
+}
+
+type aliasedTimespan struct {
+	timer aliasedTimer
+	ctx   TimerContext
+}
+
+func (ts *aliasedTimespan) Complete() time.Duration {
+	return ts.ctx.Stop()
+}
+
+func (ts *aliasedTimespan) CompleteWithDuration(d time.Duration) {
+	ts.timer.RecordDuration(d)
+}
@@ -0,0 +1,49 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lyft/gostats/mock"
+)
+
+func TestAliasedStats(t *testing.T) {
+	sink := mock.NewSink()
+	clock := mock.NewFakeClock(time.Unix(1000, 0))
+	store := NewStoreWithOptions(sink, StoreOptions{Clock: clock})
+
+	c := store.NewAliasedCounter("old.c", "new.c")
+	c.Add(2)
+	c.Inc()
+	if v := c.Value(); v != 3 {
+		t.Errorf("Counter Value: got: %d want: %d", v, 3)
+	}
+	g := store.NewAliasedGauge("old.g", "new.g")
+	g.Set(5)
+	g.Dec()
+
+	timer := store.NewAliasedTimer("old.t", "new.t")
+	tc := timer.Start()
+	clock.Advance(time.Millisecond)
+	if d := tc.Stop(); d != time.Millisecond {
+		t.Errorf("Stop: got: %s want: %s", d, time.Millisecond)
+	}
+	span := timer.AllocateSpan()
+	clock.Advance(2 * time.Millisecond)
+	span.Complete()
+	store.Flush()
+
+	for _, prefix := range []string{"old", "new"} {
+		sink.AssertCounterEquals(t, prefix+".c", 3)
+		sink.AssertGaugeEquals(t, prefix+".g", 4)
+		sink.AssertTimerCallCount(t, prefix+".t", 2)
+	}
+
+	// aliases of a child store are prefixed
+	store.NewChildStore("child").NewAliasedCounter("a", "b").Inc()
+	store.Flush()
+	sink.AssertCounterEquals(t, "child.a", 1)
+	sink.AssertCounterEquals(t, "child.b", 1)
+
+	store.NewAliasedCounter().Inc() // no names
+}
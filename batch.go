@@ -0,0 +1,34 @@
+package stats
+
+import "fmt"
+
+// A MetricRecord is a single value of a stat, see Store.RecordBatch.
+type MetricRecord struct {
+	// Type of the stat: MetricTypeCounter adds Value to a Counter,
+	// MetricTypeGauge sets a Gauge to Value, MetricTypeTimer adds Value to
+	// a Timer and MetricTypeDistribution records Value in a Distribution.
+	Type MetricType
+	Name string
+	Tags map[string]string
+	// Value of the stat, rounded down to an integer for Counters and
+	// Gauges.
+	Value float64
+}
+
+func recordBatch(scope Scope, batch []MetricRecord) {
+	for i := range batch {
+		r := &batch[i]
+		switch r.Type {
+		case MetricTypeCounter:
+			scope.NewCounterWithTags(r.Name, r.Tags).Add(uint64(r.Value))
+		case MetricTypeGauge:
+			scope.NewGaugeWithTags(r.Name, r.Tags).Set(uint64(r.Value))
+		case MetricTypeTimer:
+			scope.NewTimerWithTags(r.Name, r.Tags).AddValue(r.Value)
+		case MetricTypeDistribution:
+			scope.NewDistributionWithTags(r.Name, r.Tags).RecordValue(r.Value)
+		default:
+			panic(fmt.Sprintf("gostats: stat %q: invalid MetricType: %d", r.Name, r.Type))
+		}
+	}
+}
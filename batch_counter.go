@@ -0,0 +1,91 @@
+package stats
+
+import (
+	"strconv"
+	"time"
+)
+
+// BatchCounterOptions configures a BatchCounter.
+type BatchCounterOptions struct {
+	// BatchSize is the buffered value at which increments are added to the
+	// underlying Counter. If zero increments are only added when the
+	// BatchCounter is flushed or MaxAge is exceeded.
+	BatchSize uint64
+
+	// MaxAge is the longest increments are buffered before they are added
+	// to the underlying Counter. The age is checked on each increment, so
+	// setting MaxAge adds the cost of reading the clock to each call to Add.
+	// If zero the age of increments is not checked.
+	MaxAge time.Duration
+}
+
+// A BatchCounter buffers increments and adds them to an underlying Counter in
+// batches. This reduces contention on Counters that are incremented at a high
+// rate from many goroutines, each of which should use its own BatchCounter.
+//
+// A BatchCounter is not safe for concurrent use. Increments that are still
+// buffered when the Store is flushed are reported by a later flush, and
+// buffered increments are lost unless Flush is called when the BatchCounter
+// is no longer needed.
+type BatchCounter struct {
+	counter Counter
+	opts    BatchCounterOptions
+	pending uint64
+	first   time.Time // time of the oldest buffered increment
+}
+
+// NewBatchCounter returns a BatchCounter that adds increments to counter.
+func NewBatchCounter(counter Counter, opts BatchCounterOptions) *BatchCounter {
+	return &BatchCounter{counter: counter, opts: opts}
+}
+
+// Add buffers delta and adds the buffered increments to the underlying
+// Counter if the batch is full or has exceeded its max age.
+func (c *BatchCounter) Add(delta uint64) {
+	if c.opts.MaxAge > 0 {
+		now := time.Now()
+		if c.pending == 0 {
+			c.first = now
+		} else if now.Sub(c.first) >= c.opts.MaxAge {
+			c.pending += delta
+			c.Flush()
+			return
+		}
+	}
+	c.pending += delta
+	if c.opts.BatchSize != 0 && c.pending >= c.opts.BatchSize {
+		c.Flush()
+	}
+}
+
+// Inc buffers an increment of 1.
+func (c *BatchCounter) Inc() {
+	c.Add(1)
+}
+
+// Set discards any buffered increments and sets the value of the underlying
+// Counter.
+func (c *BatchCounter) Set(value uint64) {
+	c.pending = 0
+	c.counter.Set(value)
+}
+
+// String returns the value of the underlying Counter plus the buffered
+// increments as a string.
+func (c *BatchCounter) String() string {
+	return strconv.FormatUint(c.Value(), 10)
+}
+
+// Value returns the value of the underlying Counter plus the buffered
+// increments.
+func (c *BatchCounter) Value() uint64 {
+	return c.counter.Value() + c.pending
+}
+
+// Flush adds the buffered increments to the underlying Counter.
+func (c *BatchCounter) Flush() {
+	if c.pending != 0 {
+		c.counter.Add(c.pending)
+		c.pending = 0
+	}
+}
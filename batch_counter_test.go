@@ -0,0 +1,79 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lyft/gostats/mock"
+)
+
+var _ Counter = (*BatchCounter)(nil)
+
+func TestBatchCounter(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStore(sink, false)
+	counter := store.NewCounter("c")
+	c := NewBatchCounter(counter, BatchCounterOptions{BatchSize: 3})
+
+	c.Inc()
+	c.Inc()
+	if v := counter.Value(); v != 0 {
+		t.Errorf("underlying counter: got: %d want: %d", v, 0)
+	}
+	if v := c.Value(); v != 2 {
+		t.Errorf("Value: got: %d want: %d", v, 2)
+	}
+	c.Inc()
+	if v := counter.Value(); v != 3 {
+		t.Errorf("underlying counter after full batch: got: %d want: %d", v, 3)
+	}
+
+	c.Add(1)
+	c.Flush()
+	store.Flush()
+	sink.AssertCounterEquals(t, "c", 4)
+
+	c.Add(2)
+	c.Set(10)
+	if v := c.Value(); v != 10 {
+		t.Errorf("Value after Set: got: %d want: %d", v, 10)
+	}
+	if s := c.String(); s != "10" {
+		t.Errorf("String: got: %q want: %q", s, "10")
+	}
+}
+
+func TestBatchCounterMaxAge(t *testing.T) {
+	counter := NewStore(NewNullSink(), false).NewCounter("c")
+	c := NewBatchCounter(counter, BatchCounterOptions{MaxAge: time.Millisecond})
+	c.Inc()
+	time.Sleep(time.Millisecond * 2)
+	c.Inc()
+	if v := counter.Value(); v != 2 {
+		t.Errorf("underlying counter: got: %d want: %d", v, 2)
+	}
+	c.Inc()
+	if v := counter.Value(); v != 2 {
+		t.Errorf("underlying counter: got: %d want: %d", v, 2)
+	}
+}
+
+func BenchmarkCounterParallel(b *testing.B) {
+	c := NewStore(NewNullSink(), false).NewCounter("c")
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.Inc()
+		}
+	})
+}
+
+func BenchmarkBatchCounterParallel(b *testing.B) {
+	c := NewStore(NewNullSink(), false).NewCounter("c")
+	b.RunParallel(func(pb *testing.PB) {
+		bc := NewBatchCounter(c, BatchCounterOptions{BatchSize: 1024})
+		for pb.Next() {
+			bc.Inc()
+		}
+		bc.Flush()
+	})
+}
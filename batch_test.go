@@ -0,0 +1,68 @@
+package stats
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/lyft/gostats/mock"
+)
+
+func TestRecordBatch(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStore(sink, false)
+	tags := map[string]string{"k": "v"}
+
+	store.RecordBatch([]MetricRecord{
+		{Type: MetricTypeCounter, Name: "c", Tags: tags, Value: 2},
+		{Type: MetricTypeCounter, Name: "c", Tags: tags, Value: 3},
+		{Type: MetricTypeGauge, Name: "g", Value: 4},
+		{Type: MetricTypeGauge, Name: "g", Value: 5},
+		{Type: MetricTypeTimer, Name: "t", Tags: tags, Value: 1.5},
+		{Type: MetricTypeDistribution, Name: "d", Value: 2.5},
+	})
+	store.Scope("scope").AsStore().RecordBatch([]MetricRecord{
+		{Type: MetricTypeCounter, Name: "c", Value: 1},
+	})
+	store.Flush()
+
+	sink.AssertCounterEquals(t, mock.SerializeTags("c", tags), 5)
+	sink.AssertCounterEquals(t, "scope.c", 1)
+	sink.AssertGaugeEquals(t, "g", 5)
+	sink.AssertTimerEquals(t, mock.SerializeTags("t", tags), 1.5)
+	sink.AssertDistributionEquals(t, "d", 2.5)
+
+	expectPanic(t, "gostats: stat \"x\": invalid MetricType", func() {
+		store.RecordBatch([]MetricRecord{{Type: MetricType(100), Name: "x"}})
+	})
+	NullStore{}.RecordBatch([]MetricRecord{{Type: MetricTypeCounter, Name: "c"}})
+}
+
+func BenchmarkRecordBatch(b *testing.B) {
+	const N = 100
+	batch := make([]MetricRecord, N)
+	for i := range batch {
+		batch[i] = MetricRecord{
+			Type:  MetricTypeCounter,
+			Name:  "counter_" + strconv.Itoa(i),
+			Tags:  map[string]string{"k": "v"},
+			Value: 1,
+		}
+	}
+	b.Run("Batch", func(b *testing.B) {
+		store := NewStore(nullSink{}, false)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			store.RecordBatch(batch)
+		}
+	})
+	b.Run("Add", func(b *testing.B) {
+		store := NewStore(nullSink{}, false)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for j := range batch {
+				r := &batch[j]
+				store.NewCounterWithTags(r.Name, r.Tags).Add(uint64(r.Value))
+			}
+		}
+	})
+}
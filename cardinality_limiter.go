@@ -0,0 +1,175 @@
+package stats
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+// CardinalityLimitedStatName is the name of the counter that a
+// CardinalityLimiter increments each time it returns a limited stat.
+const CardinalityLimitedStatName = "gostats.cardinality_limited"
+
+// A CardinalityLimiterOption configures a CardinalityLimiter.
+type CardinalityLimiterOption interface {
+	apply(*CardinalityLimiter)
+}
+
+type cardinalityLimiterOptionFunc func(*CardinalityLimiter)
+
+func (f cardinalityLimiterOptionFunc) apply(l *CardinalityLimiter) {
+	f(l)
+}
+
+// WithPrefixLimit sets the limit of stats whose name starts with prefix.
+// When multiple prefixes match a name the longest is used. A limit of zero
+// disables limiting for the prefix.
+func WithPrefixLimit(prefix string, limit int) CardinalityLimiterOption {
+	return cardinalityLimiterOptionFunc(func(l *CardinalityLimiter) {
+		l.prefixLimits = append(l.prefixLimits, prefixLimit{prefix: prefix, limit: limit})
+	})
+}
+
+type prefixLimit struct {
+	prefix string
+	limit  int
+}
+
+// limitedStore allows embedding a Store in a CardinalityLimiter, a field
+// named Store would conflict with the Store method.
+type limitedStore = Store
+
+// A CardinalityLimiter limits the number of distinct tag combinations of
+// each stat name of a Store. Once the limit of a name is reached, stats
+// with new tag combinations are not registered and never flushed, a warning
+// is logged and the CardinalityLimitedStatName counter is incremented.
+// Stats with previously seen tag combinations are not affected.
+//
+// The limits apply to the Store itself, so the stats created through the
+// Store passed to NewCardinalityLimiter and any of its scopes are limited
+// too. Names are the full names of the stats, with their scopes, and tag
+// combinations are those of the stats registered by the Store, after
+// StoreOptions.TagKeyAliases and StoreOptions.NormalizeTagValue are applied.
+// Counters, gauges, timers, etc. with the same name share a limit.
+// Unregistering a stat frees its tag combination.
+type CardinalityLimiter struct {
+	limitedStore
+
+	limit        int
+	prefixLimits []prefixLimit // sorted by descending prefix length
+	limited      Counter
+
+	mu     sync.Mutex
+	seen   map[string]map[string]struct{} // name => serialized names
+	names  map[string]string              // serialized name => name
+	warned map[string]struct{}
+}
+
+// NewCardinalityLimiter returns a CardinalityLimiter that allows up to
+// limit tag combinations per stat name in store. A limit of zero disables
+// limiting for names that do not match a prefix limit. A Store has at most
+// one CardinalityLimiter, a new one replaces the limits of the previous one.
+// It has no effect if store was not created by NewStore.
+func NewCardinalityLimiter(store Store, limit int, opts ...CardinalityLimiterOption) *CardinalityLimiter {
+	l := &CardinalityLimiter{
+		limitedStore: store,
+		limit:        limit,
+		limited:      store.NewCounter(CardinalityLimitedStatName),
+		seen:         make(map[string]map[string]struct{}),
+		names:        make(map[string]string),
+		warned:       make(map[string]struct{}),
+	}
+	for _, opt := range opts {
+		opt.apply(l)
+	}
+	sort.SliceStable(l.prefixLimits, func(i, j int) bool {
+		return len(l.prefixLimits[i].prefix) > len(l.prefixLimits[j].prefix)
+	})
+	if s := storeOf(store); s != nil {
+		s.limiter.Store(l)
+	}
+	return l
+}
+
+func (l *CardinalityLimiter) limitFor(name string) int {
+	for _, p := range l.prefixLimits {
+		if strings.HasPrefix(name, p.prefix) {
+			return p.limit
+		}
+	}
+	return l.limit
+}
+
+// allow reports if the stat name, registered as serializedName, may be
+// created.
+func (l *CardinalityLimiter) allow(name, serializedName string) bool {
+	limit := l.limitFor(name)
+	if limit <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	set := l.seen[name]
+	if _, ok := set[serializedName]; ok {
+		l.mu.Unlock()
+		return true
+	}
+	if len(set) < limit {
+		if set == nil {
+			set = make(map[string]struct{})
+			l.seen[name] = set
+		}
+		set[serializedName] = struct{}{}
+		l.names[serializedName] = name
+		l.mu.Unlock()
+		return true
+	}
+	_, warned := l.warned[name]
+	if !warned {
+		l.warned[name] = struct{}{}
+	}
+	l.mu.Unlock()
+
+	if !warned {
+		logger.Warnf("[gostats] stat %q reached its cardinality limit of %d", name, limit)
+	}
+	l.limited.Inc()
+	return false
+}
+
+// forget frees the tag combination of the stat registered as
+// serializedName.
+func (l *CardinalityLimiter) forget(serializedName string) {
+	l.mu.Lock()
+	l.forgetLocked(serializedName)
+	l.mu.Unlock()
+}
+
+// forgetPrefix frees the tag combinations of the stats whose registered
+// name starts with prefix.
+func (l *CardinalityLimiter) forgetPrefix(prefix string) {
+	l.mu.Lock()
+	for serializedName := range l.names {
+		if strings.HasPrefix(serializedName, prefix) {
+			l.forgetLocked(serializedName)
+		}
+	}
+	l.mu.Unlock()
+}
+
+// forgetLocked is like forget, l.mu must be held.
+func (l *CardinalityLimiter) forgetLocked(serializedName string) {
+	name, ok := l.names[serializedName]
+	if !ok {
+		return
+	}
+	delete(l.names, serializedName)
+	if set := l.seen[name]; set != nil {
+		delete(set, serializedName)
+		if len(set) == 0 {
+			delete(l.seen, name)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package stats
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/lyft/gostats/mock"
+)
+
+var _ Store = (*CardinalityLimiter)(nil)
+
+func TestCardinalityLimiter(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewCardinalityLimiter(NewStore(sink, false), 2)
+
+	for i := 0; i < 4; i++ {
+		store.NewCounterWithTags("c", map[string]string{"id": strconv.Itoa(i)}).Inc()
+	}
+	// previously seen tags are not limited
+	store.NewCounterWithTags("c", map[string]string{"id": "0"}).Inc()
+	// names are limited independently
+	store.NewCounterWithTags("other", map[string]string{"id": "3"}).Inc()
+	store.Flush()
+
+	sink.AssertCounterEquals(t, "c.__id=0", 2)
+	sink.AssertCounterEquals(t, "c.__id=1", 1)
+	sink.AssertCounterNotExists(t, "c.__id=2")
+	sink.AssertCounterNotExists(t, "c.__id=3")
+	sink.AssertCounterEquals(t, "other.__id=3", 1)
+	sink.AssertCounterEquals(t, CardinalityLimitedStatName, 2)
+}
+
+func TestCardinalityLimiter_Scope(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewCardinalityLimiter(NewStore(sink, false), 1)
+	store.ScopeWithTags("s", map[string]string{"a": "1"}).NewGauge("g").Set(1)
+	store.ScopeWithTags("s", map[string]string{"a": "2"}).NewGauge("g").Set(2)
+	store.Scope("s").Scope("x").NewTimer("t").AddValue(1)
+	store.Scope("s").NewPerInstanceTimer("x.t", nil).AddValue(2)
+	// the limits apply to the whole Store
+	store.Scope("s").Store().NewGaugeWithTags("s.g", map[string]string{"a": "3"}).Set(3)
+	store.Flush()
+
+	sink.AssertGaugeEquals(t, "s.g.__a=1", 1)
+	sink.AssertGaugeNotExists(t, "s.g.__a=2")
+	sink.AssertGaugeNotExists(t, "s.g.__a=3")
+	sink.AssertTimerEquals(t, "s.x.t", 1)
+	sink.AssertTimerNotExists(t, "s.x.t.___f=i")
+}
+
+func TestCardinalityLimiter_PrefixLimit(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewCardinalityLimiter(NewStore(sink, false), 1,
+		WithPrefixLimit("svc.", 2),
+		WithPrefixLimit("svc.unlimited.", 0),
+	)
+	for i := 0; i < 3; i++ {
+		tags := map[string]string{"id": strconv.Itoa(i)}
+		store.NewCounterWithTags("c", tags).Inc()
+		store.NewCounterWithTags("svc.c", tags).Inc()
+		store.NewCounterWithTags("svc.unlimited.c", tags).Inc()
+	}
+	store.Flush()
+
+	if n := len(sink.ListCounters()); n != 1+2+3+1 {
+		t.Errorf("counters: got: %d want: %d: %q", n, 7, sink.ListCounters())
+	}
+	sink.AssertCounterEquals(t, CardinalityLimitedStatName, 3)
+}
+
+func TestCardinalityLimiter_Ctx(t *testing.T) {
+	type key struct{}
+	sink := mock.NewSink()
+	store := NewCardinalityLimiter(NewStore(sink, false), 1)
+	store.SetTagsFromContext(func(ctx context.Context) map[string]string {
+		v, _ := ctx.Value(key{}).(string)
+		return map[string]string{"req": v}
+	})
+	store.NewCounterCtx(context.WithValue(context.Background(), key{}, "1"), "c").Inc()
+	store.NewCounterCtx(context.WithValue(context.Background(), key{}, "2"), "c").Inc()
+	store.Flush()
+
+	sink.AssertCounterEquals(t, "c.__req=1", 1)
+	sink.AssertCounterNotExists(t, "c.__req=2")
+}
+
+func TestCardinalityLimiter_LimitedStats(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewCardinalityLimiter(NewStore(sink, false), 1)
+	store.NewTimerWithTags("t", map[string]string{"a": "1"})
+	timer := store.NewTimerWithTags("t", map[string]string{"a": "2"})
+	span := timer.AllocateSpan()
+	if d := span.Complete(); d < 0 {
+		t.Errorf("Complete: got negative duration: %s", d)
+	}
+	store.Flush()
+
+	sink.AssertTimerNotExists(t, "t.__a=2")
+	if names := store.ListTimers(); len(names) != 1 || names[0] != "t.__a=1" {
+		t.Errorf("ListTimers: got: %q want: %q", names, []string{"t.__a=1"})
+	}
+}
+
+func TestCardinalityLimiter_NormalizedTags(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewCardinalityLimiter(NewStoreWithOptions(sink, StoreOptions{
+		NormalizeTagValue: func(key, value string) string { return strings.ToLower(value) },
+		TagKeyAliases:     map[string]string{"verb": "method"},
+	}), 1)
+	// all the tags are normalized to the same stat
+	store.NewCounterWithTags("c", map[string]string{"method": "GET"}).Inc()
+	store.NewCounterWithTags("c", map[string]string{"method": "get"}).Inc()
+	store.NewCounterWithTags("c", map[string]string{"verb": "Get"}).Inc()
+	store.Flush()
+
+	sink.AssertCounterEquals(t, "c.__method=get", 3)
+	sink.AssertCounterEquals(t, CardinalityLimitedStatName, 0)
+}
+
+func TestCardinalityLimiter_UnregisterTagSeparator(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewCardinalityLimiter(NewStore(sink, false), 1)
+	// the name contains the tag separator
+	store.NewCounterWithTags("c.__x", map[string]string{"id": "1"}).Inc()
+	if !store.UnregisterWithTags("c.__x", map[string]string{"id": "1"}) {
+		t.Fatal("UnregisterWithTags: expected stat to exist")
+	}
+	store.NewCounterWithTags("c.__x", map[string]string{"id": "2"}).Inc()
+	store.Flush()
+
+	sink.AssertCounterEquals(t, "c.__x.__id=2", 1)
+	sink.AssertCounterEquals(t, CardinalityLimitedStatName, 0)
+}
+
+func TestCardinalityLimiter_Unregister(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewCardinalityLimiter(NewStore(sink, false), 1)
+	store.NewCounterWithTags("c", map[string]string{"id": "1"}).Inc()
+	if !store.UnregisterWithTags("c", map[string]string{"id": "1"}) {
+		t.Fatal("UnregisterWithTags: expected stat to exist")
+	}
+	// the freed tag combination can be reused
+	store.NewCounterWithTags("c", map[string]string{"id": "2"}).Inc()
+	store.Flush()
+
+	sink.AssertCounterNotExists(t, "c.__id=1")
+	sink.AssertCounterEquals(t, "c.__id=2", 1)
+}
+
+func TestCardinalityLimiter_PerInstanceSuffix(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewCardinalityLimiter(NewStoreWithOptions(sink, StoreOptions{PerInstanceSuffix: "instance"}), 1)
+	scope := store.ScopeWithOptions("s", ScopeOptions{PerInstanceSuffix: "scoped"})
+
+	store.NewPerInstanceCounter("c", map[string]string{"id": "1"}).Inc()
+	scope.NewPerInstanceCounter("c", map[string]string{"id": "1"}).Inc()
+	// unregistering the stats frees their tag combinations
+	if !store.UnregisterWithTags("c", map[string]string{"id": "1", "_f": "instance"}) {
+		t.Fatal("UnregisterWithTags: expected stat to exist")
+	}
+	if !store.UnregisterWithTags("s.c", map[string]string{"id": "1", "_f": "scoped"}) {
+		t.Fatal("UnregisterWithTags: expected scoped stat to exist")
+	}
+	store.NewPerInstanceCounter("c", map[string]string{"id": "2"}).Inc()
+	scope.NewPerInstanceCounter("c", map[string]string{"id": "2"}).Inc()
+	store.Flush()
+
+	sink.AssertCounterEquals(t, mock.SerializeTags("c", map[string]string{"id": "2", "_f": "instance"}), 1)
+	sink.AssertCounterEquals(t, mock.SerializeTags("s.c", map[string]string{"id": "2", "_f": "scoped"}), 1)
+	sink.AssertCounterEquals(t, CardinalityLimitedStatName, 0)
+}
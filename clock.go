@@ -0,0 +1,18 @@
+package stats
+
+import "time"
+
+// A Clock tells the time to the time dependent stats of a Store: Timers,
+// RateGauges, Summaries and WindowedCounters. It can be replaced with
+// StoreOptions.Clock to test them without sleeping, see mock.FakeClock.
+type Clock interface {
+	Now() time.Time
+}
+
+// clockNow returns the time of c, or of the system clock if c is nil.
+func clockNow(c Clock) time.Time {
+	if c == nil {
+		return time.Now()
+	}
+	return c.Now()
+}
@@ -0,0 +1,86 @@
+package stats
+
+// A dedupSink buffers the Counters, Gauges and FloatGauges flushed during
+// one flush of a Store, see StoreOptions.DeduplicateFlushes. The values
+// flushed for the same name are merged: Counters are summed and the last
+// value of a Gauge or FloatGauge wins. Timers, Distributions and
+// UpDownCounters are passed to the Sink immediately.
+type dedupSink struct {
+	Sink
+	index map[dedupKey]int // index of the stat in stats
+	stats []dedupStat      // in the order they were first flushed
+}
+
+type dedupKind uint8
+
+const (
+	dedupCounter dedupKind = iota
+	dedupGauge
+	dedupFloatGauge
+)
+
+type dedupKey struct {
+	name string
+	kind dedupKind
+}
+
+type dedupStat struct {
+	dedupKey
+	value      uint64
+	floatValue float64
+}
+
+func newDedupSink(sink Sink) *dedupSink {
+	return &dedupSink{Sink: sink, index: make(map[dedupKey]int)}
+}
+
+func (d *dedupSink) FlushCounter(name string, value uint64) {
+	d.add(dedupKey{name: name, kind: dedupCounter}, value, 0)
+}
+
+func (d *dedupSink) FlushGauge(name string, value uint64) {
+	d.add(dedupKey{name: name, kind: dedupGauge}, value, 0)
+}
+
+func (d *dedupSink) FlushFloatGauge(name string, value float64) {
+	d.add(dedupKey{name: name, kind: dedupFloatGauge}, 0, value)
+}
+
+func (d *dedupSink) FlushDistribution(name string, value float64) {
+	newDistributionSink(d.Sink).FlushDistribution(name, value)
+}
+
+func (d *dedupSink) FlushUpDownCounter(name string, value int64) {
+	newUpDownCounterSink(d.Sink).FlushUpDownCounter(name, value)
+}
+
+func (d *dedupSink) add(key dedupKey, value uint64, floatValue float64) {
+	i, ok := d.index[key]
+	if !ok {
+		d.index[key] = len(d.stats)
+		d.stats = append(d.stats, dedupStat{dedupKey: key, value: value, floatValue: floatValue})
+		return
+	}
+	switch key.kind {
+	case dedupCounter:
+		d.stats[i].value += value
+	case dedupGauge:
+		d.stats[i].value = value
+	case dedupFloatGauge:
+		d.stats[i].floatValue = floatValue
+	}
+}
+
+// emit passes the merged stats to counter, gauge and floatGauge.
+func (d *dedupSink) emit(counter, gauge func(name string, value uint64), floatGauge func(name string, value float64)) {
+	for _, st := range d.stats {
+		switch st.kind {
+		case dedupCounter:
+			counter(st.name, st.value)
+		case dedupGauge:
+			gauge(st.name, st.value)
+		case dedupFloatGauge:
+			floatGauge(st.name, st.floatValue)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+)
+
+// checkEWMAAlpha panics if alpha is not a valid smoothing factor.
+func checkEWMAAlpha(alpha float64) {
+	if !(alpha > 0 && alpha <= 1) {
+		panic(fmt.Sprintf("gostats: EWMA gauge alpha must be in (0, 1]: %v", alpha))
+	}
+}
+
+// ewmaGauge is a Gauge that flushes the exponentially weighted moving
+// average of its values. Each call that changes the value adds the new value
+// to the average with weight alpha.
+type ewmaGauge struct {
+	alpha float64
+
+	mu    sync.Mutex
+	value uint64  // last value
+	avg   float64 // moving average
+	init  bool    // avg holds a value
+}
+
+func newEWMAGauge(alpha float64) *ewmaGauge {
+	checkEWMAAlpha(alpha)
+	return &ewmaGauge{alpha: alpha}
+}
+
+// update sets the value to f(value) and adds it to the average.
+func (g *ewmaGauge) update(f func(uint64) uint64) {
+	g.mu.Lock()
+	g.value = f(g.value)
+	if g.init {
+		g.avg += g.alpha * (float64(g.value) - g.avg)
+	} else {
+		g.avg = float64(g.value)
+		g.init = true
+	}
+	g.mu.Unlock()
+}
+
+func (g *ewmaGauge) Add(delta uint64) {
+	g.update(func(v uint64) uint64 { return v + delta })
+}
+
+func (g *ewmaGauge) Sub(delta uint64) {
+	g.update(func(v uint64) uint64 { return v - delta })
+}
+
+func (g *ewmaGauge) Inc() {
+	g.Add(1)
+}
+
+func (g *ewmaGauge) Dec() {
+	g.Sub(1)
+}
+
+func (g *ewmaGauge) Set(value uint64) {
+	g.update(func(uint64) uint64 { return value })
+}
+
+// Value returns the moving average rounded to the nearest integer.
+func (g *ewmaGauge) Value() uint64 {
+	g.mu.Lock()
+	avg := g.avg
+	g.mu.Unlock()
+	return uint64(math.Round(avg))
+}
+
+func (g *ewmaGauge) String() string {
+	return strconv.FormatUint(g.Value(), 10)
+}
@@ -0,0 +1,54 @@
+package stats
+
+import (
+	"testing"
+
+	"github.com/lyft/gostats/mock"
+)
+
+func TestEWMAGauge(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStore(sink, false)
+	g := store.Scope("s").NewEWMAGaugeWithTags("g", map[string]string{"k": "v"}, 0.5)
+
+	g.Set(10) // the first value initializes the average
+	if v := g.Value(); v != 10 {
+		t.Errorf("Value: got: %d want: %d", v, 10)
+	}
+	g.Set(20) // 10 + 0.5*(20-10)
+	g.Add(10) // 15 + 0.5*(30-15)
+	if v := g.Value(); v != 23 {
+		t.Errorf("Value: got: %d want: %d", v, 23)
+	}
+	store.Flush()
+	sink.AssertGaugeEquals(t, "s.g.__k=v", 23)
+
+	// an alpha of 1 follows the last value
+	g = store.NewEWMAGauge("last", 1)
+	g.Set(4)
+	g.Dec()
+	if v := g.Value(); v != 3 {
+		t.Errorf("Value: got: %d want: %d", v, 3)
+	}
+}
+
+func TestEWMAGaugeAlpha(t *testing.T) {
+	scopes := map[string]Scope{
+		"statStore":          NewStore(mock.NewSink(), false),
+		"subScope":           NewStore(mock.NewSink(), false).Scope("s"),
+		"CardinalityLimiter": NewCardinalityLimiter(NewStore(mock.NewSink(), false), 1),
+		"NullScope":          NullScope{},
+	}
+	for name, scope := range scopes {
+		for _, alpha := range []float64{0, -0.5, 1.5} {
+			func() {
+				defer func() {
+					if recover() == nil {
+						t.Errorf("%s: NewEWMAGauge(%v): expected panic", name, alpha)
+					}
+				}()
+				scope.NewEWMAGauge("g", alpha)
+			}()
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package stats
+
+import (
+	"io"
+	"reflect"
+)
+
+type hybridSink struct {
+	counter Sink
+	gauge   Sink
+	timer   Sink
+	sinks   []Sink // the distinct sinks
+}
+
+// NewHybridSink returns a Sink that flushes Counters to counter, Gauges to
+// gauge and Timers to timer, so that each type of stat can be sent to a
+// different backend. UpDownCounters and FloatGauges are flushed to gauge and
+// Distributions to timer. A nil sink drops the stats of its type.
+//
+// Flush, Sync, Close and SetHelp are called once on each of the distinct
+// sinks, the returned Sink implements FlushableSink, DistributionSink and the
+// other optional Sink interfaces like the Sink returned by NewMultiSink.
+func NewHybridSink(counter, gauge, timer Sink) Sink {
+	h := &hybridSink{counter: counter, gauge: gauge, timer: timer}
+	for _, sink := range []*Sink{&h.counter, &h.gauge, &h.timer} {
+		if *sink == nil {
+			*sink = nullSink{}
+			continue
+		}
+		if !h.contains(*sink) {
+			h.sinks = append(h.sinks, *sink)
+		}
+	}
+	return h
+}
+
+// contains reports if sink is one of h.sinks, sinks of types that are not
+// comparable are always distinct.
+func (h *hybridSink) contains(sink Sink) bool {
+	if !reflect.TypeOf(sink).Comparable() {
+		return false
+	}
+	for _, s := range h.sinks {
+		if s == sink {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *hybridSink) FlushCounter(name string, value uint64) {
+	h.counter.FlushCounter(name, value)
+}
+
+func (h *hybridSink) FlushGauge(name string, value uint64) {
+	h.gauge.FlushGauge(name, value)
+}
+
+func (h *hybridSink) FlushTimer(name string, value float64) {
+	h.timer.FlushTimer(name, value)
+}
+
+func (h *hybridSink) FlushDistribution(name string, value float64) {
+	newDistributionSink(h.timer).FlushDistribution(name, value)
+}
+
+func (h *hybridSink) FlushUpDownCounter(name string, value int64) {
+	newUpDownCounterSink(h.gauge).FlushUpDownCounter(name, value)
+}
+
+func (h *hybridSink) FlushFloatGauge(name string, value float64) {
+	newFloatGaugeSink(h.gauge).FlushFloatGauge(name, value)
+}
+
+// Sync syncs each sink and returns the first error.
+func (h *hybridSink) Sync() error {
+	var first error
+	for _, s := range h.sinks {
+		if err := syncSink(s); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Close closes each sink that implements io.Closer and returns the first
+// error.
+func (h *hybridSink) Close() error {
+	var first error
+	for _, s := range h.sinks {
+		if c, ok := s.(io.Closer); ok {
+			if err := c.Close(); err != nil && first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+func (h *hybridSink) Flush() {
+	for _, s := range h.sinks {
+		if fs, ok := s.(FlushableSink); ok {
+			fs.Flush()
+		}
+	}
+}
+
+func (h *hybridSink) SetHelp(name, help string) {
+	for _, s := range h.sinks {
+		if hs, ok := s.(HelpSink); ok {
+			hs.SetHelp(name, help)
+		}
+	}
+}
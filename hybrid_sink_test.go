@@ -0,0 +1,82 @@
+package stats
+
+import (
+	"testing"
+
+	"github.com/lyft/gostats/mock"
+)
+
+var (
+	_ FlushableSink     = (*hybridSink)(nil)
+	_ DistributionSink  = (*hybridSink)(nil)
+	_ UpDownCounterSink = (*hybridSink)(nil)
+	_ FloatGaugeSink    = (*hybridSink)(nil)
+	_ HelpSink          = (*hybridSink)(nil)
+	_ Syncer            = (*hybridSink)(nil)
+)
+
+func TestHybridSink(t *testing.T) {
+	counters := mock.NewSink()
+	gauges := mock.NewSink()
+	timers := mock.NewSink()
+	store := NewStore(NewHybridSink(counters, gauges, timers), false)
+
+	store.NewCounter("counter").Inc()
+	store.NewGauge("gauge").Set(2)
+	store.NewTimer("timer").AddValue(3)
+	store.NewDistribution("distribution").RecordValue(4)
+	store.NewUpDownCounter("updown").Add(5)
+	store.Flush()
+
+	counters.AssertCounterEquals(t, "counter", 1)
+	gauges.AssertGaugeEquals(t, "gauge", 2)
+	timers.AssertTimerEquals(t, "timer", 3)
+	timers.AssertDistributionEquals(t, "distribution", 4)
+	gauges.AssertUpDownCounterEquals(t, "updown", 5)
+
+	if n := len(counters.ListGauges()) + len(counters.ListTimers()); n != 0 {
+		t.Errorf("counter sink: got: %d other stats want: 0", n)
+	}
+	if n := len(gauges.ListCounters()) + len(gauges.ListTimers()); n != 0 {
+		t.Errorf("gauge sink: got: %d other stats want: 0", n)
+	}
+	if n := len(timers.ListCounters()) + len(timers.ListGauges()); n != 0 {
+		t.Errorf("timer sink: got: %d other stats want: 0", n)
+	}
+}
+
+func TestHybridSinkCounterDoesNotFlushGauge(t *testing.T) {
+	counter := &countingSink{}
+	gauge := &countingSink{}
+	sink := NewHybridSink(counter, gauge, nil)
+
+	sink.FlushCounter("c", 1)
+	sink.FlushTimer("t", 1) // dropped
+	if counter.counters != 1 {
+		t.Errorf("counter sink FlushCounter: got: %d calls want: %d", counter.counters, 1)
+	}
+	if gauge.gauges != 0 || gauge.counters != 0 {
+		t.Errorf("gauge sink: got: %d FlushGauge and %d FlushCounter calls want: 0",
+			gauge.gauges, gauge.counters)
+	}
+}
+
+func TestHybridSinkDistinctSinks(t *testing.T) {
+	shared := &syncSinkStub{Sink: mock.NewSink()}
+	other := &syncSinkStub{Sink: mock.NewSink()}
+	sink := NewHybridSink(shared, other, shared).(Syncer)
+	if err := sink.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if shared.synced != 1 || other.synced != 1 {
+		t.Errorf("Sync: got: %d and %d calls want: 1 per sink", shared.synced, other.synced)
+	}
+}
+
+type countingSink struct {
+	counters, gauges, timers int
+}
+
+func (s *countingSink) FlushCounter(name string, value uint64) { s.counters++ }
+func (s *countingSink) FlushGauge(name string, value uint64)   { s.gauges++ }
+func (s *countingSink) FlushTimer(name string, value float64)  { s.timers++ }
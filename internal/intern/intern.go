@@ -0,0 +1,82 @@
+// Package intern deduplicates strings that are built repeatedly, such as
+// stat names, so that equal strings share the same backing array.
+package intern
+
+import "sync"
+
+// DefaultMaxSize is the maximum number of strings held by the zero Pool.
+const DefaultMaxSize = 64 * 1024
+
+// A Pool is a table of interned strings. It is safe for concurrent use and
+// the zero Pool is ready to use.
+//
+// Strings are never removed from a Pool, so once MaxSize strings are held
+// new strings are returned as is instead of being added. This bounds the
+// memory used by a Pool fed high cardinality strings, like tag values.
+type Pool struct {
+	// MaxSize is the maximum number of strings held by the Pool, if zero
+	// DefaultMaxSize is used.
+	MaxSize int
+
+	mu sync.RWMutex
+	m  map[string]string
+}
+
+// String returns the interned copy of s, adding s to the Pool if it is not
+// already held.
+func (p *Pool) String(s string) string {
+	if s == "" {
+		return s
+	}
+	p.mu.RLock()
+	v, ok := p.m[s]
+	p.mu.RUnlock()
+	if ok {
+		return v
+	}
+	return p.add(s)
+}
+
+// Bytes is like String for the string of b. It does not allocate if the
+// string is already held, so b can be built in a reused or stack allocated
+// buffer to look up a string without allocating it.
+func (p *Pool) Bytes(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	p.mu.RLock()
+	v, ok := p.m[string(b)] // does not allocate
+	p.mu.RUnlock()
+	if ok {
+		return v
+	}
+	return p.add(string(b))
+}
+
+func (p *Pool) add(s string) string {
+	max := p.MaxSize
+	if max <= 0 {
+		max = DefaultMaxSize
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if v, ok := p.m[s]; ok {
+		return v
+	}
+	if len(p.m) >= max {
+		return s
+	}
+	if p.m == nil {
+		p.m = make(map[string]string)
+	}
+	p.m[s] = s
+	return s
+}
+
+// Len returns the number of strings held by the Pool.
+func (p *Pool) Len() int {
+	p.mu.RLock()
+	n := len(p.m)
+	p.mu.RUnlock()
+	return n
+}
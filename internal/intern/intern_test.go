@@ -0,0 +1,94 @@
+package intern
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"unsafe"
+)
+
+func stringData(s string) uintptr {
+	return *(*uintptr)(unsafe.Pointer(&s))
+}
+
+func TestPool(t *testing.T) {
+	var p Pool
+	a := p.String(strconv.Itoa(12345))
+	b := p.String(strconv.Itoa(12345))
+	if a != b {
+		t.Fatalf("got: %q want: %q", b, a)
+	}
+	if stringData(a) != stringData(b) {
+		t.Error("interned strings do not share the same backing array")
+	}
+	if s := p.String(""); s != "" {
+		t.Errorf("got: %q want: %q", s, "")
+	}
+	if n := p.Len(); n != 1 {
+		t.Errorf("Len: got: %d want: %d", n, 1)
+	}
+}
+
+func TestPoolMaxSize(t *testing.T) {
+	p := Pool{MaxSize: 2}
+	for i := 0; i < 4; i++ {
+		p.String(strconv.Itoa(1000 + i))
+	}
+	if n := p.Len(); n != 2 {
+		t.Errorf("Len: got: %d want: %d", n, 2)
+	}
+	// small ints share a static string, so use values >= 100
+	a := p.String(strconv.Itoa(1003))
+	b := p.String(strconv.Itoa(1003))
+	if a != b || a != "1003" {
+		t.Errorf("got: %q and %q want: %q", a, b, "1003")
+	}
+	if stringData(a) == stringData(b) {
+		t.Error("strings were interned past MaxSize")
+	}
+}
+
+func TestPoolBytes(t *testing.T) {
+	var p Pool
+	a := p.String(strconv.Itoa(12345))
+	b := p.Bytes([]byte("12345"))
+	if stringData(a) != stringData(b) {
+		t.Error("Bytes did not return the interned string")
+	}
+	buf := []byte("12345")
+	if n := testing.AllocsPerRun(100, func() { p.Bytes(buf) }); n != 0 {
+		t.Errorf("Bytes of an interned string: got: %v allocs want: 0", n)
+	}
+	if s := p.Bytes(nil); s != "" {
+		t.Errorf("got: %q want: %q", s, "")
+	}
+}
+
+func TestPoolConcurrent(t *testing.T) {
+	var p Pool
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				p.String(strconv.Itoa(j))
+			}
+		}()
+	}
+	wg.Wait()
+	if n := p.Len(); n != 100 {
+		t.Errorf("Len: got: %d want: %d", n, 100)
+	}
+}
+
+func BenchmarkPoolBytes(b *testing.B) {
+	var p Pool
+	buf := []byte("prefix.scope.name")
+	p.Bytes(buf)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p.Bytes(buf)
+	}
+}
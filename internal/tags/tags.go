@@ -4,6 +4,8 @@ import (
 	"sort"
 	"strings"
 	"unsafe"
+
+	"github.com/lyft/gostats/internal/intern"
 )
 
 // A Tag is a Key/Value statsd tag.
@@ -184,6 +186,19 @@ func (t TagSet) Insert(p Tag) TagSet {
 	return a
 }
 
+// Remove removes the Tag with key from TagSet t, returning a copy unless t
+// does not contain key.
+func (t TagSet) Remove(key string) TagSet {
+	i := t.Search(key)
+	if i == len(t) || t[i].Key != key {
+		return t // no change
+	}
+	a := make(TagSet, len(t)-1)
+	copy(a[:i], t[:i])
+	copy(a[i:], t[i+1:])
+	return a
+}
+
 // MergeTags returns a TagSet that is the union of subScope's tags and the
 // provided tags map. If any keys overlap the values from the provided map
 // are used.
@@ -238,16 +253,22 @@ func mergeOneTag(set TagSet, tags map[string]string) TagSet {
 // The returned TagSet will have a per-instance key ("_f") and if neither the
 // subScope or tags have this key it's value will be the default per-instance
 // value ("i").
+func (t TagSet) MergePerInstanceTags(tags map[string]string) TagSet {
+	return t.MergePerInstanceTagsValue(tags, "i")
+}
+
+// MergePerInstanceTagsValue is like MergePerInstanceTags but value is used
+// as the default per-instance value.
 //
 // The method does not optimize for the case where there is only one tag
 // because it is used less frequently.
-func (t TagSet) MergePerInstanceTags(tags map[string]string) TagSet {
+func (t TagSet) MergePerInstanceTagsValue(tags map[string]string, value string) TagSet {
 	if len(tags) == 0 {
 		if t.Contains("_f") {
 			return t
 		}
 		// create copy with the per-instance tag
-		return t.Insert(Tag{Key: "_f", Value: "i"})
+		return t.Insert(Tag{Key: "_f", Value: value})
 	}
 
 	// write tags to the end of scratch slice
@@ -256,7 +277,7 @@ func (t TagSet) MergePerInstanceTags(tags map[string]string) TagSet {
 	i := 0
 	// add the default per-instance tag if not present
 	if tags["_f"] == "" && !t.Contains("_f") {
-		a[i] = Tag{Key: "_f", Value: "i"}
+		a[i] = Tag{Key: "_f", Value: value}
 		i++
 	}
 	for k, v := range tags {
@@ -452,22 +473,39 @@ func SerializeTags(name string, tags map[string]string) string {
 
 // ReplaceChars replaces any invalid chars ([.:|]) in value s with '_'.
 func ReplaceChars(s string) string {
-	var buf []byte // lazily allocated
-	for i := 0; i < len(s); i++ {
-		switch s[i] {
+	i := strings.IndexAny(s, ".:|")
+	if i == -1 {
+		return s
+	}
+	if len(s) > replaceBufSize {
+		buf := []byte(s)
+		replaceInvalid(buf[i:])
+		return *(*string)(unsafe.Pointer(&buf))
+	}
+	// values that fit are replaced on the stack, so looking up an interned
+	// value does not allocate
+	var stack [replaceBufSize]byte
+	buf := stack[:copy(stack[:], s)]
+	replaceInvalid(buf[i:])
+	return replaced.Bytes(buf)
+}
+
+// replaceBufSize is the length of the longest value interned by ReplaceChars.
+const replaceBufSize = 64
+
+func replaceInvalid(b []byte) {
+	for i := range b {
+		switch b[i] {
 		case '.', ':', '|':
-			if buf == nil {
-				buf = []byte(s)
-			}
-			buf[i] = '_'
+			b[i] = '_'
 		}
 	}
-	if buf == nil {
-		return s
-	}
-	return *(*string)(unsafe.Pointer(&buf))
 }
 
+// replaced interns the values returned by ReplaceChars so that the tags of
+// stats that share a value also share its replacement.
+var replaced intern.Pool
+
 // removeStatValue removes the value from a stat line
 func removeStatValue(s string) string {
 	i := strings.IndexByte(s, ':')
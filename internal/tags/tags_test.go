@@ -344,6 +344,23 @@ func randomTagSet(t testing.TB, valPrefix string, size int) TagSet {
 	return s
 }
 
+func TestTagRemove(t *testing.T) {
+	t1 := TagSet{{"a", "1"}, {"b", "2"}, {"c", "3"}}
+	t2 := t1.Remove("b")
+	if len(t2) != 2 || t2[0].Key != "a" || t2[1].Key != "c" {
+		t.Errorf("Remove: got: %v", t2)
+	}
+	if len(t1) != 3 || t1[1].Key != "b" {
+		t.Errorf("Remove modified the original TagSet: %v", t1)
+	}
+	if t3 := t1.Remove("d"); &t3[0] != &t1[0] {
+		t.Error("Remove of a missing key returned a copy")
+	}
+	if t4 := TagSet(nil).Remove("a"); len(t4) != 0 {
+		t.Errorf("Remove: got: %v", t4)
+	}
+}
+
 func TestTagInsert(t *testing.T) {
 	t1 := randomTagSet(t, "t1_", 1000)
 	t2 := randomTagSet(t, "t2_", 1000)
@@ -1048,3 +1065,41 @@ func TestParseTagSet(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateName(t *testing.T) {
+	valid := []string{"a", "foo.bar", "foo_bar-baz", "~!@#"}
+	for _, s := range valid {
+		if err := ValidateName(s); err != nil {
+			t.Errorf("ValidateName(%q): unexpected error: %v", s, err)
+		}
+	}
+	invalid := []string{"", " ", "foo bar", "foo\tbar", "foo\n", "\x7f", "héllo"}
+	for _, s := range invalid {
+		if err := ValidateName(s); err == nil {
+			t.Errorf("ValidateName(%q): expected an error", s)
+		}
+	}
+}
+
+var benchmarkString string
+
+func BenchmarkReplaceChars(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		benchmarkString = ReplaceChars("10.0.0.1:8080")
+	}
+}
+
+func BenchmarkReplaceChars_Reference(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		// ReplaceChars without interning
+		buf := []byte("10.0.0.1:8080")
+		for j, c := range buf {
+			if c == '.' || c == ':' || c == '|' {
+				buf[j] = '_'
+			}
+		}
+		benchmarkString = string(buf)
+	}
+}
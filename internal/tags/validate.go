@@ -0,0 +1,17 @@
+package tags
+
+import "fmt"
+
+// ValidateName returns an error if s is empty or contains any chars that are
+// not printable, non-whitespace ASCII.
+func ValidateName(s string) error {
+	if s == "" {
+		return fmt.Errorf("empty string")
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c <= ' ' || c > '~' {
+			return fmt.Errorf("invalid char %q at index %d in: %q", c, i, s)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package stats
+
+import (
+	"sync"
+	"time"
+)
+
+// intervalFlusher flushes a set of Counters and Gauges of a statStore on its
+// own ticker, see WithFlushInterval.
+type intervalFlusher struct {
+	store *statStore
+
+	mu         sync.Mutex
+	stats      map[interface{}]string // *counter or *gauge to serialized name
+	unresolved bool                   // some names are not known yet
+}
+
+// flushEvery adds stat to the stats flushed every d. Stats other than
+// Counters and Gauges created by s are ignored.
+func (s *statStore) flushEvery(d time.Duration, stat interface{}) {
+	for unwrapped := false; !unwrapped; {
+		switch v := stat.(type) {
+		case predicateCounter:
+			stat = v.Counter
+		case predicateGauge:
+			stat = v.Gauge
+		case aliasCounter:
+			stat = v.Counter
+		case aliasGauge:
+			stat = v.Gauge
+		default:
+			unwrapped = true
+		}
+	}
+	switch stat.(type) {
+	case *counter, *gauge:
+	default:
+		return
+	}
+
+	v, ok := s.intervals.Load(d)
+	if !ok {
+		f := &intervalFlusher{store: s, stats: make(map[interface{}]string)}
+		var loaded bool
+		if v, loaded = s.intervals.LoadOrStore(d, f); !loaded {
+			go f.run(time.NewTicker(d))
+		}
+	}
+	f := v.(*intervalFlusher)
+
+	f.mu.Lock()
+	if _, ok := f.stats[stat]; !ok {
+		f.stats[stat] = ""
+		f.unresolved = true
+	}
+	f.mu.Unlock()
+}
+
+func (f *intervalFlusher) run(ticker *time.Ticker) {
+	f.store.loopMu.RLock()
+	defer f.store.loopMu.RUnlock()
+
+	done := f.store.doneChan()
+	for {
+		select {
+		case <-ticker.C:
+			f.flush()
+		case <-done:
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+// resolve finds the names of the stats added since the last flush.
+func (f *intervalFlusher) resolve() {
+	find := func(key, v interface{}) bool {
+		if name, ok := f.stats[v]; ok && name == "" {
+			f.stats[v] = key.(string)
+		}
+		return true
+	}
+	f.store.counters.Range(find)
+	f.store.gauges.Range(find)
+	for stat, name := range f.stats {
+		if name == "" { // unregistered before it was resolved
+			delete(f.stats, stat)
+		}
+	}
+	f.unresolved = false
+}
+
+func (f *intervalFlusher) flush() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.unresolved {
+		f.resolve()
+	}
+	for stat, name := range f.stats {
+		switch v := stat.(type) {
+		case *counter:
+			if cur, ok := f.store.counters.Load(name); ok && cur == stat {
+				f.store.flushCounter(name, v.latch())
+				continue
+			}
+		case *gauge:
+			if cur, ok := f.store.gauges.Load(name); ok && cur == stat {
+				f.store.flushGauge(name, v.Value())
+				continue
+			}
+		}
+		delete(f.stats, stat) // unregistered
+	}
+	if fs, ok := f.store.sink.(FlushableSink); ok {
+		fs.Flush()
+	}
+}
@@ -0,0 +1,55 @@
+package stats
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// lazyCounter is a Counter that is created in its Scope by the first call
+// to Add, Inc or Set.
+type lazyCounter struct {
+	scope Scope
+	name  string
+
+	once    sync.Once
+	counter Counter
+	created uint32 // atomic, set once counter is created
+}
+
+func newLazyCounter(scope Scope, name string) Counter {
+	return &lazyCounter{scope: scope, name: name}
+}
+
+func (c *lazyCounter) get() Counter {
+	c.once.Do(func() {
+		c.counter = c.scope.NewCounter(c.name)
+		c.scope = nil
+		atomic.StoreUint32(&c.created, 1)
+	})
+	return c.counter
+}
+
+func (c *lazyCounter) Add(delta uint64) {
+	c.get().Add(delta)
+}
+
+func (c *lazyCounter) Inc() {
+	c.get().Inc()
+}
+
+func (c *lazyCounter) Set(value uint64) {
+	c.get().Set(value)
+}
+
+func (c *lazyCounter) String() string {
+	return strconv.FormatUint(c.Value(), 10)
+}
+
+// Value returns 0 without creating the Counter if it was not created yet.
+func (c *lazyCounter) Value() uint64 {
+	if atomic.LoadUint32(&c.created) == 0 {
+		return 0
+	}
+	return c.counter.Value()
+}
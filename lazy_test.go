@@ -0,0 +1,56 @@
+package stats
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/lyft/gostats/mock"
+)
+
+func TestLazyCounter(t *testing.T) {
+	sink := mock.NewSink()
+	var mu sync.Mutex
+	created := 0
+	store := NewStoreWithOptions(sink, StoreOptions{
+		OnMetricCreate: func(kind, name string, tags map[string]string) {
+			mu.Lock()
+			created++
+			mu.Unlock()
+		},
+	})
+
+	c := store.NewLazyCounter("lazy")
+	if v := c.Value(); v != 0 || c.String() != "0" {
+		t.Errorf("Value: got: %d want: 0", v)
+	}
+	store.Flush()
+	if created != 0 || len(sink.ListCounters()) != 0 {
+		t.Fatalf("the Counter was created before it was used: %v", sink.ListCounters())
+	}
+
+	const goroutines = 16
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Inc()
+		}()
+	}
+	wg.Wait()
+	if v := c.Value(); v != goroutines {
+		t.Errorf("Value: got: %d want: %d", v, goroutines)
+	}
+	store.Flush()
+	sink.AssertCounterEquals(t, "lazy", goroutines)
+	if created != 1 {
+		t.Errorf("OnMetricCreate: got: %d calls want: 1", created)
+	}
+
+	// the scope of the Store is used
+	store.NewChildStore("child").NewLazyCounter("c").Add(2)
+	store.Flush()
+	sink.AssertCounterEquals(t, "child.c", 2)
+
+	NullStore{}.NewLazyCounter("c").Inc()
+}
@@ -21,6 +21,18 @@ func (s *loggingSink) FlushTimer(name string, value float64) {
 	logger.Debugf("[gostats] flushing time %s: %f", name, value)
 }
 
+func (s *loggingSink) FlushDistribution(name string, value float64) {
+	logger.Debugf("[gostats] flushing distribution %s: %f", name, value)
+}
+
+func (s *loggingSink) FlushUpDownCounter(name string, value int64) {
+	logger.Debugf("[gostats] flushing up/down counter %s: %d", name, value)
+}
+
+func (s *loggingSink) FlushFloatGauge(name string, value float64) {
+	logger.Debugf("[gostats] flushing float gauge %s: %f", name, value)
+}
+
 func (s *loggingSink) Flush() {
 	logger.Debugf("[gostats] Flush() called, all stats would be flushed")
 }
@@ -0,0 +1,85 @@
+package mock
+
+import (
+	"sync"
+	"time"
+)
+
+// A TimestampedValue is the value of a single flush and the time at which
+// the flush occurred.
+type TimestampedValue struct {
+	Time time.Time
+	// Value of the flush, Counter and Gauge values larger than 2^53 lose
+	// precision.
+	Value float64
+}
+
+type valueHistory struct {
+	mu     sync.Mutex
+	values map[string][]TimestampedValue
+}
+
+func (h *valueHistory) add(name string, val float64) {
+	now := time.Now()
+	h.mu.Lock()
+	if h.values == nil {
+		h.values = make(map[string][]TimestampedValue)
+	}
+	h.values[name] = append(h.values[name], TimestampedValue{Time: now, Value: val})
+	h.mu.Unlock()
+}
+
+func (h *valueHistory) get(name string) []TimestampedValue {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	a := h.values[name]
+	if len(a) == 0 {
+		return nil
+	}
+	return append([]TimestampedValue(nil), a...)
+}
+
+type history struct {
+	counters valueHistory
+	gauges   valueHistory
+	timers   valueHistory
+}
+
+// NewSinkWithTimestamps returns a new Sink that, in addition to what is
+// recorded by NewSink, records the value and time of each flushed counter,
+// gauge and timer. The history is available from the Get*History methods.
+func NewSinkWithTimestamps() *Sink {
+	s := &Sink{timestamps: true}
+	s.sink() // lazy init
+	return s
+}
+
+// GetCounterHistory returns the flushes of Counter name in the order they
+// occurred. It returns nil if the Sink was not created with
+// NewSinkWithTimestamps.
+func (s *Sink) GetCounterHistory(name string) []TimestampedValue {
+	if h := s.sink().history; h != nil {
+		return h.counters.get(name)
+	}
+	return nil
+}
+
+// GetGaugeHistory returns the flushes of Gauge name in the order they
+// occurred. It returns nil if the Sink was not created with
+// NewSinkWithTimestamps.
+func (s *Sink) GetGaugeHistory(name string) []TimestampedValue {
+	if h := s.sink().history; h != nil {
+		return h.gauges.get(name)
+	}
+	return nil
+}
+
+// GetTimerHistory returns the flushes of Timer name in the order they
+// occurred. It returns nil if the Sink was not created with
+// NewSinkWithTimestamps.
+func (s *Sink) GetTimerHistory(name string) []TimestampedValue {
+	if h := s.sink().history; h != nil {
+		return h.timers.get(name)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package mock_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lyft/gostats/mock"
+)
+
+func TestSinkWithTimestamps(t *testing.T) {
+	sink := mock.NewSinkWithTimestamps()
+	start := time.Now()
+	sink.FlushCounter("counter", 1)
+	sink.FlushCounter("counter", 2)
+	sink.FlushGauge("gauge", 3)
+	sink.FlushTimer("timer", 4.5)
+	end := time.Now()
+
+	// the regular methods are unaffected
+	sink.AssertCounterEquals(t, "counter", 3)
+
+	h := sink.GetCounterHistory("counter")
+	if len(h) != 2 {
+		t.Fatalf("counter history: got: %d entries want: %d", len(h), 2)
+	}
+	if h[0].Value != 1 || h[1].Value != 2 {
+		t.Errorf("counter history: got: %+v", h)
+	}
+	if h[1].Time.Before(h[0].Time) {
+		t.Errorf("counter history: entries out of order: %+v", h)
+	}
+	for _, v := range h {
+		if v.Time.Before(start) || v.Time.After(end) {
+			t.Errorf("counter history: time %s not in range [%s, %s]", v.Time, start, end)
+		}
+	}
+	if h := sink.GetGaugeHistory("gauge"); len(h) != 1 || h[0].Value != 3 {
+		t.Errorf("gauge history: got: %+v", h)
+	}
+	if h := sink.GetTimerHistory("timer"); len(h) != 1 || h[0].Value != 4.5 {
+		t.Errorf("timer history: got: %+v", h)
+	}
+	if h := sink.GetTimerHistory("missing"); h != nil {
+		t.Errorf("missing timer history: got: %+v", h)
+	}
+
+	// the returned history is a copy
+	h[0].Value = 100
+	if v := sink.GetCounterHistory("counter")[0].Value; v != 1 {
+		t.Errorf("counter history modified: got: %g want: %g", v, 1.0)
+	}
+
+	// Reset clears the history but timestamps are still recorded
+	sink.Reset()
+	if h := sink.GetCounterHistory("counter"); h != nil {
+		t.Errorf("history not reset: %+v", h)
+	}
+	sink.FlushCounter("counter", 1)
+	if h := sink.GetCounterHistory("counter"); len(h) != 1 {
+		t.Errorf("history after reset: got: %+v", h)
+	}
+}
+
+func TestSinkWithoutTimestamps(t *testing.T) {
+	sink := mock.NewSink()
+	sink.FlushCounter("counter", 1)
+	if h := sink.GetCounterHistory("counter"); h != nil {
+		t.Errorf("expected no history got: %+v", h)
+	}
+}
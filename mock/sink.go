@@ -3,6 +3,7 @@ package mock
 import (
 	"fmt"
 	"math"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -16,19 +17,32 @@ type entry struct {
 }
 
 type sink struct {
-	counters sync.Map
-	timers   sync.Map
-	gauges   sync.Map
+	counters      sync.Map
+	timers        sync.Map
+	gauges        sync.Map
+	distributions sync.Map
+	upDowns       sync.Map
+	floatGauges   sync.Map
+	history       *history // nil unless timestamps are recorded
 }
 
 // A Sink is a mock sink meant for testing that is safe for concurrent use.
 type Sink struct {
-	store atomic.Value
-	once  sync.Once
+	store      atomic.Value
+	once       sync.Once
+	timestamps bool // record the history of flushes
+}
+
+func (s *Sink) newSink() *sink {
+	p := new(sink)
+	if s.timestamps {
+		p.history = new(history)
+	}
+	return p
 }
 
 func (s *Sink) sink() *sink {
-	s.once.Do(func() { s.store.Store(new(sink)) })
+	s.once.Do(func() { s.store.Store(s.newSink()) })
 	return s.store.Load().(*sink)
 }
 
@@ -36,6 +50,10 @@ func (s *Sink) counters() *sync.Map { return &s.sink().counters }
 func (s *Sink) timers() *sync.Map   { return &s.sink().timers }
 func (s *Sink) gauges() *sync.Map   { return &s.sink().gauges }
 
+func (s *Sink) distributions() *sync.Map { return &s.sink().distributions }
+func (s *Sink) upDowns() *sync.Map       { return &s.sink().upDowns }
+func (s *Sink) floatGauges() *sync.Map   { return &s.sink().floatGauges }
+
 // NewSink returns a new Sink which implements the stats.Sink interface and is
 // suitable for testing.
 func NewSink() *Sink {
@@ -47,15 +65,82 @@ func NewSink() *Sink {
 // Flush is a no-op method
 func (*Sink) Flush() {}
 
-// Reset resets the Sink's counters, timers and gauges to zero.
+// Reset resets the Sink's counters, timers, gauges, distributions, up/down
+// counters and float gauges to zero and clears their history.
 func (s *Sink) Reset() {
-	s.store.Store(new(sink))
+	s.store.Store(s.newSink())
+}
+
+// A Snapshot is a copy of the state of a Sink, see Sink.Snapshot.
+type Snapshot struct {
+	counters      map[string]entry
+	timers        map[string]entry
+	gauges        map[string]entry
+	distributions map[string]entry
+	upDowns       map[string]entry
+	floatGauges   map[string]entry
+}
+
+func copyEntries(m *sync.Map) map[string]entry {
+	c := make(map[string]entry)
+	m.Range(func(k, v interface{}) bool {
+		p := v.(*entry)
+		c[k.(string)] = entry{
+			val:   atomic.LoadUint64(&p.val),
+			count: atomic.LoadInt64(&p.count),
+		}
+		return true
+	})
+	return c
+}
+
+func storeEntries(m *sync.Map, c map[string]entry) {
+	for k, e := range c {
+		e := e
+		m.Store(k, &e)
+	}
+}
+
+// Snapshot returns a copy of the Sink's counters, timers, gauges,
+// distributions, up/down counters and float gauges including their call
+// counts. The snapshot is not affected by later flushes and can be restored
+// with RestoreSnapshot, which is useful for resetting the Sink to a baseline
+// between test cases.
+func (s *Sink) Snapshot() Snapshot {
+	p := s.sink()
+	return Snapshot{
+		counters:      copyEntries(&p.counters),
+		timers:        copyEntries(&p.timers),
+		gauges:        copyEntries(&p.gauges),
+		distributions: copyEntries(&p.distributions),
+		upDowns:       copyEntries(&p.upDowns),
+		floatGauges:   copyEntries(&p.floatGauges),
+	}
+}
+
+// RestoreSnapshot replaces the state of the Sink with snap. Like Reset it
+// is safe to call concurrently with flushes, but flushes that race with it
+// may be lost. History is not part of a Snapshot and is cleared.
+func (s *Sink) RestoreSnapshot(snap Snapshot) {
+	p := s.newSink()
+	storeEntries(&p.counters, snap.counters)
+	storeEntries(&p.timers, snap.timers)
+	storeEntries(&p.gauges, snap.gauges)
+	storeEntries(&p.distributions, snap.distributions)
+	storeEntries(&p.upDowns, snap.upDowns)
+	storeEntries(&p.floatGauges, snap.floatGauges)
+	s.sink() // make sure once has run so it does not overwrite the store
+	s.store.Store(p)
 }
 
 // FlushCounter implements the stats.Sink.FlushCounter method and adds val to
 // stat name.
 func (s *Sink) FlushCounter(name string, val uint64) {
-	counters := s.counters()
+	st := s.sink()
+	if st.history != nil {
+		st.history.counters.add(name, float64(val))
+	}
+	counters := &st.counters
 	v, ok := counters.Load(name)
 	if !ok {
 		v, _ = counters.LoadOrStore(name, new(entry))
@@ -68,7 +153,11 @@ func (s *Sink) FlushCounter(name string, val uint64) {
 // FlushGauge implements the stats.Sink.FlushGauge method and adds val to
 // stat name.
 func (s *Sink) FlushGauge(name string, val uint64) {
-	gauges := s.gauges()
+	st := s.sink()
+	if st.history != nil {
+		st.history.gauges.add(name, float64(val))
+	}
+	gauges := &st.gauges
 	v, ok := gauges.Load(name)
 	if !ok {
 		v, _ = gauges.LoadOrStore(name, new(entry))
@@ -93,7 +182,11 @@ func atomicAddFloat64(dest *uint64, delta float64) {
 // FlushTimer implements the stats.Sink.FlushTimer method and adds val to
 // stat name.
 func (s *Sink) FlushTimer(name string, val float64) {
-	timers := s.timers()
+	st := s.sink()
+	if st.history != nil {
+		st.history.timers.add(name, val)
+	}
+	timers := &st.timers
 	v, ok := timers.Load(name)
 	if !ok {
 		v, _ = timers.LoadOrStore(name, new(entry))
@@ -103,6 +196,45 @@ func (s *Sink) FlushTimer(name string, val float64) {
 	atomic.AddInt64(&p.count, 1)
 }
 
+// FlushDistribution implements the stats.DistributionSink.FlushDistribution
+// method and adds val to stat name.
+func (s *Sink) FlushDistribution(name string, val float64) {
+	distributions := s.distributions()
+	v, ok := distributions.Load(name)
+	if !ok {
+		v, _ = distributions.LoadOrStore(name, new(entry))
+	}
+	p := v.(*entry)
+	atomicAddFloat64(&p.val, val)
+	atomic.AddInt64(&p.count, 1)
+}
+
+// FlushUpDownCounter implements the stats.UpDownCounterSink.FlushUpDownCounter
+// method and adds val to stat name. Unlike gauges the value may be negative.
+func (s *Sink) FlushUpDownCounter(name string, val int64) {
+	upDowns := s.upDowns()
+	v, ok := upDowns.Load(name)
+	if !ok {
+		v, _ = upDowns.LoadOrStore(name, new(entry))
+	}
+	p := v.(*entry)
+	atomic.AddUint64(&p.val, uint64(val))
+	atomic.AddInt64(&p.count, 1)
+}
+
+// FlushFloatGauge implements the stats.FloatGaugeSink.FlushFloatGauge
+// method and adds val to stat name.
+func (s *Sink) FlushFloatGauge(name string, val float64) {
+	floatGauges := s.floatGauges()
+	v, ok := floatGauges.Load(name)
+	if !ok {
+		v, _ = floatGauges.LoadOrStore(name, new(entry))
+	}
+	p := v.(*entry)
+	atomicAddFloat64(&p.val, val)
+	atomic.AddInt64(&p.count, 1)
+}
+
 // LoadCounter returns the value for stat name and if it was found.
 func (s *Sink) LoadCounter(name string) (uint64, bool) {
 	v, ok := s.counters().Load(name)
@@ -134,6 +266,38 @@ func (s *Sink) LoadTimer(name string) (float64, bool) {
 	return 0, false
 }
 
+// LoadDistribution returns the value for stat name and if it was found.
+func (s *Sink) LoadDistribution(name string) (float64, bool) {
+	v, ok := s.distributions().Load(name)
+	if ok {
+		p := v.(*entry)
+		bits := atomic.LoadUint64(&p.val)
+		return math.Float64frombits(bits), true
+	}
+	return 0, false
+}
+
+// LoadUpDownCounter returns the value for stat name and if it was found.
+func (s *Sink) LoadUpDownCounter(name string) (int64, bool) {
+	v, ok := s.upDowns().Load(name)
+	if ok {
+		p := v.(*entry)
+		return int64(atomic.LoadUint64(&p.val)), true
+	}
+	return 0, false
+}
+
+// LoadFloatGauge returns the value for stat name and if it was found.
+func (s *Sink) LoadFloatGauge(name string) (float64, bool) {
+	v, ok := s.floatGauges().Load(name)
+	if ok {
+		p := v.(*entry)
+		bits := atomic.LoadUint64(&p.val)
+		return math.Float64frombits(bits), true
+	}
+	return 0, false
+}
+
 // ListCounters returns a list of existing counter names.
 func (s *Sink) ListCounters() []string {
 	return keys(s.counters())
@@ -149,6 +313,21 @@ func (s *Sink) ListTimers() []string {
 	return keys(s.timers())
 }
 
+// ListDistributions returns a list of existing distribution names.
+func (s *Sink) ListDistributions() []string {
+	return keys(s.distributions())
+}
+
+// ListUpDownCounters returns a list of existing up/down counter names.
+func (s *Sink) ListUpDownCounters() []string {
+	return keys(s.upDowns())
+}
+
+// ListFloatGauges returns a list of existing float gauge names.
+func (s *Sink) ListFloatGauges() []string {
+	return keys(s.floatGauges())
+}
+
 // Note, this may return an incoherent snapshot if contents is being concurrently modified
 func keys(m *sync.Map) (a []string) {
 	m.Range(func(key interface{}, _ interface{}) bool {
@@ -158,7 +337,9 @@ func keys(m *sync.Map) (a []string) {
 	return a
 }
 
-// Counters returns all the counters currently stored by the sink.
+// Counters returns a copy of all the counters currently stored by the
+// sink. The returned map is safe to modify and is not affected by later
+// flushes or calls to Reset.
 func (s *Sink) Counters() map[string]uint64 {
 	m := make(map[string]uint64)
 	s.counters().Range(func(k, v interface{}) bool {
@@ -169,7 +350,9 @@ func (s *Sink) Counters() map[string]uint64 {
 	return m
 }
 
-// Gauges returns all the gauges currently stored by the sink.
+// Gauges returns a copy of all the gauges currently stored by the
+// sink. The returned map is safe to modify and is not affected by later
+// flushes or calls to Reset.
 func (s *Sink) Gauges() map[string]uint64 {
 	m := make(map[string]uint64)
 	s.gauges().Range(func(k, v interface{}) bool {
@@ -180,7 +363,9 @@ func (s *Sink) Gauges() map[string]uint64 {
 	return m
 }
 
-// Timers returns all the timers currently stored by the sink.
+// Timers returns a copy of all the timers currently stored by the
+// sink. The returned map is safe to modify and is not affected by later
+// flushes or calls to Reset.
 func (s *Sink) Timers() map[string]float64 {
 	m := make(map[string]float64)
 	s.timers().Range(func(k, v interface{}) bool {
@@ -192,6 +377,47 @@ func (s *Sink) Timers() map[string]float64 {
 	return m
 }
 
+// Distributions returns a copy of all the distributions currently stored by the
+// sink. The returned map is safe to modify and is not affected by later
+// flushes or calls to Reset.
+func (s *Sink) Distributions() map[string]float64 {
+	m := make(map[string]float64)
+	s.distributions().Range(func(k, v interface{}) bool {
+		p := v.(*entry)
+		bits := atomic.LoadUint64(&p.val)
+		m[k.(string)] = math.Float64frombits(bits)
+		return true
+	})
+	return m
+}
+
+// UpDownCounters returns a copy of all the up/down counters currently stored
+// by the sink. The returned map is safe to modify and is not affected by
+// later flushes or calls to Reset.
+func (s *Sink) UpDownCounters() map[string]int64 {
+	m := make(map[string]int64)
+	s.upDowns().Range(func(k, v interface{}) bool {
+		p := v.(*entry)
+		m[k.(string)] = int64(atomic.LoadUint64(&p.val))
+		return true
+	})
+	return m
+}
+
+// FloatGauges returns a copy of all the float gauges currently stored by the
+// sink. The returned map is safe to modify and is not affected by later
+// flushes or calls to Reset.
+func (s *Sink) FloatGauges() map[string]float64 {
+	m := make(map[string]float64)
+	s.floatGauges().Range(func(k, v interface{}) bool {
+		p := v.(*entry)
+		bits := atomic.LoadUint64(&p.val)
+		m[k.(string)] = math.Float64frombits(bits)
+		return true
+	})
+	return m
+}
+
 // short-hand methods
 
 // Counter is shorthand for LoadCounter, zero is returned if the stat is not found.
@@ -212,6 +438,24 @@ func (s *Sink) Timer(name string) float64 {
 	return v
 }
 
+// Distribution is shorthand for LoadDistribution, zero is returned if the stat is not found.
+func (s *Sink) Distribution(name string) float64 {
+	v, _ := s.LoadDistribution(name)
+	return v
+}
+
+// UpDownCounter is shorthand for LoadUpDownCounter, zero is returned if the stat is not found.
+func (s *Sink) UpDownCounter(name string) int64 {
+	v, _ := s.LoadUpDownCounter(name)
+	return v
+}
+
+// FloatGauge is shorthand for LoadFloatGauge, zero is returned if the stat is not found.
+func (s *Sink) FloatGauge(name string) float64 {
+	v, _ := s.LoadFloatGauge(name)
+	return v
+}
+
 // these methods are mostly useful for testing
 
 // CounterCallCount returns the number of times stat name has been called/updated.
@@ -241,11 +485,74 @@ func (s *Sink) TimerCallCount(name string) int64 {
 	return 0
 }
 
+// DistributionCallCount returns the number of times stat name has been called/updated.
+func (s *Sink) DistributionCallCount(name string) int64 {
+	v, ok := s.distributions().Load(name)
+	if ok {
+		return atomic.LoadInt64(&v.(*entry).count)
+	}
+	return 0
+}
+
+// UpDownCounterCallCount returns the number of times stat name has been called/updated.
+func (s *Sink) UpDownCounterCallCount(name string) int64 {
+	v, ok := s.upDowns().Load(name)
+	if ok {
+		return atomic.LoadInt64(&v.(*entry).count)
+	}
+	return 0
+}
+
+// FloatGaugeCallCount returns the number of times stat name has been called/updated.
+func (s *Sink) FloatGaugeCallCount(name string) int64 {
+	v, ok := s.floatGauges().Load(name)
+	if ok {
+		return atomic.LoadInt64(&v.(*entry).count)
+	}
+	return 0
+}
+
 // test helpers
 
+// An AssertOption configures how the Sink Assert* methods look up a stat.
+type AssertOption interface {
+	apply(*assertOptions)
+}
+
+type assertOptions struct {
+	tags map[string]string
+}
+
+type assertOptionFunc func(*assertOptions)
+
+func (f assertOptionFunc) apply(o *assertOptions) { f(o) }
+
+// WithTags makes the Assert* methods look up the stat with name and tags,
+// so that stats with the same name and different tags can be told apart.
+//
+//	sink.AssertCounterEquals(tb, "requests", 1, mock.WithTags(map[string]string{"env": "prod"}))
+//
+func WithTags(tags map[string]string) AssertOption {
+	return assertOptionFunc(func(o *assertOptions) {
+		o.tags = tags
+	})
+}
+
+func assertName(name string, opts []AssertOption) string {
+	if len(opts) == 0 {
+		return name
+	}
+	var o assertOptions
+	for _, opt := range opts {
+		opt.apply(&o)
+	}
+	return tags.SerializeTags(name, o.tags)
+}
+
 // AssertCounterEquals asserts that Counter name is present and has value exp.
-func (s *Sink) AssertCounterEquals(tb testing.TB, name string, exp uint64) {
+func (s *Sink) AssertCounterEquals(tb testing.TB, name string, exp uint64, opts ...AssertOption) {
 	tb.Helper()
+	name = assertName(name, opts)
 	u, ok := s.LoadCounter(name)
 	if !ok {
 		tb.Errorf("gostats/mock: Counter (%q): not found in: %q", name, s.ListCounters())
@@ -257,8 +564,9 @@ func (s *Sink) AssertCounterEquals(tb testing.TB, name string, exp uint64) {
 }
 
 // AssertGaugeEquals asserts that Gauge name is present and has value exp.
-func (s *Sink) AssertGaugeEquals(tb testing.TB, name string, exp uint64) {
+func (s *Sink) AssertGaugeEquals(tb testing.TB, name string, exp uint64, opts ...AssertOption) {
 	tb.Helper()
+	name = assertName(name, opts)
 	u, ok := s.LoadGauge(name)
 	if !ok {
 		tb.Errorf("gostats/mock: Gauge (%q): not found in: %q", name, s.ListGauges())
@@ -270,8 +578,9 @@ func (s *Sink) AssertGaugeEquals(tb testing.TB, name string, exp uint64) {
 }
 
 // AssertTimerEquals asserts that Timer name is present and has value exp.
-func (s *Sink) AssertTimerEquals(tb testing.TB, name string, exp float64) {
+func (s *Sink) AssertTimerEquals(tb testing.TB, name string, exp float64, opts ...AssertOption) {
 	tb.Helper()
+	name = assertName(name, opts)
 	f, ok := s.LoadTimer(name)
 	if !ok {
 		tb.Errorf("gostats/mock: Timer (%q): not found in: %q", name, s.ListTimers())
@@ -282,57 +591,174 @@ func (s *Sink) AssertTimerEquals(tb testing.TB, name string, exp float64) {
 	}
 }
 
+// AssertDistributionEquals asserts that Distribution name is present and has value exp.
+func (s *Sink) AssertDistributionEquals(tb testing.TB, name string, exp float64, opts ...AssertOption) {
+	tb.Helper()
+	name = assertName(name, opts)
+	f, ok := s.LoadDistribution(name)
+	if !ok {
+		tb.Errorf("gostats/mock: Distribution (%q): not found in: %q", name, s.ListDistributions())
+		return
+	}
+	if f != exp {
+		tb.Errorf("gostats/mock: Distribution (%q): Expected: %f Got: %f", name, exp, f)
+	}
+}
+
+// AssertUpDownCounterEquals asserts that UpDownCounter name is present and has value exp.
+func (s *Sink) AssertUpDownCounterEquals(tb testing.TB, name string, exp int64, opts ...AssertOption) {
+	tb.Helper()
+	name = assertName(name, opts)
+	i, ok := s.LoadUpDownCounter(name)
+	if !ok {
+		tb.Errorf("gostats/mock: UpDownCounter (%q): not found in: %q", name, s.ListUpDownCounters())
+		return
+	}
+	if i != exp {
+		tb.Errorf("gostats/mock: UpDownCounter (%q): Expected: %d Got: %d", name, exp, i)
+	}
+}
+
+// AssertFloatGaugeEquals asserts that FloatGauge name is present and has value exp.
+func (s *Sink) AssertFloatGaugeEquals(tb testing.TB, name string, exp float64, opts ...AssertOption) {
+	tb.Helper()
+	name = assertName(name, opts)
+	f, ok := s.LoadFloatGauge(name)
+	if !ok {
+		tb.Errorf("gostats/mock: FloatGauge (%q): not found in: %q", name, s.ListFloatGauges())
+		return
+	}
+	if f != exp {
+		tb.Errorf("gostats/mock: FloatGauge (%q): Expected: %f Got: %f", name, exp, f)
+	}
+}
+
 // AssertCounterExists asserts that Counter name exists.
-func (s *Sink) AssertCounterExists(tb testing.TB, name string) {
+func (s *Sink) AssertCounterExists(tb testing.TB, name string, opts ...AssertOption) {
 	tb.Helper()
+	name = assertName(name, opts)
 	if _, ok := s.LoadCounter(name); !ok {
 		tb.Errorf("gostats/mock: Counter (%q): not found in: %q", name, s.ListCounters())
 	}
 }
 
 // AssertGaugeExists asserts that Gauge name exists.
-func (s *Sink) AssertGaugeExists(tb testing.TB, name string) {
+func (s *Sink) AssertGaugeExists(tb testing.TB, name string, opts ...AssertOption) {
 	tb.Helper()
+	name = assertName(name, opts)
 	if _, ok := s.LoadGauge(name); !ok {
 		tb.Errorf("gostats/mock: Gauge (%q): not found in: %q", name, s.ListGauges())
 	}
 }
 
 // AssertTimerExists asserts that Timer name exists.
-func (s *Sink) AssertTimerExists(tb testing.TB, name string) {
+func (s *Sink) AssertTimerExists(tb testing.TB, name string, opts ...AssertOption) {
 	tb.Helper()
+	name = assertName(name, opts)
 	if _, ok := s.LoadTimer(name); !ok {
 		tb.Errorf("gostats/mock: Timer (%q): not found in: %q", name, s.ListTimers())
 	}
 }
 
+// AssertDistributionExists asserts that Distribution name exists.
+func (s *Sink) AssertDistributionExists(tb testing.TB, name string, opts ...AssertOption) {
+	tb.Helper()
+	name = assertName(name, opts)
+	if _, ok := s.LoadDistribution(name); !ok {
+		tb.Errorf("gostats/mock: Distribution (%q): not found in: %q", name, s.ListDistributions())
+	}
+}
+
+// AssertUpDownCounterExists asserts that UpDownCounter name exists.
+func (s *Sink) AssertUpDownCounterExists(tb testing.TB, name string, opts ...AssertOption) {
+	tb.Helper()
+	name = assertName(name, opts)
+	if _, ok := s.LoadUpDownCounter(name); !ok {
+		tb.Errorf("gostats/mock: UpDownCounter (%q): not found in: %q", name, s.ListUpDownCounters())
+	}
+}
+
+// AssertFloatGaugeExists asserts that FloatGauge name exists.
+func (s *Sink) AssertFloatGaugeExists(tb testing.TB, name string, opts ...AssertOption) {
+	tb.Helper()
+	name = assertName(name, opts)
+	if _, ok := s.LoadFloatGauge(name); !ok {
+		tb.Errorf("gostats/mock: FloatGauge (%q): not found in: %q", name, s.ListFloatGauges())
+	}
+}
+
+// AssertTimerCalled asserts that Timer name was called at least once, like
+// AssertTimerExists.
+func (s *Sink) AssertTimerCalled(tb testing.TB, name string, opts ...AssertOption) {
+	tb.Helper()
+	s.AssertTimerExists(tb, name, opts...)
+}
+
+// AssertTimerCalledN asserts that Timer name was called exp times, like
+// AssertTimerCallCount.
+func (s *Sink) AssertTimerCalledN(tb testing.TB, name string, exp int, opts ...AssertOption) {
+	tb.Helper()
+	s.AssertTimerCallCount(tb, name, exp, opts...)
+}
+
 // AssertCounterNotExists asserts that Counter name does not exist.
-func (s *Sink) AssertCounterNotExists(tb testing.TB, name string) {
+func (s *Sink) AssertCounterNotExists(tb testing.TB, name string, opts ...AssertOption) {
 	tb.Helper()
+	name = assertName(name, opts)
 	if _, ok := s.LoadCounter(name); ok {
 		tb.Errorf("gostats/mock: Counter (%q): expected Counter to not exist", name)
 	}
 }
 
 // AssertGaugeNotExists asserts that Gauge name does not exist.
-func (s *Sink) AssertGaugeNotExists(tb testing.TB, name string) {
+func (s *Sink) AssertGaugeNotExists(tb testing.TB, name string, opts ...AssertOption) {
 	tb.Helper()
+	name = assertName(name, opts)
 	if _, ok := s.LoadGauge(name); ok {
 		tb.Errorf("gostats/mock: Gauge (%q): expected Gauge to not exist", name)
 	}
 }
 
 // AssertTimerNotExists asserts that Timer name does not exist.
-func (s *Sink) AssertTimerNotExists(tb testing.TB, name string) {
+func (s *Sink) AssertTimerNotExists(tb testing.TB, name string, opts ...AssertOption) {
 	tb.Helper()
+	name = assertName(name, opts)
 	if _, ok := s.LoadTimer(name); ok {
 		tb.Errorf("gostats/mock: Timer (%q): expected Timer to not exist", name)
 	}
 }
 
+// AssertDistributionNotExists asserts that Distribution name does not exist.
+func (s *Sink) AssertDistributionNotExists(tb testing.TB, name string, opts ...AssertOption) {
+	tb.Helper()
+	name = assertName(name, opts)
+	if _, ok := s.LoadDistribution(name); ok {
+		tb.Errorf("gostats/mock: Distribution (%q): expected Distribution to not exist", name)
+	}
+}
+
+// AssertUpDownCounterNotExists asserts that UpDownCounter name does not exist.
+func (s *Sink) AssertUpDownCounterNotExists(tb testing.TB, name string, opts ...AssertOption) {
+	tb.Helper()
+	name = assertName(name, opts)
+	if _, ok := s.LoadUpDownCounter(name); ok {
+		tb.Errorf("gostats/mock: UpDownCounter (%q): expected UpDownCounter to not exist", name)
+	}
+}
+
+// AssertFloatGaugeNotExists asserts that FloatGauge name does not exist.
+func (s *Sink) AssertFloatGaugeNotExists(tb testing.TB, name string, opts ...AssertOption) {
+	tb.Helper()
+	name = assertName(name, opts)
+	if _, ok := s.LoadFloatGauge(name); ok {
+		tb.Errorf("gostats/mock: FloatGauge (%q): expected FloatGauge to not exist", name)
+	}
+}
+
 // AssertCounterCallCount asserts that Counter name was called exp times.
-func (s *Sink) AssertCounterCallCount(tb testing.TB, name string, exp int) {
+func (s *Sink) AssertCounterCallCount(tb testing.TB, name string, exp int, opts ...AssertOption) {
 	tb.Helper()
+	name = assertName(name, opts)
 	v, ok := s.counters().Load(name)
 	if !ok {
 		tb.Errorf("gostats/mock: Counter (%q): not found in: %q", name, s.ListCounters())
@@ -347,8 +773,9 @@ func (s *Sink) AssertCounterCallCount(tb testing.TB, name string, exp int) {
 }
 
 // AssertGaugeCallCount asserts that Gauge name was called exp times.
-func (s *Sink) AssertGaugeCallCount(tb testing.TB, name string, exp int) {
+func (s *Sink) AssertGaugeCallCount(tb testing.TB, name string, exp int, opts ...AssertOption) {
 	tb.Helper()
+	name = assertName(name, opts)
 	v, ok := s.gauges().Load(name)
 	if !ok {
 		tb.Errorf("gostats/mock: Gauge (%q): not found in: %q", name, s.ListGauges())
@@ -363,8 +790,9 @@ func (s *Sink) AssertGaugeCallCount(tb testing.TB, name string, exp int) {
 }
 
 // AssertTimerCallCount asserts that Timer name was called exp times.
-func (s *Sink) AssertTimerCallCount(tb testing.TB, name string, exp int) {
+func (s *Sink) AssertTimerCallCount(tb testing.TB, name string, exp int, opts ...AssertOption) {
 	tb.Helper()
+	name = assertName(name, opts)
 	v, ok := s.timers().Load(name)
 	if !ok {
 		tb.Errorf("gostats/mock: Timer (%q): not found in: %q", name, s.ListTimers())
@@ -378,6 +806,101 @@ func (s *Sink) AssertTimerCallCount(tb testing.TB, name string, exp int) {
 	}
 }
 
+// AssertDistributionCallCount asserts that Distribution name was called exp times.
+func (s *Sink) AssertDistributionCallCount(tb testing.TB, name string, exp int, opts ...AssertOption) {
+	tb.Helper()
+	name = assertName(name, opts)
+	v, ok := s.distributions().Load(name)
+	if !ok {
+		tb.Errorf("gostats/mock: Distribution (%q): not found in: %q", name, s.ListDistributions())
+		return
+	}
+	p := v.(*entry)
+	n := atomic.LoadInt64(&p.count)
+	if n != int64(exp) {
+		tb.Errorf("gostats/mock: Distribution (%q) Call Count: Expected: %d Got: %d",
+			name, exp, n)
+	}
+}
+
+// AssertUpDownCounterCallCount asserts that UpDownCounter name was called exp times.
+func (s *Sink) AssertUpDownCounterCallCount(tb testing.TB, name string, exp int, opts ...AssertOption) {
+	tb.Helper()
+	name = assertName(name, opts)
+	v, ok := s.upDowns().Load(name)
+	if !ok {
+		tb.Errorf("gostats/mock: UpDownCounter (%q): not found in: %q", name, s.ListUpDownCounters())
+		return
+	}
+	p := v.(*entry)
+	n := atomic.LoadInt64(&p.count)
+	if n != int64(exp) {
+		tb.Errorf("gostats/mock: UpDownCounter (%q) Call Count: Expected: %d Got: %d",
+			name, exp, n)
+	}
+}
+
+// AssertFloatGaugeCallCount asserts that FloatGauge name was called exp times.
+func (s *Sink) AssertFloatGaugeCallCount(tb testing.TB, name string, exp int, opts ...AssertOption) {
+	tb.Helper()
+	name = assertName(name, opts)
+	v, ok := s.floatGauges().Load(name)
+	if !ok {
+		tb.Errorf("gostats/mock: FloatGauge (%q): not found in: %q", name, s.ListFloatGauges())
+		return
+	}
+	p := v.(*entry)
+	n := atomic.LoadInt64(&p.count)
+	if n != int64(exp) {
+		tb.Errorf("gostats/mock: FloatGauge (%q) Call Count: Expected: %d Got: %d",
+			name, exp, n)
+	}
+}
+
+// unexpected returns the sorted names in m that are not in allowed.
+func unexpected(m *sync.Map, allowed []string) []string {
+	ok := make(map[string]bool, len(allowed))
+	for _, name := range allowed {
+		ok[name] = true
+	}
+	var a []string
+	m.Range(func(k, _ interface{}) bool {
+		if name := k.(string); !ok[name] {
+			a = append(a, name)
+		}
+		return true
+	})
+	sort.Strings(a)
+	return a
+}
+
+// AssertNoUnexpectedCounters asserts that the only Counters flushed to the
+// Sink are those in allowed.
+func (s *Sink) AssertNoUnexpectedCounters(tb testing.TB, allowed ...string) {
+	tb.Helper()
+	if a := unexpected(s.counters(), allowed); len(a) != 0 {
+		tb.Errorf("gostats/mock: unexpected Counters: %q", a)
+	}
+}
+
+// AssertNoUnexpectedGauges asserts that the only Gauges flushed to the Sink
+// are those in allowed.
+func (s *Sink) AssertNoUnexpectedGauges(tb testing.TB, allowed ...string) {
+	tb.Helper()
+	if a := unexpected(s.gauges(), allowed); len(a) != 0 {
+		tb.Errorf("gostats/mock: unexpected Gauges: %q", a)
+	}
+}
+
+// AssertNoUnexpectedTimers asserts that the only Timers flushed to the Sink
+// are those in allowed.
+func (s *Sink) AssertNoUnexpectedTimers(tb testing.TB, allowed ...string) {
+	tb.Helper()
+	if a := unexpected(s.timers(), allowed); len(a) != 0 {
+		tb.Errorf("gostats/mock: unexpected Timers: %q", a)
+	}
+}
+
 var (
 	_ testing.TB = (*fatalTest)(nil)
 	_ testing.TB = (*fatalBench)(nil)
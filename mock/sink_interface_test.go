@@ -7,3 +7,7 @@ import (
 
 var _ stats.Sink = (*mock.Sink)(nil)
 var _ stats.FlushableSink = (*mock.Sink)(nil)
+var _ stats.DistributionSink = (*mock.Sink)(nil)
+var _ stats.UpDownCounterSink = (*mock.Sink)(nil)
+var _ stats.FloatGaugeSink = (*mock.Sink)(nil)
+var _ stats.Clock = (*mock.FakeClock)(nil)
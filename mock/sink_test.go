@@ -155,6 +155,107 @@ func TestSink(t *testing.T) {
 		}, "gostats/mock: Timer (%q): expected Timer to not exist", name)
 	}
 
+	testDistribution := func(t *testing.T, exp float64, sink *mock.Sink) {
+		const name = "test-distribution"
+		sink.FlushDistribution(name, exp)
+		sink.AssertDistributionExists(t, name)
+		sink.AssertDistributionEquals(t, name, exp)
+		sink.AssertDistributionCallCount(t, name, 1)
+		if n := sink.Distribution(name); n != exp {
+			t.Errorf("Distribution(): want: %f got: %f", exp, n)
+		}
+
+		const missing = name + "-MISSING"
+		sink.AssertDistributionNotExists(t, missing)
+
+		fns := []func(t testing.TB){
+			func(t testing.TB) { sink.AssertDistributionExists(t, missing) },
+			func(t testing.TB) { sink.AssertDistributionEquals(t, missing, 9999) },
+			func(t testing.TB) { sink.AssertDistributionCallCount(t, missing, 9999) },
+		}
+		for _, fn := range fns {
+			AssertErrorMsg(t, fn, "gostats/mock: Distribution (%q): not found in: [\"test-distribution\"]", missing)
+		}
+
+		AssertErrorMsg(t, func(t testing.TB) {
+			sink.AssertDistributionEquals(t, name, 9999)
+		}, "gostats/mock: Distribution (%q): Expected: %f Got: %f", name, 9999.0, exp)
+
+		AssertErrorMsg(t, func(t testing.TB) {
+			sink.AssertDistributionCallCount(t, name, 9999)
+		}, "gostats/mock: Distribution (%q) Call Count: Expected: %d Got: %d", name, 9999, 1)
+
+		AssertErrorMsg(t, func(t testing.TB) {
+			sink.AssertDistributionNotExists(t, name)
+		}, "gostats/mock: Distribution (%q): expected Distribution to not exist", name)
+	}
+
+	testUpDownCounter := func(t *testing.T, exp int64, sink *mock.Sink) {
+		const name = "test-updown"
+		sink.FlushUpDownCounter(name, exp)
+		sink.AssertUpDownCounterExists(t, name)
+		sink.AssertUpDownCounterEquals(t, name, exp)
+		sink.AssertUpDownCounterCallCount(t, name, 1)
+		if n := sink.UpDownCounter(name); n != exp {
+			t.Errorf("UpDownCounter(): want: %d got: %d", exp, n)
+		}
+
+		const missing = name + "-MISSING"
+		sink.AssertUpDownCounterNotExists(t, missing)
+
+		fns := []func(t testing.TB){
+			func(t testing.TB) { sink.AssertUpDownCounterExists(t, missing) },
+			func(t testing.TB) { sink.AssertUpDownCounterEquals(t, missing, 9999) },
+			func(t testing.TB) { sink.AssertUpDownCounterCallCount(t, missing, 9999) },
+		}
+		for _, fn := range fns {
+			AssertErrorMsg(t, fn, "gostats/mock: UpDownCounter (%q): not found in: [\"test-updown\"]", missing)
+		}
+
+		AssertErrorMsg(t, func(t testing.TB) {
+			sink.AssertUpDownCounterEquals(t, name, 9999)
+		}, "gostats/mock: UpDownCounter (%q): Expected: %d Got: %d", name, 9999, exp)
+
+		// the value may go negative without wrapping
+		sink.FlushUpDownCounter(name, -exp-5)
+		sink.AssertUpDownCounterEquals(t, name, -5)
+	}
+
+	testFloatGauge := func(t *testing.T, exp float64, sink *mock.Sink) {
+		const name = "test-float-gauge"
+		sink.FlushFloatGauge(name, exp)
+		sink.AssertFloatGaugeExists(t, name)
+		sink.AssertFloatGaugeEquals(t, name, exp)
+		sink.AssertFloatGaugeCallCount(t, name, 1)
+		if n := sink.FloatGauge(name); n != exp {
+			t.Errorf("FloatGauge(): want: %f got: %f", exp, n)
+		}
+
+		const missing = name + "-MISSING"
+		sink.AssertFloatGaugeNotExists(t, missing)
+
+		fns := []func(t testing.TB){
+			func(t testing.TB) { sink.AssertFloatGaugeExists(t, missing) },
+			func(t testing.TB) { sink.AssertFloatGaugeEquals(t, missing, 9999) },
+			func(t testing.TB) { sink.AssertFloatGaugeCallCount(t, missing, 9999) },
+		}
+		for _, fn := range fns {
+			AssertErrorMsg(t, fn, "gostats/mock: FloatGauge (%q): not found in: [\"test-float-gauge\"]", missing)
+		}
+
+		AssertErrorMsg(t, func(t testing.TB) {
+			sink.AssertFloatGaugeEquals(t, name, 9999)
+		}, "gostats/mock: FloatGauge (%q): Expected: %f Got: %f", name, 9999.0, exp)
+
+		AssertErrorMsg(t, func(t testing.TB) {
+			sink.AssertFloatGaugeCallCount(t, name, 9999)
+		}, "gostats/mock: FloatGauge (%q) Call Count: Expected: %d Got: %d", name, 9999, 1)
+
+		AssertErrorMsg(t, func(t testing.TB) {
+			sink.AssertFloatGaugeNotExists(t, name)
+		}, "gostats/mock: FloatGauge (%q): expected FloatGauge to not exist", name)
+	}
+
 	// test 0..1 - we want to make sure that 0 still registers a stat
 	for i := 0; i < 2; i++ {
 		t.Run("Counter", func(t *testing.T) {
@@ -166,11 +267,23 @@ func TestSink(t *testing.T) {
 		t.Run("Timer", func(t *testing.T) {
 			testTimer(t, float64(i), mock.NewSink())
 		})
+		t.Run("Distribution", func(t *testing.T) {
+			testDistribution(t, float64(i), mock.NewSink())
+		})
+		t.Run("UpDownCounter", func(t *testing.T) {
+			testUpDownCounter(t, int64(i), mock.NewSink())
+		})
+		t.Run("FloatGauge", func(t *testing.T) {
+			testFloatGauge(t, float64(i), mock.NewSink())
+		})
 		// all together now
 		sink := mock.NewSink()
 		testCounter(t, 1, sink)
 		testGauge(t, 1, sink)
 		testTimer(t, 1, sink)
+		testDistribution(t, 1, sink)
+		testUpDownCounter(t, 1, sink)
+		testFloatGauge(t, 1, sink)
 	}
 }
 
@@ -216,6 +329,35 @@ func TestSinkMap_Values(t *testing.T) {
 	}
 }
 
+// Test that the maps returned by Counters, Gauges, etc. are copies.
+func TestSinkMap_Copy(t *testing.T) {
+	sink := mock.NewSink()
+	sink.FlushCounter("counter", 1)
+	sink.FlushGauge("gauge", 1)
+	sink.FlushTimer("timer", 1)
+	sink.FlushDistribution("distribution", 1)
+
+	counters := sink.Counters()
+	gauges := sink.Gauges()
+	timers := sink.Timers()
+	distributions := sink.Distributions()
+
+	counters["counter"] = 2
+	gauges["other"] = 2
+	delete(timers, "timer")
+	distributions["distribution"] = 2
+	sink.AssertCounterEquals(t, "counter", 1)
+	sink.AssertNoUnexpectedGauges(t, "gauge")
+	sink.AssertTimerEquals(t, "timer", 1)
+	sink.AssertDistributionEquals(t, "distribution", 1)
+
+	sink.Reset()
+	sink.FlushCounter("counter", 3)
+	if counters["counter"] != 2 {
+		t.Errorf("Counters: map modified by Reset and flush: %v", counters)
+	}
+}
+
 // Test that the zero Sink is ready for use.
 func TestSinkLazyInit(t *testing.T) {
 	var s mock.Sink
@@ -223,6 +365,85 @@ func TestSinkLazyInit(t *testing.T) {
 	s.AssertCounterEquals(t, "counter", 1)
 }
 
+func TestSinkAssertWithTags(t *testing.T) {
+	prod := map[string]string{"env": "prod"}
+	staging := map[string]string{"env": "staging"}
+
+	sink := mock.NewSink()
+	sink.FlushCounter(mock.SerializeTags("counter", prod), 1)
+	sink.FlushCounter(mock.SerializeTags("counter", staging), 2)
+	sink.FlushGauge(mock.SerializeTags("gauge", prod), 3)
+	sink.FlushTimer(mock.SerializeTags("timer", prod), 4)
+	sink.FlushTimer(mock.SerializeTags("timer", prod), 5)
+
+	sink.AssertCounterEquals(t, "counter", 1, mock.WithTags(prod))
+	sink.AssertCounterEquals(t, "counter", 2, mock.WithTags(staging))
+	sink.AssertGaugeEquals(t, "gauge", 3, mock.WithTags(prod))
+	sink.AssertGaugeNotExists(t, "gauge", mock.WithTags(staging))
+	sink.AssertTimerCalled(t, "timer", mock.WithTags(prod))
+	sink.AssertTimerCalledN(t, "timer", 2, mock.WithTags(prod))
+
+	AssertErrorMsg(t, func(t testing.TB) {
+		sink.AssertCounterEquals(t, "counter", 2, mock.WithTags(prod))
+	}, "gostats/mock: Counter (%q): Expected: %d Got: %d", "counter.__env=prod", 2, 1)
+
+	AssertErrorMsg(t, func(t testing.TB) {
+		sink.AssertTimerCalled(t, "timer", mock.WithTags(staging))
+	}, "gostats/mock: Timer (%q): not found in: [\"timer.__env=prod\"]", "timer.__env=staging")
+}
+
+func TestSinkAssertNoUnexpected(t *testing.T) {
+	sink := mock.NewSink()
+	sink.FlushCounter("c1", 1)
+	sink.FlushCounter("c2", 1)
+	sink.FlushCounter("c3", 1)
+	sink.FlushGauge("g1", 1)
+	sink.FlushTimer("t1", 1)
+
+	sink.AssertNoUnexpectedCounters(t, "c1", "c2", "c3", "unused")
+	sink.AssertNoUnexpectedGauges(t, "g1")
+	sink.AssertNoUnexpectedTimers(t, "t1")
+
+	AssertErrorMsg(t, func(t testing.TB) {
+		sink.AssertNoUnexpectedCounters(t, "c2")
+	}, "gostats/mock: unexpected Counters: %q", []string{"c1", "c3"})
+	AssertErrorMsg(t, func(t testing.TB) {
+		sink.AssertNoUnexpectedGauges(t)
+	}, "gostats/mock: unexpected Gauges: %q", []string{"g1"})
+	AssertErrorMsg(t, func(t testing.TB) {
+		sink.AssertNoUnexpectedTimers(t, "c1")
+	}, "gostats/mock: unexpected Timers: %q", []string{"t1"})
+}
+
+func TestSinkSnapshot(t *testing.T) {
+	sink := mock.NewSink()
+	sink.FlushCounter("counter", 1)
+	sink.FlushGauge("gauge", 2)
+	sink.FlushTimer("timer", 3)
+	sink.FlushDistribution("distribution", 4)
+	snap := sink.Snapshot()
+
+	for i := 0; i < 2; i++ {
+		sink.FlushCounter("counter", 10)
+		sink.FlushCounter("other", 10)
+		sink.FlushTimer("timer", 10)
+
+		sink.RestoreSnapshot(snap)
+		sink.AssertCounterEquals(t, "counter", 1)
+		sink.AssertCounterCallCount(t, "counter", 1)
+		sink.AssertCounterNotExists(t, "other")
+		sink.AssertGaugeEquals(t, "gauge", 2)
+		sink.AssertTimerEquals(t, "timer", 3)
+		sink.AssertTimerCallCount(t, "timer", 1)
+		sink.AssertDistributionEquals(t, "distribution", 4)
+	}
+
+	// the zero Sink can restore a snapshot
+	var zero mock.Sink
+	zero.RestoreSnapshot(snap)
+	zero.AssertCounterEquals(t, "counter", 1)
+}
+
 func TestFlushTimer(t *testing.T) {
 	sink := mock.NewSink()
 	var exp float64
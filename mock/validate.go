@@ -0,0 +1,55 @@
+package mock
+
+import (
+	"fmt"
+
+	"github.com/lyft/gostats/internal/tags"
+)
+
+// ValidateStatName returns an error if name is empty or contains chars that
+// are not printable, non-whitespace ASCII.
+func ValidateStatName(name string) error {
+	if err := tags.ValidateName(name); err != nil {
+		return fmt.Errorf("gostats/mock: invalid stat name: %w", err)
+	}
+	return nil
+}
+
+// ValidateTagMap returns an error if any of the keys or values of the tags
+// of stat name are empty or contain chars that are not printable,
+// non-whitespace ASCII. Values must also not contain any of the chars
+// replaced during serialization ([.:|]).
+func ValidateTagMap(name string, tagMap map[string]string) error {
+	for k, v := range tagMap {
+		if err := tags.ValidateName(k); err != nil {
+			return fmt.Errorf("gostats/mock: stat %q: invalid tag key: %w", name, err)
+		}
+		if err := tags.ValidateName(v); err != nil {
+			return fmt.Errorf("gostats/mock: stat %q: invalid value of tag %q: %w", name, k, err)
+		}
+		if s := tags.ReplaceChars(v); s != v {
+			return fmt.Errorf("gostats/mock: stat %q: value of tag %q contains one of [.:|]: %q",
+				name, k, v)
+		}
+	}
+	return nil
+}
+
+// ValidateTagKeys returns an error if any of the keys of the tags of stat
+// name is not one of allowed. It lets tests catch new tag dimensions, which
+// increase the cardinality of the stats, before they reach a backend.
+func ValidateTagKeys(name string, tagMap map[string]string, allowed []string) error {
+	for k := range tagMap {
+		ok := false
+		for _, a := range allowed {
+			if k == a {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fmt.Errorf("gostats/mock: stat %q: tag key %q is not allowed", name, k)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package mock
+
+import "testing"
+
+func TestValidateStatName(t *testing.T) {
+	for _, name := range []string{"a", "a.b.c", "a_b-c"} {
+		if err := ValidateStatName(name); err != nil {
+			t.Errorf("%q: unexpected error: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "a b", "a\tb", "a\x00", "ü"} {
+		if err := ValidateStatName(name); err == nil {
+			t.Errorf("%q: expected an error", name)
+		}
+	}
+}
+
+func TestValidateTagMap(t *testing.T) {
+	valid := []map[string]string{
+		nil,
+		{"k": "v"},
+		{"k1": "v1", "k2": "v-2"},
+	}
+	for _, m := range valid {
+		if err := ValidateTagMap("name", m); err != nil {
+			t.Errorf("%v: unexpected error: %v", m, err)
+		}
+	}
+	invalid := []map[string]string{
+		{"": "v"},
+		{"k": ""},
+		{"k k": "v"},
+		{"k": "v v"},
+		{"k": "v.1"},
+		{"k": "v:1"},
+		{"k": "v|1"},
+	}
+	for _, m := range invalid {
+		if err := ValidateTagMap("name", m); err == nil {
+			t.Errorf("%v: expected an error", m)
+		}
+	}
+}
+
+func TestValidateTagKeys(t *testing.T) {
+	allowed := []string{"code", "method"}
+	for _, m := range []map[string]string{nil, {"code": "200"}, {"code": "200", "method": "get"}} {
+		if err := ValidateTagKeys("name", m, allowed); err != nil {
+			t.Errorf("%v: unexpected error: %v", m, err)
+		}
+	}
+	for _, m := range []map[string]string{{"user": "1"}, {"code": "200", "host": "a"}} {
+		if err := ValidateTagKeys("name", m, allowed); err == nil {
+			t.Errorf("%v: expected an error", m)
+		}
+	}
+	if err := ValidateTagKeys("name", map[string]string{"code": "200"}, nil); err == nil {
+		t.Error("expected an error with no allowed keys")
+	}
+}
@@ -0,0 +1,222 @@
+package stats
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+type multiSink struct {
+	sinks   []Sink
+	timeout time.Duration // if non-zero sinks are called by workers
+
+	workers   []*sinkWorker
+	stop      chan struct{} // closed by Close to stop the workers
+	closeOnce sync.Once
+}
+
+// multiSinkQueueSize is the number of calls queued for each sink of a
+// parallel multiSink.
+const multiSinkQueueSize = 1024
+
+// A sinkWorker calls a sink of a parallel multiSink from its own goroutine.
+type sinkWorker struct {
+	sink     Sink
+	calls    chan func(Sink)
+	dropping int32 // atomic, 1 once a call is dropped until one is queued
+}
+
+func (w *sinkWorker) run(stop <-chan struct{}) {
+	for {
+		select {
+		case fn := <-w.calls:
+			callSink(w.sink, fn)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// NewMultiSink returns a Sink that flushes stats to all of sinks. A panic in
+// one of the sinks is recovered and logged so that it does not prevent the
+// other sinks from receiving the stat.
+//
+// The returned Sink implements FlushableSink and DistributionSink, sinks
+// that do not implement them are skipped on Flush and receive distributions
+// as gauges.
+func NewMultiSink(sinks ...Sink) Sink {
+	return &multiSink{sinks: sinks}
+}
+
+// NewParallelMultiSink is like NewMultiSink, but each of sinks is called in
+// order from its own goroutine. Up to 1024 stats are queued for each sink,
+// once the queue of a sink is full its stats are dropped and a warning is
+// logged. Flush, Sync and Close wait at most timeout for the sinks to
+// process their queue. Sinks that do not return before the timeout continue
+// in the background.
+func NewParallelMultiSink(timeout time.Duration, sinks ...Sink) Sink {
+	m := &multiSink{sinks: sinks, timeout: timeout, stop: make(chan struct{})}
+	for _, sink := range sinks {
+		w := &sinkWorker{sink: sink, calls: make(chan func(Sink), multiSinkQueueSize)}
+		m.workers = append(m.workers, w)
+		go w.run(m.stop)
+	}
+	return m
+}
+
+func callSink(sink Sink, fn func(Sink)) {
+	defer func() {
+		if e := recover(); e != nil {
+			logger.Errorf("[gostats] recovered panic in sink %T: %v", sink, e)
+		}
+	}()
+	fn(sink)
+}
+
+// each calls fn with each sink, in parallel mode it queues the calls
+// without waiting for them.
+func (m *multiSink) each(fn func(Sink)) {
+	if m.timeout <= 0 {
+		for _, sink := range m.sinks {
+			callSink(sink, fn)
+		}
+		return
+	}
+	for _, w := range m.workers {
+		select {
+		case w.calls <- fn:
+			atomic.StoreInt32(&w.dropping, 0)
+		case <-m.stop:
+			return
+		default:
+			if atomic.CompareAndSwapInt32(&w.dropping, 0, 1) {
+				logger.Warnf("[gostats] multi sink: the queue of sink %T is full, dropping stats", w.sink)
+			}
+		}
+	}
+}
+
+// all is like each, but in parallel mode it waits at most m.timeout for the
+// calls, and those queued before them, to return.
+func (m *multiSink) all(fn func(Sink)) {
+	if m.timeout <= 0 {
+		m.each(fn)
+		return
+	}
+
+	expired := make(chan struct{})
+	timer := time.AfterFunc(m.timeout, func() { close(expired) })
+	defer timer.Stop()
+
+	done := make(chan struct{}, len(m.workers))
+	call := func(s Sink) {
+		defer func() { done <- struct{}{} }()
+		fn(s)
+	}
+	queued := 0
+	for _, w := range m.workers {
+		select {
+		case w.calls <- call:
+			queued++
+		case <-m.stop:
+			return
+		case <-expired:
+		}
+	}
+	timedOut := queued != len(m.workers)
+	for i := 0; i < queued && !timedOut; i++ {
+		select {
+		case <-done:
+		case <-expired:
+			timedOut = true
+		}
+	}
+	if timedOut {
+		logger.Warnf("[gostats] multi sink: timed out after %s waiting for sinks", m.timeout)
+	}
+}
+
+func (m *multiSink) FlushCounter(name string, value uint64) {
+	m.each(func(s Sink) { s.FlushCounter(name, value) })
+}
+
+func (m *multiSink) FlushGauge(name string, value uint64) {
+	m.each(func(s Sink) { s.FlushGauge(name, value) })
+}
+
+func (m *multiSink) FlushTimer(name string, value float64) {
+	m.each(func(s Sink) { s.FlushTimer(name, value) })
+}
+
+func (m *multiSink) FlushDistribution(name string, value float64) {
+	m.each(func(s Sink) { newDistributionSink(s).FlushDistribution(name, value) })
+}
+
+func (m *multiSink) FlushUpDownCounter(name string, value int64) {
+	m.each(func(s Sink) { newUpDownCounterSink(s).FlushUpDownCounter(name, value) })
+}
+
+func (m *multiSink) FlushFloatGauge(name string, value float64) {
+	m.each(func(s Sink) { newFloatGaugeSink(s).FlushFloatGauge(name, value) })
+}
+
+// Sync syncs each sink and returns the first error.
+func (m *multiSink) Sync() error {
+	var mu sync.Mutex
+	var first error
+	m.all(func(s Sink) {
+		if err := syncSink(s); err != nil {
+			mu.Lock()
+			if first == nil {
+				first = err
+			}
+			mu.Unlock()
+		}
+	})
+	mu.Lock() // sinks that timed out may still be running
+	defer mu.Unlock()
+	return first
+}
+
+// Close closes each sink that implements io.Closer and returns the first
+// error.
+func (m *multiSink) Close() error {
+	var mu sync.Mutex
+	var first error
+	m.all(func(s Sink) {
+		if c, ok := s.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				mu.Lock()
+				if first == nil {
+					first = err
+				}
+				mu.Unlock()
+			}
+		}
+	})
+	if m.stop != nil {
+		m.closeOnce.Do(func() { close(m.stop) })
+	}
+	mu.Lock() // sinks that timed out may still be running
+	defer mu.Unlock()
+	return first
+}
+
+func (m *multiSink) Flush() {
+	m.all(func(s Sink) {
+		if fs, ok := s.(FlushableSink); ok {
+			fs.Flush()
+		}
+	})
+}
+
+func (m *multiSink) SetHelp(name, help string) {
+	m.each(func(s Sink) {
+		if hs, ok := s.(HelpSink); ok {
+			hs.SetHelp(name, help)
+		}
+	})
+}
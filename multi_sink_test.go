@@ -0,0 +1,140 @@
+package stats
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/lyft/gostats/mock"
+)
+
+var (
+	_ FlushableSink     = (*multiSink)(nil)
+	_ DistributionSink  = (*multiSink)(nil)
+	_ UpDownCounterSink = (*multiSink)(nil)
+	_ Syncer            = (*multiSink)(nil)
+)
+
+type panicSink struct{}
+
+func (panicSink) FlushCounter(name string, value uint64) { panic("counter") }
+func (panicSink) FlushGauge(name string, value uint64)   { panic("gauge") }
+func (panicSink) FlushTimer(name string, value float64)  { panic("timer") }
+
+type syncSinkStub struct {
+	*mock.Sink
+	err    error
+	synced int
+}
+
+func (s *syncSinkStub) Sync() error {
+	s.synced++
+	return s.err
+}
+
+type blockingSink struct {
+	nullSink
+	unblock chan struct{}
+}
+
+func (s blockingSink) FlushCounter(name string, value uint64) { <-s.unblock }
+
+func testMultiSink(t *testing.T, newSink func(sinks ...Sink) Sink) {
+	s1 := mock.NewSink()
+	s2 := &testStatSink{}
+	s3 := mock.NewSink()
+	store := NewStore(newSink(s1, panicSink{}, s2, s3), false)
+
+	store.NewCounter("counter").Inc()
+	store.NewGauge("gauge").Set(2)
+	store.NewTimer("timer").AddValue(3)
+	store.NewDistribution("distribution").RecordValue(4)
+	store.Flush()
+
+	for _, sink := range []*mock.Sink{s1, s3} {
+		sink.AssertCounterEquals(t, "counter", 1)
+		sink.AssertGaugeEquals(t, "gauge", 2)
+		sink.AssertTimerEquals(t, "timer", 3)
+		sink.AssertDistributionEquals(t, "distribution", 4)
+	}
+
+	const expected = "timer:3.000000|ms\n" +
+		"distribution:4|g\n" +
+		"counter:1|c\n" +
+		"gauge:2|g\n"
+	if s2.record != expected {
+		t.Errorf("got: %q want: %q", s2.record, expected)
+	}
+}
+
+func TestMultiSink(t *testing.T) {
+	testMultiSink(t, NewMultiSink)
+}
+
+func TestParallelMultiSink(t *testing.T) {
+	testMultiSink(t, func(sinks ...Sink) Sink {
+		return NewParallelMultiSink(time.Second*5, sinks...)
+	})
+}
+
+func TestParallelMultiSink_Timeout(t *testing.T) {
+	unblock := make(chan struct{})
+	defer close(unblock)
+
+	fast := mock.NewSink()
+	sink := NewParallelMultiSink(time.Millisecond*10, blockingSink{unblock: unblock}, fast)
+
+	done := make(chan struct{})
+	go func() {
+		sink.FlushCounter("counter", 1)
+		sink.(FlushableSink).Flush()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Flush did not time out")
+	}
+	fast.AssertCounterEquals(t, "counter", 1)
+}
+
+func TestParallelMultiSink_BlockedSinkGoroutines(t *testing.T) {
+	unblock := make(chan struct{})
+	defer close(unblock)
+
+	before := runtime.NumGoroutine()
+	fast := mock.NewSink()
+	sink := NewParallelMultiSink(time.Millisecond*20, blockingSink{unblock: unblock}, fast)
+	for i := 0; i < multiSinkQueueSize*4; i++ {
+		sink.FlushCounter("counter", 1)
+		if i%(multiSinkQueueSize/4) == 0 {
+			sink.(FlushableSink).Flush()
+		}
+	}
+	sink.(FlushableSink).Flush()
+
+	// one worker per sink, the calls to the blocked sink are dropped
+	// instead of piling up
+	time.Sleep(time.Millisecond * 50) // let the timers of Flush return
+	if n := runtime.NumGoroutine() - before; n > 2 {
+		t.Errorf("goroutines: got: %d more want: at most 2", n)
+	}
+	fast.AssertCounterEquals(t, "counter", multiSinkQueueSize*4)
+}
+
+func TestMultiSink_Sync(t *testing.T) {
+	errSync := errors.New("sync failed")
+	s1 := &syncSinkStub{Sink: mock.NewSink()}
+	s2 := &syncSinkStub{Sink: mock.NewSink(), err: errSync}
+	s3 := &syncSinkStub{Sink: mock.NewSink(), err: errors.New("other")}
+	sink := NewMultiSink(s1, mock.NewSink(), s2, s3).(Syncer)
+	if err := sink.Sync(); err != errSync {
+		t.Errorf("Sync: got: %v want: %v", err, errSync)
+	}
+	for i, s := range []*syncSinkStub{s1, s2, s3} {
+		if s.synced != 1 {
+			t.Errorf("sink %d: synced %d times; want: 1", i, s.synced)
+		}
+	}
+}
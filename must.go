@@ -0,0 +1,30 @@
+package stats
+
+import (
+	"fmt"
+
+	tagspkg "github.com/lyft/gostats/internal/tags"
+)
+
+// mustValidateName panics if name is not a valid stat name, the names are
+// validated like mock.ValidateStatName.
+func mustValidateName(name string) {
+	if err := tagspkg.ValidateName(name); err != nil {
+		panic(fmt.Sprintf("gostats: invalid stat name: %v", err))
+	}
+}
+
+func mustNewCounter(scope Scope, name string) Counter {
+	mustValidateName(name)
+	return scope.NewCounter(name)
+}
+
+func mustNewGauge(scope Scope, name string) Gauge {
+	mustValidateName(name)
+	return scope.NewGauge(name)
+}
+
+func mustNewTimer(scope Scope, name string) Timer {
+	mustValidateName(name)
+	return scope.NewTimer(name)
+}
@@ -0,0 +1,43 @@
+package stats
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lyft/gostats/mock"
+)
+
+func TestMustNew(t *testing.T) {
+	sink := mock.NewSink()
+	stores := map[string]Store{
+		"statStore":          NewStore(sink, false),
+		"CardinalityLimiter": NewCardinalityLimiter(NewStore(sink, false), 0),
+		"ChildStore":         NewStore(sink, false).NewChildStore("child"),
+		"NullStore":          NullStore{},
+	}
+	for name, store := range stores {
+		store.MustNewCounter("c").Inc()
+		store.MustNewGauge("g").Set(1)
+		store.MustNewTimer("t").AddValue(1)
+
+		for _, statName := range []string{"", "a b", "é"} {
+			for kind, fn := range map[string]func(string){
+				"Counter": func(s string) { store.MustNewCounter(s) },
+				"Gauge":   func(s string) { store.MustNewGauge(s) },
+				"Timer":   func(s string) { store.MustNewTimer(s) },
+			} {
+				func() {
+					defer func() {
+						e := recover()
+						if e == nil {
+							t.Errorf("%s: MustNew%s(%q): expected panic", name, kind, statName)
+						} else if msg, _ := e.(string); !strings.HasPrefix(msg, "gostats: invalid stat name") {
+							t.Errorf("%s: MustNew%s(%q): unexpected panic: %v", name, kind, statName, e)
+						}
+					}()
+					fn(statName)
+				}()
+			}
+		}
+	}
+}
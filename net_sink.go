@@ -3,6 +3,7 @@ package stats
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"math"
 	"net"
@@ -89,6 +90,8 @@ func NewNetSink(opts ...SinkOption) FlushableSink {
 	s := &netSink{
 		// arbitrarily buffered
 		doFlush: make(chan chan struct{}, 8),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
 
 		// CEV: default to the standard logger to match the legacy implementation.
 		log: logger.StandardLogger(),
@@ -128,6 +131,9 @@ type netSink struct {
 	mu           sync.Mutex
 	bufWriter    *bufio.Writer
 	doFlush      chan chan struct{}
+	done         chan struct{} // closed by Close
+	stopped      chan struct{} // closed when run returns
+	closeOnce    sync.Once
 	droppedBytes uint64
 	log          *logger.Logger
 	conf         Settings
@@ -150,12 +156,61 @@ func (w *sinkWriter) Write(p []byte) (int, error) {
 }
 
 func (s *netSink) Flush() {
-	if s.flush() != nil {
-		return // nothing we can do
+	s.Sync() // nothing we can do about errors
+}
+
+// Sync flushes the buffered stats and waits for them to be written to the
+// connection.
+func (s *netSink) Sync() error {
+	select {
+	case <-s.stopped:
+		return errNetSinkClosed
+	default:
+	}
+	if err := s.flush(); err != nil {
+		return err
 	}
 	ch := make(chan struct{})
-	s.doFlush <- ch
-	<-ch
+	select {
+	case s.doFlush <- ch:
+	case <-s.stopped:
+		return errNetSinkClosed
+	}
+	select {
+	case <-ch:
+	case <-s.stopped:
+	}
+	return nil
+}
+
+// Close flushes the buffered stats, waits for them to be written and closes
+// the connection. Stats flushed to the sink after Close are dropped.
+func (s *netSink) Close() error {
+	select {
+	case <-s.stopped:
+		return nil
+	default:
+	}
+	err := s.Sync()
+	s.closeOnce.Do(func() { close(s.done) })
+	<-s.stopped
+	return err
+}
+
+// stop writes the pending buffers and closes the connection, it is called
+// by run before returning.
+func (s *netSink) stop() {
+	for n := len(s.outc); n > 0 && s.conn != nil; n-- {
+		buf := <-s.outc
+		s.writeToConn(buf)
+		putBuffer(buf)
+	}
+	if s.conn != nil {
+		_ = s.conn.Close()
+		s.conn = nil
+	}
+	close(s.stopped)
+	s.drainFlushQueue()
 }
 
 func (s *netSink) flush() error {
@@ -267,6 +322,59 @@ func (s *netSink) FlushTimer(name string, value float64) {
 	}
 }
 
+func (s *netSink) FlushDistribution(name string, value float64) {
+	if 0 <= value && value < math.MaxUint64 && math.Trunc(value) == value {
+		s.flushUint64(name, "|d\n", uint64(value))
+	} else {
+		s.flushFloat64(name, "|d\n", value)
+	}
+}
+
+// FlushUpDownCounter flushes value as a gauge. Since statsd interprets signed
+// gauge values as a change to the current value, negative values are sent as
+// a gauge of zero followed by the negative value.
+func (s *netSink) FlushUpDownCounter(name string, value int64) {
+	if value >= 0 {
+		s.flushUint64(name, "|g\n", uint64(value))
+		return
+	}
+	b := pbFree.Get().(*buffer)
+
+	b.WriteString(name)
+	b.WriteString(":0|g\n")
+	b.WriteString(name)
+	b.WriteString(":-")
+	b.WriteUnit64(uint64(-(value + 1)) + 1) // avoid overflowing math.MinInt64
+	b.WriteString("|g\n")
+
+	s.writeBuffer(b)
+
+	b.Reset()
+	pbFree.Put(b)
+}
+
+// FlushFloatGauge flushes value as a gauge, negative values are sent like
+// in FlushUpDownCounter.
+func (s *netSink) FlushFloatGauge(name string, value float64) {
+	if value >= 0 || math.IsNaN(value) {
+		s.flushFloat64(name, "|g\n", value)
+		return
+	}
+	b := pbFree.Get().(*buffer)
+
+	b.WriteString(name)
+	b.WriteString(":0|g\n")
+	b.WriteString(name)
+	b.WriteChar(':')
+	b.WriteFloat64(value)
+	b.WriteString("|g\n")
+
+	s.writeBuffer(b)
+
+	b.Reset()
+	pbFree.Put(b)
+}
+
 func (s *netSink) run() {
 	addr := net.JoinHostPort(s.conf.StatsdHost, strconv.Itoa(s.conf.StatsdPort))
 
@@ -287,7 +395,12 @@ func (s *netSink) run() {
 				reconnectFailed = true
 
 				// TODO (CEV): don't sleep on the first retry
-				time.Sleep(defaultRetryInterval)
+				select {
+				case <-time.After(defaultRetryInterval):
+				case <-s.done:
+					s.stop()
+					return
+				}
 				continue
 			}
 			reconnectFailed = false
@@ -313,6 +426,9 @@ func (s *netSink) run() {
 		case buf := <-s.outc:
 			s.writeToConn(buf)
 			putBuffer(buf)
+		case <-s.done:
+			s.stop()
+			return
 		}
 	}
 }
@@ -346,6 +462,8 @@ func (s *netSink) connect(address string) error {
 	return err
 }
 
+var errNetSinkClosed = errors.New("gostats: net sink is closed")
+
 var bufferPool sync.Pool
 
 func getBuffer() *bytes.Buffer {
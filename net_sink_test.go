@@ -21,6 +21,8 @@ import (
 	logger "github.com/sirupsen/logrus"
 )
 
+var _ Syncer = (*netSink)(nil)
+
 type testStatSink struct {
 	sync.Mutex
 	record string
@@ -588,6 +590,14 @@ func testNetSinkStatTypes(t *testing.T, protocol string) {
 		"gauge:1|g\n",
 		"timer_int:1|ms\n",
 		"timer_float:1.230000|ms\n",
+		"distribution_int:1|d\n",
+		"distribution_float:1.230000|d\n",
+		"updown_pos:2|g\n",
+		"updown_neg:0|g\n",
+		"updown_neg:-3|g\n",
+		"float_gauge_pos:1.500000|g\n",
+		"float_gauge_neg:0|g\n",
+		"float_gauge_neg:-2.500000|g\n",
 	}
 
 	ts, sink := setupTestNetSink(t, protocol, false)
@@ -597,6 +607,12 @@ func testNetSinkStatTypes(t *testing.T, protocol string) {
 	sink.FlushGauge("gauge", 1)
 	sink.FlushTimer("timer_int", 1)
 	sink.FlushTimer("timer_float", 1.23)
+	sink.FlushDistribution("distribution_int", 1)
+	sink.FlushDistribution("distribution_float", 1.23)
+	sink.FlushUpDownCounter("updown_pos", 2)
+	sink.FlushUpDownCounter("updown_neg", -3)
+	sink.FlushFloatGauge("float_gauge_pos", 1.5)
+	sink.FlushFloatGauge("float_gauge_neg", -2.5)
 	sink.Flush()
 
 	for _, exp := range expected {
@@ -879,3 +895,28 @@ func BenchmarkFlushTimer(b *testing.B) {
 		sink.FlushTimer("TestTImer.___f=i.__tag1=v1", float64(i)/3)
 	}
 }
+
+func TestNetSink_Close(t *testing.T) {
+	const expected = "counter:1|c\n"
+
+	ts, sink := setupTestNetSink(t, "tcp", false)
+	defer ts.Close()
+
+	sink.FlushCounter("counter", 1)
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close: unexpected error: %v", err)
+	}
+	stat := ts.WaitForStat(t, time.Millisecond*50)
+	if stat != expected {
+		t.Errorf("stats got: %q want: %q", stat, expected)
+	}
+
+	// the sink does not block once closed
+	if err := sink.Sync(); err != errNetSinkClosed {
+		t.Errorf("Sync: got: %v want: %v", err, errNetSinkClosed)
+	}
+	sink.Flush()
+	if err := sink.Close(); err != nil {
+		t.Errorf("Close: unexpected error: %v", err)
+	}
+}
@@ -13,4 +13,10 @@ func (s nullSink) FlushGauge(name string, value uint64) {}
 
 func (s nullSink) FlushTimer(name string, value float64) {}
 
+func (s nullSink) FlushDistribution(name string, value float64) {}
+
+func (s nullSink) FlushUpDownCounter(name string, value int64) {}
+
+func (s nullSink) FlushFloatGauge(name string, value float64) {}
+
 func (s nullSink) Flush() {}
@@ -0,0 +1,96 @@
+package stats
+
+import "time"
+
+// No-op implementations of the stat interfaces. They are zero size so
+// returning them does not allocate.
+
+type nullCounter struct{}
+
+func (nullCounter) Add(uint64)     {}
+func (nullCounter) Inc()           {}
+func (nullCounter) Set(uint64)     {}
+func (nullCounter) String() string { return "0" }
+func (nullCounter) Value() uint64  { return 0 }
+
+type nullGauge struct{}
+
+func (nullGauge) Add(uint64)     {}
+func (nullGauge) Sub(uint64)     {}
+func (nullGauge) Inc()           {}
+func (nullGauge) Dec()           {}
+func (nullGauge) Set(uint64)     {}
+func (nullGauge) String() string { return "0" }
+func (nullGauge) Value() uint64  { return 0 }
+
+type nullGaugeMin struct{}
+
+func (nullGaugeMin) UpdateMin(uint64) {}
+func (nullGaugeMin) Value() uint64    { return 0 }
+
+type nullGaugeMax struct{}
+
+func (nullGaugeMax) UpdateMax(uint64) {}
+func (nullGaugeMax) Value() uint64    { return 0 }
+
+type nullRateGauge struct{}
+
+func (nullRateGauge) Add(uint64)    {}
+func (nullRateGauge) Set(uint64)    {}
+func (nullRateGauge) Value() uint64 { return 0 }
+
+type nullUpDownCounter struct{}
+
+func (nullUpDownCounter) Add(int64)    {}
+func (nullUpDownCounter) Inc()         {}
+func (nullUpDownCounter) Dec()         {}
+func (nullUpDownCounter) Value() int64 { return 0 }
+
+type nullWindowedCounter struct{}
+
+func (nullWindowedCounter) Add(uint64)    {}
+func (nullWindowedCounter) Inc()          {}
+func (nullWindowedCounter) Rate() float64 { return 0 }
+func (nullWindowedCounter) Total() uint64 { return 0 }
+
+type nullFloatGauge struct{}
+
+func (nullFloatGauge) Set(float64)    {}
+func (nullFloatGauge) Add(float64)    {}
+func (nullFloatGauge) Sub(float64)    {}
+func (nullFloatGauge) Value() float64 { return 0 }
+
+type nullTimer struct{}
+
+func (nullTimer) AddValue(float64) {}
+
+func (nullTimer) AllocateSpan() Timespan { return nullTimespan{} }
+
+func (nullTimer) RecordDuration(time.Duration) {}
+
+func (t nullTimer) Start() TimerContext {
+	return TimerContext{timer: t, start: time.Now()}
+}
+
+func (t nullTimer) Time(f func()) Timer {
+	f()
+	return t
+}
+
+// nullTimespan does not measure the span, Complete always returns 0.
+type nullTimespan struct{}
+
+func (nullTimespan) Complete() time.Duration            { return 0 }
+func (nullTimespan) CompleteWithDuration(time.Duration) {}
+
+type nullHistogram struct{}
+
+func (nullHistogram) RecordValue(float64) {}
+
+type nullDistribution struct{}
+
+func (nullDistribution) RecordValue(float64) {}
+
+type nullSummary struct{}
+
+func (nullSummary) RecordValue(float64) {}
@@ -0,0 +1,239 @@
+package stats
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// NullStore is a Store that discards all stats. The zero value is ready to
+// use and none of its methods allocate, which makes it a safe default for
+// libraries that accept an optional Store:
+//
+//	var store stats.Store = stats.NullStore{}
+type NullStore struct {
+	NullScope
+}
+
+var _ Store = NullStore{}
+
+func (NullStore) Flush() {}
+
+func (NullStore) FlushAll() error { return nil }
+
+func (NullStore) Close() error { return nil }
+
+func (NullStore) NewChildStore(string) Store { return NullStore{} }
+
+func (NullStore) NewAliasedCounter(...string) Counter { return nullCounter{} }
+
+func (NullStore) NewAliasedGauge(...string) Gauge { return nullGauge{} }
+
+func (NullStore) NewAliasedTimer(...string) Timer { return nullTimer{} }
+
+func (NullStore) MustNewCounter(name string) Counter { return mustNewCounter(NullScope{}, name) }
+
+func (NullStore) MustNewGauge(name string) Gauge { return mustNewGauge(NullScope{}, name) }
+
+func (NullStore) MustNewTimer(name string) Timer { return mustNewTimer(NullScope{}, name) }
+
+func (NullStore) NewLazyCounter(name string) Counter { return nullCounter{} }
+
+func (NullStore) NewCounterVec(name string, labelNames []string) CounterVec {
+	return newCounterVec(NullScope{}, name, labelNames)
+}
+
+func (NullStore) NewGaugeVec(name string, labelNames []string) GaugeVec {
+	return newGaugeVec(NullScope{}, name, labelNames)
+}
+
+func (NullStore) NewTimerVec(name string, labelNames []string) TimerVec {
+	return newTimerVec(NullScope{}, name, labelNames)
+}
+
+func (NullStore) RecordBatch(batch []MetricRecord) {
+	recordBatch(NullScope{}, batch)
+}
+
+// Start returns immediately, there is nothing to flush.
+func (NullStore) Start(*time.Ticker) {}
+
+func (NullStore) AddStatGenerator(StatGenerator) {}
+
+func (NullStore) AddStatGeneratorWithPriority(StatGenerator, int) {}
+
+func (NullStore) SetStatGeneratorConcurrency(int) {}
+
+func (NullStore) SetTagsFromContext(TagsFromContext) {}
+
+func (NullStore) Unregister(string) bool { return false }
+
+func (NullStore) UnregisterWithTags(string, map[string]string) bool { return false }
+
+func (NullStore) UnregisterPrefix(string) int { return 0 }
+
+func (NullStore) ListCounters() []string { return nil }
+
+func (NullStore) ListGauges() []string { return nil }
+
+func (NullStore) ListTimers() []string { return nil }
+
+func (NullStore) ListMetricDescriptions() []MetricDescription { return nil }
+
+func (NullStore) Snapshot() Snapshot { return Snapshot{} }
+
+func (s NullStore) SnapshotHandler() http.Handler { return snapshotHandler{store: s} }
+
+// Watch returns a closed channel, a NullStore never emits events.
+func (NullStore) Watch(context.Context) <-chan MetricEvent {
+	ch := make(chan MetricEvent)
+	close(ch)
+	return ch
+}
+
+// NullScope is a Scope that discards all stats, see NullStore.
+type NullScope struct{}
+
+var _ Scope = NullScope{}
+
+func (NullScope) Scope(string) Scope { return NullScope{} }
+
+func (NullScope) ScopeWithTags(string, map[string]string) Scope { return NullScope{} }
+
+func (NullScope) ScopeWithOptions(string, ScopeOptions) Scope { return NullScope{} }
+
+func (NullScope) Parent() Scope { return nil }
+
+func (NullScope) Name() string { return "" }
+
+func (NullScope) FullName() string { return "" }
+
+func (NullScope) AsStore() Store { return NullStore{} }
+
+func (NullScope) WithOptions(...StatOption) Scope { return NullScope{} }
+
+func (NullScope) WithTag(string, string) Scope { return NullScope{} }
+
+func (NullScope) Store() Store { return NullStore{} }
+
+func (NullScope) NewCounter(string) Counter { return nullCounter{} }
+
+func (NullScope) NewCounterWithTags(string, map[string]string) Counter { return nullCounter{} }
+
+func (NullScope) NewPerInstanceCounter(string, map[string]string) Counter { return nullCounter{} }
+
+func (NullScope) NewGauge(string) Gauge { return nullGauge{} }
+
+func (NullScope) NewGaugeWithTags(string, map[string]string) Gauge { return nullGauge{} }
+
+func (NullScope) NewPerInstanceGauge(string, map[string]string) Gauge { return nullGauge{} }
+
+func (NullScope) NewTimer(string) Timer { return nullTimer{} }
+
+func (NullScope) NewTimerWithTags(string, map[string]string) Timer { return nullTimer{} }
+
+func (NullScope) NewPerInstanceTimer(string, map[string]string) Timer { return nullTimer{} }
+
+func (NullScope) NewCounterCtx(context.Context, string) Counter { return nullCounter{} }
+
+func (NullScope) NewCounterWithTagsCtx(context.Context, string, map[string]string) Counter {
+	return nullCounter{}
+}
+
+func (NullScope) NewGaugeCtx(context.Context, string) Gauge { return nullGauge{} }
+
+func (NullScope) NewGaugeWithTagsCtx(context.Context, string, map[string]string) Gauge {
+	return nullGauge{}
+}
+
+func (NullScope) NewTimerCtx(context.Context, string) Timer { return nullTimer{} }
+
+func (NullScope) NewTimerWithTagsCtx(context.Context, string, map[string]string) Timer {
+	return nullTimer{}
+}
+
+func (NullScope) NewHistogram(string) Histogram { return nullHistogram{} }
+
+func (NullScope) NewHistogramWithTags(string, map[string]string) Histogram {
+	return nullHistogram{}
+}
+
+func (NullScope) NewHistogramWithOptions(string, map[string]string, HistogramOptions) Histogram {
+	return nullHistogram{}
+}
+
+func (NullScope) NewDistribution(string) Distribution { return nullDistribution{} }
+
+func (NullScope) NewDistributionWithTags(string, map[string]string) Distribution {
+	return nullDistribution{}
+}
+
+func (NullScope) NewPerInstanceDistribution(string, map[string]string) Distribution {
+	return nullDistribution{}
+}
+
+func (NullScope) NewSummary(string, SummaryOptions) Summary { return nullSummary{} }
+
+func (NullScope) NewSummaryWithTags(string, map[string]string, SummaryOptions) Summary {
+	return nullSummary{}
+}
+
+func (NullScope) NewMinGauge(string) GaugeMin { return nullGaugeMin{} }
+
+func (NullScope) NewMinGaugeWithTags(string, map[string]string) GaugeMin { return nullGaugeMin{} }
+
+func (NullScope) NewMaxGauge(string) GaugeMax { return nullGaugeMax{} }
+
+func (NullScope) NewMaxGaugeWithTags(string, map[string]string) GaugeMax { return nullGaugeMax{} }
+
+func (NullScope) NewRateGauge(string, RateGaugeOptions) RateGauge { return nullRateGauge{} }
+
+func (NullScope) NewRateGaugeWithTags(string, map[string]string, RateGaugeOptions) RateGauge {
+	return nullRateGauge{}
+}
+
+func (NullScope) NewUpDownCounter(string) UpDownCounter { return nullUpDownCounter{} }
+
+func (NullScope) NewUpDownCounterWithTags(string, map[string]string) UpDownCounter {
+	return nullUpDownCounter{}
+}
+
+// NewSequenceCounter returns a SequenceCounter that is not flushed. Unlike
+// the other stats of a NullScope it must allocate to keep its sequence.
+func (NullScope) NewSequenceCounter(string) SequenceCounter {
+	return sequenceCounter{new(counter)}
+}
+
+func (NullScope) NewSequenceCounterWithTags(string, map[string]string) SequenceCounter {
+	return sequenceCounter{new(counter)}
+}
+
+func (NullScope) NewWindowedCounter(string, WindowedCounterOptions) WindowedCounter {
+	return nullWindowedCounter{}
+}
+
+func (NullScope) NewWindowedCounterWithTags(string, map[string]string, WindowedCounterOptions) WindowedCounter {
+	return nullWindowedCounter{}
+}
+
+// NewEWMAGauge panics if alpha is out of range, like the Gauges of other
+// Stores.
+func (NullScope) NewEWMAGauge(_ string, alpha float64) Gauge {
+	checkEWMAAlpha(alpha)
+	return nullGauge{}
+}
+
+func (NullScope) NewEWMAGaugeWithTags(_ string, _ map[string]string, alpha float64) Gauge {
+	checkEWMAAlpha(alpha)
+	return nullGauge{}
+}
+
+func (NullScope) NewFloatGauge(string) FloatGauge { return nullFloatGauge{} }
+
+func (NullScope) NewFloatGaugeWithTags(string, map[string]string) FloatGauge {
+	return nullFloatGauge{}
+}
+
+func (NullScope) NewPerInstanceFloatGauge(string, map[string]string) FloatGauge {
+	return nullFloatGauge{}
+}
@@ -0,0 +1,54 @@
+package stats
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNullStoreAllocs(t *testing.T) {
+	var store Store = NullStore{}
+	tags := map[string]string{"k": "v"}
+	ctx := context.Background()
+
+	allocs := testing.AllocsPerRun(100, func() {
+		scope := store.Scope("a").ScopeWithTags("b", tags)
+		scope.NewCounter("c").Inc()
+		scope.NewCounterWithTagsCtx(ctx, "c", tags).Add(1)
+		scope.NewGaugeWithTags("g", tags).Set(1)
+		scope.NewPerInstanceGauge("g", tags).Dec()
+		scope.NewTimer("t").AddValue(1)
+		scope.NewTimer("t").AllocateSpan().Complete()
+		scope.NewTimer("t").Start().Stop()
+		scope.NewHistogram("h").RecordValue(1)
+		scope.NewDistribution("d").RecordValue(1)
+		scope.NewSummary("s", SummaryOptions{}).RecordValue(1)
+		scope.NewMinGauge("min").UpdateMin(1)
+		scope.NewMaxGauge("max").UpdateMax(1)
+		scope.NewRateGauge("rate", RateGaugeOptions{}).Add(1)
+		scope.NewUpDownCounter("ud").Add(-1)
+		scope.Store().Flush()
+	})
+	if allocs != 0 {
+		t.Errorf("allocs: got: %.1f want: 0", allocs)
+	}
+}
+
+func TestNullStoreValues(t *testing.T) {
+	var store NullStore
+	c := store.NewCounter("c")
+	c.Add(10)
+	if v := c.Value(); v != 0 {
+		t.Errorf("Counter.Value: got: %d want: 0", v)
+	}
+	if s := c.String(); s != "0" {
+		t.Errorf("Counter.String: got: %q want: %q", s, "0")
+	}
+	g := store.NewGauge("g")
+	g.Set(10)
+	if v := g.Value(); v != 0 {
+		t.Errorf("Gauge.Value: got: %d want: 0", v)
+	}
+	if _, ok := store.Scope("s").Store().(NullStore); !ok {
+		t.Errorf("Scope.Store: got: %T want: %T", store.Scope("s").Store(), NullStore{})
+	}
+}
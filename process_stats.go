@@ -0,0 +1,78 @@
+package stats
+
+import "sync"
+
+// processStats are the resource usage stats of the process.
+type processStats struct {
+	cpuTimeMs uint64 // user and system CPU time in milliseconds
+	rss       uint64 // resident set size in bytes
+	vmSize    uint64 // virtual memory size in bytes
+}
+
+// A ProcessStatsGenerator is a StatGenerator that reports the CPU and memory
+// usage of the process, see NewProcessStatsGenerator.
+type ProcessStatsGenerator struct {
+	prefix string
+
+	mu        sync.Mutex
+	cpuTimeMs Gauge // nil if not supported by the platform
+	rss       Gauge // nil until bound
+	vmSize    Gauge
+}
+
+// NewProcessStatsGenerator returns a StatGenerator that reports the CPU time
+// (user and system) of the process in milliseconds as the Gauge "cpuTimeMs",
+// and its resident set size and virtual memory size in bytes as the Gauges
+// "rss" and "vmSize", under prefix.
+//
+// On Linux the stats are read from /proc/self/stat and /proc/self/statm. On
+// other platforms the memory stats are estimated from runtime.ReadMemStats and
+// the CPU time is not reported. The stats are created in the Store the
+// generator is added to with AddStatGenerator, if it is added to multiple
+// Stores only the first one is used.
+func NewProcessStatsGenerator(prefix string) *ProcessStatsGenerator {
+	return &ProcessStatsGenerator{prefix: prefix}
+}
+
+func (p *ProcessStatsGenerator) bindScope(scope Scope) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.rss != nil {
+		return
+	}
+	if p.prefix != "" {
+		scope = scope.Scope(p.prefix)
+	}
+	if processCPUTimeSupported {
+		p.cpuTimeMs = scope.NewGauge("cpuTimeMs")
+	}
+	p.rss = scope.NewGauge("rss")
+	p.vmSize = scope.NewGauge("vmSize")
+}
+
+// GenerateStats implements the StatGenerator interface. It does nothing if
+// the generator was not added to a Store or the stats could not be read.
+func (p *ProcessStatsGenerator) GenerateStats() {
+	p.TryGenerateStats()
+}
+
+// TryGenerateStats implements the ErrorAwareStatGenerator interface and
+// returns the error reading the stats, if any.
+func (p *ProcessStatsGenerator) TryGenerateStats() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.rss == nil {
+		return nil
+	}
+
+	ps, err := readProcessStats()
+	if err != nil {
+		return err
+	}
+	if p.cpuTimeMs != nil {
+		p.cpuTimeMs.Set(ps.cpuTimeMs)
+	}
+	p.rss.Set(ps.rss)
+	p.vmSize.Set(ps.vmSize)
+	return nil
+}
@@ -0,0 +1,76 @@
+// +build linux
+
+package stats
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+)
+
+const processCPUTimeSupported = true
+
+// clockTicks is the number of clock ticks per second used by /proc/self/stat
+// (USER_HZ), it is 100 on all supported architectures.
+const clockTicks = 100
+
+var errProcFormat = errors.New("stats: unexpected /proc format")
+
+func readProcessStats() (processStats, error) {
+	var ps processStats
+	stat, err := ioutil.ReadFile("/proc/self/stat")
+	if err != nil {
+		return ps, err
+	}
+	if ps.cpuTimeMs, err = parseProcStat(stat); err != nil {
+		return ps, err
+	}
+	statm, err := ioutil.ReadFile("/proc/self/statm")
+	if err != nil {
+		return ps, err
+	}
+	ps.vmSize, ps.rss, err = parseProcStatm(statm, uint64(os.Getpagesize()))
+	return ps, err
+}
+
+// parseProcStat returns the user and system CPU time in milliseconds of the
+// contents of /proc/[pid]/stat.
+func parseProcStat(b []byte) (uint64, error) {
+	// The command name (field 2) is in parentheses and may contain spaces,
+	// the fields after it start with the state (field 3).
+	i := bytes.LastIndexByte(b, ')')
+	if i < 0 {
+		return 0, errProcFormat
+	}
+	fields := bytes.Fields(b[i+1:])
+	if len(fields) < 13 {
+		return 0, errProcFormat
+	}
+	utime, err := strconv.ParseUint(string(fields[11]), 10, 64) // field 14
+	if err != nil {
+		return 0, err
+	}
+	stime, err := strconv.ParseUint(string(fields[12]), 10, 64) // field 15
+	if err != nil {
+		return 0, err
+	}
+	return (utime + stime) * 1000 / clockTicks, nil
+}
+
+// parseProcStatm returns the virtual memory size and the resident set size in
+// bytes of the contents of /proc/[pid]/statm.
+func parseProcStatm(b []byte, pageSize uint64) (vmSize, rss uint64, err error) {
+	fields := bytes.Fields(b)
+	if len(fields) < 2 {
+		return 0, 0, errProcFormat
+	}
+	if vmSize, err = strconv.ParseUint(string(fields[0]), 10, 64); err != nil {
+		return 0, 0, err
+	}
+	if rss, err = strconv.ParseUint(string(fields[1]), 10, 64); err != nil {
+		return 0, 0, err
+	}
+	return vmSize * pageSize, rss * pageSize, nil
+}
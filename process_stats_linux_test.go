@@ -0,0 +1,32 @@
+// +build linux
+
+package stats
+
+import "testing"
+
+func TestParseProcStat(t *testing.T) {
+	const stat = "1234 (a (b) c) S 1 1234 1234 0 -1 4194560 100 0 0 0 250 125 0 0 20 0 8 0 100 1000 200\n"
+	ms, err := parseProcStat([]byte(stat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ms != 3750 {
+		t.Errorf("cpu time: got: %d want: %d", ms, 3750)
+	}
+	if _, err := parseProcStat([]byte("1234 (a) S 1")); err == nil {
+		t.Error("expected an error for a truncated stat")
+	}
+}
+
+func TestParseProcStatm(t *testing.T) {
+	vmSize, rss, err := parseProcStatm([]byte("300 20 10 1 0 50 0\n"), 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vmSize != 300*4096 || rss != 20*4096 {
+		t.Errorf("got: vmSize=%d rss=%d want: vmSize=%d rss=%d", vmSize, rss, 300*4096, 20*4096)
+	}
+	if _, _, err := parseProcStatm([]byte("300"), 4096); err == nil {
+		t.Error("expected an error for a truncated statm")
+	}
+}
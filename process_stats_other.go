@@ -0,0 +1,18 @@
+// +build !linux
+
+package stats
+
+import "runtime"
+
+const processCPUTimeSupported = false
+
+// readProcessStats estimates the memory usage of the process from the memory
+// obtained from the OS by the Go runtime.
+func readProcessStats() (processStats, error) {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	return processStats{
+		rss:    memStats.Sys - memStats.HeapReleased,
+		vmSize: memStats.Sys,
+	}, nil
+}
@@ -0,0 +1,26 @@
+package stats
+
+import (
+	"testing"
+
+	"github.com/lyft/gostats/mock"
+)
+
+func TestProcessStatsGenerator(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStore(sink, false)
+
+	store.AddStatGenerator(NewProcessStatsGenerator("process"))
+	store.Flush()
+
+	for _, name := range []string{"process.rss", "process.vmSize"} {
+		if v := sink.Gauge(name); v == 0 {
+			t.Errorf("%s: got: %d want: > 0", name, v)
+		}
+	}
+	if processCPUTimeSupported {
+		sink.AssertGaugeExists(t, "process.cpuTimeMs")
+	} else {
+		sink.AssertGaugeNotExists(t, "process.cpuTimeMs")
+	}
+}
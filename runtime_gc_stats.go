@@ -0,0 +1,80 @@
+package stats
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// DefaultMaxGCPauses is the maximum number of GC pauses reported on each
+// flush by a RuntimeGCStatsGenerator created with a maxPauses of zero.
+const DefaultMaxGCPauses = 256
+
+// A RuntimeGCStatsGenerator is a StatGenerator that reports the GC pauses and
+// the number of goroutines of the process, see NewRuntimeGCStatsGenerator.
+type RuntimeGCStatsGenerator struct {
+	prefix    string
+	maxPauses int
+
+	mu           sync.Mutex
+	pause        Timer // nil until bound
+	numGoroutine Gauge
+	numGC        int64 // GC cycles already reported
+	gcStats      debug.GCStats
+}
+
+// NewRuntimeGCStatsGenerator returns a StatGenerator that reads
+// debug.ReadGCStats on every flush and records the duration of each GC pause
+// since the previous flush as an observation of the Timer "gcPause", and the
+// number of goroutines as the Gauge "numGoroutine", under prefix.
+//
+// At most maxPauses of the most recent pauses are recorded per flush, if it
+// is zero or less DefaultMaxGCPauses is used. The first flush records the
+// pauses since the start of the process. The stats are created in the Store
+// the generator is added to with AddStatGenerator, if it is added to multiple
+// Stores only the first one is used.
+func NewRuntimeGCStatsGenerator(prefix string, maxPauses int) *RuntimeGCStatsGenerator {
+	if maxPauses <= 0 {
+		maxPauses = DefaultMaxGCPauses
+	}
+	return &RuntimeGCStatsGenerator{prefix: prefix, maxPauses: maxPauses}
+}
+
+func (r *RuntimeGCStatsGenerator) bindScope(scope Scope) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pause != nil {
+		return
+	}
+	if r.prefix != "" {
+		scope = scope.Scope(r.prefix)
+	}
+	r.pause = scope.NewTimer("gcPause")
+	r.numGoroutine = scope.NewGauge("numGoroutine")
+}
+
+// GenerateStats implements the StatGenerator interface. It does nothing if
+// the generator was not added to a Store.
+func (r *RuntimeGCStatsGenerator) GenerateStats() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pause == nil {
+		return
+	}
+
+	debug.ReadGCStats(&r.gcStats)
+	n := r.gcStats.NumGC - r.numGC
+	if n > int64(len(r.gcStats.Pause)) {
+		n = int64(len(r.gcStats.Pause))
+	}
+	if n > int64(r.maxPauses) {
+		n = int64(r.maxPauses)
+	}
+	// Pause is ordered from the most recent pause, record the oldest first.
+	for i := n - 1; i >= 0; i-- {
+		r.pause.RecordDuration(r.gcStats.Pause[i])
+	}
+	r.numGC = r.gcStats.NumGC
+
+	r.numGoroutine.Set(uint64(runtime.NumGoroutine()))
+}
@@ -0,0 +1,87 @@
+package stats
+
+import (
+	"runtime"
+	"sync"
+)
+
+// memStatsGauges are the runtime.MemStats fields reported by a
+// RuntimeMemStatsGenerator.
+var memStatsGauges = []struct {
+	name  string
+	value func(*runtime.MemStats) uint64
+}{
+	{"alloc", func(m *runtime.MemStats) uint64 { return m.Alloc }},
+	{"totalAlloc", func(m *runtime.MemStats) uint64 { return m.TotalAlloc }},
+	{"sys", func(m *runtime.MemStats) uint64 { return m.Sys }},
+	{"lookups", func(m *runtime.MemStats) uint64 { return m.Lookups }},
+	{"mallocs", func(m *runtime.MemStats) uint64 { return m.Mallocs }},
+	{"frees", func(m *runtime.MemStats) uint64 { return m.Frees }},
+
+	{"heapAlloc", func(m *runtime.MemStats) uint64 { return m.HeapAlloc }},
+	{"heapSys", func(m *runtime.MemStats) uint64 { return m.HeapSys }},
+	{"heapIdle", func(m *runtime.MemStats) uint64 { return m.HeapIdle }},
+	{"heapInuse", func(m *runtime.MemStats) uint64 { return m.HeapInuse }},
+	{"heapReleased", func(m *runtime.MemStats) uint64 { return m.HeapReleased }},
+	{"heapObjects", func(m *runtime.MemStats) uint64 { return m.HeapObjects }},
+
+	{"stackInuse", func(m *runtime.MemStats) uint64 { return m.StackInuse }},
+	{"stackSys", func(m *runtime.MemStats) uint64 { return m.StackSys }},
+
+	{"nextGC", func(m *runtime.MemStats) uint64 { return m.NextGC }},
+	{"lastGC", func(m *runtime.MemStats) uint64 { return m.LastGC }},
+	{"pauseTotalNs", func(m *runtime.MemStats) uint64 { return m.PauseTotalNs }},
+	{"numGC", func(m *runtime.MemStats) uint64 { return uint64(m.NumGC) }},
+	{"gcCPUPercent", func(m *runtime.MemStats) uint64 { return uint64(m.GCCPUFraction * 100) }},
+}
+
+// A RuntimeMemStatsGenerator is a StatGenerator that reports the
+// runtime.MemStats of the process as Gauges, see NewRuntimeMemStatsGenerator.
+type RuntimeMemStatsGenerator struct {
+	prefix string
+
+	mu     sync.Mutex
+	gauges []Gauge // in the order of memStatsGauges, nil until bound
+}
+
+// NewRuntimeMemStatsGenerator returns a StatGenerator that reads
+// runtime.ReadMemStats on every flush and reports the heap, allocation and
+// garbage collector statistics as Gauges under prefix. The Gauges are created
+// in the Store the generator is added to with AddStatGenerator, if it is added
+// to multiple Stores only the first one is used.
+func NewRuntimeMemStatsGenerator(prefix string) *RuntimeMemStatsGenerator {
+	return &RuntimeMemStatsGenerator{prefix: prefix}
+}
+
+func (r *RuntimeMemStatsGenerator) bindScope(scope Scope) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.gauges != nil {
+		return
+	}
+	if r.prefix != "" {
+		scope = scope.Scope(r.prefix)
+	}
+	gauges := make([]Gauge, len(memStatsGauges))
+	for i, g := range memStatsGauges {
+		gauges[i] = scope.NewGauge(g.name)
+	}
+	r.gauges = gauges
+}
+
+// GenerateStats implements the StatGenerator interface. It does nothing if
+// the generator was not added to a Store.
+func (r *RuntimeMemStatsGenerator) GenerateStats() {
+	r.mu.Lock()
+	gauges := r.gauges
+	r.mu.Unlock()
+	if gauges == nil {
+		return
+	}
+
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	for i, g := range memStatsGauges {
+		gauges[i].Set(g.value(&memStats))
+	}
+}
@@ -2,7 +2,10 @@ package stats
 
 import (
 	"regexp"
+	"runtime"
 	"testing"
+
+	"github.com/lyft/gostats/mock"
 )
 
 func TestRuntime(t *testing.T) {
@@ -31,3 +34,47 @@ func TestRuntime(t *testing.T) {
 		t.Errorf("Expected: '%s' Got: '%s'", pattern, sink.record)
 	}
 }
+
+func TestRuntimeMemStatsGenerator(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStore(sink, false)
+
+	g := NewRuntimeMemStatsGenerator("runtime")
+	g.GenerateStats() // not added to a store: noop
+	store.AddStatGenerator(g)
+	store.Flush()
+
+	for _, m := range memStatsGauges {
+		sink.AssertGaugeExists(t, "runtime."+m.name)
+	}
+	if n := len(store.ListGauges()); n != len(memStatsGauges) {
+		t.Errorf("gauges: got: %d want: %d", n, len(memStatsGauges))
+	}
+	if v := sink.Gauge("runtime.sys"); v == 0 {
+		t.Errorf("runtime.sys: got: %d want: > 0", v)
+	}
+}
+
+func TestRuntimeGCStatsGenerator(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStore(sink, false)
+
+	g := NewRuntimeGCStatsGenerator("runtime", 2)
+	store.AddStatGenerator(g)
+	for i := 0; i < 3; i++ {
+		runtime.GC()
+	}
+	store.Flush()
+	sink.AssertTimerCalledN(t, "runtime.gcPause", 2)
+	sink.AssertGaugeExists(t, "runtime.numGoroutine")
+	if v := sink.Gauge("runtime.numGoroutine"); v == 0 {
+		t.Errorf("runtime.numGoroutine: got: %d want: > 0", v)
+	}
+
+	runtime.GC()
+	store.Flush()
+	n := sink.TimerCallCount("runtime.gcPause")
+	if n < 3 || n > 4 {
+		t.Errorf("runtime.gcPause calls: got: %d want: 3 or 4", n)
+	}
+}
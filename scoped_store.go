@@ -0,0 +1,149 @@
+package stats
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// rootScope allows embedding a Scope in a scopedStore, a field named Scope
+// would conflict with the Scope method.
+type rootScope = Scope
+
+// A scopedStore is a Store whose stats are created by a Scope of another
+// Store, the other Store methods act on the whole Store.
+type scopedStore struct {
+	rootScope
+
+	store Store
+}
+
+func newScopedStore(root Scope, store Store) *scopedStore {
+	return &scopedStore{rootScope: root, store: store}
+}
+
+func (s *scopedStore) AsStore() Store {
+	return s
+}
+
+func (s *scopedStore) NewChildStore(prefix string) Store {
+	return s.Scope(prefix).AsStore()
+}
+
+func (s *scopedStore) NewAliasedCounter(names ...string) Counter {
+	return newAliasedCounter(s, names)
+}
+
+func (s *scopedStore) NewAliasedGauge(names ...string) Gauge {
+	return newAliasedGauge(s, names)
+}
+
+func (s *scopedStore) NewAliasedTimer(names ...string) Timer {
+	return newAliasedTimer(s, names)
+}
+
+func (s *scopedStore) MustNewCounter(name string) Counter {
+	return mustNewCounter(s, name)
+}
+
+func (s *scopedStore) MustNewGauge(name string) Gauge {
+	return mustNewGauge(s, name)
+}
+
+func (s *scopedStore) MustNewTimer(name string) Timer {
+	return mustNewTimer(s, name)
+}
+
+func (s *scopedStore) NewLazyCounter(name string) Counter {
+	return newLazyCounter(s, name)
+}
+
+func (s *scopedStore) NewCounterVec(name string, labelNames []string) CounterVec {
+	return newCounterVec(s, name, labelNames)
+}
+
+func (s *scopedStore) NewGaugeVec(name string, labelNames []string) GaugeVec {
+	return newGaugeVec(s, name, labelNames)
+}
+
+func (s *scopedStore) NewTimerVec(name string, labelNames []string) TimerVec {
+	return newTimerVec(s, name, labelNames)
+}
+
+func (s *scopedStore) RecordBatch(batch []MetricRecord) {
+	recordBatch(s, batch)
+}
+
+func (s *scopedStore) Flush() {
+	s.store.Flush()
+}
+
+func (s *scopedStore) FlushAll() error {
+	return s.store.FlushAll()
+}
+
+func (s *scopedStore) Close() error {
+	return s.store.Close()
+}
+
+func (s *scopedStore) Start(ticker *time.Ticker) {
+	s.store.Start(ticker)
+}
+
+func (s *scopedStore) AddStatGenerator(statGenerator StatGenerator) {
+	bindStatGenerator(statGenerator, s)
+	s.store.AddStatGenerator(statGenerator)
+}
+
+func (s *scopedStore) AddStatGeneratorWithPriority(sg StatGenerator, priority int) {
+	bindStatGenerator(sg, s)
+	s.store.AddStatGeneratorWithPriority(sg, priority)
+}
+
+func (s *scopedStore) SetStatGeneratorConcurrency(n int) {
+	s.store.SetStatGeneratorConcurrency(n)
+}
+
+func (s *scopedStore) SetTagsFromContext(fn TagsFromContext) {
+	s.store.SetTagsFromContext(fn)
+}
+
+func (s *scopedStore) Unregister(name string) bool {
+	return s.store.Unregister(name)
+}
+
+func (s *scopedStore) UnregisterWithTags(name string, tags map[string]string) bool {
+	return s.store.UnregisterWithTags(name, tags)
+}
+
+func (s *scopedStore) UnregisterPrefix(prefix string) int {
+	return s.store.UnregisterPrefix(prefix)
+}
+
+func (s *scopedStore) ListCounters() []string {
+	return s.store.ListCounters()
+}
+
+func (s *scopedStore) ListGauges() []string {
+	return s.store.ListGauges()
+}
+
+func (s *scopedStore) ListTimers() []string {
+	return s.store.ListTimers()
+}
+
+func (s *scopedStore) ListMetricDescriptions() []MetricDescription {
+	return s.store.ListMetricDescriptions()
+}
+
+func (s *scopedStore) Snapshot() Snapshot {
+	return s.store.Snapshot()
+}
+
+func (s *scopedStore) SnapshotHandler() http.Handler {
+	return s.store.SnapshotHandler()
+}
+
+func (s *scopedStore) Watch(ctx context.Context) <-chan MetricEvent {
+	return s.store.Watch(ctx)
+}
@@ -1,5 +1,7 @@
 package stats
 
+import "math"
+
 // A Sink is used by a Store to flush its data.
 // These functions may buffer the given data.
 type Sink interface {
@@ -14,3 +16,116 @@ type FlushableSink interface {
 	Sink
 	Flush()
 }
+
+// Syncer is an extension of Sink for sinks that write stats asynchronously.
+// Sync flushes any buffered stats and blocks until they are written to the
+// backend, returning the first error that occurred.
+type Syncer interface {
+	Sync() error
+}
+
+// syncSink syncs sink if it implements Syncer, otherwise it flushes it if
+// it implements FlushableSink.
+func syncSink(sink Sink) error {
+	switch s := sink.(type) {
+	case Syncer:
+		return s.Sync()
+	case FlushableSink:
+		s.Flush()
+	}
+	return nil
+}
+
+// DistributionSink is an extension of Sink that provides a FlushDistribution()
+// function for backends that natively support distributions. Distributions
+// flushed to a Sink that does not implement DistributionSink are flushed as
+// gauges.
+type DistributionSink interface {
+	Sink
+	FlushDistribution(name string, value float64)
+}
+
+// gaugeDistributionSink flushes distributions to a Sink as gauges.
+type gaugeDistributionSink struct {
+	Sink
+}
+
+func (s gaugeDistributionSink) FlushDistribution(name string, value float64) {
+	if value < 0 {
+		value = 0
+	}
+	s.FlushGauge(name, uint64(value))
+}
+
+func newDistributionSink(sink Sink) DistributionSink {
+	if ds, ok := sink.(DistributionSink); ok {
+		return ds
+	}
+	return gaugeDistributionSink{sink}
+}
+
+// UpDownCounterSink is an extension of Sink that provides a
+// FlushUpDownCounter() function for backends that support negative gauge
+// values. UpDownCounters flushed to a Sink that does not implement
+// UpDownCounterSink are flushed as gauges and negative values are flushed
+// as 0.
+type UpDownCounterSink interface {
+	Sink
+	FlushUpDownCounter(name string, value int64)
+}
+
+// gaugeUpDownCounterSink flushes UpDownCounters to a Sink as gauges.
+type gaugeUpDownCounterSink struct {
+	Sink
+}
+
+func (s gaugeUpDownCounterSink) FlushUpDownCounter(name string, value int64) {
+	if value < 0 {
+		value = 0
+	}
+	s.FlushGauge(name, uint64(value))
+}
+
+func newUpDownCounterSink(sink Sink) UpDownCounterSink {
+	if us, ok := sink.(UpDownCounterSink); ok {
+		return us
+	}
+	return gaugeUpDownCounterSink{sink}
+}
+
+// FloatGaugeSink is an extension of Sink that provides a FlushFloatGauge()
+// function for backends that support floating point gauge values.
+// FloatGauges flushed to a Sink that does not implement FloatGaugeSink are
+// flushed as gauges truncated to an integer and negative values are flushed
+// as 0.
+type FloatGaugeSink interface {
+	Sink
+	FlushFloatGauge(name string, value float64)
+}
+
+// gaugeFloatGaugeSink flushes FloatGauges to a Sink as gauges.
+type gaugeFloatGaugeSink struct {
+	Sink
+}
+
+func (s gaugeFloatGaugeSink) FlushFloatGauge(name string, value float64) {
+	if value < 0 || math.IsNaN(value) {
+		value = 0
+	}
+	s.FlushGauge(name, uint64(value))
+}
+
+func newFloatGaugeSink(sink Sink) FloatGaugeSink {
+	if fs, ok := sink.(FloatGaugeSink); ok {
+		return fs
+	}
+	return gaugeFloatGaugeSink{sink}
+}
+
+// A HelpSink is a Sink that exposes the help text of stats, see WithHelp.
+// SetHelp is called with the name of the stat, including any scopes but
+// without tags, when its help text is set.
+type HelpSink interface {
+	Sink
+	SetHelp(name, help string)
+}
@@ -0,0 +1,270 @@
+// Package cloudwatch provides a stats.Sink that publishes stats to Amazon
+// CloudWatch with the PutMetricData API.
+package cloudwatch
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/lyft/gostats/internal/tags"
+)
+
+// MaxDatumsPerRequest is the maximum number of MetricDatum sent in a single
+// PutMetricData call.
+const MaxDatumsPerRequest = 20
+
+// PutMetricDataAPI is the part of the CloudWatch API used by the sink, it is
+// implemented by *cloudwatch.Client and MockClient.
+type PutMetricDataAPI interface {
+	PutMetricData(ctx context.Context, params *cloudwatch.PutMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.PutMetricDataOutput, error)
+}
+
+// An Option configures a CloudWatchSink.
+type Option interface {
+	apply(*CloudWatchSink)
+}
+
+// optionFunc wraps a func so it satisfies the Option interface.
+type optionFunc func(*CloudWatchSink)
+
+func (f optionFunc) apply(sink *CloudWatchSink) {
+	f(sink)
+}
+
+// WithRegion sets the AWS region of the client created by NewCloudWatchSink,
+// by default the region of the shared AWS configuration is used.
+func WithRegion(region string) Option {
+	return optionFunc(func(sink *CloudWatchSink) {
+		sink.region = region
+	})
+}
+
+// WithClient sets the client used to publish the stats, instead of a client
+// created from the shared AWS configuration.
+func WithClient(client PutMetricDataAPI) Option {
+	return optionFunc(func(sink *CloudWatchSink) {
+		sink.client = client
+	})
+}
+
+// WithTimerUnit sets the unit of the Timers, the default is
+// types.StandardUnitMicroseconds, the unit of the Timers created by
+// Scope.NewTimer.
+func WithTimerUnit(unit types.StandardUnit) Option {
+	return optionFunc(func(sink *CloudWatchSink) {
+		sink.timerUnit = unit
+	})
+}
+
+// WithErrorHandler sets the function called with the errors of Flush, by
+// default they are ignored.
+func WithErrorHandler(fn func(error)) Option {
+	return optionFunc(func(sink *CloudWatchSink) {
+		sink.onError = fn
+	})
+}
+
+// series aggregates the values of a stat flushed since the last publish.
+type series struct {
+	name  string
+	dims  []types.Dimension
+	count float64
+	sum   float64
+	min   float64
+	max   float64
+}
+
+func (p *series) add(value float64) {
+	p.count++
+	p.sum += value
+	p.min = math.Min(p.min, value)
+	p.max = math.Max(p.max, value)
+}
+
+func (p *series) statistics() *types.StatisticSet {
+	return &types.StatisticSet{
+		SampleCount: aws.Float64(p.count),
+		Sum:         aws.Float64(p.sum),
+		Minimum:     aws.Float64(p.min),
+		Maximum:     aws.Float64(p.max),
+	}
+}
+
+// A CloudWatchSink is a stats.Sink that aggregates the flushed stats and
+// publishes them to a CloudWatch namespace when it is flushed or synced. Tags
+// are published as dimensions, tags with an empty value are ignored.
+//
+// Counters are published as a single value, the sum of their flushes, so the
+// Sum statistic is their increase. Gauges are published as a statistic set
+// of their flushed values so that they can be averaged, and Timers as a
+// statistic set with their count, minimum, maximum and sum. Datums are sent
+// in batches of MaxDatumsPerRequest.
+type CloudWatchSink struct {
+	client    PutMetricDataAPI
+	namespace string
+	region    string
+	timerUnit types.StandardUnit
+	onError   func(error)
+	now       func() time.Time
+
+	mu       sync.Mutex
+	counters map[string]*series
+	gauges   map[string]*series
+	timers   map[string]*series
+
+	publishMu sync.Mutex // serializes the publishes
+}
+
+// NewCloudWatchSink returns a CloudWatchSink that publishes stats to
+// namespace. Unless WithClient is used the client is created from the shared
+// AWS configuration, loaded with ctx.
+func NewCloudWatchSink(ctx context.Context, namespace string, opts ...Option) (*CloudWatchSink, error) {
+	s := &CloudWatchSink{
+		namespace: namespace,
+		timerUnit: types.StandardUnitMicroseconds,
+		now:       time.Now,
+		counters:  make(map[string]*series),
+		gauges:    make(map[string]*series),
+		timers:    make(map[string]*series),
+	}
+	for _, opt := range opts {
+		opt.apply(s)
+	}
+	if s.client == nil {
+		var loadOpts []func(*config.LoadOptions) error
+		if s.region != "" {
+			loadOpts = append(loadOpts, config.WithRegion(s.region))
+		}
+		cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
+		if err != nil {
+			return nil, err
+		}
+		s.client = cloudwatch.NewFromConfig(cfg)
+	}
+	return s, nil
+}
+
+func (s *CloudWatchSink) add(m map[string]*series, stat string, value float64) {
+	s.mu.Lock()
+	p := m[stat]
+	if p == nil {
+		name, set := tags.ParseTagSet(stat)
+		p = &series{name: name, min: math.Inf(1), max: math.Inf(-1)}
+		for _, t := range set {
+			if t.Value != "" {
+				p.dims = append(p.dims, types.Dimension{
+					Name:  aws.String(t.Key),
+					Value: aws.String(t.Value),
+				})
+			}
+		}
+		m[stat] = p
+	}
+	p.add(value)
+	s.mu.Unlock()
+}
+
+// FlushCounter implements the stats.Sink.FlushCounter method.
+func (s *CloudWatchSink) FlushCounter(name string, value uint64) {
+	s.add(s.counters, name, float64(value))
+}
+
+// FlushGauge implements the stats.Sink.FlushGauge method.
+func (s *CloudWatchSink) FlushGauge(name string, value uint64) {
+	s.add(s.gauges, name, float64(value))
+}
+
+// FlushTimer implements the stats.Sink.FlushTimer method.
+func (s *CloudWatchSink) FlushTimer(name string, value float64) {
+	s.add(s.timers, name, value)
+}
+
+// Flush implements the stats.FlushableSink.Flush method and publishes the
+// stats, its error is passed to the function set with WithErrorHandler.
+func (s *CloudWatchSink) Flush() {
+	if err := s.Sync(); err != nil && s.onError != nil {
+		s.onError(err)
+	}
+}
+
+// Sync implements the stats.Syncer interface. It publishes the stats
+// aggregated since the last publish and returns the first error, the stats of
+// the failed requests are dropped.
+func (s *CloudWatchSink) Sync() error {
+	s.publishMu.Lock()
+	defer s.publishMu.Unlock()
+
+	s.mu.Lock()
+	counters, gauges, timers := s.counters, s.gauges, s.timers
+	s.counters = make(map[string]*series, len(counters))
+	s.gauges = make(map[string]*series, len(gauges))
+	s.timers = make(map[string]*series, len(timers))
+	s.mu.Unlock()
+
+	now := aws.Time(s.now())
+	var datums []types.MetricDatum
+	for _, p := range sortedSeries(counters) {
+		datums = append(datums, types.MetricDatum{
+			MetricName: aws.String(p.name),
+			Dimensions: p.dims,
+			Timestamp:  now,
+			Unit:       types.StandardUnitCount,
+			Value:      aws.Float64(p.sum),
+		})
+	}
+	for _, p := range sortedSeries(gauges) {
+		datums = append(datums, types.MetricDatum{
+			MetricName:      aws.String(p.name),
+			Dimensions:      p.dims,
+			Timestamp:       now,
+			Unit:            types.StandardUnitNone,
+			StatisticValues: p.statistics(),
+		})
+	}
+	for _, p := range sortedSeries(timers) {
+		datums = append(datums, types.MetricDatum{
+			MetricName:      aws.String(p.name),
+			Dimensions:      p.dims,
+			Timestamp:       now,
+			Unit:            s.timerUnit,
+			StatisticValues: p.statistics(),
+		})
+	}
+
+	var first error
+	for len(datums) != 0 {
+		n := len(datums)
+		if n > MaxDatumsPerRequest {
+			n = MaxDatumsPerRequest
+		}
+		_, err := s.client.PutMetricData(context.Background(), &cloudwatch.PutMetricDataInput{
+			Namespace:  aws.String(s.namespace),
+			MetricData: datums[:n],
+		})
+		if err != nil && first == nil {
+			first = err
+		}
+		datums = datums[n:]
+	}
+	return first
+}
+
+func sortedSeries(m map[string]*series) []*series {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	a := make([]*series, len(keys))
+	for i, k := range keys {
+		a[i] = m[k]
+	}
+	return a
+}
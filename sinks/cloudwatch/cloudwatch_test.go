@@ -0,0 +1,127 @@
+package cloudwatch
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	stats "github.com/lyft/gostats"
+)
+
+var (
+	_ stats.FlushableSink = (*CloudWatchSink)(nil)
+	_ stats.Syncer        = (*CloudWatchSink)(nil)
+	_ PutMetricDataAPI    = (*MockClient)(nil)
+)
+
+func newTestSink(t *testing.T, client *MockClient, opts ...Option) *CloudWatchSink {
+	t.Helper()
+	sink, err := NewCloudWatchSink(context.Background(), "App", append(opts, WithClient(client))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sink
+}
+
+func TestCloudWatchSink(t *testing.T) {
+	client := &MockClient{}
+	sink := newTestSink(t, client)
+	now := time.Unix(100, 0)
+	sink.now = func() time.Time { return now }
+
+	sink.FlushCounter("requests.__code=200.__empty=", 2)
+	sink.FlushCounter("requests.__code=200.__empty=", 3)
+	sink.FlushGauge("conns", 4)
+	sink.FlushGauge("conns", 6)
+	sink.FlushTimer("latency", 1.5)
+	sink.FlushTimer("latency", 0.5)
+	sink.FlushTimer("latency", 4)
+	if err := sink.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	inputs := client.Inputs()
+	if len(inputs) != 1 {
+		t.Fatalf("requests: got: %d want: %d", len(inputs), 1)
+	}
+	if ns := aws.ToString(inputs[0].Namespace); ns != "App" {
+		t.Errorf("namespace: got: %q want: %q", ns, "App")
+	}
+	data := inputs[0].MetricData
+	if len(data) != 3 {
+		t.Fatalf("datums: got: %d want: %d", len(data), 3)
+	}
+
+	c := data[0]
+	if aws.ToString(c.MetricName) != "requests" || aws.ToFloat64(c.Value) != 5 ||
+		c.Unit != types.StandardUnitCount || !aws.ToTime(c.Timestamp).Equal(now) {
+		t.Errorf("counter: got: %+v", c)
+	}
+	if len(c.Dimensions) != 1 || aws.ToString(c.Dimensions[0].Name) != "code" ||
+		aws.ToString(c.Dimensions[0].Value) != "200" {
+		t.Errorf("counter dimensions: got: %+v", c.Dimensions)
+	}
+
+	checkStats := func(d types.MetricDatum, name string, unit types.StandardUnit, count, sum, min, max float64) {
+		t.Helper()
+		s := d.StatisticValues
+		if aws.ToString(d.MetricName) != name || d.Unit != unit || s == nil ||
+			aws.ToFloat64(s.SampleCount) != count || aws.ToFloat64(s.Sum) != sum ||
+			aws.ToFloat64(s.Minimum) != min || aws.ToFloat64(s.Maximum) != max {
+			t.Errorf("%s: got: %+v %+v", name, d, s)
+		}
+	}
+	checkStats(data[1], "conns", types.StandardUnitNone, 2, 10, 4, 6)
+	checkStats(data[2], "latency", types.StandardUnitMicroseconds, 3, 6, 0.5, 4)
+
+	// the stats are reset after each publish
+	client.Reset()
+	if err := sink.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(client.Inputs()); n != 0 {
+		t.Errorf("requests: got: %d want: %d", n, 0)
+	}
+}
+
+func TestCloudWatchSinkBatches(t *testing.T) {
+	client := &MockClient{}
+	sink := newTestSink(t, client, WithTimerUnit(types.StandardUnitMilliseconds))
+	for i := 0; i < 2*MaxDatumsPerRequest+1; i++ {
+		sink.FlushTimer("t"+strconv.Itoa(i), 1)
+	}
+	sink.Flush()
+
+	inputs := client.Inputs()
+	if len(inputs) != 3 {
+		t.Fatalf("requests: got: %d want: %d", len(inputs), 3)
+	}
+	for i, exp := range []int{MaxDatumsPerRequest, MaxDatumsPerRequest, 1} {
+		if n := len(inputs[i].MetricData); n != exp {
+			t.Errorf("request %d: datums: got: %d want: %d", i, n, exp)
+		}
+	}
+	if u := inputs[0].MetricData[0].Unit; u != types.StandardUnitMilliseconds {
+		t.Errorf("timer unit: got: %q want: %q", u, types.StandardUnitMilliseconds)
+	}
+}
+
+func TestCloudWatchSinkError(t *testing.T) {
+	client := &MockClient{Err: errors.New("throttled")}
+	var errs []error
+	sink := newTestSink(t, client, WithErrorHandler(func(err error) { errs = append(errs, err) }))
+
+	sink.FlushGauge("g", 1)
+	if err := sink.Sync(); err != client.Err {
+		t.Errorf("Sync: got: %v want: %v", err, client.Err)
+	}
+	sink.FlushGauge("g", 1)
+	sink.Flush()
+	if len(errs) != 1 || errs[0] != client.Err {
+		t.Errorf("errors: got: %v want: [%v]", errs, client.Err)
+	}
+}
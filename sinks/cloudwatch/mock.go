@@ -0,0 +1,44 @@
+package cloudwatch
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+)
+
+// A MockClient is a PutMetricDataAPI that records the requests made by a
+// CloudWatchSink, for testing without calling AWS. It is safe for concurrent
+// use.
+type MockClient struct {
+	// Err, if not nil, is returned by PutMetricData.
+	Err error
+
+	mu     sync.Mutex
+	inputs []*cloudwatch.PutMetricDataInput
+}
+
+// PutMetricData records params and returns m.Err.
+func (m *MockClient) PutMetricData(_ context.Context, params *cloudwatch.PutMetricDataInput, _ ...func(*cloudwatch.Options)) (*cloudwatch.PutMetricDataOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inputs = append(m.inputs, params)
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	return &cloudwatch.PutMetricDataOutput{}, nil
+}
+
+// Inputs returns the requests recorded by the client.
+func (m *MockClient) Inputs() []*cloudwatch.PutMetricDataInput {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*cloudwatch.PutMetricDataInput(nil), m.inputs...)
+}
+
+// Reset removes the recorded requests.
+func (m *MockClient) Reset() {
+	m.mu.Lock()
+	m.inputs = nil
+	m.mu.Unlock()
+}
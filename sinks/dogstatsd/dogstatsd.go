@@ -0,0 +1,395 @@
+// Package dogstatsd provides a stats.Sink that writes stats, events and
+// service checks to a DogStatsD agent over UDP or a Unix domain socket.
+package dogstatsd
+
+import (
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lyft/gostats/internal/tags"
+)
+
+const (
+	// DefaultAddress is the default address of the DogStatsD agent.
+	DefaultAddress = "localhost:8125"
+
+	// DefaultMaxDatagramSizeUDP is the default maximum size of a UDP
+	// datagram, which prevents fragmentation on networks with an MTU of 1500.
+	DefaultMaxDatagramSizeUDP = 1432
+
+	// DefaultMaxDatagramSizeUDS is the default maximum size of a Unix domain
+	// socket datagram.
+	DefaultMaxDatagramSizeUDS = 8192
+
+	// DefaultFlushInterval is the default interval at which buffered stats
+	// are written.
+	DefaultFlushInterval = time.Second
+
+	unixScheme = "unix://"
+)
+
+// Service check statuses.
+const (
+	StatusOK       = 0
+	StatusWarning  = 1
+	StatusCritical = 2
+	StatusUnknown  = 3
+)
+
+// EventOptions are the optional fields of an event.
+type EventOptions struct {
+	// Timestamp of the event, if zero the agent uses the time it received
+	// the event.
+	Timestamp time.Time
+	// Hostname of the event.
+	Hostname string
+	// AggregationKey is used to group the event with other events.
+	AggregationKey string
+	// Priority of the event: "normal" or "low".
+	Priority string
+	// SourceTypeName of the event.
+	SourceTypeName string
+	// AlertType of the event: "error", "warning", "info" or "success".
+	AlertType string
+	// Tags of the event, the sink's global tags are also added.
+	Tags map[string]string
+}
+
+// ServiceCheckOptions are the optional fields of a service check.
+type ServiceCheckOptions struct {
+	// Timestamp of the service check, if zero the agent uses the time it
+	// received the service check.
+	Timestamp time.Time
+	// Hostname of the service check.
+	Hostname string
+	// Message describing the status of the service check.
+	Message string
+	// Tags of the service check, the sink's global tags are also added.
+	Tags map[string]string
+}
+
+// An Option configures a DogStatsdSink.
+type Option interface {
+	apply(*DogStatsdSink)
+}
+
+// optionFunc wraps a func so it satisfies the Option interface.
+type optionFunc func(*DogStatsdSink)
+
+func (f optionFunc) apply(sink *DogStatsdSink) {
+	f(sink)
+}
+
+// WithAddress sets the address of the DogStatsD agent, the default is
+// DefaultAddress. Addresses of the form "unix:///path/to/socket" use a Unix
+// domain socket, all others are "host:port" UDP addresses.
+func WithAddress(addr string) Option {
+	return optionFunc(func(sink *DogStatsdSink) {
+		sink.addr = addr
+	})
+}
+
+// WithMaxDatagramSize sets the maximum size of the datagrams written to the
+// agent, the default depends on the transport.
+func WithMaxDatagramSize(size int) Option {
+	return optionFunc(func(sink *DogStatsdSink) {
+		sink.maxSize = size
+	})
+}
+
+// WithFlushInterval sets the interval at which buffered stats are written,
+// the default is DefaultFlushInterval.
+func WithFlushInterval(d time.Duration) Option {
+	return optionFunc(func(sink *DogStatsdSink) {
+		sink.interval = d
+	})
+}
+
+// WithGlobalTags sets tags that are added to every stat, event and service
+// check. Tags of the stat take precedence over global tags with the same key.
+func WithGlobalTags(tagsm map[string]string) Option {
+	return optionFunc(func(sink *DogStatsdSink) {
+		sink.globalTags = tags.NewTagSet(tagsm)
+	})
+}
+
+// WithCounterSampleRate sets the rate, in the range (0, 1], at which
+// counters are sampled. The agent scales sampled counters by 1/rate.
+func WithCounterSampleRate(rate float64) Option {
+	return optionFunc(func(sink *DogStatsdSink) {
+		sink.counterRate = rate
+	})
+}
+
+// WithGaugeSampleRate sets the rate, in the range (0, 1], at which gauges
+// are sampled.
+func WithGaugeSampleRate(rate float64) Option {
+	return optionFunc(func(sink *DogStatsdSink) {
+		sink.gaugeRate = rate
+	})
+}
+
+// WithTimerSampleRate sets the rate, in the range (0, 1], at which timers
+// are sampled.
+func WithTimerSampleRate(rate float64) Option {
+	return optionFunc(func(sink *DogStatsdSink) {
+		sink.timerRate = rate
+	})
+}
+
+// A DogStatsdSink is a stats.FlushableSink that buffers stats, events and
+// service checks and writes them to a DogStatsD agent. Buffered data is
+// written when the buffer is full, at every flush interval and when Flush is
+// called.
+type DogStatsdSink struct {
+	addr        string
+	maxSize     int
+	interval    time.Duration
+	globalTags  tags.TagSet
+	counterRate float64
+	gaugeRate   float64
+	timerRate   float64
+
+	names sync.Map // stat name => *statName
+
+	mu   sync.Mutex
+	conn net.Conn
+	buf  []byte
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewDogStatsdSink returns a new DogStatsdSink configured with opts. An
+// error is returned if the agent's address cannot be resolved.
+func NewDogStatsdSink(opts ...Option) (*DogStatsdSink, error) {
+	s := &DogStatsdSink{
+		addr:        DefaultAddress,
+		interval:    DefaultFlushInterval,
+		counterRate: 1,
+		gaugeRate:   1,
+		timerRate:   1,
+		done:        make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt.apply(s)
+	}
+	if s.interval <= 0 {
+		s.interval = DefaultFlushInterval
+	}
+
+	network, addr := "udp", s.addr
+	if strings.HasPrefix(addr, unixScheme) {
+		network, addr = "unixgram", strings.TrimPrefix(addr, unixScheme)
+	}
+	if s.maxSize <= 0 {
+		if network == "udp" {
+			s.maxSize = DefaultMaxDatagramSizeUDP
+		} else {
+			s.maxSize = DefaultMaxDatagramSizeUDS
+		}
+	}
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	s.conn = conn
+	s.buf = make([]byte, 0, s.maxSize)
+
+	s.wg.Add(1)
+	go s.run()
+	return s, nil
+}
+
+func (s *DogStatsdSink) run() {
+	defer s.wg.Done()
+	t := time.NewTicker(s.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			s.Flush()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Close flushes any buffered data and closes the connection to the agent.
+// The DogStatsdSink must not be used after Close is called.
+func (s *DogStatsdSink) Close() error {
+	close(s.done)
+	s.wg.Wait()
+	s.Flush()
+	return s.conn.Close()
+}
+
+// Flush writes any buffered data to the agent.
+func (s *DogStatsdSink) Flush() {
+	s.mu.Lock()
+	s.writeBuffer()
+	s.mu.Unlock()
+}
+
+// writeBuffer writes the buffer to the connection. Errors are ignored since
+// the transport is lossy anyway. s.mu must be held.
+func (s *DogStatsdSink) writeBuffer() {
+	if len(s.buf) != 0 {
+		_, _ = s.conn.Write(s.buf)
+		s.buf = s.buf[:0]
+	}
+}
+
+// write appends the line b, which must be newline terminated, to the buffer.
+func (s *DogStatsdSink) write(b []byte) {
+	s.mu.Lock()
+	if len(s.buf)+len(b) > s.maxSize {
+		s.writeBuffer()
+	}
+	s.buf = append(s.buf, b...)
+	s.mu.Unlock()
+}
+
+// appendTags appends set merged with the global tags in the "|#k:v,k:v"
+// format to b.
+func (s *DogStatsdSink) appendTags(b []byte, set tags.TagSet) []byte {
+	for _, t := range s.globalTags {
+		if !set.Contains(t.Key) {
+			set = set.Insert(t)
+		}
+	}
+	for i, t := range set {
+		if i == 0 {
+			b = append(b, "|#"...)
+		} else {
+			b = append(b, ',')
+		}
+		b = append(b, t.Key...)
+		b = append(b, ':')
+		b = append(b, t.Value...)
+	}
+	return b
+}
+
+// A statName is a stat name with its tags serialized in the DogStatsD format.
+type statName struct {
+	name string
+	tags string
+}
+
+func (s *DogStatsdSink) statName(stat string) *statName {
+	if v, ok := s.names.Load(stat); ok {
+		return v.(*statName)
+	}
+	name, set := tags.ParseTagSet(stat)
+	p := &statName{name: name, tags: string(s.appendTags(nil, set))}
+	v, _ := s.names.LoadOrStore(stat, p)
+	return v.(*statName)
+}
+
+func (s *DogStatsdSink) writeStat(stat string, value []byte, typ string, rate float64) {
+	if rate < 1 && rand.Float64() >= rate {
+		return
+	}
+	p := s.statName(stat)
+	b := make([]byte, 0, len(p.name)+len(value)+len(typ)+len(p.tags)+16)
+	b = append(b, p.name...)
+	b = append(b, ':')
+	b = append(b, value...)
+	b = append(b, '|')
+	b = append(b, typ...)
+	if rate < 1 {
+		b = append(b, "|@"...)
+		b = strconv.AppendFloat(b, rate, 'f', -1, 64)
+	}
+	b = append(b, p.tags...)
+	b = append(b, '\n')
+	s.write(b)
+}
+
+// FlushCounter implements the stats.Sink.FlushCounter method.
+func (s *DogStatsdSink) FlushCounter(name string, value uint64) {
+	var b [20]byte
+	s.writeStat(name, strconv.AppendUint(b[:0], value, 10), "c", s.counterRate)
+}
+
+// FlushGauge implements the stats.Sink.FlushGauge method.
+func (s *DogStatsdSink) FlushGauge(name string, value uint64) {
+	var b [20]byte
+	s.writeStat(name, strconv.AppendUint(b[:0], value, 10), "g", s.gaugeRate)
+}
+
+// FlushTimer implements the stats.Sink.FlushTimer method.
+func (s *DogStatsdSink) FlushTimer(name string, value float64) {
+	var b [32]byte
+	s.writeStat(name, strconv.AppendFloat(b[:0], value, 'f', -1, 64), "ms", s.timerRate)
+}
+
+// FlushDistribution implements the stats.DistributionSink.FlushDistribution
+// method. Distributions are not sampled.
+func (s *DogStatsdSink) FlushDistribution(name string, value float64) {
+	var b [32]byte
+	s.writeStat(name, strconv.AppendFloat(b[:0], value, 'f', -1, 64), "d", 1)
+}
+
+var newlineReplacer = strings.NewReplacer("\n", `\n`)
+
+// SendEvent sends an event with title and text to the agent.
+func (s *DogStatsdSink) SendEvent(title, text string, opts EventOptions) {
+	title = newlineReplacer.Replace(title)
+	text = newlineReplacer.Replace(text)
+
+	b := make([]byte, 0, len(title)+len(text)+64)
+	b = append(b, "_e{"...)
+	b = strconv.AppendInt(b, int64(len(title)), 10)
+	b = append(b, ',')
+	b = strconv.AppendInt(b, int64(len(text)), 10)
+	b = append(b, "}:"...)
+	b = append(b, title...)
+	b = append(b, '|')
+	b = append(b, text...)
+	if !opts.Timestamp.IsZero() {
+		b = append(b, "|d:"...)
+		b = strconv.AppendInt(b, opts.Timestamp.Unix(), 10)
+	}
+	b = appendField(b, "|h:", opts.Hostname)
+	b = appendField(b, "|k:", opts.AggregationKey)
+	b = appendField(b, "|p:", opts.Priority)
+	b = appendField(b, "|s:", opts.SourceTypeName)
+	b = appendField(b, "|t:", opts.AlertType)
+	b = s.appendTags(b, tags.NewTagSet(opts.Tags))
+	b = append(b, '\n')
+	s.write(b)
+}
+
+// SendServiceCheck sends a service check with status, one of the Status*
+// constants, to the agent.
+func (s *DogStatsdSink) SendServiceCheck(name string, status int, opts ServiceCheckOptions) {
+	b := make([]byte, 0, len(name)+len(opts.Message)+64)
+	b = append(b, "_sc|"...)
+	b = append(b, name...)
+	b = append(b, '|')
+	b = strconv.AppendInt(b, int64(status), 10)
+	if !opts.Timestamp.IsZero() {
+		b = append(b, "|d:"...)
+		b = strconv.AppendInt(b, opts.Timestamp.Unix(), 10)
+	}
+	b = appendField(b, "|h:", opts.Hostname)
+	b = s.appendTags(b, tags.NewTagSet(opts.Tags))
+	// the message must be the last field
+	b = appendField(b, "|m:", newlineReplacer.Replace(opts.Message))
+	b = append(b, '\n')
+	s.write(b)
+}
+
+func appendField(b []byte, prefix, value string) []byte {
+	if value == "" {
+		return b
+	}
+	b = append(b, prefix...)
+	return append(b, value...)
+}
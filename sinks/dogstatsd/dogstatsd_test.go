@@ -0,0 +1,178 @@
+package dogstatsd
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	stats "github.com/lyft/gostats"
+	"github.com/lyft/gostats/mock"
+)
+
+var (
+	_ stats.FlushableSink    = (*DogStatsdSink)(nil)
+	_ stats.DistributionSink = (*DogStatsdSink)(nil)
+)
+
+func readDatagram(t *testing.T, conn net.Conn) string {
+	t.Helper()
+	buf := make([]byte, 64*1024)
+	if err := conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func setupUDP(t *testing.T, opts ...Option) (*net.UDPConn, *DogStatsdSink) {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts = append([]Option{
+		WithAddress(conn.LocalAddr().String()),
+		WithFlushInterval(time.Hour),
+	}, opts...)
+	sink, err := NewDogStatsdSink(opts...)
+	if err != nil {
+		conn.Close()
+		t.Fatal(err)
+	}
+	return conn, sink
+}
+
+func TestDogStatsdSink_Stats(t *testing.T) {
+	conn, sink := setupUDP(t, WithGlobalTags(map[string]string{"env": "prod", "key1": "global"}))
+	defer conn.Close()
+	defer sink.Close()
+
+	sink.FlushCounter(mock.SerializeTags("counter", map[string]string{"key1": "val1"}), 1)
+	sink.FlushGauge("gauge", 2)
+	sink.FlushTimer("timer", 1.5)
+	sink.FlushDistribution("distribution", 3)
+	sink.Flush()
+
+	const expected = "counter:1|c|#env:prod,key1:val1\n" +
+		"gauge:2|g|#env:prod,key1:global\n" +
+		"timer:1.5|ms|#env:prod,key1:global\n" +
+		"distribution:3|d|#env:prod,key1:global\n"
+	if s := readDatagram(t, conn); s != expected {
+		t.Errorf("got: %q want: %q", s, expected)
+	}
+}
+
+func TestDogStatsdSink_SampleRate(t *testing.T) {
+	conn, sink := setupUDP(t, WithCounterSampleRate(0.5), WithGaugeSampleRate(0))
+	defer conn.Close()
+	defer sink.Close()
+
+	const N = 1000
+	for i := 0; i < N; i++ {
+		sink.FlushCounter("counter", 1)
+		sink.FlushGauge("gauge", 1)
+	}
+	sink.Flush()
+
+	var lines []string
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(time.Millisecond * 100)); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 64*1024)
+		n, err := conn.Read(buf)
+		if err != nil {
+			break
+		}
+		lines = append(lines, strings.Split(strings.TrimSpace(string(buf[:n])), "\n")...)
+	}
+	for _, line := range lines {
+		if line != "counter:1|c|@0.5" {
+			t.Fatalf("unexpected line: %q", line)
+		}
+	}
+	// the bounds are ~16 standard deviations from the expected count
+	if n := len(lines); n < N/4 || n > N*3/4 {
+		t.Errorf("sampled %d of %d counters at a rate of 0.5", n, N)
+	}
+}
+
+func TestDogStatsdSink_Event(t *testing.T) {
+	conn, sink := setupUDP(t, WithGlobalTags(map[string]string{"env": "prod"}))
+	defer conn.Close()
+	defer sink.Close()
+
+	sink.SendEvent("deploy", "line1\nline2", EventOptions{
+		Timestamp: time.Unix(1600000000, 0),
+		Hostname:  "host1",
+		Priority:  "low",
+		AlertType: "info",
+		Tags:      map[string]string{"version": "v2"},
+	})
+	sink.SendEvent("title", "text", EventOptions{})
+	sink.Flush()
+
+	const expected = "_e{6,12}:deploy|line1\\nline2|d:1600000000|h:host1|p:low|t:info|#env:prod,version:v2\n" +
+		"_e{5,4}:title|text|#env:prod\n"
+	if s := readDatagram(t, conn); s != expected {
+		t.Errorf("got: %q want: %q", s, expected)
+	}
+}
+
+func TestDogStatsdSink_ServiceCheck(t *testing.T) {
+	conn, sink := setupUDP(t)
+	defer conn.Close()
+	defer sink.Close()
+
+	sink.SendServiceCheck("db.up", StatusCritical, ServiceCheckOptions{
+		Timestamp: time.Unix(1600000000, 0),
+		Hostname:  "host1",
+		Message:   "connection\nrefused",
+		Tags:      map[string]string{"db": "main"},
+	})
+	sink.SendServiceCheck("db.up", StatusOK, ServiceCheckOptions{})
+	sink.Flush()
+
+	const expected = "_sc|db.up|2|d:1600000000|h:host1|#db:main|m:connection\\nrefused\n" +
+		"_sc|db.up|0\n"
+	if s := readDatagram(t, conn); s != expected {
+		t.Errorf("got: %q want: %q", s, expected)
+	}
+}
+
+func TestDogStatsdSink_UDS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gostats-dogstatsd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "dsd.socket")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sink, err := NewDogStatsdSink(WithAddress("unix://"+path), WithFlushInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if sink.maxSize != DefaultMaxDatagramSizeUDS {
+		t.Errorf("max datagram size: got: %d want: %d", sink.maxSize, DefaultMaxDatagramSizeUDS)
+	}
+
+	sink.FlushCounter("counter", 1)
+	sink.Flush()
+	if s := readDatagram(t, conn); s != "counter:1|c\n" {
+		t.Errorf("got: %q want: %q", s, "counter:1|c\n")
+	}
+}
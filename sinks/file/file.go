@@ -0,0 +1,295 @@
+// Package file provides a stats.Sink that appends the flushed stats to a file,
+// one event per line, and a FileReader to read them back. It is meant for
+// local development, where no statsd or Prometheus server is running, and for
+// integration tests.
+package file
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lyft/gostats/internal/tags"
+)
+
+// A Format is the encoding of the events in a file.
+type Format uint8
+
+// Formats of the events.
+const (
+	// FormatJSON writes each event as a JSON object, see MetricEvent.
+	FormatJSON Format = iota
+
+	// FormatCSV writes each event as a CSV record with the fields time
+	// (RFC 3339), type, name, value and tags. The tags are serialized as
+	// "key=value" pairs separated by ";", sorted by key.
+	FormatCSV
+)
+
+// The types of a MetricEvent.
+const (
+	TypeCounter = "counter"
+	TypeGauge   = "gauge"
+	TypeTimer   = "timer"
+)
+
+// A MetricEvent is a stat flushed to a FileSink.
+type MetricEvent struct {
+	Time time.Time `json:"time"`
+	Type string    `json:"type"` // TypeCounter, TypeGauge or TypeTimer
+	Name string    `json:"name"` // without tags
+	// Value of the stat, Counter and Gauge values larger than 2^53 lose
+	// precision.
+	Value float64           `json:"value"`
+	Tags  map[string]string `json:"tags,omitempty"`
+}
+
+// An Option configures a FileSink.
+type Option interface {
+	apply(*FileSink)
+}
+
+// optionFunc wraps a func so it satisfies the Option interface.
+type optionFunc func(*FileSink)
+
+func (f optionFunc) apply(sink *FileSink) {
+	f(sink)
+}
+
+// WithFormat sets the encoding of the events, the default is FormatJSON.
+func WithFormat(format Format) Option {
+	return optionFunc(func(sink *FileSink) {
+		sink.format = format
+	})
+}
+
+// WithMaxSize rotates the file when it holds at least n bytes if n is
+// greater than zero.
+func WithMaxSize(n int64) Option {
+	return optionFunc(func(sink *FileSink) {
+		sink.maxSize = n
+	})
+}
+
+// WithRotateInterval rotates the file when it was opened at least d ago if d
+// is greater than zero.
+func WithRotateInterval(d time.Duration) Option {
+	return optionFunc(func(sink *FileSink) {
+		sink.interval = d
+	})
+}
+
+// A FileSink is a stats.Sink that appends the flushed stats to a file, one
+// MetricEvent per line. Events are buffered, the buffer is written by Flush
+// and Sync.
+//
+// When the file is rotated, see WithMaxSize and WithRotateInterval, it is
+// renamed by appending the time of the rotation to its path, for example
+// "stats.log.20060102T150405.000000000", and a new file is created.
+type FileSink struct {
+	path     string
+	format   Format
+	maxSize  int64
+	interval time.Duration
+	now      func() time.Time
+
+	mu     sync.Mutex
+	f      *os.File
+	w      *bufio.Writer
+	size   int64     // bytes written to f, including buffered bytes
+	opened time.Time // when f was opened
+	err    error     // first error since the last call to Sync
+	line   []byte
+}
+
+// NewFileSink returns a FileSink that appends events to the file at path,
+// creating it if necessary.
+func NewFileSink(path string, opts ...Option) (*FileSink, error) {
+	s := &FileSink{path: path, now: time.Now}
+	for _, opt := range opts {
+		opt.apply(s)
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// open opens the file at s.path. s.mu must be held, if s is shared.
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f = f
+	s.w = bufio.NewWriter(f)
+	s.size = fi.Size()
+	s.opened = s.now()
+	return nil
+}
+
+// rotate renames the file and opens a new one. s.mu must be held.
+func (s *FileSink) rotate(now time.Time) error {
+	if err := s.closeFile(); err != nil {
+		return err
+	}
+	rotated := s.path + "." + now.UTC().Format("20060102T150405.000000000")
+	if err := os.Rename(s.path, rotated); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+// closeFile flushes the buffer and closes the file. s.mu must be held.
+func (s *FileSink) closeFile() error {
+	if s.f == nil {
+		return nil
+	}
+	err := s.w.Flush()
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	s.f, s.w = nil, nil
+	return err
+}
+
+func (s *FileSink) setErr(err error) {
+	if err != nil && s.err == nil {
+		s.err = err
+	}
+}
+
+func (s *FileSink) write(typ, stat string, value float64) {
+	name, tagMap := tags.ParseTags(stat)
+	now := s.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil { // closed or rotation failed
+		if err := s.open(); err != nil {
+			s.setErr(err)
+			return
+		}
+	}
+	if (s.maxSize > 0 && s.size >= s.maxSize) ||
+		(s.interval > 0 && now.Sub(s.opened) >= s.interval) {
+		if err := s.rotate(now); err != nil {
+			s.setErr(err)
+			return
+		}
+	}
+
+	var err error
+	s.line, err = appendEvent(s.line[:0], s.format, MetricEvent{
+		Time:  now,
+		Type:  typ,
+		Name:  name,
+		Value: value,
+		Tags:  tagMap,
+	})
+	if err != nil {
+		s.setErr(err)
+		return
+	}
+	n, err := s.w.Write(s.line)
+	s.size += int64(n)
+	s.setErr(err)
+}
+
+// appendEvent appends the line of e, including the newline, to b.
+func appendEvent(b []byte, format Format, e MetricEvent) ([]byte, error) {
+	if format == FormatCSV {
+		var sb strings.Builder
+		w := csv.NewWriter(&sb)
+		w.Write([]string{
+			e.Time.Format(time.RFC3339Nano),
+			e.Type,
+			e.Name,
+			strconv.FormatFloat(e.Value, 'g', -1, 64),
+			formatTags(e.Tags),
+		})
+		w.Flush()
+		return append(b, sb.String()...), w.Error()
+	}
+	p, err := json.Marshal(e)
+	if err != nil {
+		return b, err
+	}
+	return append(append(b, p...), '\n'), nil
+}
+
+func formatTags(m map[string]string) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			sb.WriteByte(';')
+		}
+		sb.WriteString(k)
+		sb.WriteByte('=')
+		sb.WriteString(m[k])
+	}
+	return sb.String()
+}
+
+// FlushCounter implements the stats.Sink.FlushCounter method.
+func (s *FileSink) FlushCounter(name string, value uint64) {
+	s.write(TypeCounter, name, float64(value))
+}
+
+// FlushGauge implements the stats.Sink.FlushGauge method.
+func (s *FileSink) FlushGauge(name string, value uint64) {
+	s.write(TypeGauge, name, float64(value))
+}
+
+// FlushTimer implements the stats.Sink.FlushTimer method.
+func (s *FileSink) FlushTimer(name string, value float64) {
+	s.write(TypeTimer, name, value)
+}
+
+// Flush implements the stats.FlushableSink.Flush method and writes the
+// buffered events to the file.
+func (s *FileSink) Flush() {
+	s.mu.Lock()
+	if s.w != nil {
+		s.setErr(s.w.Flush())
+	}
+	s.mu.Unlock()
+}
+
+// Sync implements the stats.Syncer interface. It writes the buffered events
+// to the file and returns the first error that occurred since the last call
+// to Sync.
+func (s *FileSink) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.w != nil {
+		s.setErr(s.w.Flush())
+	}
+	err := s.err
+	s.err = nil
+	return err
+}
+
+// Close writes the buffered events and closes the file. Events flushed after
+// Close reopen the file.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closeFile()
+}
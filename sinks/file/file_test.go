@@ -0,0 +1,166 @@
+package file
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	stats "github.com/lyft/gostats"
+)
+
+var (
+	_ stats.FlushableSink = (*FileSink)(nil)
+	_ stats.Syncer        = (*FileSink)(nil)
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "gostats-file-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func readAll(t *testing.T, r *FileReader) []MetricEvent {
+	t.Helper()
+	var events []MetricEvent
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			return events
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, e)
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	for _, format := range []Format{FormatJSON, FormatCSV} {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "stats.log")
+		sink, err := NewFileSink(path, WithFormat(format))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sink.now = func() time.Time { return now }
+
+		store := stats.NewStore(sink, false)
+		store.NewCounterWithTags("requests", map[string]string{"code": "200", "a": "b"}).Add(3)
+		store.NewTimer("latency").AddValue(1.5)
+		store.NewGauge("conns").Set(2)
+		if err := store.FlushAll(); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := NewFileReader(path, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exp := []MetricEvent{
+			{Time: now, Type: TypeTimer, Name: "latency", Value: 1.5},
+			{Time: now, Type: TypeCounter, Name: "requests", Value: 3, Tags: map[string]string{"a": "b", "code": "200"}},
+			{Time: now, Type: TypeGauge, Name: "conns", Value: 2},
+		}
+		if events := readAll(t, r); !reflect.DeepEqual(events, exp) {
+			t.Errorf("%d: events:\ngot:  %+v\nwant: %+v", format, events, exp)
+		}
+		r.Close()
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFileSinkRotate(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "stats.log")
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	sink, err := NewFileSink(path, WithMaxSize(1), WithRotateInterval(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.now = func() time.Time { return now }
+	r, err := NewFileReader(path, FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	var got []string
+	read := func() {
+		for _, e := range readAll(t, r) {
+			got = append(got, e.Name)
+		}
+	}
+
+	sink.FlushCounter("a", 1) // empty file: not rotated
+	sink.Sync()
+	read()
+	now = now.Add(time.Second)
+	sink.FlushCounter("b", 2) // rotated by size
+	sink.maxSize = 0
+	sink.FlushCounter("c", 3)
+	sink.Sync()
+	read()
+	now = now.Add(time.Minute)
+	sink.FlushCounter("d", 4) // rotated by time
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	read()
+
+	names, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 {
+		t.Fatalf("rotated files: got: %q want: 2 files", names)
+	}
+	if exp := []string{"a", "b", "c", "d"}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("events: got: %q want: %q", got, exp)
+	}
+}
+
+func TestFileReaderTail(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "stats.log")
+	sink, err := NewFileSink(path, WithFormat(FormatCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	r, err := NewFileReader(path, FormatCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := r.Tail(ctx, time.Millisecond)
+	for i := uint64(1); i <= 3; i++ {
+		sink.FlushGauge("g", i)
+		sink.Flush()
+		select {
+		case e := <-events:
+			if e.Type != TypeGauge || e.Name != "g" || e.Value != float64(i) {
+				t.Errorf("event: got: %+v want: gauge g = %d", e, i)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for an event")
+		}
+	}
+	cancel()
+	for range events {
+	}
+}
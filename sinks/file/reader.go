@@ -0,0 +1,169 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A FileReader reads the events written by a FileSink. It can be used to tail
+// a file that is being written to: Next returns io.EOF when there are no new
+// complete lines, and can be called again later. If the file is rotated the
+// reader switches to the new file once it read the rotated one, files
+// rotated more than once between two calls to Next are skipped.
+type FileReader struct {
+	path    string
+	format  Format
+	f       *os.File
+	r       *bufio.Reader
+	partial []byte   // incomplete last line
+	next    *os.File // file that replaced f when it was rotated
+}
+
+// NewFileReader returns a FileReader that reads the events of the file at
+// path, encoded in format.
+func NewFileReader(path string, format Format) (*FileReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &FileReader{path: path, format: format, f: f, r: bufio.NewReader(f)}, nil
+}
+
+// Next returns the next event of the file, or io.EOF if there are no complete
+// lines left to read.
+func (r *FileReader) Next() (MetricEvent, error) {
+	for {
+		line, err := r.r.ReadBytes('\n')
+		if err == nil {
+			if len(r.partial) != 0 {
+				line = append(r.partial, line...)
+				r.partial = r.partial[:0]
+			}
+			return parseEvent(r.format, line)
+		}
+		if err != io.EOF {
+			return MetricEvent{}, err
+		}
+		r.partial = append(r.partial, line...)
+
+		if r.next != nil { // the rotated file was read entirely
+			r.f.Close()
+			r.f, r.next = r.next, nil
+			r.r.Reset(r.f)
+			r.partial = r.partial[:0] // lines are not split across files
+			continue
+		}
+		next, err := r.rotated()
+		if err != nil {
+			return MetricEvent{}, err
+		}
+		if next == nil {
+			return MetricEvent{}, io.EOF
+		}
+		// read the events written to the file before it was rotated
+		r.next = next
+	}
+}
+
+// rotated opens the file at r.path if it is not the file being read.
+func (r *FileReader) rotated() (*os.File, error) {
+	fi, err := os.Stat(r.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil // being rotated
+		}
+		return nil, err
+	}
+	cur, err := r.f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if os.SameFile(fi, cur) {
+		return nil, nil
+	}
+	return os.Open(r.path)
+}
+
+// Tail sends the events of the file on the returned channel, polling for new
+// events every interval, until ctx is done or an error other than io.EOF
+// occurs. The channel is closed when Tail returns. The FileReader must not be
+// used concurrently.
+func (r *FileReader) Tail(ctx context.Context, interval time.Duration) <-chan MetricEvent {
+	ch := make(chan MetricEvent)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			e, err := r.Next()
+			switch err {
+			case nil:
+				select {
+				case ch <- e:
+				case <-ctx.Done():
+					return
+				}
+				continue
+			case io.EOF:
+			default:
+				return
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// Close closes the file.
+func (r *FileReader) Close() error {
+	if r.next != nil {
+		r.next.Close()
+	}
+	return r.f.Close()
+}
+
+func parseEvent(format Format, line []byte) (MetricEvent, error) {
+	var e MetricEvent
+	if format != FormatCSV {
+		err := json.Unmarshal(line, &e)
+		return e, err
+	}
+
+	rec, err := csv.NewReader(bytes.NewReader(line)).Read()
+	if err != nil {
+		return e, err
+	}
+	if len(rec) != 5 {
+		return e, fmt.Errorf("file: invalid CSV event: %q", line)
+	}
+	if e.Time, err = time.Parse(time.RFC3339Nano, rec[0]); err != nil {
+		return e, err
+	}
+	e.Type = rec[1]
+	e.Name = rec[2]
+	if e.Value, err = strconv.ParseFloat(rec[3], 64); err != nil {
+		return e, err
+	}
+	if rec[4] != "" {
+		e.Tags = make(map[string]string)
+		for _, kv := range strings.Split(rec[4], ";") {
+			if i := strings.IndexByte(kv, '='); i != -1 {
+				e.Tags[kv[:i]] = kv[i+1:]
+			}
+		}
+	}
+	return e, nil
+}
@@ -0,0 +1,209 @@
+// Package filter provides a stats.Sink that drops stats based on their name.
+package filter
+
+import (
+	"io"
+	"math"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	stats "github.com/lyft/gostats"
+)
+
+// A Mode controls what a FilterSink does with stats that match its filters.
+type Mode uint8
+
+const (
+	// Denylist drops stats that match any filter.
+	Denylist Mode = iota
+	// Allowlist only passes stats that match a filter.
+	Allowlist
+)
+
+// An Option configures a FilterSink.
+type Option interface {
+	apply(*FilterSink)
+}
+
+type optionFunc func(*FilterSink)
+
+func (f optionFunc) apply(s *FilterSink) { f(s) }
+
+// WithPatterns matches stats whose name matches any of patterns.
+func WithPatterns(patterns ...*regexp.Regexp) Option {
+	return optionFunc(func(s *FilterSink) {
+		s.patterns = append(s.patterns, patterns...)
+	})
+}
+
+// WithPrefixes matches stats whose name starts with any of prefixes.
+func WithPrefixes(prefixes ...string) Option {
+	return optionFunc(func(s *FilterSink) {
+		s.prefixes = append(s.prefixes, prefixes...)
+	})
+}
+
+// WithSuffixes matches stats whose name ends with any of suffixes.
+func WithSuffixes(suffixes ...string) Option {
+	return optionFunc(func(s *FilterSink) {
+		s.suffixes = append(s.suffixes, suffixes...)
+	})
+}
+
+// A FilterSink wraps a stats.Sink and drops stats according to its Mode.
+//
+// Filters are matched against the name passed to the sink, which includes
+// any serialized tags (e.g. "name.__key=value").
+type FilterSink struct {
+	sink     stats.Sink
+	mode     Mode
+	patterns []*regexp.Regexp
+	prefixes []string
+	suffixes []string
+	matched  int64 // atomic
+}
+
+// NewFilterSink returns a FilterSink that filters stats flushed to sink.
+func NewFilterSink(sink stats.Sink, mode Mode, opts ...Option) *FilterSink {
+	s := &FilterSink{sink: sink, mode: mode}
+	for _, opt := range opts {
+		opt.apply(s)
+	}
+	return s
+}
+
+// MatchedCount returns the number of flushed stats that matched a filter.
+func (s *FilterSink) MatchedCount() int64 {
+	return atomic.LoadInt64(&s.matched)
+}
+
+func (s *FilterSink) match(name string) bool {
+	for _, p := range s.prefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	for _, p := range s.suffixes {
+		if strings.HasSuffix(name, p) {
+			return true
+		}
+	}
+	for _, re := range s.patterns {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// pass reports if the stat should be passed to the underlying sink.
+func (s *FilterSink) pass(name string) bool {
+	matched := s.match(name)
+	if matched {
+		atomic.AddInt64(&s.matched, 1)
+	}
+	return matched == (s.mode == Allowlist)
+}
+
+func (s *FilterSink) FlushCounter(name string, value uint64) {
+	if s.pass(name) {
+		s.sink.FlushCounter(name, value)
+	}
+}
+
+func (s *FilterSink) FlushGauge(name string, value uint64) {
+	if s.pass(name) {
+		s.sink.FlushGauge(name, value)
+	}
+}
+
+func (s *FilterSink) FlushTimer(name string, value float64) {
+	if s.pass(name) {
+		s.sink.FlushTimer(name, value)
+	}
+}
+
+// FlushDistribution passes the distribution to the underlying sink if it
+// implements stats.DistributionSink, otherwise the value is flushed as a
+// gauge.
+func (s *FilterSink) FlushDistribution(name string, value float64) {
+	if !s.pass(name) {
+		return
+	}
+	if ds, ok := s.sink.(stats.DistributionSink); ok {
+		ds.FlushDistribution(name, value)
+	} else {
+		if value < 0 {
+			value = 0
+		}
+		s.sink.FlushGauge(name, uint64(value))
+	}
+}
+
+// FlushUpDownCounter passes the value to the underlying sink if it implements
+// stats.UpDownCounterSink, otherwise the value is flushed as a gauge.
+func (s *FilterSink) FlushUpDownCounter(name string, value int64) {
+	if !s.pass(name) {
+		return
+	}
+	if us, ok := s.sink.(stats.UpDownCounterSink); ok {
+		us.FlushUpDownCounter(name, value)
+	} else {
+		if value < 0 {
+			value = 0
+		}
+		s.sink.FlushGauge(name, uint64(value))
+	}
+}
+
+// FlushFloatGauge passes the value to the underlying sink if it implements
+// stats.FloatGaugeSink, otherwise the value is flushed as a gauge.
+func (s *FilterSink) FlushFloatGauge(name string, value float64) {
+	if !s.pass(name) {
+		return
+	}
+	if fs, ok := s.sink.(stats.FloatGaugeSink); ok {
+		fs.FlushFloatGauge(name, value)
+	} else {
+		if value < 0 || math.IsNaN(value) {
+			value = 0
+		}
+		s.sink.FlushGauge(name, uint64(value))
+	}
+}
+
+// Sync syncs the underlying sink if it implements stats.Syncer, otherwise
+// it flushes it if it implements stats.FlushableSink.
+func (s *FilterSink) Sync() error {
+	switch sink := s.sink.(type) {
+	case stats.Syncer:
+		return sink.Sync()
+	case stats.FlushableSink:
+		sink.Flush()
+	}
+	return nil
+}
+
+// Close closes the underlying sink if it implements io.Closer.
+func (s *FilterSink) Close() error {
+	if c, ok := s.sink.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// SetHelp sets the help text of name on the underlying sink if it implements
+// stats.HelpSink.
+func (s *FilterSink) SetHelp(name, help string) {
+	if hs, ok := s.sink.(stats.HelpSink); ok {
+		hs.SetHelp(name, help)
+	}
+}
+
+// Flush flushes the underlying sink if it implements stats.FlushableSink.
+func (s *FilterSink) Flush() {
+	if fs, ok := s.sink.(stats.FlushableSink); ok {
+		fs.Flush()
+	}
+}
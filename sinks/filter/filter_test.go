@@ -0,0 +1,77 @@
+package filter
+
+import (
+	"regexp"
+	"testing"
+
+	stats "github.com/lyft/gostats"
+	"github.com/lyft/gostats/mock"
+)
+
+var (
+	_ stats.FlushableSink     = (*FilterSink)(nil)
+	_ stats.DistributionSink  = (*FilterSink)(nil)
+	_ stats.UpDownCounterSink = (*FilterSink)(nil)
+	_ stats.FloatGaugeSink    = (*FilterSink)(nil)
+	_ stats.Syncer            = (*FilterSink)(nil)
+)
+
+func flushAll(s *FilterSink) {
+	s.FlushCounter("svc.requests", 1)
+	s.FlushGauge("svc.debug.queue", 2)
+	s.FlushTimer("svc.latency_ms", 3)
+	s.FlushDistribution("runtime.alloc", 4)
+	s.FlushUpDownCounter("svc.debug.conns", -1)
+}
+
+func TestDenylist(t *testing.T) {
+	m := mock.NewSink()
+	s := NewFilterSink(m, Denylist,
+		WithPrefixes("runtime."),
+		WithSuffixes("_ms"),
+		WithPatterns(regexp.MustCompile(`\.debug\.`)),
+	)
+	flushAll(s)
+
+	m.AssertCounterEquals(t, "svc.requests", 1)
+	m.AssertGaugeNotExists(t, "svc.debug.queue")
+	m.AssertTimerNotExists(t, "svc.latency_ms")
+	m.AssertDistributionNotExists(t, "runtime.alloc")
+	m.AssertUpDownCounterNotExists(t, "svc.debug.conns")
+	if n := s.MatchedCount(); n != 4 {
+		t.Errorf("MatchedCount: got: %d want: %d", n, 4)
+	}
+}
+
+func TestAllowlist(t *testing.T) {
+	m := mock.NewSink()
+	s := NewFilterSink(m, Allowlist,
+		WithPrefixes("svc."),
+		WithPatterns(regexp.MustCompile(`alloc$`)),
+	)
+	flushAll(s)
+	s.FlushCounter("other", 5)
+
+	m.AssertCounterEquals(t, "svc.requests", 1)
+	m.AssertGaugeEquals(t, "svc.debug.queue", 2)
+	m.AssertTimerEquals(t, "svc.latency_ms", 3)
+	m.AssertDistributionEquals(t, "runtime.alloc", 4)
+	m.AssertUpDownCounterEquals(t, "svc.debug.conns", -1)
+	m.AssertCounterNotExists(t, "other")
+	if n := s.MatchedCount(); n != 5 {
+		t.Errorf("MatchedCount: got: %d want: %d", n, 5)
+	}
+}
+
+func TestTaggedName(t *testing.T) {
+	m := mock.NewSink()
+	store := stats.NewStore(NewFilterSink(m, Denylist,
+		WithPatterns(regexp.MustCompile(`\.__env=dev(\.|$)`)),
+	), false)
+	store.NewCounterWithTags("c", map[string]string{"env": "dev"}).Inc()
+	store.NewCounterWithTags("c", map[string]string{"env": "prod"}).Inc()
+	store.Flush()
+
+	m.AssertCounterNotExists(t, "c.__env=dev")
+	m.AssertCounterEquals(t, "c.__env=prod", 1)
+}
@@ -0,0 +1,311 @@
+// Package graphite provides a stats.Sink that writes stats to a Carbon relay
+// using the Graphite plaintext protocol.
+package graphite
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lyft/gostats/internal/tags"
+)
+
+const (
+	// DefaultAddress is the default address of the Carbon relay.
+	DefaultAddress = "localhost:2003"
+
+	// DefaultMinBackoff is the default delay before the first reconnect
+	// attempt.
+	DefaultMinBackoff = 100 * time.Millisecond
+
+	// DefaultMaxBackoff is the default maximum delay between reconnect
+	// attempts.
+	DefaultMaxBackoff = 30 * time.Second
+
+	// DefaultQueueSize is the default number of lines queued while the sink
+	// is disconnected.
+	DefaultQueueSize = 8192
+
+	flushInterval = time.Second
+	dialTimeout   = time.Second
+	writeTimeout  = time.Second
+)
+
+// An Option configures a GraphiteSink.
+type Option interface {
+	apply(*GraphiteSink)
+}
+
+// optionFunc wraps a func so it satisfies the Option interface.
+type optionFunc func(*GraphiteSink)
+
+func (f optionFunc) apply(sink *GraphiteSink) {
+	f(sink)
+}
+
+// WithAddress sets the "host:port" address of the Carbon relay, the default
+// is DefaultAddress.
+func WithAddress(addr string) Option {
+	return optionFunc(func(sink *GraphiteSink) {
+		sink.addr = addr
+	})
+}
+
+// WithBackoff sets the minimum and maximum delay between reconnect attempts.
+// The delay doubles after every failed attempt.
+func WithBackoff(min, max time.Duration) Option {
+	return optionFunc(func(sink *GraphiteSink) {
+		sink.minBackoff = min
+		sink.maxBackoff = max
+	})
+}
+
+// WithQueueSize sets the number of lines that are queued while the sink is
+// disconnected, once the queue is full lines are dropped. The default is
+// DefaultQueueSize.
+func WithQueueSize(n int) Option {
+	return optionFunc(func(sink *GraphiteSink) {
+		sink.queueSize = n
+	})
+}
+
+// A GraphiteSink is a stats.FlushableSink that writes stats to a Carbon relay
+// over a persistent TCP connection. Stats are written as:
+//
+//	name.key1=value1.key2=value2 <value> <timestamp>
+//
+// When the connection fails the sink reconnects with an exponential backoff,
+// lines are queued while it is disconnected and dropped once the queue is
+// full.
+type GraphiteSink struct {
+	addr       string
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	queueSize  int
+	now        func() time.Time
+
+	names    sync.Map // stat name => metric path
+	dropped  uint64   // atomic
+	buffered int      // lines buffered by the writer, only accessed by run()
+
+	lines  chan []byte
+	flushc chan chan struct{}
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewGraphiteSink returns a new GraphiteSink configured with opts. The
+// connection is established in the background.
+func NewGraphiteSink(opts ...Option) *GraphiteSink {
+	s := &GraphiteSink{
+		addr:       DefaultAddress,
+		minBackoff: DefaultMinBackoff,
+		maxBackoff: DefaultMaxBackoff,
+		queueSize:  DefaultQueueSize,
+		now:        time.Now,
+		flushc:     make(chan chan struct{}, 8),
+		done:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt.apply(s)
+	}
+	if s.minBackoff <= 0 {
+		s.minBackoff = DefaultMinBackoff
+	}
+	if s.maxBackoff < s.minBackoff {
+		s.maxBackoff = s.minBackoff
+	}
+	if s.queueSize <= 0 {
+		s.queueSize = DefaultQueueSize
+	}
+	s.lines = make(chan []byte, s.queueSize)
+
+	s.wg.Add(1)
+	go s.run()
+	return s
+}
+
+// Dropped returns the number of lines that have been dropped because the
+// queue was full or the connection failed while writing them.
+func (s *GraphiteSink) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Flush blocks until the queued lines are written to the connection. If
+// the sink is disconnected Flush returns without waiting for a connection.
+func (s *GraphiteSink) Flush() {
+	ch := make(chan struct{})
+	select {
+	case s.flushc <- ch:
+		<-ch
+	case <-s.done:
+	}
+}
+
+// Close writes any queued lines, if connected, and closes the connection.
+// The GraphiteSink must not be used after Close is called.
+func (s *GraphiteSink) Close() error {
+	close(s.done)
+	s.wg.Wait()
+	return nil
+}
+
+func (s *GraphiteSink) run() {
+	defer s.wg.Done()
+
+	var conn net.Conn
+	var w *bufio.Writer
+	backoff := s.minBackoff
+
+	t := time.NewTicker(flushInterval)
+	defer t.Stop()
+
+	for {
+		if conn == nil {
+			c, err := net.DialTimeout("tcp", s.addr, dialTimeout)
+			if err != nil {
+				if !s.wait(backoff) {
+					return
+				}
+				if backoff *= 2; backoff > s.maxBackoff {
+					backoff = s.maxBackoff
+				}
+				continue
+			}
+			conn, backoff = c, s.minBackoff
+			w = bufio.NewWriter(&deadlineWriter{conn})
+			s.buffered = 0
+		}
+
+		var err error
+		select {
+		case line := <-s.lines:
+			err = s.write(w, line)
+		case <-t.C:
+			err = s.flush(w)
+		case ch := <-s.flushc:
+			err = s.drain(w)
+			close(ch)
+		case <-s.done:
+			s.drain(w)
+			conn.Close()
+			return
+		}
+		if err != nil {
+			conn.Close()
+			conn = nil
+		}
+	}
+}
+
+// wait waits for d while disconnected, flush requests are released
+// immediately. It returns false if the sink was closed.
+func (s *GraphiteSink) wait(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			return true
+		case ch := <-s.flushc:
+			close(ch)
+		case <-s.done:
+			return false
+		}
+	}
+}
+
+func (s *GraphiteSink) write(w *bufio.Writer, line []byte) error {
+	if w.Available() < len(line) {
+		if err := s.flush(w); err != nil {
+			atomic.AddUint64(&s.dropped, 1)
+			return err
+		}
+	}
+	w.Write(line) // the buffer has room so this cannot fail
+	s.buffered++
+	return nil
+}
+
+// flush flushes w, on error the lines in the buffer are dropped.
+func (s *GraphiteSink) flush(w *bufio.Writer) error {
+	err := w.Flush()
+	if err != nil {
+		atomic.AddUint64(&s.dropped, uint64(s.buffered))
+	}
+	s.buffered = 0
+	return err
+}
+
+// drain writes the lines currently in the queue and flushes w.
+func (s *GraphiteSink) drain(w *bufio.Writer) error {
+	for n := len(s.lines); n > 0; n-- {
+		if err := s.write(w, <-s.lines); err != nil {
+			return err
+		}
+	}
+	return s.flush(w)
+}
+
+func (s *GraphiteSink) path(stat string) string {
+	if v, ok := s.names.Load(stat); ok {
+		return v.(string)
+	}
+	name, set := tags.ParseTagSet(stat)
+	b := []byte(name)
+	for _, t := range set {
+		b = append(b, '.')
+		b = append(b, t.Key...)
+		b = append(b, '=')
+		b = append(b, t.Value...)
+	}
+	v, _ := s.names.LoadOrStore(stat, string(b))
+	return v.(string)
+}
+
+func (s *GraphiteSink) writeLine(stat string, value []byte) {
+	path := s.path(stat)
+	b := make([]byte, 0, len(path)+len(value)+24)
+	b = append(b, path...)
+	b = append(b, ' ')
+	b = append(b, value...)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, s.now().Unix(), 10)
+	b = append(b, '\n')
+	select {
+	case s.lines <- b:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// FlushCounter implements the stats.Sink.FlushCounter method.
+func (s *GraphiteSink) FlushCounter(name string, value uint64) {
+	var b [20]byte
+	s.writeLine(name, strconv.AppendUint(b[:0], value, 10))
+}
+
+// FlushGauge implements the stats.Sink.FlushGauge method.
+func (s *GraphiteSink) FlushGauge(name string, value uint64) {
+	var b [20]byte
+	s.writeLine(name, strconv.AppendUint(b[:0], value, 10))
+}
+
+// FlushTimer implements the stats.Sink.FlushTimer method.
+func (s *GraphiteSink) FlushTimer(name string, value float64) {
+	var b [32]byte
+	s.writeLine(name, strconv.AppendFloat(b[:0], value, 'f', -1, 64))
+}
+
+// deadlineWriter sets a write deadline before every write to conn.
+type deadlineWriter struct {
+	conn net.Conn
+}
+
+func (w *deadlineWriter) Write(p []byte) (int, error) {
+	w.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return w.conn.Write(p)
+}
@@ -0,0 +1,137 @@
+package graphite
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	stats "github.com/lyft/gostats"
+	"github.com/lyft/gostats/mock"
+)
+
+var _ stats.FlushableSink = (*GraphiteSink)(nil)
+
+func listen(t *testing.T) net.Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func accept(t *testing.T, l net.Listener) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	l.(*net.TCPListener).SetDeadline(time.Now().Add(time.Second * 5))
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	return conn, bufio.NewReader(conn)
+}
+
+func fixedTime(s *GraphiteSink) {
+	s.now = func() time.Time { return time.Unix(1600000000, 0) }
+}
+
+func TestGraphiteSink_LineFormat(t *testing.T) {
+	l := listen(t)
+	defer l.Close()
+
+	sink := NewGraphiteSink(WithAddress(l.Addr().String()))
+	fixedTime(sink)
+	defer sink.Close()
+
+	conn, r := accept(t, l)
+	defer conn.Close()
+
+	sink.FlushCounter(mock.SerializeTags("service.requests", map[string]string{"code": "200", "method": "get"}), 1)
+	sink.FlushGauge("service.conns", 2)
+	sink.FlushTimer("service.latency", 1.5)
+	sink.Flush()
+
+	expected := []string{
+		"service.requests.code=200.method=get 1 1600000000\n",
+		"service.conns 2 1600000000\n",
+		"service.latency 1.5 1600000000\n",
+	}
+	for _, exp := range expected {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line != exp {
+			t.Errorf("got: %q want: %q", line, exp)
+		}
+	}
+}
+
+func TestGraphiteSink_Reconnect(t *testing.T) {
+	l := listen(t)
+	defer l.Close()
+
+	sink := NewGraphiteSink(
+		WithAddress(l.Addr().String()),
+		WithBackoff(time.Millisecond, time.Millisecond*10),
+	)
+	fixedTime(sink)
+	defer sink.Close()
+
+	conn, r := accept(t, l)
+	sink.FlushGauge("before", 1)
+	sink.Flush()
+	if line, err := r.ReadString('\n'); err != nil || line != "before 1 1600000000\n" {
+		t.Fatalf("unexpected line: %q: %v", line, err)
+	}
+	conn.Close()
+
+	// keep writing until a write fails and the sink reconnects
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				sink.FlushGauge("after", 2)
+				sink.Flush()
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+
+	conn, r = accept(t, l)
+	defer conn.Close()
+	if line, err := r.ReadString('\n'); err != nil || line != "after 2 1600000000\n" {
+		t.Fatalf("unexpected line after reconnect: %q: %v", line, err)
+	}
+}
+
+func TestGraphiteSink_FlushDisconnected(t *testing.T) {
+	l := listen(t)
+	addr := l.Addr().String()
+	l.Close() // nothing is listening
+
+	sink := NewGraphiteSink(WithAddress(addr), WithQueueSize(1))
+	defer sink.Close()
+
+	sink.FlushCounter("c", 1)
+	sink.FlushCounter("c", 1) // queue is full
+
+	done := make(chan struct{})
+	go func() {
+		sink.Flush()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Flush blocked while disconnected")
+	}
+	if n := sink.Dropped(); n != 1 {
+		t.Errorf("Dropped: got: %d want: %d", n, 1)
+	}
+}
@@ -0,0 +1,402 @@
+// Package grpc provides a stats.Sink that streams stats to a gRPC server
+// implementing the MetricsService defined in metricspb/metrics.proto.
+package grpc
+
+//go:generate protoc -I metricspb --go_out=metricspb --go_opt=paths=source_relative --go-grpc_out=metricspb --go-grpc_opt=paths=source_relative metrics.proto
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/lyft/gostats/internal/tags"
+	"github.com/lyft/gostats/sinks/grpc/metricspb"
+	"google.golang.org/grpc"
+)
+
+// Defaults of the GRPCStreamSink options.
+const (
+	DefaultBatchSize        = 500
+	DefaultBufferSize       = 50000
+	DefaultReconnectBackoff = 100 * time.Millisecond
+	MaxReconnectBackoff     = 30 * time.Second
+)
+
+// An Option configures a GRPCStreamSink.
+type Option interface {
+	apply(*GRPCStreamSink)
+}
+
+// optionFunc wraps a func so it satisfies the Option interface.
+type optionFunc func(*GRPCStreamSink)
+
+func (f optionFunc) apply(sink *GRPCStreamSink) {
+	f(sink)
+}
+
+// WithBatchSize sets the maximum number of metrics sent in a
+// StreamMetricsRequest, the default is DefaultBatchSize.
+func WithBatchSize(n int) Option {
+	return optionFunc(func(sink *GRPCStreamSink) {
+		sink.batchSize = n
+	})
+}
+
+// WithBufferSize sets the maximum number of metrics buffered by the sink,
+// including the metrics sent but not acknowledged by the server yet, the
+// default is DefaultBufferSize. Metrics flushed while the buffer is full are
+// dropped.
+func WithBufferSize(n int) Option {
+	return optionFunc(func(sink *GRPCStreamSink) {
+		sink.bufferSize = n
+	})
+}
+
+// WithReconnectBackoff sets the delay before the first attempt to reopen a
+// stream after a failure, the default is DefaultReconnectBackoff. The delay
+// doubles after each consecutive failure, up to MaxReconnectBackoff.
+func WithReconnectBackoff(d time.Duration) Option {
+	return optionFunc(func(sink *GRPCStreamSink) {
+		sink.backoff = d
+	})
+}
+
+// WithCallOptions sets the options of the StreamMetrics calls.
+func WithCallOptions(opts ...grpc.CallOption) Option {
+	return optionFunc(func(sink *GRPCStreamSink) {
+		sink.callOpts = opts
+	})
+}
+
+type inflightBatch struct {
+	seq     uint64
+	metrics []*metricspb.Metric
+}
+
+// A GRPCStreamSink is a stats.Sink that streams the flushed stats to a
+// MetricsService server. Stats are buffered and sent in batches, a batch is
+// sent when it is full or when Flush or Sync is called.
+//
+// Batches are kept in the buffer until the server acknowledges them. If the
+// stream fails the sink reopens it, with an exponential backoff, and resends
+// the batches that were not acknowledged, so a server may receive a batch
+// more than once. The underlying connection is managed by the
+// grpc.ClientConn.
+type GRPCStreamSink struct {
+	client     metricspb.MetricsServiceClient
+	callOpts   []grpc.CallOption
+	batchSize  int
+	bufferSize int
+	backoff    time.Duration
+	now        func() time.Time
+
+	ctx     context.Context // canceled when the sender returns
+	cancel  context.CancelFunc
+	closing chan struct{} // closed by Close
+	done    chan struct{} // closed when the sender returns
+
+	closeOnce sync.Once
+
+	mu       sync.Mutex
+	cond     *sync.Cond // signaled when the state below changes
+	queue    []*metricspb.Metric
+	inflight []inflightBatch // sent and not acknowledged, by sequence
+	seq      uint64          // sequence of the last batch sent
+	gen      uint64          // incremented when a stream fails
+	flushing bool            // send the queue even if it is not a full batch
+	closed   bool
+	err      error  // error of the last failure
+	failures uint64 // number of failures
+	dropped  uint64
+	closeErr error // error that caused metrics to be dropped by Close
+}
+
+// NewGRPCStreamSink returns a GRPCStreamSink that streams stats over cc,
+// usually a *grpc.ClientConn.
+func NewGRPCStreamSink(cc grpc.ClientConnInterface, opts ...Option) *GRPCStreamSink {
+	s := &GRPCStreamSink{
+		client:     metricspb.NewMetricsServiceClient(cc),
+		batchSize:  DefaultBatchSize,
+		bufferSize: DefaultBufferSize,
+		backoff:    DefaultReconnectBackoff,
+		now:        time.Now,
+		closing:    make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt.apply(s)
+	}
+	if s.batchSize <= 0 {
+		s.batchSize = DefaultBatchSize
+	}
+	if s.bufferSize < s.batchSize {
+		s.bufferSize = s.batchSize
+	}
+	if s.backoff <= 0 {
+		s.backoff = DefaultReconnectBackoff
+	}
+	s.cond = sync.NewCond(&s.mu)
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	go s.run()
+	return s
+}
+
+func (s *GRPCStreamSink) add(typ metricspb.Metric_Type, stat string, value float64) {
+	name, tagMap := tags.ParseTags(stat)
+	m := &metricspb.Metric{
+		Name:              name,
+		Type:              typ,
+		Value:             value,
+		Tags:              tagMap,
+		TimestampUnixNano: s.now().UnixNano(),
+	}
+
+	s.mu.Lock()
+	if s.closed || len(s.queue)+s.inflightLen() >= s.bufferSize {
+		s.dropped++
+	} else {
+		s.queue = append(s.queue, m)
+		if len(s.queue) == s.batchSize {
+			s.cond.Broadcast()
+		}
+	}
+	s.mu.Unlock()
+}
+
+// inflightLen returns the number of metrics sent and not acknowledged. s.mu
+// must be held.
+func (s *GRPCStreamSink) inflightLen() int {
+	n := 0
+	for _, b := range s.inflight {
+		n += len(b.metrics)
+	}
+	return n
+}
+
+// FlushCounter implements the stats.Sink.FlushCounter method.
+func (s *GRPCStreamSink) FlushCounter(name string, value uint64) {
+	s.add(metricspb.Metric_TYPE_COUNTER, name, float64(value))
+}
+
+// FlushGauge implements the stats.Sink.FlushGauge method.
+func (s *GRPCStreamSink) FlushGauge(name string, value uint64) {
+	s.add(metricspb.Metric_TYPE_GAUGE, name, float64(value))
+}
+
+// FlushTimer implements the stats.Sink.FlushTimer method.
+func (s *GRPCStreamSink) FlushTimer(name string, value float64) {
+	s.add(metricspb.Metric_TYPE_TIMER, name, value)
+}
+
+// Flush implements the stats.FlushableSink.Flush method. It sends the
+// buffered stats without waiting for them to be acknowledged.
+func (s *GRPCStreamSink) Flush() {
+	s.mu.Lock()
+	s.flushing = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+// Sync implements the stats.Syncer interface. It sends the buffered stats and
+// waits until the server acknowledged them or the stream failed, in which
+// case the error is returned and the stats are resent on a new stream.
+func (s *GRPCStreamSink) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	failures := s.failures
+	s.flushing = true
+	s.cond.Broadcast()
+	for (len(s.queue) != 0 || len(s.inflight) != 0) && s.failures == failures {
+		s.cond.Wait()
+	}
+	if s.failures != failures {
+		return s.err
+	}
+	return nil
+}
+
+// Dropped returns the number of metrics dropped because the buffer was full
+// or the sink was closed.
+func (s *GRPCStreamSink) Dropped() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// Close sends the buffered stats, waits for them to be acknowledged and
+// closes the stream. If the stream fails the remaining stats are dropped and
+// the error is returned. Stats flushed after Close are dropped.
+func (s *GRPCStreamSink) Close() error {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.closed = true
+		close(s.closing)
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	})
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closeErr
+}
+
+// next waits for a batch to send, moves it to the inflight batches and
+// returns it with its sequence and the generation of the stream it must be
+// sent on. It returns false when the sink is closed and all the batches were
+// acknowledged.
+func (s *GRPCStreamSink) next() ([]*metricspb.Metric, uint64, uint64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.queue) == 0 || (len(s.queue) < s.batchSize && !s.flushing && !s.closed) {
+		if s.closed && len(s.queue) == 0 && len(s.inflight) == 0 {
+			return nil, 0, 0, false
+		}
+		s.cond.Wait()
+	}
+	n := len(s.queue)
+	if n > s.batchSize {
+		n = s.batchSize
+	}
+	batch := append([]*metricspb.Metric(nil), s.queue[:n]...)
+	s.queue = append(s.queue[:0], s.queue[n:]...)
+	if len(s.queue) == 0 {
+		s.flushing = false
+	}
+	s.seq++
+	s.inflight = append(s.inflight, inflightBatch{seq: s.seq, metrics: batch})
+	return batch, s.seq, s.gen, true
+}
+
+// ack removes the batches acknowledged by seq on the stream gen.
+func (s *GRPCStreamSink) ack(gen, seq uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if gen != s.gen {
+		return
+	}
+	i := 0
+	for i < len(s.inflight) && s.inflight[i].seq <= seq {
+		i++
+	}
+	s.inflight = append(s.inflight[:0], s.inflight[i:]...)
+	s.cond.Broadcast()
+}
+
+// fail handles the failure of the stream gen: the inflight batches are
+// queued again to be resent on a new stream, or dropped if the sink is
+// closed.
+func (s *GRPCStreamSink) fail(gen uint64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if gen != s.gen {
+		return // already handled
+	}
+	s.gen++
+	s.err = err
+	s.failures++
+
+	var queue []*metricspb.Metric
+	for _, b := range s.inflight {
+		queue = append(queue, b.metrics...)
+	}
+	s.inflight = s.inflight[:0]
+	queue = append(queue, s.queue...)
+	if s.closed {
+		s.dropped += uint64(len(queue))
+		s.closeErr = err
+		queue = nil
+	}
+	s.queue = queue
+	s.cond.Broadcast()
+}
+
+// retire increments the generation so that the errors of the stream gen are
+// ignored, it is called before closing a stream.
+func (s *GRPCStreamSink) retire(gen uint64) {
+	s.mu.Lock()
+	if gen == s.gen {
+		s.gen++
+	}
+	s.mu.Unlock()
+}
+
+// waitFailure waits until the failure of the stream gen is handled.
+func (s *GRPCStreamSink) waitFailure(gen uint64) {
+	s.mu.Lock()
+	for s.gen == gen {
+		s.cond.Wait()
+	}
+	s.mu.Unlock()
+}
+
+// wait waits for d or until the sink is closed.
+func (s *GRPCStreamSink) wait(d time.Duration) {
+	t := time.NewTimer(d)
+	select {
+	case <-t.C:
+	case <-s.closing:
+		t.Stop()
+	}
+}
+
+// run sends the batches until the sink is closed.
+func (s *GRPCStreamSink) run() {
+	defer close(s.done)
+	defer s.cancel()
+
+	var stream metricspb.MetricsService_StreamMetricsClient
+	var gen uint64
+	backoff := s.backoff
+	for {
+		batch, seq, cur, ok := s.next()
+		if !ok {
+			break
+		}
+		if stream == nil || gen != cur {
+			if stream != nil {
+				stream.CloseSend()
+			}
+			var err error
+			stream, err = s.client.StreamMetrics(s.ctx, s.callOpts...)
+			if err != nil {
+				stream = nil
+				s.fail(cur, err)
+				s.wait(backoff)
+				if backoff *= 2; backoff > MaxReconnectBackoff {
+					backoff = MaxReconnectBackoff
+				}
+				continue
+			}
+			gen = cur
+			go s.recv(stream, gen)
+		}
+		req := &metricspb.StreamMetricsRequest{Sequence: seq, Metrics: batch}
+		if err := stream.Send(req); err != nil {
+			// the status of the stream is returned by Recv
+			s.waitFailure(gen)
+			s.wait(backoff)
+			if backoff *= 2; backoff > MaxReconnectBackoff {
+				backoff = MaxReconnectBackoff
+			}
+			continue
+		}
+		backoff = s.backoff
+	}
+	if stream != nil {
+		s.retire(gen)
+		stream.CloseSend()
+	}
+}
+
+// recv receives the acknowledgements of stream until it fails.
+func (s *GRPCStreamSink) recv(stream metricspb.MetricsService_StreamMetricsClient, gen uint64) {
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			s.fail(gen, err)
+			return
+		}
+		s.ack(gen, resp.GetSequence())
+	}
+}
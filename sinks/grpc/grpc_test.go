@@ -0,0 +1,176 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	stats "github.com/lyft/gostats"
+	"github.com/lyft/gostats/sinks/grpc/metricspb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+var (
+	_ stats.FlushableSink = (*GRPCStreamSink)(nil)
+	_ stats.Syncer        = (*GRPCStreamSink)(nil)
+)
+
+type testServer struct {
+	metricspb.UnimplementedMetricsServiceServer
+
+	mu       sync.Mutex
+	streams  int
+	failures int // number of streams to fail on their first request
+	metrics  []*metricspb.Metric
+}
+
+func (s *testServer) StreamMetrics(stream metricspb.MetricsService_StreamMetricsServer) error {
+	s.mu.Lock()
+	s.streams++
+	fail := s.streams <= s.failures
+	s.mu.Unlock()
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			return nil // closed by the client
+		}
+		if fail {
+			return status.Error(codes.Unavailable, "unavailable")
+		}
+		s.mu.Lock()
+		s.metrics = append(s.metrics, req.GetMetrics()...)
+		s.mu.Unlock()
+		if err := stream.Send(&metricspb.StreamMetricsResponse{Sequence: req.GetSequence()}); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *testServer) names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for _, m := range s.metrics {
+		names = append(names, m.GetName())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newTestConn(t *testing.T, srv *testServer) *grpc.ClientConn {
+	lis := bufconn.Listen(1 << 20)
+	gs := grpc.NewServer()
+	metricspb.RegisterMetricsServiceServer(gs, srv)
+	go gs.Serve(lis)
+	t.Cleanup(gs.Stop)
+
+	cc, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cc.Close() })
+	return cc
+}
+
+func TestGRPCStreamSink(t *testing.T) {
+	srv := &testServer{}
+	sink := NewGRPCStreamSink(newTestConn(t, srv), WithBatchSize(2))
+	now := time.Unix(10, 0)
+	sink.now = func() time.Time { return now }
+
+	store := stats.NewStore(sink, false)
+	store.NewCounterWithTags("requests", map[string]string{"code": "200"}).Add(3)
+	store.NewGauge("conns").Set(2)
+	store.NewTimer("latency").AddValue(1.5)
+	if err := store.FlushAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.mu.Lock()
+	byName := make(map[string]*metricspb.Metric)
+	for _, m := range srv.metrics {
+		byName[m.GetName()] = m
+	}
+	srv.mu.Unlock()
+	if len(byName) != 3 {
+		t.Fatalf("metrics: got: %v want: 3 metrics", byName)
+	}
+	if m := byName["requests"]; m.GetType() != metricspb.Metric_TYPE_COUNTER ||
+		m.GetValue() != 3 || m.GetTags()["code"] != "200" || m.GetTimestampUnixNano() != now.UnixNano() {
+		t.Errorf("requests: got: %v", m)
+	}
+	if m := byName["conns"]; m.GetType() != metricspb.Metric_TYPE_GAUGE || m.GetValue() != 2 {
+		t.Errorf("conns: got: %v", m)
+	}
+	if m := byName["latency"]; m.GetType() != metricspb.Metric_TYPE_TIMER || m.GetValue() != 1.5 {
+		t.Errorf("latency: got: %v", m)
+	}
+
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sink.FlushCounter("late", 1)
+	if n := sink.Dropped(); n != 1 {
+		t.Errorf("Dropped: got: %d want: %d", n, 1)
+	}
+}
+
+func TestGRPCStreamSinkReconnect(t *testing.T) {
+	srv := &testServer{failures: 2}
+	sink := NewGRPCStreamSink(newTestConn(t, srv), WithReconnectBackoff(time.Millisecond))
+	defer sink.Close()
+
+	sink.FlushCounter("a", 1)
+	sink.FlushGauge("b", 2)
+	var err error
+	for i := 0; i < 3; i++ {
+		if err = sink.Sync(); err == nil {
+			break
+		}
+		if status.Code(err) != codes.Unavailable {
+			t.Fatalf("Sync: got: %v want: code %s", err, codes.Unavailable)
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := srv.names(); len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Errorf("metrics: got: %q want: %q", names, []string{"a", "b"})
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.streams != 3 {
+		t.Errorf("streams: got: %d want: %d", srv.streams, 3)
+	}
+}
+
+func TestGRPCStreamSinkBufferFull(t *testing.T) {
+	srv := &testServer{}
+	sink := NewGRPCStreamSink(newTestConn(t, srv), WithBatchSize(2), WithBufferSize(2))
+	defer sink.Close()
+
+	sink.FlushCounter("a", 1)
+	sink.FlushCounter("b", 1)
+	sink.FlushCounter("c", 1) // buffer full
+	if err := sink.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if n := sink.Dropped(); n != 1 {
+		t.Errorf("Dropped: got: %d want: %d", n, 1)
+	}
+	if names := srv.names(); len(names) != 2 {
+		t.Errorf("metrics: got: %q want: 2 metrics", names)
+	}
+}
@@ -0,0 +1,199 @@
+// Package influx provides a stats.Sink that writes stats in the InfluxDB line
+// protocol.
+package influx
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lyft/gostats/internal/tags"
+)
+
+// DefaultBatchSize is the default number of lines buffered before they are
+// written.
+const DefaultBatchSize = 1000
+
+// An Option configures an InfluxSink.
+type Option interface {
+	apply(*InfluxSink)
+}
+
+// optionFunc wraps a func so it satisfies the Option interface.
+type optionFunc func(*InfluxSink)
+
+func (f optionFunc) apply(sink *InfluxSink) {
+	f(sink)
+}
+
+// WithBatchSize sets the number of lines that are buffered before they are
+// written, the default is DefaultBatchSize.
+func WithBatchSize(n int) Option {
+	return optionFunc(func(sink *InfluxSink) {
+		sink.batchSize = n
+	})
+}
+
+// An InfluxSink is a stats.Sink that writes stats in the InfluxDB line
+// protocol. Stat names are used as the measurement, tags as InfluxDB tags and
+// the value of the stat is written to the "value" field. Counters and Gauges
+// are written as integers and Timers as floats, in the unit they were
+// flushed in.
+//
+// Lines are buffered and written in batches, the buffer is written when it
+// holds the configured batch size or when Flush is called. Since Flush
+// returns an error InfluxSink does not implement stats.FlushableSink and
+// Flush must be called by the user, for example on shutdown.
+type InfluxSink struct {
+	w         io.Writer
+	batchSize int
+
+	names sync.Map // stat name => serialized measurement and tags
+
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	lines int
+	err   error // first error of a write not triggered by Flush
+	now   func() time.Time
+}
+
+// NewInfluxSink returns an InfluxSink that writes batches of lines to w.
+// Each batch is written with a single call to w.Write.
+func NewInfluxSink(w io.Writer, opts ...Option) *InfluxSink {
+	s := &InfluxSink{
+		w:         w,
+		batchSize: DefaultBatchSize,
+		now:       time.Now,
+	}
+	for _, opt := range opts {
+		opt.apply(s)
+	}
+	if s.batchSize <= 0 {
+		s.batchSize = DefaultBatchSize
+	}
+	return s
+}
+
+// NewHTTPInfluxSink returns an InfluxSink that writes batches of lines to the
+// InfluxDB write endpoint url, for example:
+//
+//	http://localhost:8086/write?db=stats
+//
+// If client is nil http.DefaultClient is used.
+func NewHTTPInfluxSink(url string, client *http.Client, opts ...Option) *InfluxSink {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return NewInfluxSink(&httpWriter{url: url, client: client}, opts...)
+}
+
+// Flush writes any buffered lines and returns the first error that occurred
+// while writing since the last call to Flush.
+func (s *InfluxSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.writeBuffer()
+	if s.err != nil {
+		err = s.err
+		s.err = nil
+	}
+	return err
+}
+
+// writeBuffer writes and resets the buffer. s.mu must be held.
+func (s *InfluxSink) writeBuffer() error {
+	if s.buf.Len() == 0 {
+		return nil
+	}
+	_, err := s.w.Write(s.buf.Bytes())
+	s.buf.Reset()
+	s.lines = 0
+	return err
+}
+
+func (s *InfluxSink) measurement(stat string) string {
+	if v, ok := s.names.Load(stat); ok {
+		return v.(string)
+	}
+	name, set := tags.ParseTagSet(stat)
+	var b strings.Builder
+	b.WriteString(measurementReplacer.Replace(name))
+	for _, t := range set {
+		b.WriteByte(',')
+		b.WriteString(tagReplacer.Replace(t.Key))
+		b.WriteByte('=')
+		b.WriteString(tagReplacer.Replace(t.Value))
+	}
+	v, _ := s.names.LoadOrStore(stat, b.String())
+	return v.(string)
+}
+
+func (s *InfluxSink) writeLine(stat string, value []byte) {
+	measurement := s.measurement(stat)
+
+	s.mu.Lock()
+	s.buf.WriteString(measurement)
+	s.buf.WriteString(" value=")
+	s.buf.Write(value)
+	s.buf.WriteByte(' ')
+	var b [20]byte
+	s.buf.Write(strconv.AppendInt(b[:0], s.now().UnixNano(), 10))
+	s.buf.WriteByte('\n')
+	s.lines++
+	if s.lines >= s.batchSize {
+		if err := s.writeBuffer(); err != nil && s.err == nil {
+			s.err = err
+		}
+	}
+	s.mu.Unlock()
+}
+
+// FlushCounter implements the stats.Sink.FlushCounter method.
+func (s *InfluxSink) FlushCounter(name string, value uint64) {
+	var b [21]byte
+	s.writeLine(name, append(strconv.AppendUint(b[:0], value, 10), 'i'))
+}
+
+// FlushGauge implements the stats.Sink.FlushGauge method.
+func (s *InfluxSink) FlushGauge(name string, value uint64) {
+	var b [21]byte
+	s.writeLine(name, append(strconv.AppendUint(b[:0], value, 10), 'i'))
+}
+
+// FlushTimer implements the stats.Sink.FlushTimer method.
+func (s *InfluxSink) FlushTimer(name string, value float64) {
+	var b [32]byte
+	s.writeLine(name, strconv.AppendFloat(b[:0], value, 'f', -1, 64))
+}
+
+var (
+	measurementReplacer = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagReplacer         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// httpWriter POSTs each Write to an InfluxDB write endpoint.
+type httpWriter struct {
+	url    string
+	client *http.Client
+}
+
+func (w *httpWriter) Write(p []byte) (int, error) {
+	resp, err := w.client.Post(w.url, "text/plain; charset=utf-8", bytes.NewReader(p))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return 0, fmt.Errorf("influx: write to %s failed: %s: %s",
+			w.url, resp.Status, bytes.TrimSpace(body))
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	return len(p), nil
+}
@@ -0,0 +1,116 @@
+package influx
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	stats "github.com/lyft/gostats"
+	"github.com/lyft/gostats/mock"
+)
+
+var _ stats.Sink = (*InfluxSink)(nil)
+
+type recordWriter struct {
+	writes []string
+}
+
+func (w *recordWriter) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, string(p))
+	return len(p), nil
+}
+
+func fixedTime(s *InfluxSink) {
+	s.now = func() time.Time { return time.Unix(0, 1600000000000000000) }
+}
+
+func TestInfluxSink_LineProtocol(t *testing.T) {
+	var w recordWriter
+	sink := NewInfluxSink(&w)
+	fixedTime(sink)
+
+	sink.FlushCounter(mock.SerializeTags("counter", map[string]string{"k1": "v1", "k2": "a=b c"}), 1)
+	sink.FlushGauge("my gauge,x", 2)
+	sink.FlushTimer("timer", 1.5)
+	if len(w.writes) != 0 {
+		t.Fatalf("lines written before Flush: %q", w.writes)
+	}
+	if err := sink.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	const expected = "counter,k1=v1,k2=a\\=b\\ c value=1i 1600000000000000000\n" +
+		"my\\ gauge\\,x value=2i 1600000000000000000\n" +
+		"timer value=1.5 1600000000000000000\n"
+	if len(w.writes) != 1 || w.writes[0] != expected {
+		t.Errorf("got: %q want: %q", w.writes, expected)
+	}
+}
+
+func TestInfluxSink_BatchSize(t *testing.T) {
+	var w recordWriter
+	sink := NewInfluxSink(&w, WithBatchSize(2))
+	fixedTime(sink)
+
+	for i := 0; i < 5; i++ {
+		sink.FlushCounter("c", 1)
+	}
+	if len(w.writes) != 2 {
+		t.Fatalf("writes: got: %d want: %d", len(w.writes), 2)
+	}
+	for _, s := range w.writes {
+		if n := strings.Count(s, "\n"); n != 2 {
+			t.Errorf("lines per batch: got: %d want: %d", n, 2)
+		}
+	}
+	if err := sink.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.writes) != 3 || strings.Count(w.writes[2], "\n") != 1 {
+		t.Errorf("final batch: %q", w.writes)
+	}
+}
+
+func TestInfluxSink_HTTP(t *testing.T) {
+	var body bytes.Buffer
+	fail := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("db") != "stats" {
+			t.Errorf("query: %q", r.URL.RawQuery)
+		}
+		if fail {
+			http.Error(w, "database not found", http.StatusNotFound)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		body.Write(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	sink := NewHTTPInfluxSink(ts.URL+"/write?db=stats", nil, WithBatchSize(1))
+	fixedTime(sink)
+
+	sink.FlushGauge("gauge", 1)
+	if err := sink.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if s := body.String(); s != "gauge value=1i 1600000000000000000\n" {
+		t.Errorf("body: %q", s)
+	}
+
+	// errors from batches written before Flush are returned by Flush
+	fail = true
+	sink.FlushGauge("gauge", 2)
+	err := sink.Flush()
+	if err == nil || !strings.Contains(err.Error(), "404 Not Found: database not found") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := sink.Flush(); err != nil {
+		t.Errorf("error not reset: %v", err)
+	}
+}
@@ -0,0 +1,18 @@
+module github.com/lyft/gostats/sinks/kafka
+
+go 1.25.0
+
+require (
+	github.com/lyft/gostats v0.0.0
+	github.com/segmentio/kafka-go v0.4.51
+)
+
+require (
+	github.com/klauspost/compress v1.15.9 // indirect
+	github.com/pierrec/lz4/v4 v4.1.15 // indirect
+	github.com/sirupsen/logrus v1.0.6 // indirect
+	golang.org/x/crypto v0.0.0-20200204104054-c9f3fb736b72 // indirect
+	golang.org/x/sys v0.0.0-20200519105757-fe76b779f299 // indirect
+)
+
+replace github.com/lyft/gostats => ../..
@@ -0,0 +1,320 @@
+// Package kafka provides a stats.Sink that writes stats to a Kafka topic.
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lyft/gostats/internal/tags"
+	"github.com/segmentio/kafka-go"
+)
+
+// Defaults of the KafkaSink options.
+const (
+	DefaultBufferSize = 10000
+	DefaultBatchSize  = 100
+)
+
+// DroppedStat is the name of the Counter written by the sink with the number
+// of stats dropped since the previous flush, see KafkaSink.
+const DroppedStat = "kafka_sink_dropped"
+
+// The types of a Metric.
+const (
+	TypeCounter = "counter"
+	TypeGauge   = "gauge"
+	TypeTimer   = "timer"
+)
+
+// A Metric is the JSON encoded value of the messages written by a KafkaSink.
+type Metric struct {
+	Name  string            `json:"name"` // without tags
+	Type  string            `json:"type"` // TypeCounter, TypeGauge or TypeTimer
+	Value float64           `json:"value"`
+	Tags  map[string]string `json:"tags,omitempty"`
+	// Timestamp is when the stat was flushed.
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// A KeyFunc returns the key of the message of a metric, it determines the
+// partition the message is written to. stat is the name of the stat including
+// its serialized tags.
+type KeyFunc func(stat string, m *Metric) []byte
+
+// KeyByName uses the name of the stat, without tags, as the message key so
+// that all the series of a stat are written to the same partition. This is
+// the default.
+func KeyByName(_ string, m *Metric) []byte { return []byte(m.Name) }
+
+// KeyBySeries uses the name of the stat and its tags as the message key.
+func KeyBySeries(stat string, _ *Metric) []byte { return []byte(stat) }
+
+// KeyNone writes messages without a key, they are distributed across the
+// partitions by the Balancer of the writer, round robin by default.
+func KeyNone(string, *Metric) []byte { return nil }
+
+// A MessageWriter writes messages to Kafka, it is implemented by
+// *kafka.Writer.
+type MessageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// An Option configures a KafkaSink.
+type Option interface {
+	apply(*KafkaSink)
+}
+
+// optionFunc wraps a func so it satisfies the Option interface.
+type optionFunc func(*KafkaSink)
+
+func (f optionFunc) apply(sink *KafkaSink) {
+	f(sink)
+}
+
+// WithKeyFunc sets the KeyFunc used to determine the message keys, the
+// default is KeyByName.
+func WithKeyFunc(fn KeyFunc) Option {
+	return optionFunc(func(sink *KafkaSink) {
+		sink.key = fn
+	})
+}
+
+// WithCompression sets the compression of the messages. It only applies to
+// the writer created by NewKafkaSink.
+func WithCompression(c kafka.Compression) Option {
+	return optionFunc(func(sink *KafkaSink) {
+		sink.compression = c
+	})
+}
+
+// WithBufferSize sets the number of messages buffered by the sink while they
+// are written, the default is DefaultBufferSize. Stats flushed while the
+// buffer is full are dropped.
+func WithBufferSize(n int) Option {
+	return optionFunc(func(sink *KafkaSink) {
+		sink.bufferSize = n
+	})
+}
+
+// WithBatchSize sets the maximum number of messages passed to each call to
+// WriteMessages, the default is DefaultBatchSize.
+func WithBatchSize(n int) Option {
+	return optionFunc(func(sink *KafkaSink) {
+		sink.batchSize = n
+	})
+}
+
+// A KafkaSink is a stats.Sink that writes each flushed stat as a Kafka
+// message. The value of the messages is a JSON encoded Metric and their key
+// is determined by the KeyFunc of the sink.
+//
+// Messages are buffered and written by a background goroutine. If the buffer
+// is full, because the producer can not keep up, the stat is dropped and
+// counted. The number of stats dropped since the previous flush is written as
+// the Counter DroppedStat when the sink is flushed, and the total is returned
+// by Dropped.
+type KafkaSink struct {
+	w           MessageWriter
+	key         KeyFunc
+	compression kafka.Compression
+	bufferSize  int
+	batchSize   int
+	now         func() time.Time
+
+	msgs    chan kafka.Message
+	syncs   chan chan error
+	closing chan struct{}
+	done    chan struct{}
+
+	dropped      uint64 // total
+	flushDropped uint64 // value of dropped at the last flush
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewKafkaSink returns a KafkaSink that writes stats to topic on the Kafka
+// cluster of brokers.
+func NewKafkaSink(brokers []string, topic string, opts ...Option) *KafkaSink {
+	s := newKafkaSink(opts)
+	s.w = &kafka.Writer{
+		Addr:        kafka.TCP(brokers...),
+		Topic:       topic,
+		Balancer:    &kafka.Hash{},
+		Compression: s.compression,
+		BatchSize:   s.batchSize,
+	}
+	go s.run()
+	return s
+}
+
+// NewKafkaSinkWithWriter returns a KafkaSink that writes stats with w, which
+// must be configured with the topic. It allows configuring the writer beyond
+// the options of NewKafkaSink.
+func NewKafkaSinkWithWriter(w MessageWriter, opts ...Option) *KafkaSink {
+	s := newKafkaSink(opts)
+	s.w = w
+	go s.run()
+	return s
+}
+
+func newKafkaSink(opts []Option) *KafkaSink {
+	s := &KafkaSink{
+		key:        KeyByName,
+		bufferSize: DefaultBufferSize,
+		batchSize:  DefaultBatchSize,
+		now:        time.Now,
+		syncs:      make(chan chan error),
+		closing:    make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt.apply(s)
+	}
+	if s.bufferSize <= 0 {
+		s.bufferSize = DefaultBufferSize
+	}
+	if s.batchSize <= 0 {
+		s.batchSize = DefaultBatchSize
+	}
+	s.msgs = make(chan kafka.Message, s.bufferSize)
+	return s
+}
+
+func (s *KafkaSink) write(typ, stat string, value float64) {
+	name, tagMap := tags.ParseTags(stat)
+	m := Metric{
+		Name:      name,
+		Type:      typ,
+		Value:     value,
+		Tags:      tagMap,
+		Timestamp: s.now(),
+	}
+	b, err := json.Marshal(&m)
+	if err != nil {
+		atomic.AddUint64(&s.dropped, 1)
+		return
+	}
+	msg := kafka.Message{Key: s.key(stat, &m), Value: b}
+	select {
+	case <-s.closing:
+		atomic.AddUint64(&s.dropped, 1)
+		return
+	default:
+	}
+	select {
+	case s.msgs <- msg:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// FlushCounter implements the stats.Sink.FlushCounter method.
+func (s *KafkaSink) FlushCounter(name string, value uint64) {
+	s.write(TypeCounter, name, float64(value))
+}
+
+// FlushGauge implements the stats.Sink.FlushGauge method.
+func (s *KafkaSink) FlushGauge(name string, value uint64) {
+	s.write(TypeGauge, name, float64(value))
+}
+
+// FlushTimer implements the stats.Sink.FlushTimer method.
+func (s *KafkaSink) FlushTimer(name string, value float64) {
+	s.write(TypeTimer, name, value)
+}
+
+// Flush implements the stats.FlushableSink.Flush method and writes the
+// Counter DroppedStat if any stats were dropped since the previous flush.
+func (s *KafkaSink) Flush() {
+	total := atomic.LoadUint64(&s.dropped)
+	if prev := atomic.SwapUint64(&s.flushDropped, total); total > prev {
+		s.FlushCounter(DroppedStat, total-prev)
+	}
+}
+
+// Sync implements the stats.Syncer interface. It flushes the sink, waits
+// until the buffered messages are written and returns the first error that
+// occurred since the previous call to Sync.
+func (s *KafkaSink) Sync() error {
+	s.Flush()
+	ch := make(chan error, 1)
+	select {
+	case s.syncs <- ch:
+		return <-ch
+	case <-s.done:
+		return s.closeErr
+	}
+}
+
+// Dropped returns the number of stats dropped because the buffer was full or
+// the sink was closed.
+func (s *KafkaSink) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close writes the buffered messages and closes the writer. Stats flushed
+// after Close are dropped.
+func (s *KafkaSink) Close() error {
+	s.closeOnce.Do(func() {
+		s.Flush()
+		close(s.closing)
+	})
+	<-s.done
+	return s.closeErr
+}
+
+// run writes the buffered messages until the sink is closed.
+func (s *KafkaSink) run() {
+	defer close(s.done)
+
+	var err error // first error since the last sync
+	batch := make([]kafka.Message, 0, s.batchSize)
+	// writeBuffered writes the messages in the buffer, in batches.
+	writeBuffered := func() {
+		for {
+			for len(batch) < s.batchSize {
+				select {
+				case msg := <-s.msgs:
+					batch = append(batch, msg)
+					continue
+				default:
+				}
+				break
+			}
+			if len(batch) == 0 {
+				return
+			}
+			if werr := s.w.WriteMessages(context.Background(), batch...); werr != nil && err == nil {
+				err = werr
+			}
+			for i := range batch {
+				batch[i] = kafka.Message{}
+			}
+			batch = batch[:0]
+		}
+	}
+
+	for {
+		select {
+		case msg := <-s.msgs:
+			batch = append(batch, msg)
+			writeBuffered()
+		case ch := <-s.syncs:
+			writeBuffered()
+			ch <- err
+			err = nil
+		case <-s.closing:
+			writeBuffered()
+			if cerr := s.w.Close(); err == nil {
+				err = cerr
+			}
+			s.closeErr = err
+			return
+		}
+	}
+}
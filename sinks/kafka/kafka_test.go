@@ -0,0 +1,183 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	stats "github.com/lyft/gostats"
+	"github.com/segmentio/kafka-go"
+)
+
+var (
+	_ stats.FlushableSink = (*KafkaSink)(nil)
+	_ stats.Syncer        = (*KafkaSink)(nil)
+	_ MessageWriter       = (*kafka.Writer)(nil)
+)
+
+type testWriter struct {
+	mu     sync.Mutex
+	msgs   []kafka.Message
+	err    error
+	block  chan struct{} // if not nil WriteMessages waits for it to be closed
+	closed bool
+}
+
+func (w *testWriter) WriteMessages(_ context.Context, msgs ...kafka.Message) error {
+	if w.block != nil {
+		<-w.block
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.msgs = append(w.msgs, msgs...)
+	return w.err
+}
+
+func (w *testWriter) Close() error {
+	w.mu.Lock()
+	w.closed = true
+	w.mu.Unlock()
+	return nil
+}
+
+func (w *testWriter) metrics(t *testing.T) map[string]Metric {
+	t.Helper()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	m := make(map[string]Metric)
+	for _, msg := range w.msgs {
+		var v Metric
+		if err := json.Unmarshal(msg.Value, &v); err != nil {
+			t.Fatal(err)
+		}
+		m[string(msg.Key)] = v
+	}
+	return m
+}
+
+func TestKafkaSink(t *testing.T) {
+	w := &testWriter{}
+	sink := NewKafkaSinkWithWriter(w, WithBatchSize(2))
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	sink.now = func() time.Time { return now }
+
+	store := stats.NewStore(sink, false)
+	store.NewCounterWithTags("requests", map[string]string{"code": "200"}).Add(3)
+	store.NewGauge("conns").Set(2)
+	store.NewTimer("latency").AddValue(1.5)
+	if err := store.FlushAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string]Metric{
+		"requests": {Name: "requests", Type: TypeCounter, Value: 3, Tags: map[string]string{"code": "200"}, Timestamp: now},
+		"conns":    {Name: "conns", Type: TypeGauge, Value: 2, Timestamp: now},
+		"latency":  {Name: "latency", Type: TypeTimer, Value: 1.5, Timestamp: now},
+	}
+	got := w.metrics(t)
+	if len(got) != len(exp) {
+		t.Fatalf("metrics: got: %+v want: %+v", got, exp)
+	}
+	for k, e := range exp {
+		g := got[k]
+		if g.Name != e.Name || g.Type != e.Type || g.Value != e.Value ||
+			g.Tags["code"] != e.Tags["code"] || !g.Timestamp.Equal(e.Timestamp) {
+			t.Errorf("%s: got: %+v want: %+v", k, g, e)
+		}
+	}
+
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !w.closed {
+		t.Error("writer not closed")
+	}
+	sink.FlushCounter("late", 1)
+	if n := sink.Dropped(); n != 1 {
+		t.Errorf("Dropped: got: %d want: %d", n, 1)
+	}
+}
+
+func TestKafkaSinkKeyFunc(t *testing.T) {
+	tests := []struct {
+		fn  KeyFunc
+		exp string
+	}{
+		{KeyByName, "requests"},
+		{KeyBySeries, "requests.__code=200"},
+		{KeyNone, ""},
+	}
+	for _, x := range tests {
+		w := &testWriter{}
+		sink := NewKafkaSinkWithWriter(w, WithKeyFunc(x.fn))
+		sink.FlushCounter("requests.__code=200", 1)
+		if err := sink.Sync(); err != nil {
+			t.Fatal(err)
+		}
+		if key := string(w.msgs[0].Key); key != x.exp {
+			t.Errorf("key: got: %q want: %q", key, x.exp)
+		}
+		sink.Close()
+	}
+}
+
+func TestKafkaSinkBackpressure(t *testing.T) {
+	w := &testWriter{block: make(chan struct{})}
+	sink := NewKafkaSinkWithWriter(w, WithBufferSize(2), WithBatchSize(1))
+	defer sink.Close()
+
+	// the writer is blocked on the first message, the next 2 are buffered
+	sink.FlushCounter("a", 1)
+	for i := 0; len(sink.msgs) != 0; i++ {
+		if i == 1000 {
+			t.Fatal("the first message was not written")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	sink.FlushCounter("b", 1)
+	sink.FlushCounter("c", 1)
+	sink.FlushCounter("d", 1) // dropped
+	if n := sink.Dropped(); n != 1 {
+		t.Errorf("Dropped: got: %d want: %d", n, 1)
+	}
+
+	close(w.block)
+	for i := 0; len(sink.msgs) != 0; i++ {
+		if i == 1000 {
+			t.Fatal("the buffered messages were not written")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := sink.Sync(); err != nil { // writes DroppedStat
+		t.Fatal(err)
+	}
+	got := w.metrics(t)
+	for _, name := range []string{"a", "b", "c"} {
+		if _, ok := got[name]; !ok {
+			t.Errorf("missing metric %q", name)
+		}
+	}
+	if _, ok := got["d"]; ok {
+		t.Error("dropped metric was written")
+	}
+	if m := got[DroppedStat]; m.Type != TypeCounter || m.Value != 1 {
+		t.Errorf("%s: got: %+v want: counter = 1", DroppedStat, m)
+	}
+}
+
+func TestKafkaSinkError(t *testing.T) {
+	w := &testWriter{err: errors.New("write failed")}
+	sink := NewKafkaSinkWithWriter(w)
+	defer sink.Close()
+
+	sink.FlushGauge("g", 1)
+	if err := sink.Sync(); err != w.err {
+		t.Errorf("Sync: got: %v want: %v", err, w.err)
+	}
+	if err := sink.Sync(); err != nil {
+		t.Errorf("Sync: got: %v want: %v", err, nil)
+	}
+}
@@ -0,0 +1,326 @@
+// Package newrelic provides a stats.Sink that submits stats to the New Relic
+// Metric API.
+package newrelic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lyft/gostats/internal/tags"
+)
+
+// Endpoints of the Metric API.
+const (
+	DefaultEndpoint = "https://metric-api.newrelic.com/metric/v1"
+	EUEndpoint      = "https://metric-api.eu.newrelic.com/metric/v1"
+)
+
+// Defaults of the NewRelicSink options.
+const (
+	DefaultMaxRetries = 3
+	// DefaultRetryAfter is the delay before retrying a request rejected with
+	// a 429 status without a valid Retry-After header.
+	DefaultRetryAfter = time.Second
+)
+
+// An Option configures a NewRelicSink.
+type Option interface {
+	apply(*NewRelicSink)
+}
+
+// optionFunc wraps a func so it satisfies the Option interface.
+type optionFunc func(*NewRelicSink)
+
+func (f optionFunc) apply(sink *NewRelicSink) {
+	f(sink)
+}
+
+// WithEndpoint sets the URL of the Metric API, the default is
+// DefaultEndpoint. Use EUEndpoint for accounts in the EU region.
+func WithEndpoint(url string) Option {
+	return optionFunc(func(sink *NewRelicSink) {
+		sink.endpoint = url
+	})
+}
+
+// WithHTTPClient sets the client used to submit the stats, the default is
+// http.DefaultClient.
+func WithHTTPClient(client *http.Client) Option {
+	return optionFunc(func(sink *NewRelicSink) {
+		sink.client = client
+	})
+}
+
+// WithMaxRetries sets the number of times a request rejected with a 429
+// status is retried, the default is DefaultMaxRetries.
+func WithMaxRetries(n int) Option {
+	return optionFunc(func(sink *NewRelicSink) {
+		sink.maxRetries = n
+	})
+}
+
+// WithAttributes adds attributes common to all the stats of the sink.
+func WithAttributes(attrs map[string]string) Option {
+	return optionFunc(func(sink *NewRelicSink) {
+		for k, v := range attrs {
+			sink.attrs[k] = v
+		}
+	})
+}
+
+// WithErrorHandler sets the function called with the errors of Flush, by
+// default they are ignored.
+func WithErrorHandler(fn func(error)) Option {
+	return optionFunc(func(sink *NewRelicSink) {
+		sink.onError = fn
+	})
+}
+
+type series struct {
+	name  string
+	attrs map[string]string
+	value float64 // sum of Counters, last value of Gauges
+	count float64 // Timers only
+	min   float64
+	max   float64
+}
+
+// A NewRelicSink is a stats.Sink that aggregates the flushed stats and submits
+// them to the New Relic Metric API in the dimensional metric JSON format when
+// it is flushed or synced. Tags are submitted as attributes.
+//
+// Counters are submitted as "count" metrics with the sum of their flushes,
+// Gauges as "gauge" metrics with their last value, and Timers as "summary"
+// metrics with their count, sum, minimum and maximum, over the interval since
+// the previous submission. Requests rejected with a 429 status are retried
+// after the delay of their Retry-After header.
+type NewRelicSink struct {
+	endpoint   string
+	apiKey     string
+	client     *http.Client
+	maxRetries int
+	attrs      map[string]string
+	onError    func(error)
+	now        func() time.Time
+	sleep      func(time.Duration)
+
+	mu       sync.Mutex
+	counters map[string]*series
+	gauges   map[string]*series
+	timers   map[string]*series
+	start    time.Time // start of the interval
+
+	submitMu sync.Mutex // serializes the submissions
+}
+
+// NewNewRelicSink returns a NewRelicSink that submits stats with the license
+// or Insert API key apiKey. The stats are sent to the account of the key,
+// accountID is added to their common attributes as "accountId".
+func NewNewRelicSink(apiKey, accountID string, opts ...Option) *NewRelicSink {
+	s := &NewRelicSink{
+		endpoint:   DefaultEndpoint,
+		apiKey:     apiKey,
+		client:     http.DefaultClient,
+		maxRetries: DefaultMaxRetries,
+		attrs:      map[string]string{"accountId": accountID},
+		now:        time.Now,
+		sleep:      time.Sleep,
+		counters:   make(map[string]*series),
+		gauges:     make(map[string]*series),
+		timers:     make(map[string]*series),
+	}
+	for _, opt := range opts {
+		opt.apply(s)
+	}
+	if s.client == nil {
+		s.client = http.DefaultClient
+	}
+	s.start = s.now()
+	return s
+}
+
+// get returns the series of stat in m. s.mu must be held.
+func (s *NewRelicSink) get(m map[string]*series, stat string) *series {
+	p := m[stat]
+	if p == nil {
+		name, attrs := tags.ParseTags(stat)
+		p = &series{name: name, attrs: attrs}
+		m[stat] = p
+	}
+	return p
+}
+
+// FlushCounter implements the stats.Sink.FlushCounter method.
+func (s *NewRelicSink) FlushCounter(name string, value uint64) {
+	s.mu.Lock()
+	s.get(s.counters, name).value += float64(value)
+	s.mu.Unlock()
+}
+
+// FlushGauge implements the stats.Sink.FlushGauge method.
+func (s *NewRelicSink) FlushGauge(name string, value uint64) {
+	s.mu.Lock()
+	s.get(s.gauges, name).value = float64(value)
+	s.mu.Unlock()
+}
+
+// FlushTimer implements the stats.Sink.FlushTimer method.
+func (s *NewRelicSink) FlushTimer(name string, value float64) {
+	s.mu.Lock()
+	p := s.get(s.timers, name)
+	if p.count == 0 || value < p.min {
+		p.min = value
+	}
+	if p.count == 0 || value > p.max {
+		p.max = value
+	}
+	p.count++
+	p.value += value
+	s.mu.Unlock()
+}
+
+// Flush implements the stats.FlushableSink.Flush method and submits the
+// stats, its error is passed to the function set with WithErrorHandler.
+func (s *NewRelicSink) Flush() {
+	if err := s.Sync(); err != nil && s.onError != nil {
+		s.onError(err)
+	}
+}
+
+type summary struct {
+	Count float64 `json:"count"`
+	Sum   float64 `json:"sum"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+}
+
+type metric struct {
+	Name       string            `json:"name"`
+	Type       string            `json:"type"`
+	Value      interface{}       `json:"value"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+type common struct {
+	Timestamp  int64             `json:"timestamp"`
+	IntervalMs int64             `json:"interval.ms"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+type payload struct {
+	Common  common   `json:"common"`
+	Metrics []metric `json:"metrics"`
+}
+
+// Sync implements the stats.Syncer interface. It submits the stats
+// aggregated since the previous submission and returns the error of the
+// request, the stats are dropped if it fails.
+func (s *NewRelicSink) Sync() error {
+	s.submitMu.Lock()
+	defer s.submitMu.Unlock()
+
+	s.mu.Lock()
+	counters, gauges, timers := s.counters, s.gauges, s.timers
+	s.counters = make(map[string]*series, len(counters))
+	s.gauges = make(map[string]*series, len(gauges))
+	s.timers = make(map[string]*series, len(timers))
+	start := s.start
+	s.start = s.now()
+	end := s.start
+	s.mu.Unlock()
+
+	var metrics []metric
+	for _, p := range sortedSeries(counters) {
+		metrics = append(metrics, metric{Name: p.name, Type: "count", Value: p.value, Attributes: p.attrs})
+	}
+	for _, p := range sortedSeries(gauges) {
+		metrics = append(metrics, metric{Name: p.name, Type: "gauge", Value: p.value, Attributes: p.attrs})
+	}
+	for _, p := range sortedSeries(timers) {
+		metrics = append(metrics, metric{
+			Name:       p.name,
+			Type:       "summary",
+			Value:      summary{Count: p.count, Sum: p.value, Min: p.min, Max: p.max},
+			Attributes: p.attrs,
+		})
+	}
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal([]payload{{
+		Common: common{
+			Timestamp:  start.UnixNano() / int64(time.Millisecond),
+			IntervalMs: end.Sub(start).Nanoseconds() / int64(time.Millisecond),
+			Attributes: s.attrs,
+		},
+		Metrics: metrics,
+	}})
+	if err != nil {
+		return err
+	}
+	return s.submit(body)
+}
+
+// submit POSTs body, retrying the requests rejected with a 429 status.
+func (s *NewRelicSink) submit(body []byte) error {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Api-Key", s.apiKey)
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return err
+		}
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		if resp.StatusCode/100 == 2 {
+			return nil
+		}
+		err = fmt.Errorf("newrelic: submit to %s failed: %s: %s",
+			s.endpoint, resp.Status, bytes.TrimSpace(msg))
+		if resp.StatusCode != http.StatusTooManyRequests || attempt >= s.maxRetries {
+			return err
+		}
+		s.sleep(retryAfter(resp.Header.Get("Retry-After"), s.now()))
+	}
+}
+
+// retryAfter returns the delay of the Retry-After header value v, in seconds
+// or as an HTTP date, or DefaultRetryAfter if it is not valid.
+func retryAfter(v string, now time.Time) time.Duration {
+	if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+		return time.Duration(n) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d
+		}
+		return 0
+	}
+	return DefaultRetryAfter
+}
+
+func sortedSeries(m map[string]*series) []*series {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	a := make([]*series, len(keys))
+	for i, k := range keys {
+		a[i] = m[k]
+	}
+	return a
+}
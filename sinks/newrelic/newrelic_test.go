@@ -0,0 +1,120 @@
+package newrelic
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	stats "github.com/lyft/gostats"
+)
+
+var (
+	_ stats.FlushableSink = (*NewRelicSink)(nil)
+	_ stats.Syncer        = (*NewRelicSink)(nil)
+)
+
+func TestNewRelicSink(t *testing.T) {
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key := r.Header.Get("Api-Key"); key != "key" {
+			t.Errorf("Api-Key: got: %q want: %q", key, "key")
+		}
+		body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	now := time.Unix(100, 0)
+	sink := NewNewRelicSink("key", "123", WithEndpoint(srv.URL),
+		WithAttributes(map[string]string{"service": "api"}))
+	sink.now = func() time.Time { return now }
+	sink.start = now
+	now = now.Add(10 * time.Second)
+
+	sink.FlushCounter("requests.__code=200", 2)
+	sink.FlushCounter("requests.__code=200", 3)
+	sink.FlushGauge("conns", 4)
+	sink.FlushGauge("conns", 6)
+	sink.FlushTimer("latency", 1.5)
+	sink.FlushTimer("latency", 0.5)
+	if err := sink.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	const exp = `[{"common":{"timestamp":100000,"interval.ms":10000,` +
+		`"attributes":{"accountId":"123","service":"api"}},"metrics":[` +
+		`{"name":"requests","type":"count","value":5,"attributes":{"code":"200"}},` +
+		`{"name":"conns","type":"gauge","value":6},` +
+		`{"name":"latency","type":"summary","value":{"count":2,"sum":2,"min":0.5,"max":1.5}}]}]`
+	var got, want interface{}
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatal(err)
+	}
+	json.Unmarshal([]byte(exp), &want)
+	gb, _ := json.Marshal(got)
+	wb, _ := json.Marshal(want)
+	if string(gb) != string(wb) {
+		t.Errorf("payload:\ngot:  %s\nwant: %s", gb, wb)
+	}
+
+	// nothing to submit
+	body = nil
+	if err := sink.Sync(); err != nil || body != nil {
+		t.Errorf("Sync: got: %v, %s want: no request", err, body)
+	}
+}
+
+func TestNewRelicSinkRetry(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests <= 2 {
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	var slept []time.Duration
+	sink := NewNewRelicSink("key", "123", WithEndpoint(srv.URL), WithMaxRetries(2))
+	sink.sleep = func(d time.Duration) { slept = append(slept, d) }
+
+	sink.FlushGauge("g", 1)
+	if err := sink.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 3 || len(slept) != 2 || slept[0] != 7*time.Second {
+		t.Errorf("requests: %d sleeps: %v want: 3 requests and 2 sleeps of 7s", requests, slept)
+	}
+
+	requests = 0
+	sink.FlushGauge("g", 1)
+	sink.maxRetries = 1
+	if err := sink.Sync(); err == nil {
+		t.Error("Sync: expected an error when the retries are exhausted")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		v   string
+		exp time.Duration
+	}{
+		{"3", 3 * time.Second},
+		{"", DefaultRetryAfter},
+		{"-1", DefaultRetryAfter},
+		{now.Add(time.Minute).Format(http.TimeFormat), time.Minute},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+	}
+	for _, x := range tests {
+		if d := retryAfter(x.v, now); d != x.exp {
+			t.Errorf("retryAfter(%q): got: %s want: %s", x.v, d, x.exp)
+		}
+	}
+}
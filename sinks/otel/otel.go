@@ -0,0 +1,142 @@
+// Package otel provides a stats.Sink that records stats with an OpenTelemetry
+// metric.MeterProvider.
+package otel
+
+import (
+	"context"
+	"sync"
+
+	"github.com/lyft/gostats/internal/tags"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// ScopeName is the instrumentation scope name of the Meter used by the Sink.
+const ScopeName = "github.com/lyft/gostats/sinks/otel"
+
+type counter struct {
+	inst metric.Int64Counter
+	opt  metric.AddOption
+}
+
+type gauge struct {
+	inst metric.Int64Gauge
+	opt  metric.RecordOption
+}
+
+type histogram struct {
+	inst metric.Float64Histogram
+	opt  metric.RecordOption
+}
+
+// A Sink is a stats.Sink that records Counters with OpenTelemetry Int64Counter
+// instruments, Gauges with Int64Gauge instruments and Timers with
+// Float64Histogram instruments. Tags are recorded as attributes.
+//
+// Instrument creation errors are reported to the global OpenTelemetry error
+// handler and the stat is dropped.
+type Sink struct {
+	meter metric.Meter
+
+	// instruments and attribute sets are cached by the serialized stat name
+	mu         sync.Mutex
+	counters   map[string]*counter
+	gauges     map[string]*gauge
+	histograms map[string]*histogram
+}
+
+// NewSink returns a Sink that records stats with a Meter obtained from
+// provider. Any OpenTelemetry exporter can be used by configuring it on the
+// provider.
+func NewSink(provider metric.MeterProvider) *Sink {
+	return &Sink{
+		meter:      provider.Meter(ScopeName),
+		counters:   make(map[string]*counter),
+		gauges:     make(map[string]*gauge),
+		histograms: make(map[string]*histogram),
+	}
+}
+
+func attributes(set tags.TagSet) attribute.Set {
+	kvs := make([]attribute.KeyValue, len(set))
+	for i, t := range set {
+		kvs[i] = attribute.String(t.Key, t.Value)
+	}
+	return attribute.NewSet(kvs...)
+}
+
+func (s *Sink) counter(stat string) *counter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.counters[stat]; ok {
+		return c
+	}
+	name, set := tags.ParseTagSet(stat)
+	inst, err := s.meter.Int64Counter(name)
+	if err != nil {
+		otel.Handle(err)
+		return nil
+	}
+	c := &counter{inst: inst, opt: metric.WithAttributeSet(attributes(set))}
+	s.counters[stat] = c
+	return c
+}
+
+func (s *Sink) gauge(stat string) *gauge {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if g, ok := s.gauges[stat]; ok {
+		return g
+	}
+	name, set := tags.ParseTagSet(stat)
+	inst, err := s.meter.Int64Gauge(name)
+	if err != nil {
+		otel.Handle(err)
+		return nil
+	}
+	g := &gauge{inst: inst, opt: metric.WithAttributeSet(attributes(set))}
+	s.gauges[stat] = g
+	return g
+}
+
+func (s *Sink) histogram(stat string) *histogram {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h, ok := s.histograms[stat]; ok {
+		return h
+	}
+	name, set := tags.ParseTagSet(stat)
+	inst, err := s.meter.Float64Histogram(name)
+	if err != nil {
+		otel.Handle(err)
+		return nil
+	}
+	h := &histogram{inst: inst, opt: metric.WithAttributeSet(attributes(set))}
+	s.histograms[stat] = h
+	return h
+}
+
+// FlushCounter implements the stats.Sink.FlushCounter method and adds value
+// to the Counter's instrument.
+func (s *Sink) FlushCounter(name string, value uint64) {
+	if c := s.counter(name); c != nil {
+		c.inst.Add(context.Background(), int64(value), c.opt)
+	}
+}
+
+// FlushGauge implements the stats.Sink.FlushGauge method and records value
+// with the Gauge's instrument.
+func (s *Sink) FlushGauge(name string, value uint64) {
+	if g := s.gauge(name); g != nil {
+		g.inst.Record(context.Background(), int64(value), g.opt)
+	}
+}
+
+// FlushTimer implements the stats.Sink.FlushTimer method and records value
+// with the Timer's instrument.
+func (s *Sink) FlushTimer(name string, value float64) {
+	if h := s.histogram(name); h != nil {
+		h.inst.Record(context.Background(), value, h.opt)
+	}
+}
@@ -0,0 +1,88 @@
+package otel
+
+import (
+	"context"
+	"testing"
+
+	stats "github.com/lyft/gostats"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric/noop"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+var _ stats.Sink = (*Sink)(nil)
+
+func collect(t *testing.T, reader sdkmetric.Reader) map[string]metricdata.Metrics {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	m := make(map[string]metricdata.Metrics)
+	for _, sm := range rm.ScopeMetrics {
+		if sm.Scope.Name != ScopeName {
+			t.Errorf("scope name: got: %q want: %q", sm.Scope.Name, ScopeName)
+		}
+		for _, x := range sm.Metrics {
+			m[x.Name] = x
+		}
+	}
+	return m
+}
+
+func TestSink(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	store := stats.NewStore(NewSink(provider), false)
+
+	scope := store.ScopeWithTags("service", map[string]string{"region": "us"})
+	scope.NewCounterWithTags("requests", map[string]string{"code": "200"}).Add(2)
+	scope.NewCounterWithTags("requests", map[string]string{"code": "500"}).Inc()
+	store.NewGauge("conns").Set(7)
+	timer := store.NewTimer("latency")
+	timer.AddValue(1)
+	timer.AddValue(3)
+	store.Flush()
+
+	metrics := collect(t, reader)
+
+	sum, ok := metrics["service.requests"].Data.(metricdata.Sum[int64])
+	if !ok {
+		t.Fatalf("service.requests: unexpected data: %#v", metrics["service.requests"].Data)
+	}
+	if n := len(sum.DataPoints); n != 2 {
+		t.Fatalf("service.requests: want 2 attribute sets got: %d", n)
+	}
+	for _, dp := range sum.DataPoints {
+		if region, _ := dp.Attributes.Value("region"); region.AsString() != "us" {
+			t.Errorf("service.requests: region attribute: %q", region.AsString())
+		}
+		code, _ := dp.Attributes.Value(attribute.Key("code"))
+		exp := map[string]int64{"200": 2, "500": 1}[code.AsString()]
+		if dp.Value != exp {
+			t.Errorf("service.requests{code=%s}: got: %d want: %d", code.AsString(), dp.Value, exp)
+		}
+	}
+
+	gauge, ok := metrics["conns"].Data.(metricdata.Gauge[int64])
+	if !ok || len(gauge.DataPoints) != 1 || gauge.DataPoints[0].Value != 7 {
+		t.Errorf("conns: unexpected data: %#v", metrics["conns"].Data)
+	}
+
+	hist, ok := metrics["latency"].Data.(metricdata.Histogram[float64])
+	if !ok || len(hist.DataPoints) != 1 {
+		t.Fatalf("latency: unexpected data: %#v", metrics["latency"].Data)
+	}
+	if dp := hist.DataPoints[0]; dp.Count != 2 || dp.Sum != 4 {
+		t.Errorf("latency: got count: %d sum: %f want count: 2 sum: 4", dp.Count, dp.Sum)
+	}
+}
+
+func TestSinkNoopProvider(t *testing.T) {
+	store := stats.NewStore(NewSink(noop.NewMeterProvider()), false)
+	store.NewCounterWithTags("counter", map[string]string{"key": "val"}).Inc()
+	store.NewGauge("gauge").Set(1)
+	store.NewTimer("timer").AddValue(1)
+	store.Flush()
+}
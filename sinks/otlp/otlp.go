@@ -0,0 +1,383 @@
+// Package otlp provides a stats.Sink that exports stats to an OpenTelemetry
+// collector with the OTLP/gRPC protocol.
+package otlp
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lyft/gostats/internal/tags"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+)
+
+// ScopeName is the instrumentation scope name of the exported metrics.
+const ScopeName = "github.com/lyft/gostats"
+
+// Defaults of the OTLPSink options.
+const (
+	DefaultBatchSize     = 1000
+	DefaultBatchInterval = 10 * time.Second
+	DefaultTimeout       = 10 * time.Second
+)
+
+// An Option configures an OTLPSink.
+type Option interface {
+	apply(*OTLPSink)
+}
+
+// optionFunc wraps a func so it satisfies the Option interface.
+type optionFunc func(*OTLPSink)
+
+func (f optionFunc) apply(sink *OTLPSink) {
+	f(sink)
+}
+
+// WithBatchSize sets the number of series that triggers an export, the
+// default is DefaultBatchSize.
+func WithBatchSize(n int) Option {
+	return optionFunc(func(sink *OTLPSink) {
+		sink.batchSize = n
+	})
+}
+
+// WithBatchInterval sets the interval of the exports, the default is
+// DefaultBatchInterval. If d is not positive the stats are only exported by
+// Sync and when a batch is full.
+func WithBatchInterval(d time.Duration) Option {
+	return optionFunc(func(sink *OTLPSink) {
+		sink.interval = d
+	})
+}
+
+// WithTimeout sets the timeout of the Export calls, the default is
+// DefaultTimeout.
+func WithTimeout(d time.Duration) Option {
+	return optionFunc(func(sink *OTLPSink) {
+		sink.timeout = d
+	})
+}
+
+// WithResource sets the attributes of the resource of the metrics, for
+// example service.name.
+func WithResource(attrs map[string]string) Option {
+	return optionFunc(func(sink *OTLPSink) {
+		sink.resource = &resourcepb.Resource{Attributes: keyValues(tags.NewTagSet(attrs))}
+	})
+}
+
+// WithBuckets sets the explicit bounds of the buckets of the Timer
+// histograms, in increasing order. By default the histograms only have a
+// count, sum, minimum and maximum.
+func WithBuckets(bounds []float64) Option {
+	return optionFunc(func(sink *OTLPSink) {
+		sink.bounds = append([]float64(nil), bounds...)
+	})
+}
+
+// WithCallOptions sets the options of the Export calls.
+func WithCallOptions(opts ...grpc.CallOption) Option {
+	return optionFunc(func(sink *OTLPSink) {
+		sink.callOpts = opts
+	})
+}
+
+// WithErrorHandler sets the function called with the errors of the exports
+// started by the sink, by default they are ignored.
+func WithErrorHandler(fn func(error)) Option {
+	return optionFunc(func(sink *OTLPSink) {
+		sink.onError = fn
+	})
+}
+
+type metricType int
+
+const (
+	counterType metricType = iota
+	gaugeType
+	timerType
+)
+
+type seriesKey struct {
+	stat string
+	typ  metricType
+}
+
+// series aggregates the values of a stat flushed since the last export.
+type series struct {
+	typ     metricType
+	name    string
+	attrs   []*commonpb.KeyValue
+	value   uint64 // sum of a Counter, last value of a Gauge
+	count   uint64 // Timers only
+	sum     float64
+	min     float64
+	max     float64
+	buckets []uint64
+}
+
+// An OTLPSink is a stats.Sink that aggregates the flushed stats and exports
+// them as ExportMetricsServiceRequest messages to the collector
+// metrics service, when a batch is full and periodically. Tags are exported
+// as attributes.
+//
+// Counters are exported as monotonic delta sums, Gauges as gauges of their
+// last value and Timers as delta histograms. The stats of a failed export are
+// dropped.
+type OTLPSink struct {
+	client    collectorpb.MetricsServiceClient
+	batchSize int
+	interval  time.Duration
+	timeout   time.Duration
+	resource  *resourcepb.Resource
+	bounds    []float64
+	callOpts  []grpc.CallOption
+	onError   func(error)
+	now       func() time.Time
+
+	mu     sync.Mutex
+	series map[seriesKey]*series
+	start  time.Time // start of the aggregation interval
+
+	exportMu sync.Mutex // serializes the exports
+
+	closeOnce sync.Once
+	full      chan struct{} // signals a full batch to run
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewOTLPSink returns an OTLPSink that exports stats with the OTLP metrics
+// service of cc. The sink must be closed to stop its periodic exports.
+func NewOTLPSink(cc grpc.ClientConnInterface, opts ...Option) *OTLPSink {
+	s := &OTLPSink{
+		client:    collectorpb.NewMetricsServiceClient(cc),
+		batchSize: DefaultBatchSize,
+		interval:  DefaultBatchInterval,
+		timeout:   DefaultTimeout,
+		resource:  &resourcepb.Resource{},
+		now:       time.Now,
+		series:    make(map[seriesKey]*series),
+		full:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt.apply(s)
+	}
+	if s.batchSize <= 0 {
+		s.batchSize = DefaultBatchSize
+	}
+	s.start = s.now()
+	if s.interval > 0 {
+		s.wg.Add(1)
+		go s.run()
+	}
+	return s
+}
+
+func keyValues(set tags.TagSet) []*commonpb.KeyValue {
+	kvs := make([]*commonpb.KeyValue, len(set))
+	for i, t := range set {
+		kvs[i] = &commonpb.KeyValue{
+			Key:   t.Key,
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: t.Value}},
+		}
+	}
+	return kvs
+}
+
+// add updates the series of stat with fn and exports a batch if one is full.
+func (s *OTLPSink) add(stat string, typ metricType, fn func(*series)) {
+	s.mu.Lock()
+	k := seriesKey{stat, typ}
+	p := s.series[k]
+	if p == nil {
+		name, set := tags.ParseTagSet(stat)
+		p = &series{typ: typ, name: name, attrs: keyValues(set), min: math.Inf(1), max: math.Inf(-1)}
+		if typ == timerType && len(s.bounds) != 0 {
+			p.buckets = make([]uint64, len(s.bounds)+1)
+		}
+		s.series[k] = p
+	}
+	fn(p)
+	full := len(s.series) >= s.batchSize
+	s.mu.Unlock()
+
+	if !full {
+		return
+	}
+	if s.interval <= 0 {
+		s.handle(s.Sync())
+		return
+	}
+	select {
+	case s.full <- struct{}{}:
+	default:
+	}
+}
+
+// FlushCounter implements the stats.Sink.FlushCounter method.
+func (s *OTLPSink) FlushCounter(name string, value uint64) {
+	s.add(name, counterType, func(p *series) { p.value += value })
+}
+
+// FlushGauge implements the stats.Sink.FlushGauge method.
+func (s *OTLPSink) FlushGauge(name string, value uint64) {
+	s.add(name, gaugeType, func(p *series) { p.value = value })
+}
+
+// FlushTimer implements the stats.Sink.FlushTimer method.
+func (s *OTLPSink) FlushTimer(name string, value float64) {
+	s.add(name, timerType, func(p *series) {
+		p.count++
+		p.sum += value
+		p.min = math.Min(p.min, value)
+		p.max = math.Max(p.max, value)
+		if p.buckets != nil {
+			// buckets are (bounds[i-1], bounds[i]]
+			p.buckets[sort.SearchFloat64s(s.bounds, value)]++
+		}
+	})
+}
+
+// Sync implements the stats.Syncer interface. It exports the stats
+// aggregated since the last export and returns the error of the export.
+func (s *OTLPSink) Sync() error {
+	s.exportMu.Lock()
+	defer s.exportMu.Unlock()
+
+	s.mu.Lock()
+	m := s.series
+	s.series = make(map[seriesKey]*series, len(m))
+	start := s.start
+	s.start = s.now()
+	end := s.start
+	s.mu.Unlock()
+	if len(m) == 0 {
+		return nil
+	}
+
+	req := &collectorpb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: s.resource,
+			ScopeMetrics: []*metricspb.ScopeMetrics{{
+				Scope:   &commonpb.InstrumentationScope{Name: ScopeName},
+				Metrics: s.metrics(m, uint64(start.UnixNano()), uint64(end.UnixNano())),
+			}},
+		}},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	if s.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+	}
+	defer cancel()
+	_, err := s.client.Export(ctx, req, s.callOpts...)
+	return err
+}
+
+// metrics returns the metrics of the series of m, the data points of the
+// series with the same name and type are grouped in one metric.
+func (s *OTLPSink) metrics(m map[seriesKey]*series, start, end uint64) []*metricspb.Metric {
+	keys := make([]seriesKey, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].stat != keys[j].stat {
+			return keys[i].stat < keys[j].stat
+		}
+		return keys[i].typ < keys[j].typ
+	})
+
+	type key struct {
+		name string
+		typ  metricType
+	}
+	index := make(map[key]*metricspb.Metric)
+	var metrics []*metricspb.Metric
+	for _, k := range keys {
+		p := m[k]
+		metric := index[key{p.name, p.typ}]
+		if metric == nil {
+			metric = &metricspb.Metric{Name: p.name}
+			switch p.typ {
+			case counterType:
+				metric.Data = &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+					AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+					IsMonotonic:            true,
+				}}
+			case gaugeType:
+				metric.Data = &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{}}
+			default:
+				metric.Data = &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+					AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+				}}
+			}
+			index[key{p.name, p.typ}] = metric
+			metrics = append(metrics, metric)
+		}
+		switch data := metric.Data.(type) {
+		case *metricspb.Metric_Sum:
+			data.Sum.DataPoints = append(data.Sum.DataPoints, &metricspb.NumberDataPoint{
+				Attributes:        p.attrs,
+				StartTimeUnixNano: start,
+				TimeUnixNano:      end,
+				Value:             &metricspb.NumberDataPoint_AsInt{AsInt: int64(p.value)},
+			})
+		case *metricspb.Metric_Gauge:
+			data.Gauge.DataPoints = append(data.Gauge.DataPoints, &metricspb.NumberDataPoint{
+				Attributes:   p.attrs,
+				TimeUnixNano: end,
+				Value:        &metricspb.NumberDataPoint_AsInt{AsInt: int64(p.value)},
+			})
+		case *metricspb.Metric_Histogram:
+			sum, min, max := p.sum, p.min, p.max
+			data.Histogram.DataPoints = append(data.Histogram.DataPoints, &metricspb.HistogramDataPoint{
+				Attributes:        p.attrs,
+				StartTimeUnixNano: start,
+				TimeUnixNano:      end,
+				Count:             p.count,
+				Sum:               &sum,
+				Min:               &min,
+				Max:               &max,
+				BucketCounts:      p.buckets,
+				ExplicitBounds:    s.bounds,
+			})
+		}
+	}
+	return metrics
+}
+
+// Close stops the periodic exports and syncs the sink.
+func (s *OTLPSink) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	s.wg.Wait()
+	return s.Sync()
+}
+
+func (s *OTLPSink) run() {
+	defer s.wg.Done()
+	tick := time.NewTicker(s.interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+		case <-s.full:
+		case <-s.done:
+			return
+		}
+		s.handle(s.Sync())
+	}
+}
+
+func (s *OTLPSink) handle(err error) {
+	if err != nil && s.onError != nil {
+		s.onError(err)
+	}
+}
@@ -0,0 +1,196 @@
+package otlp
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	stats "github.com/lyft/gostats"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+var _ stats.Syncer = (*OTLPSink)(nil)
+
+// receiver is a local OTLP metrics receiver.
+type receiver struct {
+	collectorpb.UnimplementedMetricsServiceServer
+	mu       sync.Mutex
+	requests []*collectorpb.ExportMetricsServiceRequest
+	err      error
+	received chan struct{}
+}
+
+func (r *receiver) Export(_ context.Context, req *collectorpb.ExportMetricsServiceRequest) (*collectorpb.ExportMetricsServiceResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return nil, r.err
+	}
+	r.requests = append(r.requests, req)
+	select {
+	case r.received <- struct{}{}:
+	default:
+	}
+	return &collectorpb.ExportMetricsServiceResponse{}, nil
+}
+
+func (r *receiver) Requests() []*collectorpb.ExportMetricsServiceRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	reqs := r.requests
+	r.requests = nil
+	return reqs
+}
+
+func newReceiver(t *testing.T) (*receiver, *grpc.ClientConn) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &receiver{received: make(chan struct{}, 1)}
+	gs := grpc.NewServer()
+	collectorpb.RegisterMetricsServiceServer(gs, r)
+	go gs.Serve(lis)
+	t.Cleanup(gs.Stop)
+
+	cc, err := grpc.NewClient(lis.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cc.Close() })
+	return r, cc
+}
+
+func TestOTLPSink(t *testing.T) {
+	r, cc := newReceiver(t)
+	sink := NewOTLPSink(cc, WithBatchInterval(0), WithBuckets([]float64{1, 2}),
+		WithResource(map[string]string{"service.name": "api"}))
+	defer sink.Close()
+	start := time.Unix(100, 0)
+	sink.start = start
+	sink.now = func() time.Time { return start.Add(10 * time.Second) }
+
+	sink.FlushCounter("requests.__code=200", 2)
+	sink.FlushCounter("requests.__code=200", 3)
+	sink.FlushCounter("requests.__code=500", 1)
+	sink.FlushGauge("conns", 4)
+	sink.FlushGauge("conns", 6)
+	sink.FlushTimer("latency", 0.5)
+	sink.FlushTimer("latency", 1.5)
+	sink.FlushTimer("latency", 4)
+	if err := sink.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	reqs := r.Requests()
+	if len(reqs) != 1 {
+		t.Fatalf("requests: got: %d want: %d", len(reqs), 1)
+	}
+	rm := reqs[0].ResourceMetrics[0]
+	if attrs := rm.Resource.Attributes; len(attrs) != 1 || attrs[0].Key != "service.name" ||
+		attrs[0].Value.GetStringValue() != "api" {
+		t.Errorf("resource: got: %v", rm.Resource)
+	}
+	sm := rm.ScopeMetrics[0]
+	if sm.Scope.Name != ScopeName || len(sm.Metrics) != 3 {
+		t.Fatalf("scope metrics: got: %v", sm)
+	}
+	byName := make(map[string]*metricspb.Metric)
+	for _, m := range sm.Metrics {
+		byName[m.Name] = m
+	}
+
+	sum := byName["requests"].GetSum()
+	if sum == nil || !sum.IsMonotonic || len(sum.DataPoints) != 2 ||
+		sum.AggregationTemporality != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA {
+		t.Fatalf("counter: got: %v", byName["requests"])
+	}
+	dp := sum.DataPoints[0]
+	if dp.GetAsInt() != 5 || dp.Attributes[0].Key != "code" || dp.Attributes[0].Value.GetStringValue() != "200" ||
+		dp.StartTimeUnixNano != uint64(start.UnixNano()) || dp.TimeUnixNano != uint64(start.Add(10*time.Second).UnixNano()) {
+		t.Errorf("counter data point: got: %v", dp)
+	}
+	if dp := sum.DataPoints[1]; dp.GetAsInt() != 1 {
+		t.Errorf("counter data point: got: %v", dp)
+	}
+
+	gauge := byName["conns"].GetGauge()
+	if gauge == nil || len(gauge.DataPoints) != 1 || gauge.DataPoints[0].GetAsInt() != 6 {
+		t.Errorf("gauge: got: %v", byName["conns"])
+	}
+
+	hist := byName["latency"].GetHistogram()
+	if hist == nil || len(hist.DataPoints) != 1 {
+		t.Fatalf("timer: got: %v", byName["latency"])
+	}
+	h := hist.DataPoints[0]
+	if h.Count != 3 || h.GetSum() != 6 || h.GetMin() != 0.5 || h.GetMax() != 4 {
+		t.Errorf("histogram: got: %v", h)
+	}
+	if b := h.BucketCounts; len(b) != 3 || b[0] != 1 || b[1] != 1 || b[2] != 1 {
+		t.Errorf("bucket counts: got: %v", b)
+	}
+
+	// the stats are reset after each export
+	if err := sink.Sync(); err != nil || len(r.Requests()) != 0 {
+		t.Errorf("Sync: got: %v want: no request", err)
+	}
+}
+
+func TestOTLPSinkBatchSize(t *testing.T) {
+	r, cc := newReceiver(t)
+	sink := NewOTLPSink(cc, WithBatchInterval(0), WithBatchSize(2))
+	defer sink.Close()
+
+	sink.FlushGauge("a", 1)
+	if n := len(r.Requests()); n != 0 {
+		t.Errorf("requests: got: %d want: %d", n, 0)
+	}
+	sink.FlushGauge("b", 1) // fills the batch
+	if n := len(r.Requests()); n != 1 {
+		t.Errorf("requests: got: %d want: %d", n, 1)
+	}
+}
+
+func TestOTLPSinkBatchInterval(t *testing.T) {
+	r, cc := newReceiver(t)
+	sink := NewOTLPSink(cc, WithBatchInterval(10*time.Millisecond))
+	defer sink.Close()
+
+	sink.FlushCounter("c", 1)
+	select {
+	case <-r.received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stats were not exported periodically")
+	}
+}
+
+func TestOTLPSinkError(t *testing.T) {
+	r, cc := newReceiver(t)
+	r.err = status.Error(codes.Unavailable, "overloaded")
+	var mu sync.Mutex
+	var errs []error
+	sink := NewOTLPSink(cc, WithBatchInterval(0), WithBatchSize(1),
+		WithErrorHandler(func(err error) {
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+		}))
+	defer sink.Close()
+
+	sink.FlushGauge("g", 1)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(errs) != 1 || status.Code(errs[0]) != codes.Unavailable {
+		t.Errorf("errors: got: %v want: an Unavailable error", errs)
+	}
+}
@@ -0,0 +1,293 @@
+// Package prometheus provides a stats.Sink that exposes stats in the
+// Prometheus text exposition format.
+package prometheus
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/lyft/gostats/internal/tags"
+)
+
+// ContentType is the media type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+type metricType int
+
+const (
+	counterType metricType = iota
+	gaugeType
+	summaryType
+)
+
+func (t metricType) String() string {
+	switch t {
+	case counterType:
+		return "counter"
+	case gaugeType:
+		return "gauge"
+	default:
+		return "summary"
+	}
+}
+
+type series struct {
+	labels string // serialized label pairs, without braces
+	value  uint64 // value of a counter or gauge
+	sum    float64
+	count  uint64
+}
+
+type family struct {
+	typ    metricType
+	series map[string]*series
+}
+
+// A PrometheusSink is a stats.Sink that accumulates flushed stats in memory
+// and serves them in the Prometheus text exposition format through the
+// http.Handler returned by Handler().
+//
+// Stat names are sanitized to match the Prometheus naming rules and tags are
+// exposed as labels. Counters are exposed as Prometheus counters, Gauges as
+// gauges and Timers as summaries with a sum and count, but no quantiles.
+// Stats that share a sanitized name with a stat of another type are dropped,
+// as are tags whose key sanitizes to the label name of another tag of the
+// stat.
+// The help text set with stats.WithHelp is exposed as the HELP of the
+// families.
+type PrometheusSink struct {
+	mu       sync.Mutex
+	families map[string]*family
+	help     map[string]string // sanitized name => help text
+}
+
+// NewPrometheusSink returns a new PrometheusSink.
+func NewPrometheusSink() *PrometheusSink {
+	return &PrometheusSink{
+		families: make(map[string]*family),
+		help:     make(map[string]string),
+	}
+}
+
+// series returns the series of stat, creating it if necessary. Nil is
+// returned if the stat's name is already used by a family of a different
+// type. s.mu must be held.
+func (s *PrometheusSink) series(stat string, typ metricType) *series {
+	name, labels := parseStat(stat)
+	f := s.families[name]
+	if f == nil {
+		f = &family{typ: typ, series: make(map[string]*series)}
+		s.families[name] = f
+	}
+	if f.typ != typ {
+		return nil
+	}
+	p := f.series[labels]
+	if p == nil {
+		p = &series{labels: labels}
+		f.series[labels] = p
+	}
+	return p
+}
+
+// FlushCounter implements the stats.Sink.FlushCounter method and adds value
+// to the counter.
+func (s *PrometheusSink) FlushCounter(name string, value uint64) {
+	s.mu.Lock()
+	if p := s.series(name, counterType); p != nil {
+		p.value += value
+	}
+	s.mu.Unlock()
+}
+
+// FlushGauge implements the stats.Sink.FlushGauge method and sets the gauge
+// to value.
+func (s *PrometheusSink) FlushGauge(name string, value uint64) {
+	s.mu.Lock()
+	if p := s.series(name, gaugeType); p != nil {
+		p.value = value
+	}
+	s.mu.Unlock()
+}
+
+// FlushTimer implements the stats.Sink.FlushTimer method and records value in
+// the timer's summary.
+func (s *PrometheusSink) FlushTimer(name string, value float64) {
+	s.mu.Lock()
+	if p := s.series(name, summaryType); p != nil {
+		p.sum += value
+		p.count++
+	}
+	s.mu.Unlock()
+}
+
+// SetHelp implements the stats.HelpSink.SetHelp method and sets the help text
+// of the family of name.
+func (s *PrometheusSink) SetHelp(name, help string) {
+	s.mu.Lock()
+	s.help[sanitizeName(name)] = help
+	s.mu.Unlock()
+}
+
+// Handler returns an http.Handler that serves the stats flushed to the sink
+// in the Prometheus text exposition format.
+func (s *PrometheusSink) Handler() http.Handler {
+	return http.HandlerFunc(s.serveHTTP)
+}
+
+func (s *PrometheusSink) serveHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	bw := bufio.NewWriter(w)
+	s.writeTo(bw)
+	bw.Flush()
+}
+
+// WriteTo writes the stats flushed to the sink to w in the Prometheus text
+// exposition format.
+func (s *PrometheusSink) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	s.writeTo(bw)
+	err := bw.Flush()
+	return cw.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func (s *PrometheusSink) writeTo(w *bufio.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.families))
+	for name := range s.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := s.families[name]
+		if help, ok := s.help[name]; ok {
+			w.WriteString("# HELP " + name + " " + helpReplacer.Replace(help) + "\n")
+		}
+		w.WriteString("# TYPE " + name + " " + f.typ.String() + "\n")
+
+		labels := make([]string, 0, len(f.series))
+		for l := range f.series {
+			labels = append(labels, l)
+		}
+		sort.Strings(labels)
+
+		for _, l := range labels {
+			p := f.series[l]
+			switch f.typ {
+			case summaryType:
+				writeSample(w, name+"_sum", p.labels, strconv.FormatFloat(p.sum, 'g', -1, 64))
+				writeSample(w, name+"_count", p.labels, strconv.FormatUint(p.count, 10))
+			default:
+				writeSample(w, name, p.labels, strconv.FormatUint(p.value, 10))
+			}
+		}
+	}
+}
+
+func writeSample(w *bufio.Writer, name, labels, value string) {
+	w.WriteString(name)
+	if labels != "" {
+		w.WriteByte('{')
+		w.WriteString(labels)
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(value)
+	w.WriteByte('\n')
+}
+
+// parseStat returns the sanitized name and serialized labels of stat. Tags
+// whose key sanitizes to the label name of a previous tag are dropped since
+// Prometheus rejects duplicate labels.
+func parseStat(stat string) (string, string) {
+	name, set := tags.ParseTagSet(stat)
+	if len(set) == 0 {
+		return sanitizeName(name), ""
+	}
+	var b strings.Builder
+	labels := make([]string, 0, len(set))
+	for _, t := range set {
+		label := sanitizeLabelName(t.Key)
+		if containsString(labels, label) {
+			continue
+		}
+		if len(labels) != 0 {
+			b.WriteByte(',')
+		}
+		labels = append(labels, label)
+		b.WriteString(label)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabelValue(t.Value))
+		b.WriteByte('"')
+	}
+	return sanitizeName(name), b.String()
+}
+
+func containsString(a []string, s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitizeName replaces any chars that are not valid in a Prometheus metric
+// name ([a-zA-Z_:][a-zA-Z0-9_:]*) with '_'.
+func sanitizeName(s string) string {
+	return sanitize(s, true)
+}
+
+// sanitizeLabelName replaces any chars that are not valid in a Prometheus label
+// name ([a-zA-Z_][a-zA-Z0-9_]*) with '_'.
+func sanitizeLabelName(s string) string {
+	return sanitize(s, false)
+}
+
+func sanitize(s string, allowColon bool) string {
+	if s == "" {
+		return "_"
+	}
+	b := []byte(s)
+	for i, c := range b {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', c == '_':
+		case '0' <= c && c <= '9':
+			if i == 0 {
+				b[i] = '_'
+			}
+		case c == ':' && allowColon:
+		default:
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(s string) string {
+	return labelValueReplacer.Replace(s)
+}
+
+var helpReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
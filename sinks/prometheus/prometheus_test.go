@@ -0,0 +1,113 @@
+package prometheus
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	stats "github.com/lyft/gostats"
+)
+
+var _ stats.Sink = (*PrometheusSink)(nil)
+
+func TestPrometheusSink(t *testing.T) {
+	sink := NewPrometheusSink()
+	store := stats.NewStore(sink, false)
+
+	scope := store.ScopeWithTags("service", map[string]string{"region": "us-east-1"})
+	scope.NewCounterWithTags("requests", map[string]string{"code": "200"}).Add(2)
+	scope.NewCounterWithTags("requests", map[string]string{"code": "500"}).Inc()
+	store.NewGauge("active-conns").Set(7)
+	timer := store.NewTimer("rq.latency")
+	timer.AddValue(1.5)
+	timer.AddValue(2)
+	store.Flush()
+
+	scope.NewCounterWithTags("requests", map[string]string{"code": "200"}).Inc()
+	store.NewGauge("rq.latency").Set(1) // type conflict: dropped
+	store.Flush()
+
+	rec := httptest.NewRecorder()
+	sink.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("Content-Type: got: %q want: %q", ct, ContentType)
+	}
+	b, err := ioutil.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const expected = "# TYPE active_conns gauge\n" +
+		"active_conns 7\n" +
+		"# TYPE rq_latency summary\n" +
+		"rq_latency_sum 3.5\n" +
+		"rq_latency_count 2\n" +
+		"# TYPE service_requests counter\n" +
+		"service_requests{code=\"200\",region=\"us-east-1\"} 3\n" +
+		"service_requests{code=\"500\",region=\"us-east-1\"} 1\n"
+	if string(b) != expected {
+		t.Errorf("exposition\ngot:\n%s\nwant:\n%s", b, expected)
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		in, name, label string
+	}{
+		{"", "_", "_"},
+		{"a.b", "a_b", "a_b"},
+		{"1abc", "_abc", "_abc"},
+		{"a:b", "a:b", "a_b"},
+		{"a-b c", "a_b_c", "a_b_c"},
+		{"_f", "_f", "_f"},
+	}
+	for _, x := range tests {
+		if s := sanitizeName(x.in); s != x.name {
+			t.Errorf("sanitizeName(%q): got: %q want: %q", x.in, s, x.name)
+		}
+		if s := sanitizeLabelName(x.in); s != x.label {
+			t.Errorf("sanitizeLabelName(%q): got: %q want: %q", x.in, s, x.label)
+		}
+	}
+}
+
+func TestParseStatLabelCollision(t *testing.T) {
+	stat := "requests.__a-b=1.__a_b=2.__c=3"
+	name, labels := parseStat(stat)
+	if name != "requests" {
+		t.Errorf("name: got: %q want: %q", name, "requests")
+	}
+	const exp = `a_b="1",c="3"`
+	if labels != exp {
+		t.Errorf("labels: got: %q want: %q", labels, exp)
+	}
+}
+
+func TestEscapeLabelValue(t *testing.T) {
+	const in = "a\\b\"c\nd"
+	const exp = `a\\b\"c\nd`
+	if s := escapeLabelValue(in); s != exp {
+		t.Errorf("escapeLabelValue(%q): got: %q want: %q", in, s, exp)
+	}
+}
+
+func TestPrometheusSinkHelp(t *testing.T) {
+	sink := NewPrometheusSink()
+	store := stats.NewStore(sink, false)
+
+	store.Scope("service").WithOptions(stats.WithHelp("Requests served.\nBy code \\ status.")).
+		NewCounterWithTags("requests", map[string]string{"code": "200"}).Inc()
+	store.NewGauge("active-conns").Set(7)
+	store.Flush()
+
+	rec := httptest.NewRecorder()
+	sink.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	const expected = "# TYPE active_conns gauge\n" +
+		"active_conns 7\n" +
+		"# HELP service_requests Requests served.\\nBy code \\\\ status.\n" +
+		"# TYPE service_requests counter\n" +
+		"service_requests{code=\"200\"} 1\n"
+	if s := rec.Body.String(); s != expected {
+		t.Errorf("exposition\ngot:\n%s\nwant:\n%s", s, expected)
+	}
+}
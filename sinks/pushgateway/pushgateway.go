@@ -0,0 +1,262 @@
+// Package pushgateway provides a stats.Sink that pushes stats to a Prometheus
+// Pushgateway.
+package pushgateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lyft/gostats/sinks/prometheus"
+)
+
+// Defaults of the PushgatewaySink options.
+const (
+	DefaultMaxRetries   = 3
+	DefaultRetryBackoff = 100 * time.Millisecond
+)
+
+// An Option configures a PushgatewaySink.
+type Option interface {
+	apply(*PushgatewaySink)
+}
+
+// optionFunc wraps a func so it satisfies the Option interface.
+type optionFunc func(*PushgatewaySink)
+
+func (f optionFunc) apply(sink *PushgatewaySink) {
+	f(sink)
+}
+
+// WithInstance sets the instance label of the pushed group, by default the
+// group only has a job label.
+func WithInstance(instance string) Option {
+	return optionFunc(func(sink *PushgatewaySink) {
+		sink.instance = instance
+	})
+}
+
+// WithPushInterval pushes the stats at interval d in the background if d is
+// greater than zero. Close stops the pushes.
+func WithPushInterval(d time.Duration) Option {
+	return optionFunc(func(sink *PushgatewaySink) {
+		sink.interval = d
+	})
+}
+
+// WithHTTPClient sets the client used to push the stats, the default is
+// http.DefaultClient.
+func WithHTTPClient(client *http.Client) Option {
+	return optionFunc(func(sink *PushgatewaySink) {
+		sink.client = client
+	})
+}
+
+// WithRetries sets the number of times a failed push is retried, the default
+// is DefaultMaxRetries, and the delay before the first retry, the default is
+// DefaultRetryBackoff. The delay doubles after each retry.
+func WithRetries(n int, backoff time.Duration) Option {
+	return optionFunc(func(sink *PushgatewaySink) {
+		sink.maxRetries = n
+		sink.backoff = backoff
+	})
+}
+
+// WithErrorHandler sets the function called with the errors of the pushes
+// started by WithPushInterval, by default they are ignored.
+func WithErrorHandler(fn func(error)) Option {
+	return optionFunc(func(sink *PushgatewaySink) {
+		sink.onError = fn
+	})
+}
+
+// A PushgatewaySink is a stats.Sink that accumulates the flushed stats in a
+// prometheus.PrometheusSink and pushes all of them to a Prometheus Pushgateway
+// in the text exposition format when Sync is called, and at the interval set
+// with WithPushInterval.
+//
+// The stats are POSTed to the group of the job and instance labels, so they
+// replace the metrics of the same name in the group. Pushes that fail with a
+// network error or a 5xx status are retried with an exponential backoff.
+type PushgatewaySink struct {
+	registry *prometheus.PrometheusSink
+
+	pushURL    string
+	instance   string
+	interval   time.Duration
+	client     *http.Client
+	maxRetries int
+	backoff    time.Duration
+	onError    func(error)
+
+	pushMu sync.Mutex // serializes the pushes
+
+	closeOnce sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// NewPushgatewaySink returns a PushgatewaySink that pushes stats to the
+// Pushgateway at baseURL, for example "http://localhost:9091", with the job
+// label job.
+func NewPushgatewaySink(baseURL, job string, opts ...Option) *PushgatewaySink {
+	s := &PushgatewaySink{
+		registry:   prometheus.NewPrometheusSink(),
+		client:     http.DefaultClient,
+		maxRetries: DefaultMaxRetries,
+		backoff:    DefaultRetryBackoff,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt.apply(s)
+	}
+	if s.client == nil {
+		s.client = http.DefaultClient
+	}
+	if s.backoff <= 0 {
+		s.backoff = DefaultRetryBackoff
+	}
+	s.pushURL = strings.TrimSuffix(baseURL, "/") + "/metrics" +
+		groupingPath("job", job)
+	if s.instance != "" {
+		s.pushURL += groupingPath("instance", s.instance)
+	}
+	if s.interval > 0 {
+		go s.run()
+	} else {
+		close(s.done)
+	}
+	return s
+}
+
+// groupingPath returns the URL path of a grouping label. Values that can not
+// be used in a path segment are base64 encoded.
+func groupingPath(name, value string) string {
+	if value == "" || strings.Contains(value, "/") {
+		return "/" + name + "@base64/" + base64.RawURLEncoding.EncodeToString([]byte(value))
+	}
+	return "/" + name + "/" + url.PathEscape(value)
+}
+
+// FlushCounter implements the stats.Sink.FlushCounter method.
+func (s *PushgatewaySink) FlushCounter(name string, value uint64) {
+	s.registry.FlushCounter(name, value)
+}
+
+// FlushGauge implements the stats.Sink.FlushGauge method.
+func (s *PushgatewaySink) FlushGauge(name string, value uint64) {
+	s.registry.FlushGauge(name, value)
+}
+
+// FlushTimer implements the stats.Sink.FlushTimer method.
+func (s *PushgatewaySink) FlushTimer(name string, value float64) {
+	s.registry.FlushTimer(name, value)
+}
+
+// SetHelp implements the stats.HelpSink.SetHelp method.
+func (s *PushgatewaySink) SetHelp(name, help string) {
+	s.registry.SetHelp(name, help)
+}
+
+// Sync implements the stats.Syncer interface and pushes the stats.
+func (s *PushgatewaySink) Sync() error {
+	return s.Push(context.Background())
+}
+
+// Push pushes the stats, retrying failed pushes, and returns the error of the
+// last attempt.
+func (s *PushgatewaySink) Push(ctx context.Context) error {
+	s.pushMu.Lock()
+	defer s.pushMu.Unlock()
+
+	var buf bytes.Buffer
+	if _, err := s.registry.WriteTo(&buf); err != nil {
+		return err
+	}
+	body := buf.Bytes()
+
+	backoff := s.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := s.push(ctx, body)
+		if err == nil || !retry || attempt >= s.maxRetries {
+			return err
+		}
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// push POSTs body once and reports if a failure can be retried.
+func (s *PushgatewaySink) push(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, s.pushURL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", prometheus.ContentType)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return resp.StatusCode/100 == 5, fmt.Errorf("pushgateway: push to %s failed: %s: %s",
+			s.pushURL, resp.Status, bytes.TrimSpace(msg))
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	return false, nil
+}
+
+func (s *PushgatewaySink) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Push(ctx); err != nil && s.onError != nil && ctx.Err() == nil {
+				s.onError(err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Close stops the pushes started by WithPushInterval and pushes the stats a
+// last time.
+func (s *PushgatewaySink) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		<-s.done
+		err = s.Push(context.Background())
+	})
+	return err
+}
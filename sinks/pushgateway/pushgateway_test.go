@@ -0,0 +1,142 @@
+package pushgateway
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	stats "github.com/lyft/gostats"
+	"github.com/lyft/gostats/sinks/prometheus"
+)
+
+var (
+	_ stats.Syncer   = (*PushgatewaySink)(nil)
+	_ stats.HelpSink = (*PushgatewaySink)(nil)
+)
+
+type pushRecorder struct {
+	mu     sync.Mutex
+	paths  []string
+	bodies []string
+	fail   int // number of requests to fail with a 503
+	status int // if not zero the status of the requests that do not fail
+}
+
+func (p *pushRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, _ := ioutil.ReadAll(r.Body)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paths = append(p.paths, r.Method+" "+r.URL.EscapedPath())
+	if ct := r.Header.Get("Content-Type"); ct != prometheus.ContentType {
+		http.Error(w, "bad content type: "+ct, http.StatusBadRequest)
+		return
+	}
+	if p.fail > 0 {
+		p.fail--
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if p.status != 0 {
+		http.Error(w, "rejected", p.status)
+		return
+	}
+	p.bodies = append(p.bodies, string(b))
+}
+
+func TestPushgatewaySink(t *testing.T) {
+	rec := &pushRecorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	sink := NewPushgatewaySink(srv.URL+"/", "batch", WithInstance("host/1"))
+	store := stats.NewStore(sink, false)
+	store.NewCounter("jobs").Add(2)
+	store.NewGauge("last_run").Set(7)
+	if err := store.FlushAll(); err != nil {
+		t.Fatal(err)
+	}
+	store.NewCounter("jobs").Inc()
+	if err := store.FlushAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	expPath := "POST /metrics/job/batch/instance@base64/aG9zdC8x"
+	if len(rec.paths) != 2 || rec.paths[0] != expPath {
+		t.Errorf("requests: got: %q want: 2 x %q", rec.paths, expPath)
+	}
+	const exp = "# TYPE jobs counter\n" +
+		"jobs 3\n" +
+		"# TYPE last_run gauge\n" +
+		"last_run 7\n"
+	if len(rec.bodies) != 2 || rec.bodies[1] != exp {
+		t.Errorf("body: got: %q want: %q", rec.bodies, exp)
+	}
+}
+
+func TestPushgatewaySinkRetry(t *testing.T) {
+	rec := &pushRecorder{fail: 2}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	sink := NewPushgatewaySink(srv.URL, "batch", WithRetries(2, time.Millisecond))
+	sink.FlushGauge("g", 1)
+	if err := sink.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.paths) != 3 || len(rec.bodies) != 1 {
+		t.Errorf("requests: got: %d pushed: %d want: 3 and 1", len(rec.paths), len(rec.bodies))
+	}
+
+	// exhausted retries
+	rec.fail = 3
+	if err := sink.Sync(); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("Sync: got: %v want: a 503 error", err)
+	}
+
+	// client errors are not retried
+	rec.paths = nil
+	rec.status = http.StatusBadRequest
+	if err := sink.Sync(); err == nil {
+		t.Error("Sync: expected an error")
+	}
+	if len(rec.paths) != 1 {
+		t.Errorf("requests: got: %d want: %d", len(rec.paths), 1)
+	}
+}
+
+func TestPushgatewaySinkInterval(t *testing.T) {
+	rec := &pushRecorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	sink := NewPushgatewaySink(srv.URL, "svc", WithPushInterval(time.Millisecond))
+	sink.FlushCounter("c", 1)
+	for i := 0; ; i++ {
+		rec.mu.Lock()
+		n := len(rec.bodies)
+		rec.mu.Unlock()
+		if n >= 2 {
+			break
+		}
+		if i == 1000 {
+			t.Fatal("stats were not pushed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rec.mu.Lock()
+	n := len(rec.bodies)
+	rec.mu.Unlock()
+	time.Sleep(time.Millisecond * 5)
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.bodies) != n {
+		t.Errorf("pushes after Close: got: %d want: 0", len(rec.bodies)-n)
+	}
+}
@@ -0,0 +1,114 @@
+// Package ringbuffer provides a fixed capacity, in-memory stats.Sink that is
+// cheap enough to be used in benchmarks.
+package ringbuffer
+
+import "sync"
+
+// A Kind is the type of stat of a SinkEntry.
+type Kind uint8
+
+// Kinds of stats.
+const (
+	KindCounter Kind = iota
+	KindGauge
+	KindTimer
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindCounter:
+		return "counter"
+	case KindGauge:
+		return "gauge"
+	case KindTimer:
+		return "timer"
+	default:
+		return "unknown"
+	}
+}
+
+// A SinkEntry is a stat flushed to a Sink.
+type SinkEntry struct {
+	Kind Kind
+	Name string
+	// Value of the stat, Counter and Gauge values larger than 2^53 lose
+	// precision.
+	Value float64
+}
+
+// A Sink is a stats.Sink that records flushed stats in a ring buffer of
+// fixed capacity. When the buffer is full the oldest entry is overwritten.
+// Flushing a stat does not allocate. A Sink is safe for concurrent use.
+type Sink struct {
+	mu      sync.Mutex
+	entries []SinkEntry
+	next    int // index of the next entry to write
+	full    bool
+}
+
+// NewSink returns a Sink that holds up to capacity entries. It panics if
+// capacity is not positive.
+func NewSink(capacity int) *Sink {
+	if capacity <= 0 {
+		panic("ringbuffer: capacity must be positive")
+	}
+	return &Sink{entries: make([]SinkEntry, capacity)}
+}
+
+func (s *Sink) add(kind Kind, name string, value float64) {
+	s.mu.Lock()
+	s.entries[s.next] = SinkEntry{Kind: kind, Name: name, Value: value}
+	if s.next++; s.next == len(s.entries) {
+		s.next = 0
+		s.full = true
+	}
+	s.mu.Unlock()
+}
+
+// FlushCounter implements the stats.Sink.FlushCounter method.
+func (s *Sink) FlushCounter(name string, value uint64) {
+	s.add(KindCounter, name, float64(value))
+}
+
+// FlushGauge implements the stats.Sink.FlushGauge method.
+func (s *Sink) FlushGauge(name string, value uint64) {
+	s.add(KindGauge, name, float64(value))
+}
+
+// FlushTimer implements the stats.Sink.FlushTimer method.
+func (s *Sink) FlushTimer(name string, value float64) {
+	s.add(KindTimer, name, value)
+}
+
+// Len returns the number of entries in the buffer.
+func (s *Sink) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.full {
+		return len(s.entries)
+	}
+	return s.next
+}
+
+// Entries returns a copy of the entries in the buffer, oldest first.
+func (s *Sink) Entries() []SinkEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.full {
+		return append([]SinkEntry(nil), s.entries[:s.next]...)
+	}
+	a := make([]SinkEntry, 0, len(s.entries))
+	a = append(a, s.entries[s.next:]...)
+	return append(a, s.entries[:s.next]...)
+}
+
+// Reset removes all entries from the buffer.
+func (s *Sink) Reset() {
+	s.mu.Lock()
+	for i := range s.entries {
+		s.entries[i] = SinkEntry{}
+	}
+	s.next = 0
+	s.full = false
+	s.mu.Unlock()
+}
@@ -0,0 +1,80 @@
+package ringbuffer
+
+import (
+	"reflect"
+	"testing"
+
+	stats "github.com/lyft/gostats"
+)
+
+var _ stats.Sink = (*Sink)(nil)
+
+func TestSink(t *testing.T) {
+	sink := NewSink(3)
+	if n := sink.Len(); n != 0 {
+		t.Errorf("Len: got: %d want: %d", n, 0)
+	}
+
+	sink.FlushCounter("counter", 1)
+	sink.FlushGauge("gauge", 2)
+	exp := []SinkEntry{
+		{Kind: KindCounter, Name: "counter", Value: 1},
+		{Kind: KindGauge, Name: "gauge", Value: 2},
+	}
+	if entries := sink.Entries(); !reflect.DeepEqual(entries, exp) {
+		t.Errorf("Entries: got: %+v want: %+v", entries, exp)
+	}
+
+	// the oldest entries are dropped
+	sink.FlushTimer("timer", 3.5)
+	sink.FlushCounter("counter", 4)
+	sink.FlushCounter("counter", 5)
+	exp = []SinkEntry{
+		{Kind: KindTimer, Name: "timer", Value: 3.5},
+		{Kind: KindCounter, Name: "counter", Value: 4},
+		{Kind: KindCounter, Name: "counter", Value: 5},
+	}
+	if entries := sink.Entries(); !reflect.DeepEqual(entries, exp) {
+		t.Errorf("Entries: got: %+v want: %+v", entries, exp)
+	}
+	if n := sink.Len(); n != 3 {
+		t.Errorf("Len: got: %d want: %d", n, 3)
+	}
+
+	sink.Reset()
+	if n := sink.Len(); n != 0 {
+		t.Errorf("Len after Reset: got: %d want: %d", n, 0)
+	}
+	if entries := sink.Entries(); len(entries) != 0 {
+		t.Errorf("Entries after Reset: %+v", entries)
+	}
+}
+
+func TestSinkStore(t *testing.T) {
+	sink := NewSink(16)
+	store := stats.NewStore(sink, false)
+	store.NewCounter("c").Inc()
+	store.NewGauge("g").Set(1)
+	store.Flush()
+	if n := sink.Len(); n != 2 {
+		t.Errorf("Len: got: %d want: %d: %+v", n, 2, sink.Entries())
+	}
+}
+
+func TestSinkAllocs(t *testing.T) {
+	sink := NewSink(8)
+	n := testing.AllocsPerRun(100, func() {
+		sink.FlushCounter("counter", 1)
+		sink.FlushTimer("timer", 1)
+	})
+	if n != 0 {
+		t.Errorf("allocations per flush: %f", n)
+	}
+}
+
+func BenchmarkSink_FlushCounter(b *testing.B) {
+	sink := NewSink(1024)
+	for i := 0; i < b.N; i++ {
+		sink.FlushCounter("counter", 1)
+	}
+}
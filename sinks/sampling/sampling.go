@@ -0,0 +1,212 @@
+// Package sampling provides a stats.Sink that randomly drops flushed stats.
+package sampling
+
+import (
+	"io"
+	"math"
+	"math/rand"
+
+	stats "github.com/lyft/gostats"
+)
+
+// An Option configures a SamplingSink.
+type Option interface {
+	apply(*SamplingSink)
+}
+
+type optionFunc func(*SamplingSink)
+
+func (f optionFunc) apply(s *SamplingSink) { f(s) }
+
+// clampRate limits rate to the range [0, 1].
+func clampRate(rate float64) float64 {
+	if !(rate > 0) { // also catches NaN
+		return 0
+	}
+	if rate > 1 {
+		return 1
+	}
+	return rate
+}
+
+// WithCounterRate sets the sample rate of counters (default 1).
+func WithCounterRate(rate float64) Option {
+	return optionFunc(func(s *SamplingSink) {
+		s.counterRate = clampRate(rate)
+	})
+}
+
+// WithGaugeRate sets the sample rate of gauges and up/down counters
+// (default 1).
+func WithGaugeRate(rate float64) Option {
+	return optionFunc(func(s *SamplingSink) {
+		s.gaugeRate = clampRate(rate)
+	})
+}
+
+// WithTimerRate sets the sample rate of timers and distributions (default 1).
+func WithTimerRate(rate float64) Option {
+	return optionFunc(func(s *SamplingSink) {
+		s.timerRate = clampRate(rate)
+	})
+}
+
+// WithNameRates sets the sample rate of individual stats, overriding the
+// rate of their type. Names must include any serialized tags.
+func WithNameRates(rates map[string]float64) Option {
+	return optionFunc(func(s *SamplingSink) {
+		if s.nameRates == nil {
+			s.nameRates = make(map[string]float64, len(rates))
+		}
+		for name, rate := range rates {
+			s.nameRates[name] = clampRate(rate)
+		}
+	})
+}
+
+// A SamplingSink wraps a stats.Sink and forwards each flushed stat with the
+// configured probability. Sampled counter values are scaled by 1/rate so
+// that their totals remain approximately correct.
+type SamplingSink struct {
+	sink        stats.Sink
+	counterRate float64
+	gaugeRate   float64
+	timerRate   float64
+	nameRates   map[string]float64
+	rand        func() float64 // returns a float in [0, 1)
+}
+
+// NewSamplingSink returns a SamplingSink that forwards stats to sink.
+func NewSamplingSink(sink stats.Sink, opts ...Option) *SamplingSink {
+	s := &SamplingSink{
+		sink:        sink,
+		counterRate: 1,
+		gaugeRate:   1,
+		timerRate:   1,
+		rand:        rand.Float64,
+	}
+	for _, opt := range opts {
+		opt.apply(s)
+	}
+	return s
+}
+
+// sample returns the rate for name and if the stat should be forwarded.
+func (s *SamplingSink) sample(name string, rate float64) (float64, bool) {
+	if r, ok := s.nameRates[name]; ok {
+		rate = r
+	}
+	if rate >= 1 {
+		return 1, true
+	}
+	return rate, rate > 0 && s.rand() < rate
+}
+
+func (s *SamplingSink) FlushCounter(name string, value uint64) {
+	rate, ok := s.sample(name, s.counterRate)
+	if !ok {
+		return
+	}
+	if rate < 1 {
+		value = uint64(math.Round(float64(value) / rate))
+	}
+	s.sink.FlushCounter(name, value)
+}
+
+func (s *SamplingSink) FlushGauge(name string, value uint64) {
+	if _, ok := s.sample(name, s.gaugeRate); ok {
+		s.sink.FlushGauge(name, value)
+	}
+}
+
+func (s *SamplingSink) FlushTimer(name string, value float64) {
+	if _, ok := s.sample(name, s.timerRate); ok {
+		s.sink.FlushTimer(name, value)
+	}
+}
+
+// FlushDistribution samples distributions at the timer rate. If the underlying
+// sink does not implement stats.DistributionSink the value is flushed as a
+// gauge.
+func (s *SamplingSink) FlushDistribution(name string, value float64) {
+	if _, ok := s.sample(name, s.timerRate); !ok {
+		return
+	}
+	if ds, ok := s.sink.(stats.DistributionSink); ok {
+		ds.FlushDistribution(name, value)
+	} else {
+		if value < 0 {
+			value = 0
+		}
+		s.sink.FlushGauge(name, uint64(value))
+	}
+}
+
+// FlushUpDownCounter samples up/down counters at the gauge rate. If the
+// underlying sink does not implement stats.UpDownCounterSink the value is
+// flushed as a gauge.
+func (s *SamplingSink) FlushUpDownCounter(name string, value int64) {
+	if _, ok := s.sample(name, s.gaugeRate); !ok {
+		return
+	}
+	if us, ok := s.sink.(stats.UpDownCounterSink); ok {
+		us.FlushUpDownCounter(name, value)
+	} else {
+		if value < 0 {
+			value = 0
+		}
+		s.sink.FlushGauge(name, uint64(value))
+	}
+}
+
+// FlushFloatGauge samples float gauges at the gauge rate. If the underlying
+// sink does not implement stats.FloatGaugeSink the value is flushed as a
+// gauge.
+func (s *SamplingSink) FlushFloatGauge(name string, value float64) {
+	if _, ok := s.sample(name, s.gaugeRate); !ok {
+		return
+	}
+	if fs, ok := s.sink.(stats.FloatGaugeSink); ok {
+		fs.FlushFloatGauge(name, value)
+	} else {
+		if value < 0 || math.IsNaN(value) {
+			value = 0
+		}
+		s.sink.FlushGauge(name, uint64(value))
+	}
+}
+
+// Sync syncs the underlying sink if it implements stats.Syncer, otherwise
+// it flushes it if it implements stats.FlushableSink.
+func (s *SamplingSink) Sync() error {
+	switch sink := s.sink.(type) {
+	case stats.Syncer:
+		return sink.Sync()
+	case stats.FlushableSink:
+		sink.Flush()
+	}
+	return nil
+}
+
+// Close closes the underlying sink if it implements io.Closer.
+func (s *SamplingSink) Close() error {
+	if c, ok := s.sink.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// SetHelp sets the help text of name on the underlying sink if it implements
+// stats.HelpSink.
+func (s *SamplingSink) SetHelp(name, help string) {
+	if hs, ok := s.sink.(stats.HelpSink); ok {
+		hs.SetHelp(name, help)
+	}
+}
+
+// Flush flushes the underlying sink if it implements stats.FlushableSink.
+func (s *SamplingSink) Flush() {
+	if fs, ok := s.sink.(stats.FlushableSink); ok {
+		fs.Flush()
+	}
+}
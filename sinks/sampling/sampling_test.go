@@ -0,0 +1,88 @@
+package sampling
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	stats "github.com/lyft/gostats"
+	"github.com/lyft/gostats/mock"
+)
+
+var (
+	_ stats.FlushableSink     = (*SamplingSink)(nil)
+	_ stats.DistributionSink  = (*SamplingSink)(nil)
+	_ stats.UpDownCounterSink = (*SamplingSink)(nil)
+	_ stats.FloatGaugeSink    = (*SamplingSink)(nil)
+	_ stats.Syncer            = (*SamplingSink)(nil)
+)
+
+func newTestSink(sink stats.Sink, opts ...Option) *SamplingSink {
+	s := NewSamplingSink(sink, opts...)
+	s.rand = rand.New(rand.NewSource(1)).Float64
+	return s
+}
+
+func TestCounterScaling(t *testing.T) {
+	const (
+		N     = 100000
+		value = 3
+	)
+	for _, rate := range []float64{0.5, 0.1, 0.25, 0.01} {
+		m := mock.NewSink()
+		s := newTestSink(m, WithCounterRate(rate))
+		for i := 0; i < N; i++ {
+			s.FlushCounter("c", value)
+		}
+		want := float64(N * value)
+		got := float64(m.Counter("c"))
+		// The relative standard deviation of the estimate is sqrt((1-p)/(N*p)),
+		// allow for 5 standard deviations.
+		tolerance := 5 * math.Sqrt((1-rate)/(N*rate))
+		if d := math.Abs(got-want) / want; d > tolerance {
+			t.Errorf("rate %g: got: %g want: %g (error %.4f > %.4f)",
+				rate, got, want, d, tolerance)
+		}
+	}
+}
+
+func TestRates(t *testing.T) {
+	const N = 10000
+	m := mock.NewSink()
+	s := newTestSink(m,
+		WithGaugeRate(0),
+		WithTimerRate(0.5),
+		WithNameRates(map[string]float64{"g.always": 1, "t.never": 0}),
+	)
+	for i := 0; i < N; i++ {
+		s.FlushCounter("c", 1)
+		s.FlushGauge("g", 1)
+		s.FlushGauge("g.always", 1)
+		s.FlushTimer("t", 1)
+		s.FlushTimer("t.never", 1)
+	}
+	m.AssertCounterEquals(t, "c", N)
+	m.AssertGaugeNotExists(t, "g")
+	m.AssertGaugeCallCount(t, "g.always", N)
+	m.AssertTimerNotExists(t, "t.never")
+
+	if n := m.TimerCallCount("t"); n < N*0.45 || n > N*0.55 {
+		t.Errorf("timer call count: got: %d want: ~%d", n, N/2)
+	}
+}
+
+func TestClampRate(t *testing.T) {
+	tests := map[float64]float64{
+		-1:         0,
+		0:          0,
+		0.5:        0.5,
+		1:          1,
+		2:          1,
+		math.NaN(): 0,
+	}
+	for in, want := range tests {
+		if got := clampRate(in); got != want {
+			t.Errorf("clampRate(%g): got: %g want: %g", in, got, want)
+		}
+	}
+}
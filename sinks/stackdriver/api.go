@@ -0,0 +1,59 @@
+package stackdriver
+
+// The JSON representation of the timeSeries.create request of the Cloud
+// Monitoring v3 API. 64-bit integers are encoded as strings.
+
+type createRequest struct {
+	TimeSeries []timeSeries `json:"timeSeries"`
+}
+
+type timeSeries struct {
+	Metric     metric            `json:"metric"`
+	Resource   monitoredResource `json:"resource"`
+	MetricKind string            `json:"metricKind"`
+	ValueType  string            `json:"valueType"`
+	Points     []point           `json:"points"`
+}
+
+type metric struct {
+	Type   string            `json:"type"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+type monitoredResource struct {
+	Type   string            `json:"type"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+type point struct {
+	Interval interval   `json:"interval"`
+	Value    typedValue `json:"value"`
+}
+
+type interval struct {
+	StartTime string `json:"startTime,omitempty"`
+	EndTime   string `json:"endTime"`
+}
+
+type typedValue struct {
+	Int64Value   string        `json:"int64Value,omitempty"`
+	Distribution *distribution `json:"distributionValue,omitempty"`
+}
+
+type distribution struct {
+	Count                 string        `json:"count"`
+	Mean                  float64       `json:"mean"`
+	SumOfSquaredDeviation float64       `json:"sumOfSquaredDeviation"`
+	BucketOptions         bucketOptions `json:"bucketOptions"`
+	BucketCounts          []string      `json:"bucketCounts"`
+}
+
+type bucketOptions struct {
+	ExponentialBuckets exponentialBuckets `json:"exponentialBuckets"`
+}
+
+type exponentialBuckets struct {
+	NumFiniteBuckets int     `json:"numFiniteBuckets"`
+	GrowthFactor     float64 `json:"growthFactor"`
+	Scale            float64 `json:"scale"`
+}
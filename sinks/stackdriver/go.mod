@@ -0,0 +1,17 @@
+module github.com/lyft/gostats/sinks/stackdriver
+
+go 1.25.0
+
+require (
+	github.com/lyft/gostats v0.0.0
+	golang.org/x/oauth2 v0.30.0
+)
+
+require (
+	cloud.google.com/go/compute/metadata v0.3.0 // indirect
+	github.com/sirupsen/logrus v1.0.6 // indirect
+	golang.org/x/crypto v0.0.0-20200204104054-c9f3fb736b72 // indirect
+	golang.org/x/sys v0.0.0-20200519105757-fe76b779f299 // indirect
+)
+
+replace github.com/lyft/gostats => ../..
@@ -0,0 +1,502 @@
+// Package stackdriver provides a stats.Sink that writes stats to Google Cloud
+// Monitoring, formerly Stackdriver, with the monitoring.googleapis.com/v3 REST
+// API.
+package stackdriver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lyft/gostats/internal/tags"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// Scope is the OAuth2 scope of the credentials used by the sink.
+const Scope = "https://www.googleapis.com/auth/monitoring.write"
+
+// Defaults of the StackdriverSink options.
+const (
+	DefaultEndpoint     = "https://monitoring.googleapis.com/v3"
+	DefaultMetricPrefix = "custom.googleapis.com/"
+	DefaultMaxRetries   = 3
+	DefaultRetryBackoff = 500 * time.Millisecond
+)
+
+// MaxTimeSeriesPerRequest is the maximum number of time series written by a
+// single timeSeries.create request.
+const MaxTimeSeriesPerRequest = 200
+
+// An Option configures a StackdriverSink.
+type Option interface {
+	apply(*StackdriverSink)
+}
+
+// optionFunc wraps a func so it satisfies the Option interface.
+type optionFunc func(*StackdriverSink)
+
+func (f optionFunc) apply(sink *StackdriverSink) {
+	f(sink)
+}
+
+// WithEndpoint sets the base URL of the Cloud Monitoring API, the default is
+// DefaultEndpoint.
+func WithEndpoint(url string) Option {
+	return optionFunc(func(sink *StackdriverSink) {
+		sink.endpoint = strings.TrimSuffix(url, "/")
+	})
+}
+
+// WithHTTPClient sets the client used to write the time series, it must
+// authorize its requests. By default an OAuth2 client is created from the
+// credentials set with WithCredentialsJSON or the Application Default
+// Credentials.
+func WithHTTPClient(client *http.Client) Option {
+	return optionFunc(func(sink *StackdriverSink) {
+		sink.client = client
+	})
+}
+
+// WithCredentialsJSON sets the JSON credentials used to authorize the
+// requests, instead of the Application Default Credentials. It accepts
+// service account keys as well as external account configurations, used by
+// workload identity federation.
+func WithCredentialsJSON(data []byte) Option {
+	return optionFunc(func(sink *StackdriverSink) {
+		sink.credentials = data
+	})
+}
+
+// WithMetricPrefix sets the prefix of the metric types, the default is
+// DefaultMetricPrefix.
+func WithMetricPrefix(prefix string) Option {
+	return optionFunc(func(sink *StackdriverSink) {
+		sink.prefix = prefix
+	})
+}
+
+// WithResource sets the monitored resource of the time series, the default is
+// the "global" resource of the project. Tags with the key of one of labels set
+// the value of that label for their stat.
+func WithResource(typ string, labels map[string]string) Option {
+	return optionFunc(func(sink *StackdriverSink) {
+		sink.resource = monitoredResource{Type: typ, Labels: labels}
+	})
+}
+
+// WithBuckets sets the exponential buckets of the Timer distributions: n
+// finite buckets, the first with the lower bound scale and each following one
+// growth times larger. The default is 32 buckets, a growth of 2 and a scale of
+// 1.
+func WithBuckets(n int, growth, scale float64) Option {
+	return optionFunc(func(sink *StackdriverSink) {
+		sink.buckets = exponentialBuckets{NumFiniteBuckets: n, GrowthFactor: growth, Scale: scale}
+	})
+}
+
+// WithRetries sets the number of times a failed request is retried, the
+// default is DefaultMaxRetries, and the delay before the first retry, the
+// default is DefaultRetryBackoff. The delay doubles after each retry.
+func WithRetries(n int, backoff time.Duration) Option {
+	return optionFunc(func(sink *StackdriverSink) {
+		sink.maxRetries = n
+		sink.backoff = backoff
+	})
+}
+
+// WithErrorHandler sets the function called with the errors of Flush, by
+// default they are ignored.
+func WithErrorHandler(fn func(error)) Option {
+	return optionFunc(func(sink *StackdriverSink) {
+		sink.onError = fn
+	})
+}
+
+// series is the state of a time series.
+type series struct {
+	metric   metric
+	resource monitoredResource
+	start    time.Time
+	dirty    bool // flushed since the last write
+
+	value int64 // total of Counters, last value of Gauges
+
+	// Timers only
+	count   int64
+	mean    float64
+	m2      float64 // sum of the squared deviations from mean
+	buckets []int64
+}
+
+// A StackdriverSink is a stats.Sink that aggregates the flushed stats and
+// writes them to Cloud Monitoring when it is flushed or synced. The metric
+// type of a stat is its name, with dots replaced by slashes, appended to the
+// metric prefix. Tags are written as metric labels or, if their key is a label
+// of the monitored resource, as resource labels.
+//
+// Counters are written as INT64 CUMULATIVE time series of their total since
+// the sink first flushed them, Gauges as INT64 GAUGE time series of their last
+// value and Timers as CUMULATIVE DISTRIBUTION time series with exponential
+// buckets. Only the stats flushed since the previous write are written.
+// Requests that fail with a network error or a 429, 500, 502, 503 or 504
+// status are retried with an exponential backoff.
+type StackdriverSink struct {
+	client      *http.Client
+	endpoint    string
+	project     string
+	credentials []byte
+	prefix      string
+	resource    monitoredResource
+	buckets     exponentialBuckets
+	maxRetries  int
+	backoff     time.Duration
+	onError     func(error)
+	now         func() time.Time
+
+	mu       sync.Mutex
+	counters map[string]*series
+	gauges   map[string]*series
+	timers   map[string]*series
+
+	writeMu sync.Mutex // serializes the writes
+}
+
+// NewStackdriverSink returns a StackdriverSink that writes time series to the
+// Cloud Monitoring project projectID. If projectID is empty the project of the
+// credentials is used.
+//
+// Unless WithHTTPClient or WithCredentialsJSON is used, the requests are
+// authorized with the Application Default Credentials: the
+// GOOGLE_APPLICATION_CREDENTIALS file, which may configure workload identity
+// federation, or the metadata server credentials of the GKE workload identity
+// or the Compute Engine service account. ctx is used to retrieve the
+// credentials and their tokens.
+func NewStackdriverSink(ctx context.Context, projectID string, opts ...Option) (*StackdriverSink, error) {
+	s := &StackdriverSink{
+		endpoint:   DefaultEndpoint,
+		project:    projectID,
+		prefix:     DefaultMetricPrefix,
+		buckets:    exponentialBuckets{NumFiniteBuckets: 32, GrowthFactor: 2, Scale: 1},
+		maxRetries: DefaultMaxRetries,
+		backoff:    DefaultRetryBackoff,
+		now:        time.Now,
+		counters:   make(map[string]*series),
+		gauges:     make(map[string]*series),
+		timers:     make(map[string]*series),
+	}
+	for _, opt := range opts {
+		opt.apply(s)
+	}
+	if s.backoff <= 0 {
+		s.backoff = DefaultRetryBackoff
+	}
+	if s.buckets.NumFiniteBuckets <= 0 || s.buckets.GrowthFactor <= 1 || s.buckets.Scale <= 0 {
+		return nil, errors.New("stackdriver: invalid bucket options")
+	}
+	if s.client == nil {
+		var creds *google.Credentials
+		var err error
+		if s.credentials != nil {
+			creds, err = google.CredentialsFromJSON(ctx, s.credentials, Scope)
+		} else {
+			creds, err = google.FindDefaultCredentials(ctx, Scope)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if s.project == "" {
+			s.project = creds.ProjectID
+		}
+		s.client = oauth2.NewClient(ctx, creds.TokenSource)
+	}
+	if s.project == "" {
+		return nil, errors.New("stackdriver: no project ID")
+	}
+	if s.resource.Type == "" {
+		s.resource = monitoredResource{
+			Type:   "global",
+			Labels: map[string]string{"project_id": s.project},
+		}
+	}
+	return s, nil
+}
+
+// get returns the series of stat in m, s.mu must be held.
+func (s *StackdriverSink) get(m map[string]*series, stat string) *series {
+	p := m[stat]
+	if p != nil {
+		return p
+	}
+	name, set := tags.ParseTagSet(stat)
+	p = &series{
+		metric:   metric{Type: s.prefix + strings.Replace(name, ".", "/", -1)},
+		resource: s.resource,
+		start:    s.now(),
+	}
+	copied := false
+	for _, t := range set {
+		if _, ok := s.resource.Labels[t.Key]; ok {
+			if !copied {
+				p.resource.Labels = make(map[string]string, len(s.resource.Labels))
+				for k, v := range s.resource.Labels {
+					p.resource.Labels[k] = v
+				}
+				copied = true
+			}
+			p.resource.Labels[t.Key] = t.Value
+			continue
+		}
+		if p.metric.Labels == nil {
+			p.metric.Labels = make(map[string]string, len(set))
+		}
+		p.metric.Labels[labelKey(t.Key)] = t.Value
+	}
+	m[stat] = p
+	return p
+}
+
+// FlushCounter implements the stats.Sink.FlushCounter method.
+func (s *StackdriverSink) FlushCounter(name string, value uint64) {
+	s.mu.Lock()
+	p := s.get(s.counters, name)
+	p.value += int64(value)
+	p.dirty = true
+	s.mu.Unlock()
+}
+
+// FlushGauge implements the stats.Sink.FlushGauge method.
+func (s *StackdriverSink) FlushGauge(name string, value uint64) {
+	s.mu.Lock()
+	p := s.get(s.gauges, name)
+	p.value = int64(value)
+	p.dirty = true
+	s.mu.Unlock()
+}
+
+// FlushTimer implements the stats.Sink.FlushTimer method.
+func (s *StackdriverSink) FlushTimer(name string, value float64) {
+	s.mu.Lock()
+	p := s.get(s.timers, name)
+	if p.buckets == nil {
+		p.buckets = make([]int64, s.buckets.NumFiniteBuckets+2)
+	}
+	p.count++
+	delta := value - p.mean
+	p.mean += delta / float64(p.count)
+	p.m2 += delta * (value - p.mean)
+	p.buckets[s.buckets.index(value)]++
+	p.dirty = true
+	s.mu.Unlock()
+}
+
+// Flush implements the stats.FlushableSink.Flush method and writes the stats,
+// its error is passed to the function set with WithErrorHandler.
+func (s *StackdriverSink) Flush() {
+	if err := s.Sync(); err != nil && s.onError != nil {
+		s.onError(err)
+	}
+}
+
+// Sync implements the stats.Syncer interface. It writes the stats flushed
+// since the previous write and returns the first error. The time series of
+// the failed requests are written again by the next write.
+func (s *StackdriverSink) Sync() error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	end := s.now()
+	var written []*series
+	var batch []timeSeries
+
+	s.mu.Lock()
+	for _, p := range dirtySeries(s.counters) {
+		batch = append(batch, s.cumulative(p, end, typedValue{Int64Value: strconv.FormatInt(p.value, 10)}, "INT64"))
+		written = append(written, p)
+	}
+	for _, p := range dirtySeries(s.gauges) {
+		batch = append(batch, timeSeries{
+			Metric:     p.metric,
+			Resource:   p.resource,
+			MetricKind: "GAUGE",
+			ValueType:  "INT64",
+			Points: []point{{
+				Interval: interval{EndTime: timestamp(end)},
+				Value:    typedValue{Int64Value: strconv.FormatInt(p.value, 10)},
+			}},
+		})
+		written = append(written, p)
+	}
+	for _, p := range dirtySeries(s.timers) {
+		counts := make([]string, len(p.buckets))
+		for i, n := range p.buckets {
+			counts[i] = strconv.FormatInt(n, 10)
+		}
+		batch = append(batch, s.cumulative(p, end, typedValue{Distribution: &distribution{
+			Count:                 strconv.FormatInt(p.count, 10),
+			Mean:                  p.mean,
+			SumOfSquaredDeviation: p.m2,
+			BucketOptions:         bucketOptions{ExponentialBuckets: s.buckets},
+			BucketCounts:          counts,
+		}}, "DISTRIBUTION"))
+		written = append(written, p)
+	}
+	for _, p := range written {
+		p.dirty = false
+	}
+	s.mu.Unlock()
+
+	var first error
+	for len(batch) != 0 {
+		n := len(batch)
+		if n > MaxTimeSeriesPerRequest {
+			n = MaxTimeSeriesPerRequest
+		}
+		if err := s.write(context.Background(), batch[:n]); err != nil {
+			if first == nil {
+				first = err
+			}
+			s.mu.Lock()
+			for _, p := range written[:n] {
+				p.dirty = true
+			}
+			s.mu.Unlock()
+		}
+		batch, written = batch[n:], written[n:]
+	}
+	return first
+}
+
+func (s *StackdriverSink) cumulative(p *series, end time.Time, v typedValue, valueType string) timeSeries {
+	if !end.After(p.start) {
+		end = p.start.Add(time.Millisecond)
+	}
+	return timeSeries{
+		Metric:     p.metric,
+		Resource:   p.resource,
+		MetricKind: "CUMULATIVE",
+		ValueType:  valueType,
+		Points: []point{{
+			Interval: interval{StartTime: timestamp(p.start), EndTime: timestamp(end)},
+			Value:    v,
+		}},
+	}
+}
+
+// write creates the time series ts, retrying the failed requests, and returns
+// the error of the last attempt.
+func (s *StackdriverSink) write(ctx context.Context, ts []timeSeries) error {
+	body, err := json.Marshal(createRequest{TimeSeries: ts})
+	if err != nil {
+		return err
+	}
+	u := s.endpoint + "/projects/" + url.PathEscape(s.project) + "/timeSeries"
+
+	backoff := s.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := s.post(ctx, u, body)
+		if err == nil || !retry || attempt >= s.maxRetries {
+			return err
+		}
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// post POSTs body to u once and reports if a failure can be retried.
+func (s *StackdriverSink) post(ctx context.Context, u string, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return retryable(resp.StatusCode), fmt.Errorf("stackdriver: write to %s failed: %s: %s",
+			u, resp.Status, bytes.TrimSpace(msg))
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	return false, nil
+}
+
+func retryable(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// index returns the index of the bucket of value, 0 is the underflow bucket
+// and NumFiniteBuckets+1 the overflow bucket.
+func (b exponentialBuckets) index(value float64) int {
+	if value < b.Scale {
+		return 0
+	}
+	i := int(math.Floor(math.Log(value/b.Scale)/math.Log(b.GrowthFactor))) + 1
+	// correct the rounding errors of the logarithms at the bucket bounds
+	if value >= b.Scale*math.Pow(b.GrowthFactor, float64(i)) {
+		i++
+	} else if i > 1 && value < b.Scale*math.Pow(b.GrowthFactor, float64(i-1)) {
+		i--
+	}
+	if i > b.NumFiniteBuckets {
+		return b.NumFiniteBuckets + 1
+	}
+	return i
+}
+
+// labelKey replaces the characters that are not valid in a label key with
+// underscores.
+func labelKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+func timestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func dirtySeries(m map[string]*series) []*series {
+	keys := make([]string, 0, len(m))
+	for k, p := range m {
+		if p.dirty {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	a := make([]*series, len(keys))
+	for i, k := range keys {
+		a[i] = m[k]
+	}
+	return a
+}
@@ -0,0 +1,211 @@
+package stackdriver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	stats "github.com/lyft/gostats"
+)
+
+var (
+	_ stats.FlushableSink = (*StackdriverSink)(nil)
+	_ stats.Syncer        = (*StackdriverSink)(nil)
+)
+
+type testServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []createRequest
+	paths    []string
+	codes    []int // status codes of the next requests
+}
+
+func newTestServer(t *testing.T) *testServer {
+	ts := &testServer{}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req createRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		ts.mu.Lock()
+		defer ts.mu.Unlock()
+		ts.requests = append(ts.requests, req)
+		ts.paths = append(ts.paths, r.URL.Path)
+		if len(ts.codes) != 0 {
+			code := ts.codes[0]
+			ts.codes = ts.codes[1:]
+			w.WriteHeader(code)
+			return
+		}
+		w.Write([]byte("{}"))
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func (ts *testServer) Requests() []createRequest {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	reqs := ts.requests
+	ts.requests = nil
+	return reqs
+}
+
+func newTestSink(t *testing.T, ts *testServer, opts ...Option) *StackdriverSink {
+	t.Helper()
+	opts = append([]Option{WithEndpoint(ts.URL), WithHTTPClient(ts.Client()),
+		WithRetries(2, time.Millisecond)}, opts...)
+	sink, err := NewStackdriverSink(context.Background(), "proj", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sink
+}
+
+func TestStackdriverSink(t *testing.T) {
+	ts := newTestServer(t)
+	sink := newTestSink(t, ts)
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	now := start
+	sink.now = func() time.Time { return now }
+
+	sink.FlushCounter("svc.requests.__code=200", 2)
+	sink.FlushGauge("svc.conns", 4)
+	sink.FlushTimer("svc.latency", 0.5)
+	sink.FlushTimer("svc.latency", 3)
+	sink.FlushTimer("svc.latency", 5)
+	now = now.Add(time.Minute)
+	sink.FlushCounter("svc.requests.__code=200", 3)
+	sink.FlushGauge("svc.conns", 6)
+	if err := sink.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	reqs := ts.Requests()
+	if len(reqs) != 1 || ts.paths[0] != "/projects/proj/timeSeries" {
+		t.Fatalf("requests: got: %d %v want: 1 to /projects/proj/timeSeries", len(reqs), ts.paths)
+	}
+	series := reqs[0].TimeSeries
+	if len(series) != 3 {
+		t.Fatalf("time series: got: %d want: %d", len(series), 3)
+	}
+
+	c := series[0]
+	if c.Metric.Type != "custom.googleapis.com/svc/requests" || c.Metric.Labels["code"] != "200" ||
+		c.MetricKind != "CUMULATIVE" || c.ValueType != "INT64" || c.Points[0].Value.Int64Value != "5" {
+		t.Errorf("counter: got: %+v", c)
+	}
+	if c.Resource.Type != "global" || c.Resource.Labels["project_id"] != "proj" {
+		t.Errorf("resource: got: %+v", c.Resource)
+	}
+	if iv := c.Points[0].Interval; iv.StartTime != timestamp(start) || iv.EndTime != timestamp(now) {
+		t.Errorf("counter interval: got: %+v", iv)
+	}
+
+	g := series[1]
+	if g.MetricKind != "GAUGE" || g.Points[0].Value.Int64Value != "6" || g.Points[0].Interval.StartTime != "" {
+		t.Errorf("gauge: got: %+v", g)
+	}
+
+	d := series[2]
+	dist := d.Points[0].Value.Distribution
+	if d.MetricKind != "CUMULATIVE" || d.ValueType != "DISTRIBUTION" || dist == nil {
+		t.Fatalf("timer: got: %+v", d)
+	}
+	// mean 2.83, squared deviations 5.44 + 0.03 + 4.69
+	if dist.Count != "3" || dist.Mean < 2.83 || dist.Mean > 2.84 ||
+		dist.SumOfSquaredDeviation < 10.16 || dist.SumOfSquaredDeviation > 10.17 {
+		t.Errorf("distribution: got: %+v", dist)
+	}
+	// 0.5 underflows, 3 is in [2,4) and 5 in [4,8)
+	counts := dist.BucketCounts
+	if len(counts) != 34 || counts[0] != "1" || counts[2] != "1" || counts[3] != "1" {
+		t.Errorf("bucket counts: got: %v", counts)
+	}
+
+	// only the flushed stats are written and Counters stay cumulative
+	now = now.Add(time.Minute)
+	sink.FlushCounter("svc.requests.__code=200", 1)
+	if err := sink.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	reqs = ts.Requests()
+	if len(reqs) != 1 || len(reqs[0].TimeSeries) != 1 ||
+		reqs[0].TimeSeries[0].Points[0].Value.Int64Value != "6" {
+		t.Errorf("second write: got: %+v", reqs)
+	}
+}
+
+func TestStackdriverSinkResourceLabels(t *testing.T) {
+	ts := newTestServer(t)
+	sink := newTestSink(t, ts, WithMetricPrefix("custom.googleapis.com/app/"),
+		WithResource("k8s_container", map[string]string{
+			"project_id":     "proj",
+			"namespace_name": "default",
+			"pod_name":       "",
+		}))
+	sink.FlushGauge("g.__pod_name=web-1.__http.method=GET", 1)
+	sink.FlushGauge("h", 1)
+	sink.Flush()
+
+	series := ts.Requests()[0].TimeSeries
+	g, h := series[0], series[1]
+	if g.Metric.Type != "custom.googleapis.com/app/g" || g.Resource.Type != "k8s_container" ||
+		g.Resource.Labels["pod_name"] != "web-1" || g.Resource.Labels["namespace_name"] != "default" {
+		t.Errorf("resource: got: %+v", g)
+	}
+	if len(g.Metric.Labels) != 1 || g.Metric.Labels["http_method"] != "GET" {
+		t.Errorf("metric labels: got: %v", g.Metric.Labels)
+	}
+	if h.Resource.Labels["pod_name"] != "" {
+		t.Errorf("resource labels are shared: got: %v", h.Resource.Labels)
+	}
+}
+
+func TestStackdriverSinkRetry(t *testing.T) {
+	ts := newTestServer(t)
+	var errs []error
+	sink := newTestSink(t, ts, WithErrorHandler(func(err error) { errs = append(errs, err) }))
+
+	ts.codes = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}
+	sink.FlushCounter("c", 1)
+	if err := sink.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(ts.Requests()); n != 3 {
+		t.Errorf("requests: got: %d want: %d", n, 3)
+	}
+
+	// client errors are not retried and the series are written again
+	ts.codes = []int{http.StatusBadRequest}
+	sink.FlushCounter("c", 1)
+	sink.Flush()
+	if n := len(ts.Requests()); n != 1 || len(errs) != 1 {
+		t.Errorf("requests: got: %d errors: %v want: 1 request and 1 error", n, errs)
+	}
+	sink.Flush()
+	reqs := ts.Requests()
+	if len(reqs) != 1 || reqs[0].TimeSeries[0].Points[0].Value.Int64Value != "2" {
+		t.Errorf("rewrite: got: %+v", reqs)
+	}
+}
+
+func TestExponentialBuckets(t *testing.T) {
+	b := exponentialBuckets{NumFiniteBuckets: 3, GrowthFactor: 10, Scale: 1}
+	tests := []struct {
+		v   float64
+		exp int
+	}{
+		{0, 0}, {0.9, 0}, {1, 1}, {9, 1}, {10, 2}, {999, 3}, {1000, 4}, {1e9, 4},
+	}
+	for _, x := range tests {
+		if i := b.index(x.v); i != x.exp {
+			t.Errorf("index(%g): got: %d want: %d", x.v, i, x.exp)
+		}
+	}
+}
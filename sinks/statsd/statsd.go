@@ -0,0 +1,279 @@
+// Package statsd provides a stats.Sink that writes stats to a StatsD server
+// over UDP or a Unix domain socket.
+//
+// Unlike the sink returned by stats.NewNetSink, which writes stat names with
+// their tags in the gostats serialization (name.__key=value) for the Lyft
+// statsrelay and is configured from the environment, StatsdSink serializes
+// tags for StatsD servers that do not understand that format: as DogStatsD
+// tags or as Graphite name segments.
+package statsd
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lyft/gostats/internal/tags"
+)
+
+const (
+	// DefaultAddress is the default address of the StatsD server.
+	DefaultAddress = "localhost:8125"
+
+	// DefaultMaxDatagramSize is the default maximum size of a datagram. 1432
+	// bytes is optimal for networks with an MTU of 1500 and prevents the
+	// fragmentation of UDP datagrams.
+	DefaultMaxDatagramSize = 1432
+
+	// DefaultFlushInterval is the default interval at which buffered stats
+	// are written.
+	DefaultFlushInterval = time.Second
+)
+
+// A TagStyle determines how tags are serialized.
+type TagStyle int
+
+const (
+	// TagStyleDogStatsD appends tags in the DogStatsD format:
+	//  name:1|c|#key1:value1,key2:value2
+	TagStyleDogStatsD TagStyle = iota
+
+	// TagStyleGraphite appends tags to the stat name as hierarchical
+	// segments:
+	//  name.key1.value1.key2.value2:1|c
+	TagStyleGraphite
+)
+
+// An Option configures a StatsdSink.
+type Option interface {
+	apply(*StatsdSink)
+}
+
+// optionFunc wraps a func so it satisfies the Option interface.
+type optionFunc func(*StatsdSink)
+
+func (f optionFunc) apply(sink *StatsdSink) {
+	f(sink)
+}
+
+// WithAddress sets the address of the StatsD server, the default is
+// DefaultAddress. The transport is selected by the scheme of addr:
+// "udp://host:port" and "host:port" write UDP datagrams and "unix:///path"
+// writes datagrams to the Unix domain socket at path, which is useful when the
+// StatsD agent runs on the same host.
+func WithAddress(addr string) Option {
+	return optionFunc(func(sink *StatsdSink) {
+		sink.addr = addr
+	})
+}
+
+// WithMaxDatagramSize sets the maximum size of the datagrams written to the
+// StatsD server, the default is DefaultMaxDatagramSize.
+func WithMaxDatagramSize(size int) Option {
+	return optionFunc(func(sink *StatsdSink) {
+		sink.maxSize = size
+	})
+}
+
+// WithFlushInterval sets the interval at which buffered stats are written,
+// the default is DefaultFlushInterval.
+func WithFlushInterval(d time.Duration) Option {
+	return optionFunc(func(sink *StatsdSink) {
+		sink.interval = d
+	})
+}
+
+// WithTagStyle sets how tags are serialized, the default is
+// TagStyleDogStatsD.
+func WithTagStyle(style TagStyle) Option {
+	return optionFunc(func(sink *StatsdSink) {
+		sink.style = style
+	})
+}
+
+// A StatsdSink is a stats.FlushableSink that buffers stats and writes them to
+// a StatsD server in UDP or Unix domain socket datagrams. Stats are written when the buffer is
+// full, at every flush interval and when Flush is called.
+type StatsdSink struct {
+	addr     string
+	maxSize  int
+	interval time.Duration
+	style    TagStyle
+
+	mu   sync.Mutex
+	conn net.Conn
+	buf  []byte
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// NewStatsdSink returns a new StatsdSink configured with opts. An error is
+// returned if the address of the StatsD server cannot be resolved or, for a
+// Unix domain socket, if the socket does not exist.
+func NewStatsdSink(opts ...Option) (*StatsdSink, error) {
+	s := &StatsdSink{
+		addr:     DefaultAddress,
+		maxSize:  DefaultMaxDatagramSize,
+		interval: DefaultFlushInterval,
+		style:    TagStyleDogStatsD,
+		done:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt.apply(s)
+	}
+	if s.maxSize <= 0 {
+		s.maxSize = DefaultMaxDatagramSize
+	}
+	if s.interval <= 0 {
+		s.interval = DefaultFlushInterval
+	}
+
+	network, addr, err := parseAddress(s.addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	s.conn = conn
+	s.buf = make([]byte, 0, s.maxSize)
+
+	s.wg.Add(1)
+	go s.run()
+	return s, nil
+}
+
+// parseAddress returns the network and address to dial for the sink address
+// addr.
+func parseAddress(addr string) (network, address string, err error) {
+	i := strings.Index(addr, "://")
+	if i == -1 {
+		return "udp", addr, nil
+	}
+	scheme, rest := addr[:i], addr[i+len("://"):]
+	switch scheme {
+	case "udp":
+		return "udp", rest, nil
+	case "unix":
+		return "unixgram", rest, nil
+	}
+	return "", "", fmt.Errorf("statsd: unsupported address scheme: %q", scheme)
+}
+
+func (s *StatsdSink) run() {
+	defer s.wg.Done()
+	t := time.NewTicker(s.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			s.Flush()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Close flushes any buffered stats and closes the connection to the StatsD
+// server. The StatsdSink must not be used after Close is called, calling
+// Close again has no effect and returns nil.
+func (s *StatsdSink) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.wg.Wait()
+		s.Flush()
+		err = s.conn.Close()
+	})
+	return err
+}
+
+// Flush writes any buffered stats to the StatsD server.
+func (s *StatsdSink) Flush() {
+	s.mu.Lock()
+	s.writeBuffer()
+	s.mu.Unlock()
+}
+
+// writeBuffer writes the buffer to the connection. Errors are ignored since
+// datagrams are lossy anyway. s.mu must be held.
+func (s *StatsdSink) writeBuffer() {
+	if len(s.buf) != 0 {
+		_, _ = s.conn.Write(s.buf)
+		s.buf = s.buf[:0]
+	}
+}
+
+// statLen returns the length of the line of a stat with name, tags, value
+// and typ.
+func (s *StatsdSink) statLen(name string, set tags.TagSet, value []byte, typ string) int {
+	n := len(name) + 1 + len(value) + 1 + len(typ) + 1
+	for _, t := range set {
+		// ".key.value" or "key:value," in DogStatsD
+		n += len(t.Key) + 1 + len(t.Value) + 1
+	}
+	if s.style != TagStyleGraphite && len(set) != 0 {
+		n++ // "|#" without the trailing ','
+	}
+	return n
+}
+
+func (s *StatsdSink) write(stat string, value []byte, typ string) {
+	name, set := tags.ParseTagSet(stat)
+	n := s.statLen(name, set, value, typ)
+
+	s.mu.Lock()
+	if len(s.buf)+n > s.maxSize {
+		s.writeBuffer()
+	}
+	s.buf = append(s.buf, name...)
+	if s.style == TagStyleGraphite {
+		for _, t := range set {
+			s.buf = append(s.buf, '.')
+			s.buf = append(s.buf, t.Key...)
+			s.buf = append(s.buf, '.')
+			s.buf = append(s.buf, t.Value...)
+		}
+	}
+	s.buf = append(s.buf, ':')
+	s.buf = append(s.buf, value...)
+	s.buf = append(s.buf, '|')
+	s.buf = append(s.buf, typ...)
+	if s.style != TagStyleGraphite && len(set) != 0 {
+		s.buf = append(s.buf, "|#"...)
+		for i, t := range set {
+			if i != 0 {
+				s.buf = append(s.buf, ',')
+			}
+			s.buf = append(s.buf, t.Key...)
+			s.buf = append(s.buf, ':')
+			s.buf = append(s.buf, t.Value...)
+		}
+	}
+	s.buf = append(s.buf, '\n')
+	s.mu.Unlock()
+}
+
+// FlushCounter implements the stats.Sink.FlushCounter method.
+func (s *StatsdSink) FlushCounter(name string, value uint64) {
+	var b [20]byte
+	s.write(name, strconv.AppendUint(b[:0], value, 10), "c")
+}
+
+// FlushGauge implements the stats.Sink.FlushGauge method.
+func (s *StatsdSink) FlushGauge(name string, value uint64) {
+	var b [20]byte
+	s.write(name, strconv.AppendUint(b[:0], value, 10), "g")
+}
+
+// FlushTimer implements the stats.Sink.FlushTimer method.
+func (s *StatsdSink) FlushTimer(name string, value float64) {
+	var b [32]byte
+	s.write(name, strconv.AppendFloat(b[:0], value, 'f', -1, 64), "ms")
+}
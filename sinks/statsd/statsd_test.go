@@ -0,0 +1,220 @@
+package statsd
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	stats "github.com/lyft/gostats"
+	"github.com/lyft/gostats/internal/tags"
+)
+
+var _ stats.FlushableSink = (*StatsdSink)(nil)
+
+func listen(t *testing.T) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func readDatagram(t *testing.T, conn *net.UDPConn) string {
+	t.Helper()
+	buf := make([]byte, 64*1024)
+	if err := conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestStatsdSink_WireFormat(t *testing.T) {
+	tests := []struct {
+		style    TagStyle
+		expected string
+	}{
+		{
+			style: TagStyleDogStatsD,
+			expected: "timer:1.5|ms|#key1:val1\n" +
+				"counter:1|c|#key1:val1,key2:val2\n" +
+				"gauge:2|g\n",
+		},
+		{
+			style: TagStyleGraphite,
+			expected: "timer.key1.val1:1.5|ms\n" +
+				"counter.key1.val1.key2.val2:1|c\n" +
+				"gauge:2|g\n",
+		},
+	}
+	for _, x := range tests {
+		conn := listen(t)
+		sink, err := NewStatsdSink(
+			WithAddress(conn.LocalAddr().String()),
+			WithTagStyle(x.style),
+			WithFlushInterval(time.Hour),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// timers are written immediately, counters and gauges on Flush
+		store := stats.NewStore(sink, false)
+		store.NewCounterWithTags("counter", map[string]string{"key1": "val1", "key2": "val2"}).Inc()
+		store.NewGauge("gauge").Set(2)
+		store.NewTimerWithTags("timer", map[string]string{"key1": "val1"}).AddValue(1.5)
+		store.Flush()
+
+		if s := readDatagram(t, conn); s != x.expected {
+			t.Errorf("TagStyle(%d): got: %q want: %q", x.style, s, x.expected)
+		}
+		sink.Close()
+		conn.Close()
+	}
+}
+
+func TestStatsdSink_StatLen(t *testing.T) {
+	for _, style := range []TagStyle{TagStyleDogStatsD, TagStyleGraphite} {
+		for _, stat := range []string{"c", "c.__k=v", "c.__k1=v1.__key2=value2"} {
+			sink := &StatsdSink{style: style, maxSize: DefaultMaxDatagramSize}
+			sink.write(stat, []byte("1"), "c")
+			name, set := tags.ParseTagSet(stat)
+			if n := sink.statLen(name, set, []byte("1"), "c"); n != len(sink.buf) {
+				t.Errorf("TagStyle(%d) %q: got: %d want: %d (%q)", style, stat, n, len(sink.buf), sink.buf)
+			}
+		}
+	}
+}
+
+func TestStatsdSink_CloseTwice(t *testing.T) {
+	conn := listen(t)
+	defer conn.Close()
+
+	sink, err := NewStatsdSink(WithAddress(conn.LocalAddr().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Errorf("second Close: %s", err)
+	}
+}
+
+func TestStatsdSink_MaxDatagramSize(t *testing.T) {
+	conn := listen(t)
+	defer conn.Close()
+
+	const line = "counter_000:1|c\n"
+	sink, err := NewStatsdSink(
+		WithAddress(conn.LocalAddr().String()),
+		WithMaxDatagramSize(len(line)*2),
+		WithFlushInterval(time.Hour),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	for i := 0; i < 3; i++ {
+		sink.FlushCounter("counter_000", 1)
+	}
+	if s := readDatagram(t, conn); s != strings.Repeat(line, 2) {
+		t.Errorf("got: %q want: %q", s, strings.Repeat(line, 2))
+	}
+	sink.Flush()
+	if s := readDatagram(t, conn); s != line {
+		t.Errorf("got: %q want: %q", s, line)
+	}
+}
+
+func TestStatsdSink_FlushInterval(t *testing.T) {
+	conn := listen(t)
+	defer conn.Close()
+
+	sink, err := NewStatsdSink(
+		WithAddress(conn.LocalAddr().String()),
+		WithFlushInterval(time.Millisecond*10),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	sink.FlushGauge("gauge", 1)
+	if s := readDatagram(t, conn); s != "gauge:1|g\n" {
+		t.Errorf("got: %q want: %q", s, "gauge:1|g\n")
+	}
+}
+
+func TestStatsdSink_UnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gostats-statsd-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "statsd.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("unixgram sockets are not supported: %s", err)
+	}
+	defer conn.Close()
+
+	sink, err := NewStatsdSink(
+		WithAddress("unix://"+path),
+		WithFlushInterval(time.Hour),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	sink.FlushCounter("counter", 1)
+	sink.FlushGauge("gauge", 2)
+	sink.Flush()
+
+	buf := make([]byte, 64*1024)
+	if err := conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(buf[:n]); s != "counter:1|c\ngauge:2|g\n" {
+		t.Errorf("got: %q want: %q", s, "counter:1|c\ngauge:2|g\n")
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		addr    string
+		network string
+		address string
+	}{
+		{"localhost:8125", "udp", "localhost:8125"},
+		{"udp://127.0.0.1:8125", "udp", "127.0.0.1:8125"},
+		{"unix:///var/run/statsd.sock", "unixgram", "/var/run/statsd.sock"},
+	}
+	for _, x := range tests {
+		network, address, err := parseAddress(x.addr)
+		if err != nil {
+			t.Errorf("%q: %s", x.addr, err)
+			continue
+		}
+		if network != x.network || address != x.address {
+			t.Errorf("%q: got: %q %q want: %q %q", x.addr, network, address, x.network, x.address)
+		}
+	}
+	if _, err := NewStatsdSink(WithAddress("tcp://localhost:8125")); err == nil {
+		t.Error("expected an error for an unsupported scheme")
+	}
+}
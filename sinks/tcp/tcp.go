@@ -0,0 +1,426 @@
+// Package tcp provides a stats.Sink that writes stats to a TCP stats
+// receiver, such as a Carbon relay or a custom aggregator, over a pool of
+// persistent connections.
+package tcp
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lyft/gostats/internal/tags"
+)
+
+const (
+	// DefaultPoolSize is the default number of connections of a TCPSink.
+	DefaultPoolSize = 4
+
+	// DefaultMinBackoff is the default delay before the first reconnect
+	// attempt of a connection.
+	DefaultMinBackoff = 100 * time.Millisecond
+
+	// DefaultMaxBackoff is the default maximum delay between reconnect
+	// attempts of a connection.
+	DefaultMaxBackoff = 30 * time.Second
+
+	// DefaultWriteTimeout is the default timeout of the writes to a
+	// connection.
+	DefaultWriteTimeout = time.Second
+
+	// DefaultQueueSize is the default number of lines queued per connection.
+	DefaultQueueSize = 4096
+
+	flushInterval = time.Second
+	dialTimeout   = time.Second
+)
+
+// A Format is the line format of the stats written by a TCPSink.
+type Format int
+
+const (
+	// FormatCarbon writes the stats in the Graphite plaintext protocol:
+	//
+	//	name.key1=value1.key2=value2 <value> <timestamp>
+	FormatCarbon Format = iota
+
+	// FormatStatsd writes the stats in the statsd protocol, with the tags
+	// serialized in the stat name like the default net sink:
+	//
+	//	name.__key1=value1:<value>|c
+	FormatStatsd
+)
+
+// An Option configures a TCPSink.
+type Option interface {
+	apply(*TCPSink)
+}
+
+// optionFunc wraps a func so it satisfies the Option interface.
+type optionFunc func(*TCPSink)
+
+func (f optionFunc) apply(sink *TCPSink) {
+	f(sink)
+}
+
+// WithPoolSize sets the number of connections, the default is
+// DefaultPoolSize.
+func WithPoolSize(n int) Option {
+	return optionFunc(func(sink *TCPSink) {
+		sink.poolSize = n
+	})
+}
+
+// WithBackoff sets the minimum and maximum delay between reconnect attempts.
+// The delay doubles after every failed attempt.
+func WithBackoff(min, max time.Duration) Option {
+	return optionFunc(func(sink *TCPSink) {
+		sink.minBackoff = min
+		sink.maxBackoff = max
+	})
+}
+
+// WithWriteTimeout sets the timeout of the writes, a connection whose write
+// times out is closed and reconnected. The default is DefaultWriteTimeout.
+func WithWriteTimeout(d time.Duration) Option {
+	return optionFunc(func(sink *TCPSink) {
+		sink.writeTimeout = d
+	})
+}
+
+// WithQueueSize sets the number of lines queued per connection, the default
+// is DefaultQueueSize.
+func WithQueueSize(n int) Option {
+	return optionFunc(func(sink *TCPSink) {
+		sink.queueSize = n
+	})
+}
+
+// WithFormat sets the line format, the default is FormatCarbon.
+func WithFormat(f Format) Option {
+	return optionFunc(func(sink *TCPSink) {
+		sink.format = f
+	})
+}
+
+// A TCPSink is a stats.FlushableSink that writes stats to a TCP receiver
+// over a pool of persistent connections. Lines are distributed over the
+// connected connections in round-robin order. A connection that fails is
+// reconnected in the background with an exponential backoff.
+//
+// Lines are dropped when no connection is connected or all the queues are
+// full, and the lines buffered by a connection are dropped when it fails.
+// Dropped returns the number of dropped lines.
+type TCPSink struct {
+	addr         string
+	poolSize     int
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+	writeTimeout time.Duration
+	queueSize    int
+	format       Format
+	now          func() time.Time
+
+	names   sync.Map // stat name => formatted name
+	next    uint32   // atomic, index of the next connection
+	dropped uint64   // atomic
+
+	conns []*conn
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// NewTCPSink returns a new TCPSink that writes to the "host:port" address
+// addr. The connections are established in the background.
+func NewTCPSink(addr string, opts ...Option) *TCPSink {
+	s := &TCPSink{
+		addr:         addr,
+		poolSize:     DefaultPoolSize,
+		minBackoff:   DefaultMinBackoff,
+		maxBackoff:   DefaultMaxBackoff,
+		writeTimeout: DefaultWriteTimeout,
+		queueSize:    DefaultQueueSize,
+		now:          time.Now,
+		done:         make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt.apply(s)
+	}
+	if s.poolSize <= 0 {
+		s.poolSize = DefaultPoolSize
+	}
+	if s.minBackoff <= 0 {
+		s.minBackoff = DefaultMinBackoff
+	}
+	if s.maxBackoff < s.minBackoff {
+		s.maxBackoff = s.minBackoff
+	}
+	if s.writeTimeout <= 0 {
+		s.writeTimeout = DefaultWriteTimeout
+	}
+	if s.queueSize <= 0 {
+		s.queueSize = DefaultQueueSize
+	}
+
+	s.conns = make([]*conn, s.poolSize)
+	for i := range s.conns {
+		c := &conn{
+			sink:   s,
+			lines:  make(chan []byte, s.queueSize),
+			flushc: make(chan chan struct{}, 8),
+		}
+		s.conns[i] = c
+		s.wg.Add(1)
+		go c.run()
+	}
+	return s
+}
+
+// Dropped returns the number of lines that have been dropped.
+func (s *TCPSink) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Connected returns the number of connected connections.
+func (s *TCPSink) Connected() int {
+	n := 0
+	for _, c := range s.conns {
+		if atomic.LoadUint32(&c.connected) == 1 {
+			n++
+		}
+	}
+	return n
+}
+
+// Flush blocks until the queued lines are written to the connections.
+// Disconnected connections are not waited for.
+func (s *TCPSink) Flush() {
+	chans := make([]chan struct{}, 0, len(s.conns))
+	for _, c := range s.conns {
+		ch := make(chan struct{})
+		select {
+		case c.flushc <- ch:
+			chans = append(chans, ch)
+		case <-s.done:
+			return
+		}
+	}
+	for _, ch := range chans {
+		<-ch
+	}
+}
+
+// Close writes any queued lines, on the connected connections, and closes
+// the connections. The TCPSink must not be used after Close is called.
+func (s *TCPSink) Close() error {
+	close(s.done)
+	s.wg.Wait()
+	return nil
+}
+
+// send queues line on the next connected connection with room in its queue.
+func (s *TCPSink) send(line []byte) {
+	n := uint32(len(s.conns))
+	start := atomic.AddUint32(&s.next, 1)
+	for i := uint32(0); i < n; i++ {
+		c := s.conns[(start+i)%n]
+		if atomic.LoadUint32(&c.connected) == 0 {
+			continue
+		}
+		select {
+		case c.lines <- line:
+			return
+		default:
+		}
+	}
+	atomic.AddUint64(&s.dropped, 1)
+}
+
+func (s *TCPSink) name(stat string) string {
+	if v, ok := s.names.Load(stat); ok {
+		return v.(string)
+	}
+	var name string
+	switch s.format {
+	case FormatStatsd:
+		name = stat
+	default:
+		base, set := tags.ParseTagSet(stat)
+		b := []byte(base)
+		for _, t := range set {
+			b = append(b, '.')
+			b = append(b, t.Key...)
+			b = append(b, '=')
+			b = append(b, t.Value...)
+		}
+		name = string(b)
+	}
+	v, _ := s.names.LoadOrStore(stat, name)
+	return v.(string)
+}
+
+// writeLine formats and sends the line of stat, suffix is the statsd type.
+func (s *TCPSink) writeLine(stat string, value []byte, suffix string) {
+	name := s.name(stat)
+	b := make([]byte, 0, len(name)+len(value)+24)
+	b = append(b, name...)
+	switch s.format {
+	case FormatStatsd:
+		b = append(b, ':')
+		b = append(b, value...)
+		b = append(b, suffix...)
+	default:
+		b = append(b, ' ')
+		b = append(b, value...)
+		b = append(b, ' ')
+		b = strconv.AppendInt(b, s.now().Unix(), 10)
+	}
+	b = append(b, '\n')
+	s.send(b)
+}
+
+// FlushCounter implements the stats.Sink.FlushCounter method.
+func (s *TCPSink) FlushCounter(name string, value uint64) {
+	var b [20]byte
+	s.writeLine(name, strconv.AppendUint(b[:0], value, 10), "|c")
+}
+
+// FlushGauge implements the stats.Sink.FlushGauge method.
+func (s *TCPSink) FlushGauge(name string, value uint64) {
+	var b [20]byte
+	s.writeLine(name, strconv.AppendUint(b[:0], value, 10), "|g")
+}
+
+// FlushTimer implements the stats.Sink.FlushTimer method.
+func (s *TCPSink) FlushTimer(name string, value float64) {
+	var b [32]byte
+	s.writeLine(name, strconv.AppendFloat(b[:0], value, 'f', -1, 64), "|ms")
+}
+
+// A conn is a connection of the pool, its lines are written by run.
+type conn struct {
+	sink      *TCPSink
+	connected uint32 // atomic
+	buffered  int    // lines buffered by the writer, only accessed by run()
+
+	lines  chan []byte
+	flushc chan chan struct{}
+}
+
+func (c *conn) run() {
+	s := c.sink
+	defer s.wg.Done()
+
+	var nc net.Conn
+	var w *bufio.Writer
+	backoff := s.minBackoff
+
+	t := time.NewTicker(flushInterval)
+	defer t.Stop()
+
+	for {
+		if nc == nil {
+			cn, err := net.DialTimeout("tcp", s.addr, dialTimeout)
+			if err != nil {
+				if !c.wait(backoff) {
+					return
+				}
+				if backoff *= 2; backoff > s.maxBackoff {
+					backoff = s.maxBackoff
+				}
+				continue
+			}
+			nc, backoff = cn, s.minBackoff
+			w = bufio.NewWriter(&deadlineWriter{cn, s.writeTimeout})
+			c.buffered = 0
+			atomic.StoreUint32(&c.connected, 1)
+		}
+
+		var err error
+		select {
+		case line := <-c.lines:
+			err = c.write(w, line)
+		case <-t.C:
+			err = c.flush(w)
+		case ch := <-c.flushc:
+			err = c.drain(w)
+			close(ch)
+		case <-s.done:
+			c.drain(w)
+			nc.Close()
+			return
+		}
+		if err != nil {
+			atomic.StoreUint32(&c.connected, 0)
+			nc.Close()
+			nc = nil
+			// the queued lines would wait for the reconnect, move them to
+			// the other connections
+			for n := len(c.lines); n > 0; n-- {
+				s.send(<-c.lines)
+			}
+		}
+	}
+}
+
+// wait waits for d while disconnected, flush requests are released
+// immediately. It returns false if the sink was closed.
+func (c *conn) wait(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			return true
+		case ch := <-c.flushc:
+			close(ch)
+		case <-c.sink.done:
+			return false
+		}
+	}
+}
+
+func (c *conn) write(w *bufio.Writer, line []byte) error {
+	if w.Available() < len(line) {
+		if err := c.flush(w); err != nil {
+			atomic.AddUint64(&c.sink.dropped, 1)
+			return err
+		}
+	}
+	w.Write(line) // the buffer has room so this cannot fail
+	c.buffered++
+	return nil
+}
+
+// flush flushes w, on error the lines in the buffer are dropped.
+func (c *conn) flush(w *bufio.Writer) error {
+	err := w.Flush()
+	if err != nil {
+		atomic.AddUint64(&c.sink.dropped, uint64(c.buffered))
+	}
+	c.buffered = 0
+	return err
+}
+
+// drain writes the lines currently in the queue and flushes w.
+func (c *conn) drain(w *bufio.Writer) error {
+	for n := len(c.lines); n > 0; n-- {
+		if err := c.write(w, <-c.lines); err != nil {
+			return err
+		}
+	}
+	return c.flush(w)
+}
+
+// deadlineWriter sets a write deadline before every write to conn.
+type deadlineWriter struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (w *deadlineWriter) Write(p []byte) (int, error) {
+	w.conn.SetWriteDeadline(time.Now().Add(w.timeout))
+	return w.conn.Write(p)
+}
@@ -0,0 +1,236 @@
+package tcp
+
+import (
+	"bufio"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	stats "github.com/lyft/gostats"
+	"github.com/lyft/gostats/mock"
+)
+
+var _ stats.FlushableSink = (*TCPSink)(nil)
+
+func listen(t *testing.T) net.Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func accept(t *testing.T, l net.Listener) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	l.(*net.TCPListener).SetDeadline(time.Now().Add(time.Second * 5))
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	return conn, bufio.NewReader(conn)
+}
+
+func fixedTime(s *TCPSink) {
+	s.now = func() time.Time { return time.Unix(1600000000, 0) }
+}
+
+func waitConnected(t *testing.T, s *TCPSink, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second * 5)
+	for s.Connected() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("connected: got: %d want: %d", s.Connected(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func readLines(t *testing.T, r *bufio.Reader, n int) []string {
+	t.Helper()
+	lines := make([]string, n)
+	for i := range lines {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines[i] = line
+	}
+	return lines
+}
+
+func TestTCPSink_LineFormat(t *testing.T) {
+	l := listen(t)
+	defer l.Close()
+
+	sink := NewTCPSink(l.Addr().String(), WithPoolSize(1))
+	fixedTime(sink)
+	defer sink.Close()
+
+	conn, r := accept(t, l)
+	defer conn.Close()
+	waitConnected(t, sink, 1)
+
+	sink.FlushCounter(mock.SerializeTags("service.requests", map[string]string{"code": "200", "method": "get"}), 1)
+	sink.FlushGauge("service.conns", 2)
+	sink.FlushTimer("service.latency", 1.5)
+	sink.Flush()
+
+	expected := []string{
+		"service.requests.code=200.method=get 1 1600000000\n",
+		"service.conns 2 1600000000\n",
+		"service.latency 1.5 1600000000\n",
+	}
+	for i, line := range readLines(t, r, len(expected)) {
+		if line != expected[i] {
+			t.Errorf("got: %q want: %q", line, expected[i])
+		}
+	}
+}
+
+func TestTCPSink_StatsdFormat(t *testing.T) {
+	l := listen(t)
+	defer l.Close()
+
+	sink := NewTCPSink(l.Addr().String(), WithPoolSize(1), WithFormat(FormatStatsd))
+	defer sink.Close()
+
+	conn, r := accept(t, l)
+	defer conn.Close()
+	waitConnected(t, sink, 1)
+
+	sink.FlushCounter("service.requests.__code=200", 1)
+	sink.FlushGauge("service.conns", 2)
+	sink.FlushTimer("service.latency", 1.5)
+	sink.Flush()
+
+	expected := []string{
+		"service.requests.__code=200:1|c\n",
+		"service.conns:2|g\n",
+		"service.latency:1.5|ms\n",
+	}
+	for i, line := range readLines(t, r, len(expected)) {
+		if line != expected[i] {
+			t.Errorf("got: %q want: %q", line, expected[i])
+		}
+	}
+}
+
+func TestTCPSink_RoundRobin(t *testing.T) {
+	l := listen(t)
+	defer l.Close()
+
+	sink := NewTCPSink(l.Addr().String(), WithPoolSize(2))
+	fixedTime(sink)
+	defer sink.Close()
+
+	c1, r1 := accept(t, l)
+	defer c1.Close()
+	c2, r2 := accept(t, l)
+	defer c2.Close()
+	waitConnected(t, sink, 2)
+
+	for _, name := range []string{"a", "b", "c", "d"} {
+		sink.FlushCounter(name, 1)
+	}
+	sink.Flush()
+
+	// each connection gets every other line
+	lines := append(readLines(t, r1, 2), readLines(t, r2, 2)...)
+	sort.Strings(lines)
+	expected := []string{
+		"a 1 1600000000\n",
+		"b 1 1600000000\n",
+		"c 1 1600000000\n",
+		"d 1 1600000000\n",
+	}
+	for i, line := range lines {
+		if line != expected[i] {
+			t.Errorf("got: %q want: %q", line, expected[i])
+		}
+	}
+	if n := sink.Dropped(); n != 0 {
+		t.Errorf("dropped: got: %d want: %d", n, 0)
+	}
+}
+
+func TestTCPSink_Reconnect(t *testing.T) {
+	l := listen(t)
+	defer l.Close()
+
+	sink := NewTCPSink(l.Addr().String(),
+		WithPoolSize(1),
+		WithBackoff(time.Millisecond, time.Millisecond*10),
+		WithWriteTimeout(time.Millisecond*100),
+	)
+	fixedTime(sink)
+	defer sink.Close()
+
+	conn, _ := accept(t, l)
+	waitConnected(t, sink, 1)
+	conn.Close()
+
+	// write until the sink notices the closed connection and reconnects
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		l.(*net.TCPListener).SetDeadline(time.Now().Add(time.Second * 5))
+		conn, err := l.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}()
+	for conn = nil; conn == nil; {
+		select {
+		case c, ok := <-accepted:
+			if !ok {
+				t.Fatal("the sink did not reconnect")
+			}
+			conn = c
+		case <-time.After(time.Millisecond):
+			sink.FlushCounter("c", 1)
+			sink.Flush()
+		}
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	r := bufio.NewReader(conn)
+
+	// the lines written before the failure was detected are dropped
+	sink.Flush()
+	if sink.Dropped() == 0 {
+		t.Error("dropped: got: 0 want: the lines written to the closed connection")
+	}
+	waitConnected(t, sink, 1)
+
+	sink.FlushGauge("g", 1)
+	sink.Flush()
+	// skip the counters written to the new connection before it was accepted
+	line := readLines(t, r, 1)[0]
+	for line == "c 1 1600000000\n" {
+		line = readLines(t, r, 1)[0]
+	}
+	if line != "g 1 1600000000\n" {
+		t.Errorf("got: %q want: %q", line, "g 1 1600000000\n")
+	}
+}
+
+func TestTCPSink_Dropped(t *testing.T) {
+	l := listen(t)
+	addr := l.Addr().String()
+	l.Close()
+
+	sink := NewTCPSink(addr, WithPoolSize(2), WithBackoff(time.Millisecond, time.Millisecond))
+	defer sink.Close()
+
+	// no connection is available
+	sink.FlushCounter("c", 1)
+	sink.FlushGauge("g", 1)
+	sink.Flush()
+	if n := sink.Dropped(); n != 2 {
+		t.Errorf("dropped: got: %d want: %d", n, 2)
+	}
+}
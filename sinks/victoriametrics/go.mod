@@ -0,0 +1,17 @@
+module github.com/lyft/gostats/sinks/victoriametrics
+
+go 1.25.0
+
+require (
+	github.com/golang/snappy v1.0.0
+	github.com/lyft/gostats v0.0.0
+	google.golang.org/protobuf v1.23.0
+)
+
+require (
+	github.com/sirupsen/logrus v1.0.6 // indirect
+	golang.org/x/crypto v0.0.0-20200204104054-c9f3fb736b72 // indirect
+	golang.org/x/sys v0.0.0-20200519105757-fe76b779f299 // indirect
+)
+
+replace github.com/lyft/gostats => ../..
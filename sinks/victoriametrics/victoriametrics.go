@@ -0,0 +1,437 @@
+// Package victoriametrics provides a stats.Sink that writes stats to
+// VictoriaMetrics, or any other Prometheus remote write receiver, with the
+// remote write protocol.
+package victoriametrics
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/lyft/gostats/internal/tags"
+)
+
+// WritePath is the path of the remote write endpoint of VictoriaMetrics.
+const WritePath = "/api/v1/write"
+
+// Defaults of the VictoriaMetricsSink options.
+const (
+	DefaultBatchSize    = 10000
+	DefaultBufferSize   = 100000
+	DefaultPushInterval = 10 * time.Second
+	DefaultTimeout      = 10 * time.Second
+)
+
+// An Option configures a VictoriaMetricsSink.
+type Option interface {
+	apply(*VictoriaMetricsSink)
+}
+
+// optionFunc wraps a func so it satisfies the Option interface.
+type optionFunc func(*VictoriaMetricsSink)
+
+func (f optionFunc) apply(sink *VictoriaMetricsSink) {
+	f(sink)
+}
+
+// WithBatchSize sets the maximum number of samples written by a request, the
+// default is DefaultBatchSize.
+func WithBatchSize(n int) Option {
+	return optionFunc(func(sink *VictoriaMetricsSink) {
+		sink.batchSize = n
+	})
+}
+
+// WithBufferSize sets the maximum number of samples waiting to be written,
+// the oldest samples are dropped when it is exceeded. The default is
+// DefaultBufferSize.
+func WithBufferSize(n int) Option {
+	return optionFunc(func(sink *VictoriaMetricsSink) {
+		sink.bufferSize = n
+	})
+}
+
+// WithPushInterval sets the interval at which the samples are written, the
+// default is DefaultPushInterval. If d is not positive the samples are only
+// written by Sync and when a batch is full.
+func WithPushInterval(d time.Duration) Option {
+	return optionFunc(func(sink *VictoriaMetricsSink) {
+		sink.interval = d
+	})
+}
+
+// WithTimeout sets the timeout of the requests, the default is
+// DefaultTimeout.
+func WithTimeout(d time.Duration) Option {
+	return optionFunc(func(sink *VictoriaMetricsSink) {
+		sink.timeout = d
+	})
+}
+
+// WithHTTPClient sets the client used to write the samples, the default is
+// http.DefaultClient.
+func WithHTTPClient(client *http.Client) Option {
+	return optionFunc(func(sink *VictoriaMetricsSink) {
+		sink.client = client
+	})
+}
+
+// WithGzip compresses the requests with gzip instead of snappy.
+func WithGzip() Option {
+	return optionFunc(func(sink *VictoriaMetricsSink) {
+		sink.gzip = true
+	})
+}
+
+// WithErrorHandler sets the function called with the errors of the writes
+// started by the sink, by default they are ignored.
+func WithErrorHandler(fn func(error)) Option {
+	return optionFunc(func(sink *VictoriaMetricsSink) {
+		sink.onError = fn
+	})
+}
+
+type series struct {
+	labels []label // sorted by name, including __name__
+	value  float64 // total of a Counter, last value of a Gauge, sum of a Timer
+	dirty  bool    // flushed since the last sample
+
+	// Timers only
+	count       float64
+	countLabels []label // labels of the _count series
+}
+
+// A VictoriaMetricsSink is a stats.Sink that writes stats to VictoriaMetrics
+// with the Prometheus remote write protocol: snappy compressed protocol
+// buffers POSTed to the api/v1/write endpoint.
+//
+// Stats are named and typed like in the prometheus package: names are
+// sanitized to match the Prometheus naming rules and tags become labels.
+// Counters are written as the total of their flushes, Gauges as their last
+// value and Timers as _sum and _count series. Each Flush takes a sample of the
+// stats flushed since the previous one, the samples are written in batches
+// periodically. If the server does not support snappy, the requests are
+// compressed with gzip instead: once it responds with a 415 status.
+type VictoriaMetricsSink struct {
+	writeURL   string
+	client     *http.Client
+	batchSize  int
+	bufferSize int
+	interval   time.Duration
+	timeout    time.Duration
+	onError    func(error)
+	now        func() time.Time
+
+	mu      sync.Mutex
+	series  map[string]*series
+	pending []timeSeries
+	dropped uint64
+
+	writeMu sync.Mutex // serializes the writes
+	gzip    bool       // guarded by writeMu after NewVictoriaMetricsSink
+
+	closeOnce sync.Once
+	full      chan struct{} // signals a full batch to run
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewVictoriaMetricsSink returns a VictoriaMetricsSink that writes to the
+// VictoriaMetrics server at baseURL, for example http://localhost:8428. The
+// sink must be closed to stop its periodic writes.
+func NewVictoriaMetricsSink(baseURL string, opts ...Option) *VictoriaMetricsSink {
+	s := &VictoriaMetricsSink{
+		writeURL:   strings.TrimSuffix(baseURL, "/") + WritePath,
+		client:     http.DefaultClient,
+		batchSize:  DefaultBatchSize,
+		bufferSize: DefaultBufferSize,
+		interval:   DefaultPushInterval,
+		timeout:    DefaultTimeout,
+		now:        time.Now,
+		series:     make(map[string]*series),
+		full:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt.apply(s)
+	}
+	if s.client == nil {
+		s.client = http.DefaultClient
+	}
+	if s.batchSize <= 0 {
+		s.batchSize = DefaultBatchSize
+	}
+	if s.bufferSize < s.batchSize {
+		s.bufferSize = s.batchSize
+	}
+	if s.interval > 0 {
+		s.wg.Add(1)
+		go s.run()
+	}
+	return s
+}
+
+// get returns the series of stat with its sanitized name suffixed with
+// suffix, s.mu must be held.
+func (s *VictoriaMetricsSink) get(stat, suffix string) *series {
+	key := stat + suffix
+	p := s.series[key]
+	if p == nil {
+		name, set := tags.ParseTagSet(stat)
+		p = &series{labels: make([]label, 0, len(set)+1)}
+		p.labels = append(p.labels, label{"__name__", sanitizeName(name) + suffix})
+		for _, t := range set {
+			p.labels = append(p.labels, label{sanitizeLabelName(t.Key), t.Value})
+		}
+		sort.Slice(p.labels, func(i, j int) bool { return p.labels[i].name < p.labels[j].name })
+		s.series[key] = p
+	}
+	return p
+}
+
+// FlushCounter implements the stats.Sink.FlushCounter method.
+func (s *VictoriaMetricsSink) FlushCounter(name string, value uint64) {
+	s.mu.Lock()
+	p := s.get(name, "")
+	p.value += float64(value)
+	p.dirty = true
+	s.mu.Unlock()
+}
+
+// FlushGauge implements the stats.Sink.FlushGauge method.
+func (s *VictoriaMetricsSink) FlushGauge(name string, value uint64) {
+	s.mu.Lock()
+	p := s.get(name, "")
+	p.value = float64(value)
+	p.dirty = true
+	s.mu.Unlock()
+}
+
+// FlushTimer implements the stats.Sink.FlushTimer method.
+func (s *VictoriaMetricsSink) FlushTimer(name string, value float64) {
+	s.mu.Lock()
+	p := s.get(name, "_sum")
+	if p.countLabels == nil {
+		p.countLabels = append([]label(nil), p.labels...)
+		for i, l := range p.countLabels {
+			if l.name == "__name__" {
+				p.countLabels[i].value = strings.TrimSuffix(l.value, "_sum") + "_count"
+			}
+		}
+	}
+	p.value += value
+	p.count++
+	p.dirty = true
+	s.mu.Unlock()
+}
+
+// Flush implements the stats.FlushableSink.Flush method. It samples the stats
+// flushed since the previous Flush and writes a batch if one is full.
+func (s *VictoriaMetricsSink) Flush() {
+	if !s.sample() {
+		return
+	}
+	if s.interval <= 0 {
+		s.handle(s.write(true))
+		return
+	}
+	select {
+	case s.full <- struct{}{}:
+	default:
+	}
+}
+
+// sample appends a sample of the stats flushed since the previous sample to
+// the pending samples and reports if a batch is full.
+func (s *VictoriaMetricsSink) sample() bool {
+	ts := s.now().UnixNano() / int64(time.Millisecond)
+	s.mu.Lock()
+	for _, p := range s.series {
+		if !p.dirty {
+			continue
+		}
+		p.dirty = false
+		s.pending = append(s.pending, timeSeries{p.labels, sample{p.value, ts}})
+		if p.countLabels != nil {
+			s.pending = append(s.pending, timeSeries{p.countLabels, sample{p.count, ts}})
+		}
+	}
+	if n := len(s.pending) - s.bufferSize; n > 0 {
+		s.dropped += uint64(n)
+		s.pending = append(s.pending[:0], s.pending[n:]...)
+	}
+	full := len(s.pending) >= s.batchSize
+	s.mu.Unlock()
+	return full
+}
+
+// Dropped returns the number of samples dropped because the buffer was full.
+func (s *VictoriaMetricsSink) Dropped() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// Sync implements the stats.Syncer interface. It samples the flushed stats
+// and writes the pending samples, it stops at the first failed request and
+// returns its error. The samples of a failed request are written again by
+// the next write.
+func (s *VictoriaMetricsSink) Sync() error {
+	s.sample()
+	return s.write(false)
+}
+
+// Close stops the periodic writes and syncs the sink.
+func (s *VictoriaMetricsSink) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	s.wg.Wait()
+	return s.Sync()
+}
+
+func (s *VictoriaMetricsSink) run() {
+	defer s.wg.Done()
+	tick := time.NewTicker(s.interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			s.handle(s.write(false))
+		case <-s.full:
+			s.handle(s.write(true))
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *VictoriaMetricsSink) handle(err error) {
+	if err != nil && s.onError != nil {
+		s.onError(err)
+	}
+}
+
+// write writes the pending samples in batches, only the full batches if
+// fullOnly is true. The samples of a failed batch are put back in front of
+// the pending samples.
+func (s *VictoriaMetricsSink) write(fullOnly bool) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	for {
+		s.mu.Lock()
+		n := len(s.pending)
+		if n > s.batchSize {
+			n = s.batchSize
+		}
+		if n == 0 || fullOnly && n < s.batchSize {
+			s.mu.Unlock()
+			return nil
+		}
+		batch := append([]timeSeries(nil), s.pending[:n]...)
+		s.pending = s.pending[n:]
+		s.mu.Unlock()
+
+		if err := s.post(batch); err != nil {
+			s.mu.Lock()
+			s.pending = append(batch, s.pending...)
+			s.mu.Unlock()
+			return err
+		}
+	}
+}
+
+// post writes batch, falling back to gzip if the server rejects snappy.
+// s.writeMu must be held.
+func (s *VictoriaMetricsSink) post(batch []timeSeries) error {
+	data := marshalWriteRequest(batch)
+	for {
+		code, err := s.send(data, s.gzip)
+		if err != nil && !s.gzip && code == http.StatusUnsupportedMediaType {
+			s.gzip = true
+			continue
+		}
+		return err
+	}
+}
+
+// send POSTs data with the encoding of gz and returns the status code of the
+// response.
+func (s *VictoriaMetricsSink) send(data []byte, gz bool) (int, error) {
+	var body []byte
+	encoding := "snappy"
+	if gz {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(data)
+		zw.Close()
+		body, encoding = buf.Bytes(), "gzip"
+	} else {
+		body = snappy.Encode(nil, data)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if s.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+	}
+	defer cancel()
+	req, err := http.NewRequest(http.MethodPost, s.writeURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", encoding)
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return resp.StatusCode, fmt.Errorf("victoriametrics: write to %s failed: %s: %s",
+			s.writeURL, resp.Status, bytes.TrimSpace(msg))
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+// sanitizeName replaces any chars that are not valid in a Prometheus metric
+// name ([a-zA-Z_:][a-zA-Z0-9_:]*) with '_'.
+func sanitizeName(s string) string {
+	return sanitize(s, true)
+}
+
+// sanitizeLabelName replaces any chars that are not valid in a Prometheus label
+// name ([a-zA-Z_][a-zA-Z0-9_]*) with '_'.
+func sanitizeLabelName(s string) string {
+	return sanitize(s, false)
+}
+
+func sanitize(s string, allowColon bool) string {
+	if s == "" {
+		return "_"
+	}
+	b := []byte(s)
+	for i, c := range b {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', c == '_':
+		case '0' <= c && c <= '9':
+			if i == 0 {
+				b[i] = '_'
+			}
+		case c == ':' && allowColon:
+		default:
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,277 @@
+package victoriametrics
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	stats "github.com/lyft/gostats"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var (
+	_ stats.FlushableSink = (*VictoriaMetricsSink)(nil)
+	_ stats.Syncer        = (*VictoriaMetricsSink)(nil)
+)
+
+// unmarshalWriteRequest decodes a WriteRequest encoded by
+// marshalWriteRequest, the series are formatted as `name{labels} value ts`.
+func unmarshalWriteRequest(t *testing.T, b []byte) []string {
+	t.Helper()
+	fields := func(b []byte, fn func(num protowire.Number, typ protowire.Type, b []byte) int) {
+		for len(b) != 0 {
+			num, typ, n := protowire.ConsumeTag(b)
+			if n < 0 {
+				t.Fatal(protowire.ParseError(n))
+			}
+			b = b[n:]
+			n = fn(num, typ, b)
+			if n < 0 {
+				t.Fatal(protowire.ParseError(n))
+			}
+			b = b[n:]
+		}
+	}
+	var series []string
+	fields(b, func(_ protowire.Number, _ protowire.Type, b []byte) int {
+		ts, n := protowire.ConsumeBytes(b)
+		var name string
+		var labels []string
+		var value float64
+		var timestamp int64
+		fields(ts, func(num protowire.Number, _ protowire.Type, b []byte) int {
+			msg, n := protowire.ConsumeBytes(b)
+			var k, v string
+			fields(msg, func(field protowire.Number, _ protowire.Type, b []byte) int {
+				switch {
+				case num == 1:
+					s, n := protowire.ConsumeString(b)
+					if field == 1 {
+						k = s
+					} else {
+						v = s
+					}
+					return n
+				case field == 1:
+					x, n := protowire.ConsumeFixed64(b)
+					value = math.Float64frombits(x)
+					return n
+				default:
+					x, n := protowire.ConsumeVarint(b)
+					timestamp = int64(x)
+					return n
+				}
+			})
+			if num == 1 {
+				if k == "__name__" {
+					name = v
+				} else {
+					labels = append(labels, k+"="+v)
+				}
+			}
+			return n
+		})
+		series = append(series, fmt.Sprintf("%s{%s} %g %d", name, strings.Join(labels, ","), value, timestamp))
+		return n
+	})
+	sort.Strings(series)
+	return series
+}
+
+type testServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	requests  [][]string
+	encodings []string
+	snappy    bool // accept snappy requests
+}
+
+func newTestServer(t *testing.T) *testServer {
+	ts := &testServer{snappy: true}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != WritePath {
+			t.Errorf("path: got: %q want: %q", r.URL.Path, WritePath)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		ts.mu.Lock()
+		defer ts.mu.Unlock()
+		enc := r.Header.Get("Content-Encoding")
+		ts.encodings = append(ts.encodings, enc)
+		var data []byte
+		var err error
+		switch {
+		case enc == "snappy" && ts.snappy:
+			data, err = snappy.Decode(nil, body)
+		case enc == "gzip":
+			var zr *gzip.Reader
+			if zr, err = gzip.NewReader(bytes.NewReader(body)); err == nil {
+				data, err = ioutil.ReadAll(zr)
+			}
+		default:
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		if err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ts.requests = append(ts.requests, unmarshalWriteRequest(t, data))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func (ts *testServer) Requests() [][]string {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	reqs := ts.requests
+	ts.requests = nil
+	return reqs
+}
+
+func TestVictoriaMetricsSink(t *testing.T) {
+	ts := newTestServer(t)
+	sink := NewVictoriaMetricsSink(ts.URL, WithPushInterval(0))
+	defer sink.Close()
+	sink.now = func() time.Time { return time.Unix(100, 0) }
+
+	sink.FlushCounter("svc.requests.__code=200", 2)
+	sink.FlushCounter("svc.requests.__code=200", 3)
+	sink.FlushGauge("svc.conns", 4)
+	sink.FlushGauge("svc.conns", 6)
+	sink.FlushTimer("svc.latency", 1.5)
+	sink.FlushTimer("svc.latency", 0.5)
+	sink.Flush()
+	sink.FlushCounter("svc.requests.__code=200", 1)
+	if err := sink.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	reqs := ts.Requests()
+	exp := []string{
+		"svc_conns{} 6 100000",
+		"svc_latency_count{} 2 100000",
+		"svc_latency_sum{} 2 100000",
+		"svc_requests{code=200} 5 100000",
+		"svc_requests{code=200} 6 100000",
+	}
+	if len(reqs) != 1 || strings.Join(reqs[0], "\n") != strings.Join(exp, "\n") {
+		t.Errorf("requests:\ngot:  %q\nwant: %q", reqs, exp)
+	}
+
+	// nothing was flushed
+	if err := sink.Sync(); err != nil || len(ts.Requests()) != 0 {
+		t.Errorf("Sync: got: %v want: no request", err)
+	}
+}
+
+func TestVictoriaMetricsSinkBatches(t *testing.T) {
+	ts := newTestServer(t)
+	sink := NewVictoriaMetricsSink(ts.URL, WithBatchSize(2), WithPushInterval(0))
+	defer sink.Close()
+
+	for i := 0; i < 5; i++ {
+		sink.FlushGauge(fmt.Sprintf("g%d", i), uint64(i))
+	}
+	sink.Flush() // writes the full batches
+	if reqs := ts.Requests(); len(reqs) != 2 || len(reqs[0]) != 2 || len(reqs[1]) != 2 {
+		t.Errorf("full batches: got: %q", reqs)
+	}
+	if err := sink.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if reqs := ts.Requests(); len(reqs) != 1 || len(reqs[0]) != 1 {
+		t.Errorf("last batch: got: %q", reqs)
+	}
+}
+
+func TestVictoriaMetricsSinkGzipFallback(t *testing.T) {
+	ts := newTestServer(t)
+	ts.snappy = false
+	sink := NewVictoriaMetricsSink(ts.URL, WithPushInterval(0))
+	defer sink.Close()
+
+	sink.FlushGauge("g", 1)
+	if err := sink.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	sink.FlushGauge("g", 2)
+	if err := sink.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	ts.mu.Lock()
+	encodings := strings.Join(ts.encodings, ",")
+	ts.mu.Unlock()
+	if encodings != "snappy,gzip,gzip" {
+		t.Errorf("encodings: got: %s want: %s", encodings, "snappy,gzip,gzip")
+	}
+	if n := len(ts.Requests()); n != 2 {
+		t.Errorf("requests: got: %d want: %d", n, 2)
+	}
+}
+
+func TestVictoriaMetricsSinkRetry(t *testing.T) {
+	var mu sync.Mutex
+	fail := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	var errs []error
+	sink := NewVictoriaMetricsSink(srv.URL, WithPushInterval(0), WithBufferSize(1),
+		WithBatchSize(1), WithErrorHandler(func(err error) { errs = append(errs, err) }))
+	sink.FlushGauge("g", 1)
+	if err := sink.Sync(); err == nil {
+		t.Fatal("Sync: expected an error")
+	}
+	// the failed sample is kept, the oldest sample is dropped when the buffer
+	// is full
+	sink.FlushGauge("g", 2)
+	sink.Flush()
+	if len(errs) != 1 || sink.Dropped() != 1 {
+		t.Errorf("errors: %v dropped: %d want: 1 error and 1 dropped sample", errs, sink.Dropped())
+	}
+	mu.Lock()
+	fail = false
+	mu.Unlock()
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVictoriaMetricsSinkInterval(t *testing.T) {
+	ts := newTestServer(t)
+	sink := NewVictoriaMetricsSink(ts.URL, WithPushInterval(10*time.Millisecond))
+	defer sink.Close()
+
+	sink.FlushCounter("c", 1)
+	sink.Flush()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		ts.mu.Lock()
+		n := len(ts.requests)
+		ts.mu.Unlock()
+		if n != 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Error("the samples were not written periodically")
+}
@@ -0,0 +1,15 @@
+module github.com/lyft/gostats/sinks/wavefront
+
+go 1.25.0
+
+require github.com/lyft/gostats v0.0.0
+
+require (
+	github.com/caio/go-tdigest/v4 v4.0.1 // indirect
+	github.com/sirupsen/logrus v1.0.6 // indirect
+	github.com/wavefronthq/wavefront-sdk-go v0.15.0
+	golang.org/x/crypto v0.0.0-20200204104054-c9f3fb736b72 // indirect
+	golang.org/x/sys v0.0.0-20200519105757-fe76b779f299 // indirect
+)
+
+replace github.com/lyft/gostats => ../..
@@ -0,0 +1,250 @@
+// Package wavefront provides a stats.Sink that sends stats to Wavefront with
+// the wavefront-sdk-go library, either directly or through a Wavefront proxy.
+package wavefront
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lyft/gostats/internal/tags"
+	"github.com/wavefronthq/wavefront-sdk-go/histogram"
+	"github.com/wavefronthq/wavefront-sdk-go/senders"
+)
+
+// A Sender sends points and distributions to Wavefront, it is implemented by
+// the senders.Sender of wavefront-sdk-go.
+type Sender interface {
+	senders.MetricSender
+	senders.DistributionSender
+	Flush() error
+	Close()
+}
+
+// An Option configures a WavefrontSink.
+type Option interface {
+	apply(*WavefrontSink)
+}
+
+// optionFunc wraps a func so it satisfies the Option interface.
+type optionFunc func(*WavefrontSink)
+
+func (f optionFunc) apply(sink *WavefrontSink) {
+	f(sink)
+}
+
+// WithSource sets the source of the stats, by default the sender uses the
+// hostname.
+func WithSource(source string) Option {
+	return optionFunc(func(sink *WavefrontSink) {
+		sink.source = source
+	})
+}
+
+// WithPointTags adds point tags to all the stats of the sink, the tags of a
+// stat take precedence.
+func WithPointTags(pointTags map[string]string) Option {
+	return optionFunc(func(sink *WavefrontSink) {
+		for k, v := range pointTags {
+			sink.tags[k] = v
+		}
+	})
+}
+
+// WithHistograms converts the Timers into Wavefront histograms aggregated at
+// each of granularities, for example histogram.MINUTE for !M histograms,
+// instead of sending each of their values as a point.
+func WithHistograms(granularities ...histogram.Granularity) Option {
+	return optionFunc(func(sink *WavefrontSink) {
+		sink.granularities = granularities
+	})
+}
+
+// WithSenderOptions sets options of the sender created by NewDirectSink or
+// NewProxySink, by default the sender does not report its internal metrics.
+func WithSenderOptions(opts ...senders.Option) Option {
+	return optionFunc(func(sink *WavefrontSink) {
+		sink.senderOpts = append(sink.senderOpts, opts...)
+	})
+}
+
+// WithErrorHandler sets the function called with the errors of the sender,
+// by default they are ignored.
+func WithErrorHandler(fn func(error)) Option {
+	return optionFunc(func(sink *WavefrontSink) {
+		sink.onError = fn
+	})
+}
+
+type timerSeries struct {
+	name       string
+	tags       map[string]string
+	histograms []histogram.Histogram
+}
+
+// A WavefrontSink is a stats.Sink that sends stats to Wavefront in the
+// Wavefront data format, with the tags of the stats as point tags.
+//
+// Counters are sent as delta counters, so that the increments of several
+// flushes and sources add up, and Gauges as points. Timers are sent as points
+// or, with WithHistograms, as distributions: their values are aggregated by
+// the sink and the histograms of the completed intervals are sent when the
+// sink is flushed.
+type WavefrontSink struct {
+	sender        Sender
+	source        string
+	tags          map[string]string
+	granularities []histogram.Granularity
+	senderOpts    []senders.Option
+	onError       func(error)
+	now           func() time.Time
+
+	mu     sync.Mutex
+	timers map[string]*timerSeries
+}
+
+func newSink(opts []Option) *WavefrontSink {
+	s := &WavefrontSink{
+		tags:       make(map[string]string),
+		senderOpts: []senders.Option{senders.SendInternalMetrics(false)},
+		now:        time.Now,
+		timers:     make(map[string]*timerSeries),
+	}
+	for _, opt := range opts {
+		opt.apply(s)
+	}
+	return s
+}
+
+// NewWavefrontSink returns a WavefrontSink that sends stats with sender.
+func NewWavefrontSink(sender Sender, opts ...Option) *WavefrontSink {
+	s := newSink(opts)
+	s.sender = sender
+	return s
+}
+
+// NewDirectSink returns a WavefrontSink that sends stats directly to the
+// Wavefront server at serverURL, for example https://INSTANCE.wavefront.com,
+// authenticated with the API token apiToken.
+func NewDirectSink(serverURL, apiToken string, opts ...Option) (*WavefrontSink, error) {
+	s := newSink(opts)
+	sender, err := senders.NewSender(serverURL, append(s.senderOpts, senders.APIToken(apiToken))...)
+	if err != nil {
+		return nil, err
+	}
+	s.sender = sender
+	return s, nil
+}
+
+// NewProxySink returns a WavefrontSink that sends stats to the Wavefront
+// proxy at proxyURL, for example http://localhost:2878.
+func NewProxySink(proxyURL string, opts ...Option) (*WavefrontSink, error) {
+	s := newSink(opts)
+	sender, err := senders.NewSender(proxyURL, s.senderOpts...)
+	if err != nil {
+		return nil, err
+	}
+	s.sender = sender
+	return s, nil
+}
+
+func (s *WavefrontSink) pointTags(stat string) (string, map[string]string) {
+	name, statTags := tags.ParseTags(stat)
+	if len(s.tags) == 0 {
+		return name, statTags
+	}
+	m := make(map[string]string, len(s.tags)+len(statTags))
+	for k, v := range s.tags {
+		m[k] = v
+	}
+	for k, v := range statTags {
+		m[k] = v
+	}
+	return name, m
+}
+
+func (s *WavefrontSink) handle(err error) {
+	if err != nil && s.onError != nil {
+		s.onError(err)
+	}
+}
+
+// FlushCounter implements the stats.Sink.FlushCounter method.
+func (s *WavefrontSink) FlushCounter(stat string, value uint64) {
+	name, pointTags := s.pointTags(stat)
+	s.handle(s.sender.SendDeltaCounter(name, float64(value), s.source, pointTags))
+}
+
+// FlushGauge implements the stats.Sink.FlushGauge method.
+func (s *WavefrontSink) FlushGauge(stat string, value uint64) {
+	name, pointTags := s.pointTags(stat)
+	s.handle(s.sender.SendMetric(name, float64(value), s.now().UnixNano()/int64(time.Millisecond), s.source, pointTags))
+}
+
+// FlushTimer implements the stats.Sink.FlushTimer method.
+func (s *WavefrontSink) FlushTimer(stat string, value float64) {
+	if len(s.granularities) == 0 {
+		name, pointTags := s.pointTags(stat)
+		s.handle(s.sender.SendMetric(name, value, s.now().UnixNano()/int64(time.Millisecond), s.source, pointTags))
+		return
+	}
+	s.mu.Lock()
+	t := s.timers[stat]
+	if t == nil {
+		t = &timerSeries{}
+		t.name, t.tags = s.pointTags(stat)
+		for _, g := range s.granularities {
+			t.histograms = append(t.histograms, histogram.New(
+				histogram.GranularityOption(g),
+				histogram.TimeSupplier(s.now),
+			))
+		}
+		s.timers[stat] = t
+	}
+	s.mu.Unlock()
+	for _, h := range t.histograms {
+		h.Update(value)
+	}
+}
+
+// Flush implements the stats.FlushableSink.Flush method, it sends the
+// histograms of the completed intervals and flushes the sender.
+func (s *WavefrontSink) Flush() {
+	s.handle(s.Sync())
+}
+
+// Sync implements the stats.Syncer interface. It sends the histograms of the
+// completed intervals, flushes the sender and returns the first error.
+func (s *WavefrontSink) Sync() error {
+	s.mu.Lock()
+	timers := make([]*timerSeries, 0, len(s.timers))
+	for _, t := range s.timers {
+		timers = append(timers, t)
+	}
+	s.mu.Unlock()
+
+	var first error
+	for _, t := range timers {
+		for i, h := range t.histograms {
+			hgs := map[histogram.Granularity]bool{s.granularities[i]: true}
+			for _, d := range h.Distributions() {
+				ts := d.Timestamp.UnixNano() / int64(time.Millisecond)
+				err := s.sender.SendDistribution(t.name, d.Centroids, hgs, ts, s.source, t.tags)
+				if err != nil && first == nil {
+					first = err
+				}
+			}
+		}
+	}
+	if err := s.sender.Flush(); err != nil && first == nil {
+		first = err
+	}
+	return first
+}
+
+// Close sends the histograms of the completed intervals and closes the
+// sender, the values of the current intervals are dropped.
+func (s *WavefrontSink) Close() error {
+	err := s.Sync()
+	s.sender.Close()
+	return err
+}
@@ -0,0 +1,184 @@
+package wavefront
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	stats "github.com/lyft/gostats"
+	"github.com/wavefronthq/wavefront-sdk-go/histogram"
+	"github.com/wavefronthq/wavefront-sdk-go/senders"
+)
+
+var (
+	_ stats.FlushableSink = (*WavefrontSink)(nil)
+	_ stats.Syncer        = (*WavefrontSink)(nil)
+	_ Sender              = (senders.Sender)(nil)
+)
+
+// testServer receives the lines reported by a sender, like a Wavefront proxy
+// or server.
+type testServer struct {
+	*httptest.Server
+	mu    sync.Mutex
+	lines []string
+	auth  []string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	ts := &testServer{}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ts.mu.Lock()
+		defer ts.mu.Unlock()
+		sc := bufio.NewScanner(zr)
+		for sc.Scan() {
+			ts.lines = append(ts.lines, sc.Text())
+		}
+		ts.auth = append(ts.auth, r.Header.Get("Authorization"))
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func (ts *testServer) Lines() []string {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	lines := ts.lines
+	ts.lines = nil
+	return lines
+}
+
+// contains reports if lines contains exp, ignoring the order of the point
+// tags which is random.
+func contains(lines []string, exp string) bool {
+	key := func(s string) string {
+		fields := strings.Fields(s)
+		sort.Strings(fields)
+		return strings.Join(fields, " ")
+	}
+	for _, s := range lines {
+		if key(s) == key(exp) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestProxySink(t *testing.T) {
+	ts := newTestServer(t)
+	sink, err := NewProxySink(ts.URL, WithSource("web-1"),
+		WithPointTags(map[string]string{"env": "prod", "code": "0"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	sink.now = func() time.Time { return time.Unix(100, 0) }
+
+	sink.FlushCounter("requests.__code=200", 3)
+	sink.FlushGauge("conns", 6)
+	sink.FlushTimer("latency", 1.5)
+	if err := sink.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := ts.Lines()
+	for _, exp := range []string{
+		`"∆requests" 3 source="web-1" "code"="200" "env"="prod"`,
+		`"conns" 6 100000 source="web-1" "code"="0" "env"="prod"`,
+		`"latency" 1.5 100000 source="web-1" "code"="0" "env"="prod"`,
+	} {
+		if !contains(lines, exp) {
+			t.Errorf("missing line: %s\ngot: %q", exp, lines)
+		}
+	}
+}
+
+func TestDirectSink(t *testing.T) {
+	ts := newTestServer(t)
+	sink, err := NewDirectSink(ts.URL, "token", WithSource("web-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	sink.FlushCounter("requests", 1)
+	if err := sink.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if lines := ts.Lines(); !contains(lines, `"∆requests" 1 source="web-1"`) {
+		t.Errorf("lines: got: %q", lines)
+	}
+	ts.mu.Lock()
+	auth := ts.auth
+	ts.mu.Unlock()
+	if len(auth) == 0 || auth[0] != "Bearer token" {
+		t.Errorf("Authorization: got: %q want: %q", auth, "Bearer token")
+	}
+}
+
+func TestHistograms(t *testing.T) {
+	ts := newTestServer(t)
+	now := time.Unix(120, 0)
+	sink, err := NewProxySink(ts.URL, WithSource("web-1"), WithHistograms(histogram.MINUTE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	sink.now = func() time.Time { return now }
+
+	sink.FlushTimer("latency.__path=a", 2)
+	sink.FlushTimer("latency.__path=a", 2)
+	sink.FlushTimer("latency.__path=a", 5)
+	if err := sink.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if lines := ts.Lines(); len(lines) != 0 {
+		t.Errorf("the current minute was sent: %q", lines)
+	}
+
+	now = now.Add(time.Minute)
+	if err := sink.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	lines := ts.Lines()
+	if len(lines) != 1 || !strings.HasPrefix(lines[0], "!M 120000 #2 2 #1 5 ") ||
+		!strings.HasSuffix(lines[0], ` "latency" source="web-1" "path"="a"`) {
+		t.Errorf("histogram: got: %q", lines)
+	}
+}
+
+type errSender struct {
+	senders.Sender
+	err error
+}
+
+func (s errSender) SendMetric(string, float64, int64, string, map[string]string) error {
+	return s.err
+}
+
+func TestErrorHandler(t *testing.T) {
+	noop, _ := senders.NewWavefrontNoOpClient()
+	exp := errors.New("buffer full")
+	var errs []error
+	sink := NewWavefrontSink(errSender{noop, exp}, WithErrorHandler(func(err error) {
+		errs = append(errs, err)
+	}))
+	sink.FlushGauge("g", 1)
+	sink.FlushCounter("c", 1)
+	if len(errs) != 1 || errs[0] != exp {
+		t.Errorf("errors: got: %v want: [%v]", errs, exp)
+	}
+}
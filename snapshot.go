@@ -0,0 +1,39 @@
+package stats
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// MarshalJSON encodes the Snapshot as:
+//
+//	{"counters":{"name":1},"gauges":{"name":2}}
+//
+// nil maps are encoded as empty objects.
+func (s Snapshot) MarshalJSON() ([]byte, error) {
+	v := struct {
+		Counters map[string]uint64 `json:"counters"`
+		Gauges   map[string]uint64 `json:"gauges"`
+	}{s.Counters, s.Gauges}
+	if v.Counters == nil {
+		v.Counters = map[string]uint64{}
+	}
+	if v.Gauges == nil {
+		v.Gauges = map[string]uint64{}
+	}
+	return json.Marshal(v)
+}
+
+type snapshotHandler struct {
+	store Store
+}
+
+func (h snapshotHandler) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	b, err := h.store.Snapshot().MarshalJSON()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
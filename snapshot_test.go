@@ -0,0 +1,50 @@
+package stats
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSnapshotMarshalJSON(t *testing.T) {
+	snap := Snapshot{
+		Counters: map[string]uint64{"c": 1},
+		Gauges:   map[string]uint64{"g.__k=v": 2},
+	}
+	b, err := snap.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `{"counters":{"c":1},"gauges":{"g.__k=v":2}}`
+	if string(b) != want {
+		t.Errorf("got: %s want: %s", b, want)
+	}
+
+	b, err = Snapshot{}.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const empty = `{"counters":{},"gauges":{}}`
+	if string(b) != empty {
+		t.Errorf("got: %s want: %s", b, empty)
+	}
+}
+
+func TestSnapshotHandler(t *testing.T) {
+	store := NewStore(NewNullSink(), false)
+	store.NewCounter("c").Add(3)
+
+	rec := httptest.NewRecorder()
+	store.SnapshotHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("status: got: %d want: %d", rec.Code, http.StatusOK)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type: got: %q want: %q", ct, "application/json")
+	}
+	const want = `{"counters":{"c":3},"gauges":{}}`
+	if body := rec.Body.String(); body != want {
+		t.Errorf("body: got: %s want: %s", body, want)
+	}
+}
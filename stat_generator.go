@@ -0,0 +1,111 @@
+package stats
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	logger "github.com/sirupsen/logrus"
+)
+
+// A generatorEntry is a StatGenerator added to a statStore.
+type generatorEntry struct {
+	StatGenerator
+	priority int
+	running  uint32 // 1 while a run that timed out has not returned
+}
+
+func (s *statStore) AddStatGeneratorWithPriority(sg StatGenerator, priority int) {
+	bindStatGenerator(sg, s)
+	s.genMtx.Lock()
+	defer s.genMtx.Unlock()
+
+	// keep the generators sorted by decreasing priority
+	i := sort.Search(len(s.statGenerators), func(i int) bool {
+		return s.statGenerators[i].priority < priority
+	})
+	s.statGenerators = append(s.statGenerators, nil)
+	copy(s.statGenerators[i+1:], s.statGenerators[i:])
+	s.statGenerators[i] = &generatorEntry{StatGenerator: sg, priority: priority}
+}
+
+// run runs the StatGenerator and reports its error, if any, to onError or
+// logs it if onError is nil.
+func (g *generatorEntry) run(onError func(error)) {
+	eg, ok := g.StatGenerator.(ErrorAwareStatGenerator)
+	if !ok {
+		g.GenerateStats()
+		return
+	}
+	if err := eg.TryGenerateStats(); err != nil {
+		if onError != nil {
+			onError(err)
+		} else {
+			logger.Warnf("[gostats] stat generator %T: %v", g.StatGenerator, err)
+		}
+	}
+}
+
+// generate runs the StatGenerator and waits for it to return or for timeout,
+// if it is greater than zero. The generator is skipped if a previous run that
+// timed out is still running.
+func (g *generatorEntry) generate(timeout time.Duration, onError func(error)) {
+	if timeout <= 0 {
+		g.run(onError)
+		return
+	}
+	if !atomic.CompareAndSwapUint32(&g.running, 0, 1) {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		defer atomic.StoreUint32(&g.running, 0)
+		defer close(done)
+		g.run(onError)
+	}()
+	t := time.NewTimer(timeout)
+	select {
+	case <-done:
+		t.Stop()
+	case <-t.C:
+	}
+}
+
+func (s *statStore) SetStatGeneratorConcurrency(n int) {
+	atomic.StoreInt32(&s.genConcurrency, int32(n))
+}
+
+// generateStats runs the StatGenerators of the store, at most
+// genConcurrency at a time. Generators of a lower priority are started after
+// all the generators of a higher priority returned.
+func (s *statStore) generateStats() {
+	s.genMtx.RLock()
+	defer s.genMtx.RUnlock()
+
+	n := int(atomic.LoadInt32(&s.genConcurrency))
+	if n <= 1 {
+		for _, g := range s.statGenerators {
+			g.generate(s.genTimeout, s.onError)
+		}
+		return
+	}
+
+	sem := make(chan struct{}, n)
+	var wg sync.WaitGroup
+	for i, g := range s.statGenerators {
+		if i > 0 && g.priority != s.statGenerators[i-1].priority {
+			wg.Wait()
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(g *generatorEntry) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			g.generate(s.genTimeout, s.onError)
+		}(g)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,174 @@
+package stats
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lyft/gostats/mock"
+)
+
+type statGeneratorFunc func()
+
+func (f statGeneratorFunc) GenerateStats() { f() }
+
+func TestStatGeneratorConcurrency(t *testing.T) {
+	for _, n := range []int{0, 1, 3} {
+		store := NewStore(mock.NewSink(), false)
+		store.SetStatGeneratorConcurrency(n)
+
+		var running, max, calls int32
+		for i := 0; i < 10; i++ {
+			store.AddStatGenerator(statGeneratorFunc(func() {
+				cur := atomic.AddInt32(&running, 1)
+				for {
+					m := atomic.LoadInt32(&max)
+					if cur <= m || atomic.CompareAndSwapInt32(&max, m, cur) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond * 5)
+				atomic.AddInt32(&running, -1)
+				atomic.AddInt32(&calls, 1)
+			}))
+		}
+		store.Flush()
+
+		exp := int32(n)
+		if exp < 1 {
+			exp = 1
+		}
+		if calls != 10 {
+			t.Errorf("%d: calls: got: %d want: %d", n, calls, 10)
+		}
+		if max != exp {
+			t.Errorf("%d: concurrent generators: got: %d want: %d", n, max, exp)
+		}
+	}
+}
+
+func TestStatGeneratorTimeout(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStoreWithOptions(sink, StoreOptions{
+		StatGeneratorTimeout: time.Millisecond * 10,
+	})
+
+	var mu sync.Mutex
+	mu.Lock()
+	var slowCalls int32
+	slow := store.NewCounter("slow")
+	store.AddStatGenerator(statGeneratorFunc(func() {
+		atomic.AddInt32(&slowCalls, 1)
+		mu.Lock()
+		slow.Inc()
+		mu.Unlock()
+	}))
+	fast := store.NewCounter("fast")
+	store.AddStatGenerator(statGeneratorFunc(func() { fast.Inc() }))
+
+	start := time.Now()
+	store.Flush()
+	store.Flush() // the slow generator is still running: skipped
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Flush blocked on the slow generator for %s", d)
+	}
+	sink.AssertCounterEquals(t, "fast", 2)
+	sink.AssertCounterEquals(t, "slow", 0)
+	if n := atomic.LoadInt32(&slowCalls); n != 1 {
+		t.Errorf("slow generator calls: got: %d want: %d", n, 1)
+	}
+
+	mu.Unlock()
+	for i := 0; atomic.LoadUint32(&store.(*statStore).statGenerators[0].running) != 0; i++ {
+		if i == 100 {
+			t.Fatal("slow generator did not return")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	store.Flush()
+	sink.AssertCounterEquals(t, "slow", 2)
+	if n := atomic.LoadInt32(&slowCalls); n != 2 {
+		t.Errorf("slow generator calls: got: %d want: %d", n, 2)
+	}
+}
+
+type errorStatGenerator struct {
+	err   error
+	calls int
+}
+
+func (g *errorStatGenerator) GenerateStats() { panic("GenerateStats called") }
+
+func (g *errorStatGenerator) TryGenerateStats() error {
+	g.calls++
+	return g.err
+}
+
+func TestErrorAwareStatGenerator(t *testing.T) {
+	var errs []error
+	store := NewStoreWithOptions(mock.NewSink(), StoreOptions{
+		OnError: func(err error) { errs = append(errs, err) },
+	})
+	ok := &errorStatGenerator{}
+	failing := &errorStatGenerator{err: errors.New("unavailable")}
+	store.AddStatGenerator(ok)
+	store.AddStatGenerator(failing)
+	store.Flush()
+	store.Flush()
+
+	if ok.calls != 2 || failing.calls != 2 {
+		t.Errorf("calls: got: %d, %d want: 2, 2", ok.calls, failing.calls)
+	}
+	if len(errs) != 2 || errs[0] != failing.err || errs[1] != failing.err {
+		t.Errorf("errors: got: %v want: [%[2]v %[2]v]", errs, failing.err)
+	}
+
+	// without OnError the error is logged
+	store = NewStore(mock.NewSink(), false)
+	store.AddStatGenerator(failing)
+	store.Flush()
+	if failing.calls != 3 {
+		t.Errorf("calls: got: %d want: %d", failing.calls, 3)
+	}
+}
+
+func TestAddStatGeneratorWithPriority(t *testing.T) {
+	for _, n := range []int{1, 4} {
+		store := NewStore(mock.NewSink(), false)
+		store.SetStatGeneratorConcurrency(n)
+
+		var mu sync.Mutex
+		var order []int
+		add := func(priority int) {
+			store.AddStatGeneratorWithPriority(statGeneratorFunc(func() {
+				time.Sleep(time.Millisecond * time.Duration(priority+2))
+				mu.Lock()
+				order = append(order, priority)
+				mu.Unlock()
+			}), priority)
+		}
+		add(-1)
+		add(5)
+		store.AddStatGenerator(statGeneratorFunc(func() {
+			mu.Lock()
+			order = append(order, 0)
+			mu.Unlock()
+		}))
+		add(2)
+		add(5)
+		store.Flush()
+
+		exp := []int{5, 5, 2, 0, -1}
+		if len(order) != len(exp) {
+			t.Fatalf("%d: order: got: %v want: %v", n, order, exp)
+		}
+		for i := range exp {
+			if order[i] != exp[i] {
+				t.Errorf("%d: order: got: %v want: %v", n, order, exp)
+				break
+			}
+		}
+	}
+}
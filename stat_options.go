@@ -0,0 +1,720 @@
+package stats
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// A StatOption configures the stats created by the Scope returned by
+// Scope.WithOptions.
+type StatOption interface {
+	apply(*statOptions)
+}
+
+type statOptionFunc func(*statOptions)
+
+func (f statOptionFunc) apply(o *statOptions) {
+	f(o)
+}
+
+type statOptions struct {
+	predicate     func() bool
+	flushInterval time.Duration
+	aliases       []string
+	help          string
+}
+
+// WithPredicate makes the stats silently discard their Add, Set, Record and
+// similar calls while fn returns false:
+//
+//	scope := store.WithOptions(stats.WithPredicate(flags.DetailedStats))
+//	scope.NewCounter("requests." + path).Inc()
+//
+// fn is called at most once per Flush of the backing Store, by the first
+// call made to one of the stats after it, rather than on every call. It may
+// be called concurrently and must be safe for use by multiple goroutines.
+func WithPredicate(fn func() bool) StatOption {
+	return statOptionFunc(func(o *statOptions) {
+		o.predicate = fn
+	})
+}
+
+// WithFlushInterval makes the Counters and Gauges flushed every d, in
+// addition to the flushes of the backing Store. Counters only flush the
+// increments since their last flush, so a Counter is never counted twice.
+// Stats with the same interval share a ticker. It has no effect on other
+// stats or if the backing Store was not created by NewStore.
+func WithFlushInterval(d time.Duration) StatOption {
+	return statOptionFunc(func(o *statOptions) {
+		o.flushInterval = d
+	})
+}
+
+// WithAlias makes the Counters and Gauges also update the stat of the same
+// type registered under oldName, if there is one. This allows renaming a
+// stat while legacy code still creates it with its old name:
+//
+//	scope.WithOptions(stats.WithAlias("legacy.requests")).NewCounter("requests")
+//
+// oldName is the fully qualified name of the old stat, including scopes and
+// serialized tags, as returned by Store.ListCounters. The old stat is looked
+// up on each update and is never created by the alias. The alias applies to
+// every Counter and Gauge of the Scope, so the Scope is usually used for a
+// single stat. WithAlias can be given more than once. It has no effect on
+// other stats or if the backing Store was not created by NewStore.
+func WithAlias(oldName string) StatOption {
+	return statOptionFunc(func(o *statOptions) {
+		o.aliases = append(o.aliases, oldName)
+	})
+}
+
+// WithHelp sets the help text of the stats, it is returned by
+// Store.ListMetricDescriptions and passed to the Sink if it implements
+// HelpSink. It has no effect if the backing Store was not created by
+// NewStore.
+func WithHelp(text string) StatOption {
+	return statOptionFunc(func(o *statOptions) {
+		o.help = text
+	})
+}
+
+// storeOf returns the statStore that backs store, or nil.
+func storeOf(store Store) *statStore {
+	switch s := store.(type) {
+	case *statStore:
+		return s
+	case *CardinalityLimiter:
+		return storeOf(s.limitedStore)
+	case *scopedStore:
+		return storeOf(s.store)
+	}
+	return nil
+}
+
+type predicate struct {
+	seen    uint64 // flush generation of enabled plus one, 0 if not evaluated
+	enabled uint32
+	flushes *uint64
+	fn      func() bool
+}
+
+// allow reports if the stats may be updated, a nil predicate allows all
+// updates.
+func (p *predicate) allow() bool {
+	if p == nil {
+		return true
+	}
+	if p.flushes == nil {
+		return p.fn()
+	}
+	gen := atomic.LoadUint64(p.flushes) + 1
+	if atomic.LoadUint64(&p.seen) == gen {
+		return atomic.LoadUint32(&p.enabled) == 1
+	}
+	enabled := p.fn()
+	if enabled {
+		atomic.StoreUint32(&p.enabled, 1)
+	} else {
+		atomic.StoreUint32(&p.enabled, 0)
+	}
+	atomic.StoreUint64(&p.seen, gen)
+	return enabled
+}
+
+// newOptionScope returns scope if opts do not change its stats.
+func newOptionScope(scope Scope, opts []StatOption) Scope {
+	var o statOptions
+	for _, opt := range opts {
+		opt.apply(&o)
+	}
+	if o.predicate == nil && o.flushInterval <= 0 && len(o.aliases) == 0 && o.help == "" {
+		return scope
+	}
+	w := &optionScope{scope: scope, store: storeOf(scope.Store())}
+	if o.predicate != nil {
+		w.p = &predicate{fn: o.predicate}
+		if w.store != nil {
+			w.p.flushes = &w.store.flushes
+		}
+	}
+	if o.flushInterval > 0 && w.store != nil {
+		w.interval = o.flushInterval
+	}
+	if w.store != nil {
+		w.aliases = o.aliases
+		w.help = o.help
+	}
+	return w
+}
+
+// optionScope wraps the stats of scope to apply statOptions.
+type optionScope struct {
+	scope    Scope
+	store    *statStore // nil if unknown
+	p        *predicate // nil if there is no predicate
+	interval time.Duration
+	aliases  []string // old names, see WithAlias
+	help     string
+	parent   Scope // nil if created by WithOptions
+}
+
+func (s *optionScope) Scope(name string) Scope {
+	c := *s
+	c.scope = s.scope.Scope(name)
+	c.parent = s
+	return &c
+}
+
+func (s *optionScope) ScopeWithTags(name string, tags map[string]string) Scope {
+	c := *s
+	c.scope = s.scope.ScopeWithTags(name, tags)
+	c.parent = s
+	return &c
+}
+
+func (s *optionScope) ScopeWithOptions(name string, opts ScopeOptions) Scope {
+	c := *s
+	c.scope = s.scope.ScopeWithOptions(name, opts)
+	c.parent = s
+	return &c
+}
+
+// Parent returns the parent of the wrapped scope if the Scope was created by
+// WithOptions, so that options only apply to the scopes derived from it.
+func (s *optionScope) Parent() Scope {
+	if s.parent != nil {
+		return s.parent
+	}
+	return s.scope.Parent()
+}
+
+func (s *optionScope) Name() string {
+	return s.scope.Name()
+}
+
+func (s *optionScope) FullName() string {
+	return s.scope.FullName()
+}
+
+func (s *optionScope) AsStore() Store {
+	return newScopedStore(s, s.scope.Store())
+}
+
+func (s *optionScope) WithOptions(opts ...StatOption) Scope {
+	return newOptionScope(s, opts)
+}
+
+func (s *optionScope) WithTag(key, value string) Scope {
+	c := *s
+	c.scope = s.scope.WithTag(key, value)
+	return &c
+}
+
+func (s *optionScope) Store() Store {
+	return s.scope.Store()
+}
+
+func (s *optionScope) NewCounter(name string) Counter {
+	s.describe(name)
+	return s.counter(s.scope.NewCounter(name))
+}
+
+func (s *optionScope) NewCounterWithTags(name string, tags map[string]string) Counter {
+	s.describe(name)
+	return s.counter(s.scope.NewCounterWithTags(name, tags))
+}
+
+func (s *optionScope) NewPerInstanceCounter(name string, tags map[string]string) Counter {
+	s.describe(name)
+	return s.counter(s.scope.NewPerInstanceCounter(name, tags))
+}
+
+func (s *optionScope) NewGauge(name string) Gauge {
+	s.describe(name)
+	return s.gauge(s.scope.NewGauge(name))
+}
+
+func (s *optionScope) NewGaugeWithTags(name string, tags map[string]string) Gauge {
+	s.describe(name)
+	return s.gauge(s.scope.NewGaugeWithTags(name, tags))
+}
+
+func (s *optionScope) NewPerInstanceGauge(name string, tags map[string]string) Gauge {
+	s.describe(name)
+	return s.gauge(s.scope.NewPerInstanceGauge(name, tags))
+}
+
+func (s *optionScope) NewTimer(name string) Timer {
+	s.describe(name)
+	return predicateTimer{s.scope.NewTimer(name), s.p}
+}
+
+func (s *optionScope) NewTimerWithTags(name string, tags map[string]string) Timer {
+	s.describe(name)
+	return predicateTimer{s.scope.NewTimerWithTags(name, tags), s.p}
+}
+
+func (s *optionScope) NewPerInstanceTimer(name string, tags map[string]string) Timer {
+	s.describe(name)
+	return predicateTimer{s.scope.NewPerInstanceTimer(name, tags), s.p}
+}
+
+func (s *optionScope) NewCounterCtx(ctx context.Context, name string) Counter {
+	s.describe(name)
+	return s.counter(s.scope.NewCounterCtx(ctx, name))
+}
+
+func (s *optionScope) NewCounterWithTagsCtx(ctx context.Context, name string, tags map[string]string) Counter {
+	s.describe(name)
+	return s.counter(s.scope.NewCounterWithTagsCtx(ctx, name, tags))
+}
+
+func (s *optionScope) NewGaugeCtx(ctx context.Context, name string) Gauge {
+	s.describe(name)
+	return s.gauge(s.scope.NewGaugeCtx(ctx, name))
+}
+
+func (s *optionScope) NewGaugeWithTagsCtx(ctx context.Context, name string, tags map[string]string) Gauge {
+	s.describe(name)
+	return s.gauge(s.scope.NewGaugeWithTagsCtx(ctx, name, tags))
+}
+
+func (s *optionScope) NewTimerCtx(ctx context.Context, name string) Timer {
+	s.describe(name)
+	return predicateTimer{s.scope.NewTimerCtx(ctx, name), s.p}
+}
+
+func (s *optionScope) NewTimerWithTagsCtx(ctx context.Context, name string, tags map[string]string) Timer {
+	s.describe(name)
+	return predicateTimer{s.scope.NewTimerWithTagsCtx(ctx, name, tags), s.p}
+}
+
+func (s *optionScope) NewHistogram(name string) Histogram {
+	s.describe(name)
+	return predicateRecorder{s.scope.NewHistogram(name), s.p}
+}
+
+func (s *optionScope) NewHistogramWithTags(name string, tags map[string]string) Histogram {
+	s.describe(name)
+	return predicateRecorder{s.scope.NewHistogramWithTags(name, tags), s.p}
+}
+
+func (s *optionScope) NewHistogramWithOptions(name string, tags map[string]string, opts HistogramOptions) Histogram {
+	s.describe(name)
+	return predicateRecorder{s.scope.NewHistogramWithOptions(name, tags, opts), s.p}
+}
+
+func (s *optionScope) NewDistribution(name string) Distribution {
+	s.describe(name)
+	return predicateRecorder{s.scope.NewDistribution(name), s.p}
+}
+
+func (s *optionScope) NewDistributionWithTags(name string, tags map[string]string) Distribution {
+	s.describe(name)
+	return predicateRecorder{s.scope.NewDistributionWithTags(name, tags), s.p}
+}
+
+func (s *optionScope) NewPerInstanceDistribution(name string, tags map[string]string) Distribution {
+	s.describe(name)
+	return predicateRecorder{s.scope.NewPerInstanceDistribution(name, tags), s.p}
+}
+
+func (s *optionScope) NewSummary(name string, opts SummaryOptions) Summary {
+	s.describe(name)
+	return predicateRecorder{s.scope.NewSummary(name, opts), s.p}
+}
+
+func (s *optionScope) NewSummaryWithTags(name string, tags map[string]string, opts SummaryOptions) Summary {
+	s.describe(name)
+	return predicateRecorder{s.scope.NewSummaryWithTags(name, tags, opts), s.p}
+}
+
+func (s *optionScope) NewMinGauge(name string) GaugeMin {
+	s.describe(name)
+	return predicateGaugeMin{s.scope.NewMinGauge(name), s.p}
+}
+
+func (s *optionScope) NewMinGaugeWithTags(name string, tags map[string]string) GaugeMin {
+	s.describe(name)
+	return predicateGaugeMin{s.scope.NewMinGaugeWithTags(name, tags), s.p}
+}
+
+func (s *optionScope) NewMaxGauge(name string) GaugeMax {
+	s.describe(name)
+	return predicateGaugeMax{s.scope.NewMaxGauge(name), s.p}
+}
+
+func (s *optionScope) NewMaxGaugeWithTags(name string, tags map[string]string) GaugeMax {
+	s.describe(name)
+	return predicateGaugeMax{s.scope.NewMaxGaugeWithTags(name, tags), s.p}
+}
+
+func (s *optionScope) NewRateGauge(name string, opts RateGaugeOptions) RateGauge {
+	s.describe(name)
+	return predicateRateGauge{s.scope.NewRateGauge(name, opts), s.p}
+}
+
+func (s *optionScope) NewRateGaugeWithTags(name string, tags map[string]string, opts RateGaugeOptions) RateGauge {
+	s.describe(name)
+	return predicateRateGauge{s.scope.NewRateGaugeWithTags(name, tags, opts), s.p}
+}
+
+func (s *optionScope) NewUpDownCounter(name string) UpDownCounter {
+	s.describe(name)
+	return predicateUpDownCounter{s.scope.NewUpDownCounter(name), s.p}
+}
+
+func (s *optionScope) NewUpDownCounterWithTags(name string, tags map[string]string) UpDownCounter {
+	s.describe(name)
+	return predicateUpDownCounter{s.scope.NewUpDownCounterWithTags(name, tags), s.p}
+}
+
+// NewSequenceCounter ignores the predicate: callers depend on the values
+// returned by Next.
+func (s *optionScope) NewSequenceCounter(name string) SequenceCounter {
+	s.describe(name)
+	return s.scope.NewSequenceCounter(name)
+}
+
+func (s *optionScope) NewSequenceCounterWithTags(name string, tags map[string]string) SequenceCounter {
+	s.describe(name)
+	return s.scope.NewSequenceCounterWithTags(name, tags)
+}
+
+func (s *optionScope) NewWindowedCounter(name string, opts WindowedCounterOptions) WindowedCounter {
+	s.describe(name)
+	return predicateWindowedCounter{s.scope.NewWindowedCounter(name, opts), s.p}
+}
+
+func (s *optionScope) NewWindowedCounterWithTags(name string, tags map[string]string, opts WindowedCounterOptions) WindowedCounter {
+	s.describe(name)
+	return predicateWindowedCounter{s.scope.NewWindowedCounterWithTags(name, tags, opts), s.p}
+}
+
+func (s *optionScope) NewEWMAGauge(name string, alpha float64) Gauge {
+	s.describe(name)
+	return s.gauge(s.scope.NewEWMAGauge(name, alpha))
+}
+
+func (s *optionScope) NewEWMAGaugeWithTags(name string, tags map[string]string, alpha float64) Gauge {
+	s.describe(name)
+	return s.gauge(s.scope.NewEWMAGaugeWithTags(name, tags, alpha))
+}
+
+func (s *optionScope) NewFloatGauge(name string) FloatGauge {
+	s.describe(name)
+	return predicateFloatGauge{s.scope.NewFloatGauge(name), s.p}
+}
+
+func (s *optionScope) NewFloatGaugeWithTags(name string, tags map[string]string) FloatGauge {
+	s.describe(name)
+	return predicateFloatGauge{s.scope.NewFloatGaugeWithTags(name, tags), s.p}
+}
+
+func (s *optionScope) NewPerInstanceFloatGauge(name string, tags map[string]string) FloatGauge {
+	s.describe(name)
+	return predicateFloatGauge{s.scope.NewPerInstanceFloatGauge(name, tags), s.p}
+}
+
+// describe sets the help text of the stat name of the scope, if any.
+func (s *optionScope) describe(name string) {
+	if s.help != "" {
+		s.store.describe(joinScopes(s.scope.FullName(), name), s.help)
+	}
+}
+
+func (s *optionScope) counter(c Counter) Counter {
+	if s.interval > 0 {
+		s.store.flushEvery(s.interval, c)
+	}
+	if len(s.aliases) != 0 {
+		c = aliasCounter{c, s.store, s.aliases}
+	}
+	return predicateCounter{c, s.p}
+}
+
+func (s *optionScope) gauge(g Gauge) Gauge {
+	if s.interval > 0 {
+		s.store.flushEvery(s.interval, g)
+	}
+	if len(s.aliases) != 0 {
+		g = aliasGauge{g, s.store, s.aliases}
+	}
+	return predicateGauge{g, s.p}
+}
+
+type predicateCounter struct {
+	Counter
+	p *predicate
+}
+
+func (c predicateCounter) Add(delta uint64) {
+	if c.p.allow() {
+		c.Counter.Add(delta)
+	}
+}
+
+func (c predicateCounter) Inc() {
+	if c.p.allow() {
+		c.Counter.Inc()
+	}
+}
+
+func (c predicateCounter) Set(value uint64) {
+	if c.p.allow() {
+		c.Counter.Set(value)
+	}
+}
+
+type predicateGauge struct {
+	Gauge
+	p *predicate
+}
+
+func (g predicateGauge) Add(delta uint64) {
+	if g.p.allow() {
+		g.Gauge.Add(delta)
+	}
+}
+
+func (g predicateGauge) Sub(delta uint64) {
+	if g.p.allow() {
+		g.Gauge.Sub(delta)
+	}
+}
+
+func (g predicateGauge) Inc() {
+	if g.p.allow() {
+		g.Gauge.Inc()
+	}
+}
+
+func (g predicateGauge) Dec() {
+	if g.p.allow() {
+		g.Gauge.Dec()
+	}
+}
+
+func (g predicateGauge) Set(value uint64) {
+	if g.p.allow() {
+		g.Gauge.Set(value)
+	}
+}
+
+type predicateTimer struct {
+	Timer
+	p *predicate
+}
+
+func (t predicateTimer) AddValue(value float64) {
+	if t.p.allow() {
+		t.Timer.AddValue(value)
+	}
+}
+
+func (t predicateTimer) AllocateSpan() Timespan {
+	if t.p.allow() {
+		return t.Timer.AllocateSpan()
+	}
+	return nullTimespan{}
+}
+
+func (t predicateTimer) RecordDuration(d time.Duration) {
+	if t.p.allow() {
+		t.Timer.RecordDuration(d)
+	}
+}
+
+func (t predicateTimer) Time(f func()) Timer {
+	if t.p.allow() {
+		t.Timer.Time(f)
+	} else {
+		f()
+	}
+	return t
+}
+
+func (t predicateTimer) Start() TimerContext {
+	c := t.Timer.Start() // use the clock of the wrapped Timer
+	c.timer = t
+	return c
+}
+
+// predicateRecorder wraps a Histogram, Distribution or Summary.
+type predicateRecorder struct {
+	recorder interface{ RecordValue(float64) }
+	p        *predicate
+}
+
+func (r predicateRecorder) RecordValue(value float64) {
+	if r.p.allow() {
+		r.recorder.RecordValue(value)
+	}
+}
+
+type predicateGaugeMin struct {
+	GaugeMin
+	p *predicate
+}
+
+func (g predicateGaugeMin) UpdateMin(value uint64) {
+	if g.p.allow() {
+		g.GaugeMin.UpdateMin(value)
+	}
+}
+
+type predicateGaugeMax struct {
+	GaugeMax
+	p *predicate
+}
+
+func (g predicateGaugeMax) UpdateMax(value uint64) {
+	if g.p.allow() {
+		g.GaugeMax.UpdateMax(value)
+	}
+}
+
+type predicateRateGauge struct {
+	RateGauge
+	p *predicate
+}
+
+func (g predicateRateGauge) Add(delta uint64) {
+	if g.p.allow() {
+		g.RateGauge.Add(delta)
+	}
+}
+
+func (g predicateRateGauge) Set(value uint64) {
+	if g.p.allow() {
+		g.RateGauge.Set(value)
+	}
+}
+
+type predicateUpDownCounter struct {
+	UpDownCounter
+	p *predicate
+}
+
+func (c predicateUpDownCounter) Add(delta int64) {
+	if c.p.allow() {
+		c.UpDownCounter.Add(delta)
+	}
+}
+
+func (c predicateUpDownCounter) Inc() {
+	if c.p.allow() {
+		c.UpDownCounter.Inc()
+	}
+}
+
+func (c predicateUpDownCounter) Dec() {
+	if c.p.allow() {
+		c.UpDownCounter.Dec()
+	}
+}
+
+type predicateFloatGauge struct {
+	FloatGauge
+	p *predicate
+}
+
+func (g predicateFloatGauge) Set(value float64) {
+	if g.p.allow() {
+		g.FloatGauge.Set(value)
+	}
+}
+
+func (g predicateFloatGauge) Add(delta float64) {
+	if g.p.allow() {
+		g.FloatGauge.Add(delta)
+	}
+}
+
+func (g predicateFloatGauge) Sub(delta float64) {
+	if g.p.allow() {
+		g.FloatGauge.Sub(delta)
+	}
+}
+
+type predicateWindowedCounter struct {
+	WindowedCounter
+	p *predicate
+}
+
+func (c predicateWindowedCounter) Add(delta uint64) {
+	if c.p.allow() {
+		c.WindowedCounter.Add(delta)
+	}
+}
+
+func (c predicateWindowedCounter) Inc() {
+	if c.p.allow() {
+		c.WindowedCounter.Inc()
+	}
+}
+
+// aliasCounter also updates the Counters registered under its old names.
+type aliasCounter struct {
+	Counter
+	store   *statStore
+	aliases []string
+}
+
+func (c aliasCounter) each(f func(Counter)) {
+	f(c.Counter)
+	for _, name := range c.aliases {
+		if v, ok := c.store.counters.Load(name); ok && v.(Counter) != c.Counter {
+			f(v.(*counter))
+		}
+	}
+}
+
+func (c aliasCounter) Add(delta uint64) {
+	c.each(func(v Counter) { v.Add(delta) })
+}
+
+func (c aliasCounter) Inc() {
+	c.each(func(v Counter) { v.Inc() })
+}
+
+func (c aliasCounter) Set(value uint64) {
+	c.each(func(v Counter) { v.Set(value) })
+}
+
+// aliasGauge also updates the Gauges registered under its old names.
+type aliasGauge struct {
+	Gauge
+	store   *statStore
+	aliases []string
+}
+
+func (g aliasGauge) each(f func(Gauge)) {
+	f(g.Gauge)
+	for _, name := range g.aliases {
+		if v, ok := g.store.gauges.Load(name); ok && v.(Gauge) != g.Gauge {
+			f(v.(*gauge))
+		}
+	}
+}
+
+func (g aliasGauge) Add(delta uint64) {
+	g.each(func(v Gauge) { v.Add(delta) })
+}
+
+func (g aliasGauge) Sub(delta uint64) {
+	g.each(func(v Gauge) { v.Sub(delta) })
+}
+
+func (g aliasGauge) Inc() {
+	g.each(func(v Gauge) { v.Inc() })
+}
+
+func (g aliasGauge) Dec() {
+	g.each(func(v Gauge) { v.Dec() })
+}
+
+func (g aliasGauge) Set(value uint64) {
+	g.each(func(v Gauge) { v.Set(value) })
+}
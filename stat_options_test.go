@@ -0,0 +1,196 @@
+package stats
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lyft/gostats/mock"
+)
+
+func TestWithPredicate(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStore(sink, false)
+
+	var enabled, calls int32
+	scope := store.WithOptions(WithPredicate(func() bool {
+		atomic.AddInt32(&calls, 1)
+		return atomic.LoadInt32(&enabled) == 1
+	})).Scope("s")
+
+	counter := scope.NewCounter("c")
+	gauge := scope.NewGauge("g")
+	timer := scope.NewTimer("t")
+	counter.Inc()
+	counter.Add(2)
+	gauge.Set(3)
+	timer.AddValue(4)
+	timer.Start().Stop()
+	ran := false
+	timer.Time(func() { ran = true })
+	if !ran {
+		t.Error("Timer.Time: function not called while disabled")
+	}
+	store.Flush()
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("predicate called %d times before Flush; want: 1", n)
+	}
+	sink.AssertCounterEquals(t, "s.c", 0)
+	sink.AssertGaugeEquals(t, "s.g", 0)
+	sink.AssertTimerNotExists(t, "s.t")
+
+	// The result of the predicate is kept until the next Flush.
+	counter.Inc()
+	atomic.StoreInt32(&enabled, 1)
+	counter.Inc()
+	store.Flush()
+	sink.AssertCounterEquals(t, "s.c", 0)
+
+	counter.Inc()
+	gauge.Set(3)
+	timer.AddValue(4)
+	store.Flush()
+	sink.AssertCounterEquals(t, "s.c", 1)
+	sink.AssertGaugeEquals(t, "s.g", 3)
+	sink.AssertTimerCallCount(t, "s.t", 1)
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("predicate called %d times; want: 3", n)
+	}
+}
+
+func TestWithPredicateSharedValue(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStore(sink, false)
+
+	store.WithOptions(WithPredicate(func() bool { return false })).NewCounter("c").Inc()
+	store.NewCounter("c").Inc()
+	store.Flush()
+	sink.AssertCounterEquals(t, "c", 1)
+}
+
+func TestWithOptionsNoop(t *testing.T) {
+	store := NewStore(mock.NewSink(), false)
+	if scope := store.WithOptions(); scope != store {
+		t.Errorf("WithOptions() = %T; want the receiver", scope)
+	}
+}
+
+func TestWithPredicateCardinalityLimiter(t *testing.T) {
+	sink := mock.NewSink()
+	limiter := NewCardinalityLimiter(NewStore(sink, false), 0)
+
+	var calls int32
+	counter := limiter.WithOptions(WithPredicate(func() bool {
+		atomic.AddInt32(&calls, 1)
+		return true
+	})).NewCounter("c")
+	counter.Inc()
+	counter.Inc()
+	limiter.Flush()
+	sink.AssertCounterEquals(t, "c", 2)
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("predicate called %d times; want: 1", n)
+	}
+}
+
+func TestWithFlushInterval(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStore(sink, false)
+
+	scope := store.WithOptions(WithFlushInterval(time.Millisecond)).Scope("s")
+	counter := scope.NewCounterWithTags("c", map[string]string{"k": "v"})
+	gauge := scope.NewGauge("g")
+	counter.Add(2)
+	gauge.Set(3)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for sink.Counter("s.c.__k=v") != 2 || sink.GaugeCallCount("s.g") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the interval flush")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// the Store's flushes do not count the increments again
+	store.Flush()
+	sink.AssertCounterEquals(t, "s.c.__k=v", 2)
+
+	// unregistered stats are no longer flushed
+	store.Unregister("s.g")
+	f, _ := store.(*statStore).intervals.Load(time.Millisecond)
+	f.(*intervalFlusher).flush()
+	f.(*intervalFlusher).mu.Lock()
+	n := len(f.(*intervalFlusher).stats)
+	f.(*intervalFlusher).mu.Unlock()
+	if n != 1 {
+		t.Errorf("flushed stats: got: %d want: %d", n, 1)
+	}
+}
+
+func TestWithAlias(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStore(sink, false)
+	legacy := store.NewCounter("legacy.requests")
+	store.NewGaugeWithTags("legacy.size", map[string]string{"k": "v"})
+
+	scope := store.Scope("svc").WithOptions(
+		WithAlias("legacy.requests"),
+		WithAlias("legacy.size.__k=v"),
+	)
+	c := scope.NewCounter("requests")
+	c.Add(2)
+	legacy.Inc()
+	g := scope.NewGauge("size")
+	g.Set(5)
+	g.Dec()
+	store.Flush()
+
+	sink.AssertCounterEquals(t, "svc.requests", 2)
+	sink.AssertCounterEquals(t, "legacy.requests", 3)
+	sink.AssertGaugeEquals(t, "svc.size", 4)
+	sink.AssertGaugeEquals(t, "legacy.size.__k=v", 4)
+
+	// old stats are never created
+	store.Scope("svc").WithOptions(WithAlias("missing")).NewCounter("c").Inc()
+	store.Flush()
+	sink.AssertCounterNotExists(t, "missing")
+	sink.AssertCounterEquals(t, "svc.c", 1)
+}
+
+type helpSinkStub struct {
+	*mock.Sink
+	help map[string]string
+}
+
+func (s *helpSinkStub) SetHelp(name, help string) { s.help[name] = help }
+
+func TestWithHelp(t *testing.T) {
+	sink := &helpSinkStub{Sink: mock.NewSink(), help: make(map[string]string)}
+	store := NewStore(sink, false)
+
+	scope := store.Scope("svc")
+	scope.WithOptions(WithHelp("Requests served.")).NewCounterWithTags("requests", map[string]string{"code": "200"}).Inc()
+	scope.WithOptions(WithHelp("Requests served.")).NewCounterWithTags("requests", map[string]string{"code": "500"}).Inc()
+	store.WithOptions(WithHelp("Open connections.")).NewGauge("conns").Set(1)
+	store.NewTimer("latency")
+
+	expected := []MetricDescription{
+		{Name: "conns", Help: "Open connections."},
+		{Name: "svc.requests", Help: "Requests served."},
+	}
+	descs := store.ListMetricDescriptions()
+	if len(descs) != len(expected) {
+		t.Fatalf("descriptions: got: %v want: %v", descs, expected)
+	}
+	for i, d := range descs {
+		if d != expected[i] {
+			t.Errorf("descriptions[%d]: got: %v want: %v", i, d, expected[i])
+		}
+		if help := sink.help[d.Name]; help != d.Help {
+			t.Errorf("sink help of %q: got: %q want: %q", d.Name, help, d.Help)
+		}
+	}
+	if len(sink.help) != len(expected) {
+		t.Errorf("sink help: got: %v want: %d entries", sink.help, len(expected))
+	}
+}
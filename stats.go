@@ -1,11 +1,18 @@
 package stats
 
 import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/lyft/gostats/internal/intern"
 	tagspkg "github.com/lyft/gostats/internal/tags"
 	logger "github.com/sirupsen/logrus"
 )
@@ -38,14 +45,163 @@ type Store interface {
 	// and flush all the Counters and Gauges registered with it.
 	Flush()
 
+	// FlushAll flushes the Store like Flush, after waiting for flushes that
+	// are in progress. If the Sink implements Syncer it then waits for the
+	// flushed stats to be written and returns any error.
+	FlushAll() error
+
+	// Close stops the flushes started by Start, flushes the Store with
+	// FlushAll and closes the Sink if it implements io.Closer. Stats can
+	// still be used after Close, but they are not flushed and Flush and
+	// FlushAll have no effect. Calling Close more than once has no effect. See StoreOptions.DrainTimeout to keep
+	// accepting stats for a while before closing.
+	Close() error
+
 	// Start a timer for periodic stat Flushes.
 	Start(*time.Ticker)
 
 	// Add a StatGenerator to the Store that programatically generates stats.
 	AddStatGenerator(StatGenerator)
+
+	// AddStatGeneratorWithPriority adds a StatGenerator to the Store with a
+	// priority. Generators are run in decreasing order of priority, and in
+	// the order they were added for the same priority, so that the stats of
+	// a lower priority generator are generated after the stats of the higher
+	// priority ones. AddStatGenerator uses a priority of 0.
+	AddStatGeneratorWithPriority(sg StatGenerator, priority int)
+
+	// SetStatGeneratorConcurrency sets the maximum number of StatGenerators
+	// run concurrently by Flush. If n is 1 or less, the default, they are
+	// run sequentially. See StoreOptions.StatGeneratorTimeout to limit how
+	// long Flush waits for each of them.
+	SetStatGeneratorConcurrency(n int)
+
+	// SetTagsFromContext sets the hook used by the New*Ctx methods to
+	// extract Tags from a context.Context. It is meant to be called once
+	// when the Store is created.
+	SetTagsFromContext(TagsFromContext)
+
+	// Unregister removes the stats with the fully qualified name (including
+	// any scopes and serialized tags) from the Store so that they are no
+	// longer flushed, and reports if any stats were removed. Values that
+	// have not been flushed are discarded. Stats returned by the Store
+	// before it was called can still be used, but are not flushed unless
+	// they are created again.
+	Unregister(name string) bool
+
+	// UnregisterWithTags is like Unregister, but serializes name and tags
+	// to get the stat name.
+	UnregisterWithTags(name string, tags map[string]string) bool
+
+	// UnregisterPrefix removes all stats whose fully qualified name starts
+	// with prefix, as if by Unregister, and returns the number of stats
+	// removed.
+	UnregisterPrefix(prefix string) int
+
+	// ListCounters returns the sorted, fully qualified names (including any
+	// scopes and serialized tags) of the Counters registered with the Store.
+	ListCounters() []string
+
+	// ListGauges returns the sorted, fully qualified names of the Gauges
+	// registered with the Store.
+	ListGauges() []string
+
+	// ListTimers returns the sorted, fully qualified names of the Timers
+	// registered with the Store.
+	ListTimers() []string
+
+	// Snapshot returns a copy of the current values of the Counters and
+	// Gauges registered with the Store without flushing them. Timer values
+	// are not retained by the Store, so they are not included.
+	Snapshot() Snapshot
+
+	// SnapshotHandler returns an http.Handler that serves the Store's
+	// Snapshot as JSON.
+	SnapshotHandler() http.Handler
+
+	// Watch returns a channel that receives a MetricEvent for each value
+	// written to the Store's Sink, sent before the value is written. The
+	// values flushed for Histograms and Summaries are Counters and Gauges. The channel is buffered and never blocks the Store:
+	// events that do not fit are dropped and counted by the
+	// WatchDroppedStatName counter. The channel is closed when ctx is done.
+	Watch(ctx context.Context) <-chan MetricEvent
+
+	// NewChildStore returns a Store whose stats are prefixed by prefix, it
+	// is the same as Scope(prefix).AsStore().
+	NewChildStore(prefix string) Store
+
+	// NewAliasedCounter returns a Counter that updates the Counters with
+	// each of the names, for example to emit both the old and the new name
+	// of a renamed stat. Its value is the value of the first Counter.
+	NewAliasedCounter(names ...string) Counter
+
+	// NewAliasedGauge is like NewAliasedCounter, but for Gauges.
+	NewAliasedGauge(names ...string) Gauge
+
+	// NewAliasedTimer is like NewAliasedCounter, but for Timers. Durations
+	// are measured once and recorded by each Timer.
+	NewAliasedTimer(names ...string) Timer
+
+	// MustNewCounter is like NewCounter but panics if name is empty or
+	// contains chars that are not printable, non-whitespace ASCII. It is
+	// meant for stats created at startup.
+	MustNewCounter(name string) Counter
+
+	// MustNewGauge is like MustNewCounter, but for Gauges.
+	MustNewGauge(name string) Gauge
+
+	// MustNewTimer is like MustNewCounter, but for Timers.
+	MustNewTimer(name string) Timer
+
+	// NewLazyCounter returns a Counter that is only created, and flushed,
+	// after the first call to Add, Inc or Set, which saves the memory of the
+	// Counters that are declared but never used.
+	NewLazyCounter(name string) Counter
+
+	// NewCounterVec returns a CounterVec of the Counters name with the tag
+	// keys labelNames. It panics if the label names are not valid tag keys
+	// or not distinct.
+	NewCounterVec(name string, labelNames []string) CounterVec
+
+	// NewGaugeVec is like NewCounterVec, but for Gauges.
+	NewGaugeVec(name string, labelNames []string) GaugeVec
+
+	// NewTimerVec is like NewCounterVec, but for Timers.
+	NewTimerVec(name string, labelNames []string) TimerVec
+
+	// RecordBatch records each of the values in batch, in order, as if by
+	// calling NewCounterWithTags(name, tags).Add(value) or the matching
+	// Gauge or Timer method. It panics if the Type of a MetricRecord is not
+	// a Counter, Gauge or Timer.
+	RecordBatch(batch []MetricRecord)
+
+	// ListMetricDescriptions returns the help text of the stats set with
+	// WithHelp, sorted by name.
+	ListMetricDescriptions() []MetricDescription
 	Scope
 }
 
+// A MetricDescription is the help text of the stats with a name, see
+// WithHelp.
+type MetricDescription struct {
+	// Name of the stats, including any scopes but without tags.
+	Name string
+	Help string
+}
+
+// A Snapshot is a point-in-time copy of the stats of a Store, keyed by their
+// fully qualified names. Each stat is read atomically, but the Snapshot as a
+// whole is not.
+type Snapshot struct {
+	// Counters holds the values that have not yet been flushed.
+	Counters map[string]uint64
+	Gauges   map[string]uint64
+}
+
+// TagsFromContext returns the Tags carried by a context.Context, such as
+// request or span IDs. The returned map must not be modified.
+type TagsFromContext func(context.Context) map[string]string
+
 // A Scope namespaces Statistics.
 //  store := stats.NewDefaultStore()
 //  scope := stats.Scope("service")
@@ -58,13 +214,47 @@ type Store interface {
 //  // the following counter will be emitted at the stats tree rooted at service.network.
 //  c := networkScope.NewCounter("requests")
 type Scope interface {
-	// Scope creates a subscope.
+	// Scope creates a subscope. The subscope inherits the Tags of its parent.
 	Scope(name string) Scope
 
 	// ScopeWithTags creates a subscope with Tags to a store or scope. All child scopes and metrics
-	// will inherit these tags by default.
+	// will inherit these tags by default. The tags are merged with the Tags of the parent scope,
+	// if a key is present in both the value from tags is used.
 	ScopeWithTags(name string, tags map[string]string) Scope
 
+	// ScopeWithOptions creates a subscope like ScopeWithTags, configured
+	// with opts. See ScopeOptions.
+	ScopeWithOptions(name string, opts ScopeOptions) Scope
+
+	// Parent returns the scope that created the Scope, or nil if the Scope
+	// is the root scope of a Store.
+	Parent() Scope
+
+	// Name returns the name the Scope was created with, for example "bar"
+	// for store.Scope("foo").Scope("bar"). It is empty for the root scope.
+	Name() string
+
+	// FullName returns the prefix of the stats of the Scope, for example
+	// "foo.bar" for store.Scope("foo").Scope("bar").
+	FullName() string
+
+	// AsStore returns a Store whose stats are created by the Scope, so
+	// they are prefixed by its FullName and have its Tags. The other Store
+	// methods, such as Flush or ListCounters, act on the whole Store of
+	// the Scope.
+	AsStore() Store
+
+	// WithOptions returns a Scope with the same name and Tags whose stats are
+	// configured by opts. Stats created by the returned Scope share their
+	// values with the stats of the same name created by other Scopes.
+	WithOptions(opts ...StatOption) Scope
+
+	// WithTag returns a Scope with the same name and the Tags of the Scope
+	// with the tag key=value added, overriding any tag with the same key:
+	//
+	//	store.Scope("http").WithTag("method", "GET").NewCounter("requests")
+	WithTag(key, value string) Scope
+
 	// Store returns the Scope's backing Store.
 	Store() Store
 
@@ -94,9 +284,113 @@ type Scope interface {
 
 	// NewPerInstanceTimer adds a Per instance Timer with optional Tags to a store, or a scope.
 	NewPerInstanceTimer(name string, tags map[string]string) Timer
+
+	// NewCounterCtx adds a Counter with the Tags carried by ctx to a store, or a scope.
+	NewCounterCtx(ctx context.Context, name string) Counter
+
+	// NewCounterWithTagsCtx adds a Counter with Tags and the Tags carried by ctx to a store, or a scope.
+	NewCounterWithTagsCtx(ctx context.Context, name string, tags map[string]string) Counter
+
+	// NewGaugeCtx adds a Gauge with the Tags carried by ctx to a store, or a scope.
+	NewGaugeCtx(ctx context.Context, name string) Gauge
+
+	// NewGaugeWithTagsCtx adds a Gauge with Tags and the Tags carried by ctx to a store, or a scope.
+	NewGaugeWithTagsCtx(ctx context.Context, name string, tags map[string]string) Gauge
+
+	// NewTimerCtx adds a Timer with the Tags carried by ctx to a store, or a scope.
+	NewTimerCtx(ctx context.Context, name string) Timer
+
+	// NewTimerWithTagsCtx adds a Timer with Tags and the Tags carried by ctx to a store, or a scope.
+	NewTimerWithTagsCtx(ctx context.Context, name string, tags map[string]string) Timer
+
+	// NewHistogram adds a Histogram to a store, or a scope.
+	NewHistogram(name string) Histogram
+
+	// NewHistogramWithTags adds a Histogram with Tags to a store, or a scope.
+	NewHistogramWithTags(name string, tags map[string]string) Histogram
+
+	// NewHistogramWithOptions adds a Histogram with Tags and the buckets of
+	// opts to a store, or a scope.
+	NewHistogramWithOptions(name string, tags map[string]string, opts HistogramOptions) Histogram
+
+	// NewDistribution adds a Distribution to a store, or a scope.
+	NewDistribution(name string) Distribution
+
+	// NewDistributionWithTags adds a Distribution with Tags to a store, or a scope.
+	NewDistributionWithTags(name string, tags map[string]string) Distribution
+
+	// NewPerInstanceDistribution adds a Per instance Distribution with optional Tags to a store, or a scope.
+	NewPerInstanceDistribution(name string, tags map[string]string) Distribution
+
+	// NewSummary adds a Summary to a store, or a scope.
+	NewSummary(name string, opts SummaryOptions) Summary
+
+	// NewSummaryWithTags adds a Summary with Tags to a store, or a scope.
+	NewSummaryWithTags(name string, tags map[string]string, opts SummaryOptions) Summary
+
+	// NewMinGauge adds a GaugeMin to a store, or a scope.
+	NewMinGauge(name string) GaugeMin
+
+	// NewMinGaugeWithTags adds a GaugeMin with Tags to a store, or a scope.
+	NewMinGaugeWithTags(name string, tags map[string]string) GaugeMin
+
+	// NewMaxGauge adds a GaugeMax to a store, or a scope.
+	NewMaxGauge(name string) GaugeMax
+
+	// NewMaxGaugeWithTags adds a GaugeMax with Tags to a store, or a scope.
+	NewMaxGaugeWithTags(name string, tags map[string]string) GaugeMax
+
+	// NewRateGauge adds a RateGauge to a store, or a scope.
+	NewRateGauge(name string, opts RateGaugeOptions) RateGauge
+
+	// NewRateGaugeWithTags adds a RateGauge with Tags to a store, or a scope.
+	NewRateGaugeWithTags(name string, tags map[string]string, opts RateGaugeOptions) RateGauge
+
+	// NewUpDownCounter adds an UpDownCounter to a store, or a scope.
+	NewUpDownCounter(name string) UpDownCounter
+
+	// NewUpDownCounterWithTags adds an UpDownCounter with Tags to a store, or a scope.
+	NewUpDownCounterWithTags(name string, tags map[string]string) UpDownCounter
+
+	// NewFloatGauge adds a FloatGauge to a store, or a scope.
+	NewFloatGauge(name string) FloatGauge
+
+	// NewFloatGaugeWithTags adds a FloatGauge with Tags to a store, or a scope.
+	NewFloatGaugeWithTags(name string, tags map[string]string) FloatGauge
+
+	// NewPerInstanceFloatGauge adds a Per instance FloatGauge with optional Tags to a store, or a scope.
+	NewPerInstanceFloatGauge(name string, tags map[string]string) FloatGauge
+
+	// NewSequenceCounter adds a SequenceCounter to a store, or a scope.
+	NewSequenceCounter(name string) SequenceCounter
+
+	// NewSequenceCounterWithTags adds a SequenceCounter with Tags to a store, or a scope.
+	NewSequenceCounterWithTags(name string, tags map[string]string) SequenceCounter
+
+	// NewWindowedCounter adds a WindowedCounter to a store, or a scope.
+	NewWindowedCounter(name string, opts WindowedCounterOptions) WindowedCounter
+
+	// NewWindowedCounterWithTags adds a WindowedCounter with Tags to a store, or a scope.
+	NewWindowedCounterWithTags(name string, tags map[string]string, opts WindowedCounterOptions) WindowedCounter
+
+	// NewEWMAGauge adds a Gauge to a store, or a scope, that flushes the
+	// exponentially weighted moving average of its values instead of its
+	// last value. Each Set, Add or similar call adds the new value to the
+	// average with weight alpha, which must be in (0, 1]: a larger alpha
+	// follows changes faster, an alpha of 1 disables smoothing. The alpha of
+	// the first Gauge created with a name is used. NewEWMAGauge panics if
+	// alpha is out of range.
+	NewEWMAGauge(name string, alpha float64) Gauge
+
+	// NewEWMAGaugeWithTags adds an EWMA Gauge with Tags to a store, or a scope.
+	NewEWMAGaugeWithTags(name string, tags map[string]string, alpha float64) Gauge
 }
 
 // A Counter is an always incrementing stat.
+//
+// A Counter is bound to its stat when it is created: Add and Inc are a
+// single atomic add and do not look up the name or tags again, so hot
+// paths should keep the Counter rather than call NewCounter on each use.
 type Counter interface {
 	// Add increments the Counter by the argument's value.
 	Add(uint64)
@@ -139,6 +433,92 @@ type Gauge interface {
 	Value() uint64
 }
 
+// A GaugeMin is a gauge that holds the minimum value it has observed. It is
+// safe for concurrent use without external synchronization.
+type GaugeMin interface {
+	// UpdateMin sets the GaugeMin to value if value is less than its current
+	// value. A value of math.MaxUint64 is ignored.
+	UpdateMin(value uint64)
+
+	// Value returns the minimum observed value, or 0 if no values have been
+	// observed.
+	Value() uint64
+}
+
+// A GaugeMax is a gauge that holds the maximum value it has observed. It is
+// safe for concurrent use without external synchronization.
+type GaugeMax interface {
+	// UpdateMax sets the GaugeMax to value if value is greater than its
+	// current value.
+	UpdateMax(value uint64)
+
+	// Value returns the maximum observed value.
+	Value() uint64
+}
+
+// A RateGauge accumulates a value and is flushed as a gauge of the rate at
+// which the value changed since the previous flush. The first flush is
+// always 0 since there is no previous value to compare against.
+type RateGauge interface {
+	// Add increments the accumulated value by the argument's value.
+	Add(uint64)
+
+	// Set sets the accumulated value, this is useful when the value is a
+	// cumulative counter maintained elsewhere. If the value is less than it
+	// was at the previous flush it is treated as having been reset to zero.
+	Set(uint64)
+
+	// Value returns the accumulated value.
+	Value() uint64
+}
+
+// An UpDownCounter is a stat that can be incremented and decremented by
+// signed deltas and whose value may be negative. Like a Gauge its current
+// value is flushed, but negative values are only preserved by sinks that
+// implement UpDownCounterSink.
+type UpDownCounter interface {
+	// Add adds delta, which may be negative, to the UpDownCounter.
+	Add(delta int64)
+
+	// Inc increments the UpDownCounter by 1.
+	Inc()
+
+	// Dec decrements the UpDownCounter by 1.
+	Dec()
+
+	// Value returns the current value of the UpDownCounter.
+	Value() int64
+}
+
+// A SequenceCounter is a Counter that returns its new value each time it is
+// incremented, which makes it usable to number events or generate IDs that
+// are unique within the process. It is flushed like a Counter and shares its
+// value with the Counter of the same name, so that Counter must not be Set.
+type SequenceCounter interface {
+	// Next increments the SequenceCounter by 1 and returns its new value.
+	Next() uint64
+
+	// Value returns the current value of the SequenceCounter.
+	Value() uint64
+}
+
+// A FloatGauge is a Gauge with a float64 value. Its value is only
+// preserved by sinks that implement FloatGaugeSink, other sinks receive it
+// as a Gauge truncated to an integer, with negative values flushed as 0.
+type FloatGauge interface {
+	// Set sets the FloatGauge to a value.
+	Set(value float64)
+
+	// Add adds delta to the FloatGauge.
+	Add(delta float64)
+
+	// Sub subtracts delta from the FloatGauge.
+	Sub(delta float64)
+
+	// Value returns the current value of the FloatGauge.
+	Value() float64
+}
+
 // A Timer is used to flush timing statistics.
 type Timer interface {
 	// AddValue flushs the timer with the argument's value.
@@ -146,6 +526,75 @@ type Timer interface {
 
 	// AllocateSpan allocates a Timespan.
 	AllocateSpan() Timespan
+
+	// RecordDuration flushes the timer with duration d, using the same unit
+	// as Timespan.
+	RecordDuration(d time.Duration)
+
+	// Time calls f, records how long it took to return and returns the
+	// Timer.
+	Time(f func()) Timer
+
+	// Start returns a TimerContext that records the time elapsed between
+	// the call to Start and TimerContext.Stop:
+	//
+	//	defer store.NewTimer("rpc.latency").Start().Stop()
+	//
+	Start() TimerContext
+}
+
+// A TimerContext records the time elapsed since it was started by
+// Timer.Start. Unlike a Timespan it is not allocated on the heap.
+type TimerContext struct {
+	timer Timer
+	start time.Time
+	clock Clock
+}
+
+// Stop records the elapsed time with the Timer that started the
+// TimerContext and returns it. Stop is a no-op on the zero TimerContext.
+func (c TimerContext) Stop() time.Duration {
+	if c.timer == nil {
+		return 0
+	}
+	d := clockNow(c.clock).Sub(c.start)
+	c.timer.RecordDuration(d)
+	return d
+}
+
+// A Histogram records the distribution of observed values.
+//
+// Observations are counted in buckets with fixed upper bounds, see
+// HistogramOptions. When flushed a Histogram emits one Counter per bucket,
+// with the bucket's upper bound in the "le" tag, under the name
+// "<name>.bucket" as well as the Counter "<name>.count" and the FloatGauge
+// "<name>.sum" of all the observations. Bucket Counters are cumulative, that
+// is a bucket counts all observations less than or equal to its upper bound,
+// and the last bucket, with the "le" tag "+Inf", counts every observation.
+type Histogram interface {
+	// RecordValue records an observation in the Histogram.
+	RecordValue(float64)
+}
+
+// A Distribution is used to flush observations whose percentiles are
+// computed by the backend. Observations are flushed to Sinks that do not
+// implement DistributionSink as Gauges.
+type Distribution interface {
+	// RecordValue flushes the distribution with the argument's value.
+	RecordValue(float64)
+}
+
+// A Summary computes quantiles of its observations before they are flushed.
+// This is useful for backends that cannot compute percentiles.
+//
+// When flushed a Summary emits one FloatGauge per quantile, with the
+// quantile in the "quantile" tag, as well as the Counter "<name>.count" and
+// the FloatGauge "<name>.sum" of all the observations. Quantiles are
+// computed over the most recent observations recorded in the
+// SummaryOptions.MaxAge window, up to SummaryOptions.MaxSamples of them.
+type Summary interface {
+	// RecordValue records an observation in the Summary.
+	RecordValue(float64)
 }
 
 // A Timespan is used to measure spans of time.
@@ -177,10 +626,38 @@ type StatGenerator interface {
 	GenerateStats()
 }
 
+// An ErrorAwareStatGenerator is a StatGenerator that reports if it failed to
+// generate its stats, for example because their data source is unavailable.
+// The Store calls TryGenerateStats instead of GenerateStats, its errors are
+// passed to StoreOptions.OnError, or logged if it is nil.
+type ErrorAwareStatGenerator interface {
+	StatGenerator
+
+	// TryGenerateStats runs the StatGenerator to generate Stats. If it
+	// returns an error the stats of the generator should be left unchanged
+	// for this flush.
+	TryGenerateStats() error
+}
+
+// A scopedStatGenerator is a StatGenerator that creates its stats in the
+// Scope of the Store it is added to.
+type scopedStatGenerator interface {
+	StatGenerator
+	bindScope(Scope)
+}
+
+// bindStatGenerator binds statGenerator to scope if it is a
+// scopedStatGenerator that is not bound yet.
+func bindStatGenerator(statGenerator StatGenerator, scope Scope) {
+	if g, ok := statGenerator.(scopedStatGenerator); ok {
+		g.bindScope(scope)
+	}
+}
+
 // NewStore returns an Empty store that flushes to Sink passed as an argument.
 // Note: the export argument is unused.
-func NewStore(sink Sink, _ bool) Store {
-	return &statStore{sink: sink}
+func NewStore(sink Sink, export bool) Store {
+	return NewStoreWithOptions(sink, StoreOptions{})
 }
 
 // NewDefaultStore returns a Store with a TCP statsd sink, and a running flush timer.
@@ -233,6 +710,18 @@ func (c *counter) latch() uint64 {
 	return value - lastSent
 }
 
+type sequenceCounter struct {
+	c *counter
+}
+
+func (s sequenceCounter) Next() uint64 {
+	return atomic.AddUint64(&s.c.currentValue, 1)
+}
+
+func (s sequenceCounter) Value() uint64 {
+	return s.c.Value()
+}
+
 type gauge struct {
 	value uint64
 }
@@ -265,266 +754,1888 @@ func (c *gauge) Value() uint64 {
 	return atomic.LoadUint64(&c.value)
 }
 
-type timer struct {
-	name string
-	sink Sink
+type upDownCounter struct {
+	value int64
 }
 
-func (t *timer) time(dur time.Duration) {
-	t.sink.FlushTimer(t.name, float64(dur/time.Microsecond))
+func (c *upDownCounter) Add(delta int64) {
+	atomic.AddInt64(&c.value, delta)
 }
 
-func (t *timer) AddValue(value float64) {
-	t.sink.FlushTimer(t.name, value)
+func (c *upDownCounter) Inc() {
+	atomic.AddInt64(&c.value, 1)
 }
 
-func (t *timer) AllocateSpan() Timespan {
-	return &timespan{timer: t, start: time.Now()}
+func (c *upDownCounter) Dec() {
+	atomic.AddInt64(&c.value, -1)
 }
 
-type timespan struct {
-	timer *timer
-	start time.Time
+func (c *upDownCounter) Value() int64 {
+	return atomic.LoadInt64(&c.value)
 }
 
-func (ts *timespan) Complete() time.Duration {
-	d := time.Now().Sub(ts.start)
-	ts.timer.time(d)
-	return d
+// floatGauge holds the bits of its float64 value.
+type floatGauge struct {
+	bits uint64
 }
 
-func (ts *timespan) CompleteWithDuration(value time.Duration) {
-	ts.timer.time(value)
+func (g *floatGauge) Set(value float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(value))
 }
 
-type statStore struct {
-	counters sync.Map
-	gauges   sync.Map
-	timers   sync.Map
-
-	genMtx         sync.RWMutex
-	statGenerators []StatGenerator
+func (g *floatGauge) Add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&g.bits)
+		v := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&g.bits, old, v) {
+			return
+		}
+	}
+}
 
-	sink Sink
+func (g *floatGauge) Sub(delta float64) {
+	g.Add(-delta)
 }
 
-func (s *statStore) Flush() {
-	s.genMtx.RLock()
-	for _, g := range s.statGenerators {
-		g.GenerateStats()
-	}
-	s.genMtx.RUnlock()
+func (g *floatGauge) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
 
-	s.counters.Range(func(key, v interface{}) bool {
-		s.sink.FlushCounter(key.(string), v.(*counter).latch())
-		return true
-	})
+// minGauge holds math.MaxUint64 until the first value is observed and is
+// not flushed until then.
+type minGauge struct {
+	value uint64
+}
 
-	s.gauges.Range(func(key, v interface{}) bool {
-		s.sink.FlushGauge(key.(string), v.(*gauge).Value())
-		return true
-	})
+func newMinGauge() *minGauge {
+	return &minGauge{value: math.MaxUint64}
+}
 
-	flushableSink, ok := s.sink.(FlushableSink)
-	if ok {
-		flushableSink.Flush()
+func (g *minGauge) UpdateMin(value uint64) {
+	for {
+		cur := atomic.LoadUint64(&g.value)
+		if value >= cur || atomic.CompareAndSwapUint64(&g.value, cur, value) {
+			return
+		}
 	}
 }
 
-func (s *statStore) Start(ticker *time.Ticker) {
-	s.run(ticker)
+func (g *minGauge) Value() uint64 {
+	if v := atomic.LoadUint64(&g.value); v != math.MaxUint64 {
+		return v
+	}
+	return 0
 }
 
-func (s *statStore) AddStatGenerator(statGenerator StatGenerator) {
-	s.genMtx.Lock()
-	s.statGenerators = append(s.statGenerators, statGenerator)
-	s.genMtx.Unlock()
+type maxGauge struct {
+	value uint64
 }
 
-func (s *statStore) run(ticker *time.Ticker) {
-	for range ticker.C {
-		s.Flush()
+func (g *maxGauge) UpdateMax(value uint64) {
+	for {
+		cur := atomic.LoadUint64(&g.value)
+		if value <= cur || atomic.CompareAndSwapUint64(&g.value, cur, value) {
+			return
+		}
 	}
 }
 
-func (s *statStore) Store() Store {
-	return s
+func (g *maxGauge) Value() uint64 {
+	return atomic.LoadUint64(&g.value)
 }
 
-func (s *statStore) Scope(name string) Scope {
-	return newSubScope(s, name, nil)
+// RateGaugeOptions configures a RateGauge.
+type RateGaugeOptions struct {
+	// Per is the unit of time of the flushed rate, for example a Per of
+	// time.Minute flushes the rate per minute. If zero the rate is per
+	// second.
+	Per time.Duration
 }
 
-func (s *statStore) ScopeWithTags(name string, tags map[string]string) Scope {
-	return newSubScope(s, name, tags)
+type rateGauge struct {
+	value uint64 // atomic
+	per   float64
+
+	mu        sync.Mutex
+	prevValue uint64
+	prevTime  time.Time
 }
 
-func (s *statStore) newCounter(serializedName string) *counter {
-	if v, ok := s.counters.Load(serializedName); ok {
-		return v.(*counter)
-	}
-	c := new(counter)
-	if v, loaded := s.counters.LoadOrStore(serializedName, c); loaded {
-		return v.(*counter)
+func newRateGauge(opts RateGaugeOptions) *rateGauge {
+	per := opts.Per
+	if per <= 0 {
+		per = time.Second
 	}
-	return c
+	return &rateGauge{per: per.Seconds()}
 }
 
-func (s *statStore) NewCounter(name string) Counter {
-	return s.newCounter(name)
+func (g *rateGauge) Add(delta uint64) {
+	atomic.AddUint64(&g.value, delta)
 }
 
-func (s *statStore) NewCounterWithTags(name string, tags map[string]string) Counter {
-	return s.newCounter(tagspkg.SerializeTags(name, tags))
+func (g *rateGauge) Set(value uint64) {
+	atomic.StoreUint64(&g.value, value)
 }
 
-func (s *statStore) newCounterWithTagSet(name string, tags tagspkg.TagSet) Counter {
-	return s.newCounter(tags.Serialize(name))
+func (g *rateGauge) Value() uint64 {
+	return atomic.LoadUint64(&g.value)
 }
 
-var emptyPerInstanceTags = map[string]string{"_f": "i"}
+// rate returns the rate of change since the previous call to rate.
+func (g *rateGauge) rate(now time.Time) uint64 {
+	cur := g.Value()
 
-func (s *statStore) NewPerInstanceCounter(name string, tags map[string]string) Counter {
-	if len(tags) == 0 {
-		return s.NewCounterWithTags(name, emptyPerInstanceTags)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	prevValue, prevTime := g.prevValue, g.prevTime
+	g.prevValue, g.prevTime = cur, now
+
+	elapsed := now.Sub(prevTime).Seconds()
+	if prevTime.IsZero() || elapsed <= 0 {
+		return 0
 	}
-	if _, found := tags["_f"]; found {
-		return s.NewCounterWithTags(name, tags)
+	delta := cur
+	if cur >= prevValue {
+		delta = cur - prevValue
 	}
-	return s.newCounterWithTagSet(name, tagspkg.TagSet(nil).MergePerInstanceTags(tags))
+	return uint64(math.Round(float64(delta) / elapsed * g.per))
 }
 
-func (s *statStore) newGauge(serializedName string) *gauge {
-	if v, ok := s.gauges.Load(serializedName); ok {
-		return v.(*gauge)
-	}
-	g := new(gauge)
-	if v, loaded := s.gauges.LoadOrStore(serializedName, g); loaded {
-		return v.(*gauge)
-	}
-	return g
+type timer struct {
+	name  string
+	sink  Sink
+	watch *watchers
+	clock Clock
 }
 
-func (s *statStore) NewGauge(name string) Gauge {
-	return s.newGauge(name)
+func (t *timer) time(dur time.Duration) {
+	t.AddValue(float64(dur / time.Microsecond))
 }
 
-func (s *statStore) NewGaugeWithTags(name string, tags map[string]string) Gauge {
-	return s.newGauge(tagspkg.SerializeTags(name, tags))
+func (t *timer) AddValue(value float64) {
+	t.watch.send(MetricTypeTimer, t.name, value)
+	t.sink.FlushTimer(t.name, value)
 }
 
-func (s *statStore) newGaugeWithTagSet(name string, tags tagspkg.TagSet) Gauge {
-	return s.newGauge(tags.Serialize(name))
+func (t *timer) AllocateSpan() Timespan {
+	return &timespan{timer: t, start: clockNow(t.clock)}
 }
 
-func (s *statStore) NewPerInstanceGauge(name string, tags map[string]string) Gauge {
-	if len(tags) == 0 {
-		return s.NewGaugeWithTags(name, emptyPerInstanceTags)
-	}
-	if _, found := tags["_f"]; found {
-		return s.NewGaugeWithTags(name, tags)
-	}
-	return s.newGaugeWithTagSet(name, tagspkg.TagSet(nil).MergePerInstanceTags(tags))
+func (t *timer) Start() TimerContext {
+	return TimerContext{timer: t, start: clockNow(t.clock), clock: t.clock}
 }
 
-func (s *statStore) newTimer(serializedName string) *timer {
-	if v, ok := s.timers.Load(serializedName); ok {
-		return v.(*timer)
-	}
-	t := &timer{name: serializedName, sink: s.sink}
-	if v, loaded := s.timers.LoadOrStore(serializedName, t); loaded {
-		return v.(*timer)
-	}
+func (t *timer) RecordDuration(d time.Duration) {
+	t.time(d)
+}
+
+func (t *timer) Time(f func()) Timer {
+	start := clockNow(t.clock)
+	f()
+	t.time(clockNow(t.clock).Sub(start))
 	return t
 }
 
-func (s *statStore) NewTimer(name string) Timer {
-	return s.newTimer(name)
+type timespan struct {
+	timer *timer
+	start time.Time
 }
 
-func (s *statStore) NewTimerWithTags(name string, tags map[string]string) Timer {
-	return s.newTimer(tagspkg.SerializeTags(name, tags))
+func (ts *timespan) Complete() time.Duration {
+	d := clockNow(ts.timer.clock).Sub(ts.start)
+	ts.timer.time(d)
+	return d
 }
 
-func (s *statStore) newTimerWithTagSet(name string, tags tagspkg.TagSet) Timer {
-	return s.newTimer(tags.Serialize(name))
+func (ts *timespan) CompleteWithDuration(value time.Duration) {
+	ts.timer.time(value)
 }
 
-func (s *statStore) NewPerInstanceTimer(name string, tags map[string]string) Timer {
-	if len(tags) == 0 {
-		return s.NewTimerWithTags(name, emptyPerInstanceTags)
-	}
-	if _, found := tags["_f"]; found {
-		return s.NewTimerWithTags(name, tags)
-	}
-	return s.newTimerWithTagSet(name, tagspkg.TagSet(nil).MergePerInstanceTags(tags))
+type distribution struct {
+	name  string
+	sink  DistributionSink
+	watch *watchers
 }
 
-type subScope struct {
-	registry *statStore
-	name     string
-	tags     tagspkg.TagSet // read-only and may be shared by multiple subScopes
+func (d *distribution) RecordValue(value float64) {
+	d.watch.send(MetricTypeDistribution, d.name, value)
+	d.sink.FlushDistribution(d.name, value)
 }
 
-func newSubScope(registry *statStore, name string, tags map[string]string) *subScope {
-	return &subScope{registry: registry, name: name, tags: tagspkg.NewTagSet(tags)}
+// defaultHistogramBuckets are the upper bounds, in ascending order, of the
+// buckets used by Histograms.
+var defaultHistogramBuckets = []float64{
+	1, 2, 4, 8, 16, 32, 64, 128, 256, 512, 1024, 2048, 4096, 8192, 16384, 32768,
 }
 
-func (s *subScope) Scope(name string) Scope {
-	return s.ScopeWithTags(name, nil)
+// HistogramOptions configures a Histogram.
+type HistogramOptions struct {
+	// Buckets are the upper bounds of the buckets of the Histogram, they
+	// are sorted and duplicates, NaN and infinite values are ignored. The
+	// "+Inf" bucket is always added. If empty the powers of two from 1 to
+	// 32768 are used.
+	Buckets []float64
 }
 
-func (s *subScope) ScopeWithTags(name string, tags map[string]string) Scope {
-	return &subScope{
-		registry: s.registry,
-		name:     joinScopes(s.name, name),
-		tags:     s.tags.MergeTags(tags),
+// bounds returns the sorted finite upper bounds of opts.Buckets.
+func (opts HistogramOptions) bounds() []float64 {
+	bounds := make([]float64, 0, len(opts.Buckets))
+	for _, b := range opts.Buckets {
+		if !math.IsNaN(b) && !math.IsInf(b, 0) {
+			bounds = append(bounds, b)
+		}
 	}
+	if len(bounds) == 0 {
+		return defaultHistogramBuckets
+	}
+	sort.Float64s(bounds)
+	n := 1
+	for _, b := range bounds[1:] {
+		if b != bounds[n-1] {
+			bounds[n] = b
+			n++
+		}
+	}
+	return bounds[:n]
 }
 
-func (s *subScope) Store() Store {
-	return s.registry
+// A floatSum is the sum of float64 values, it is flushed as a FloatGauge.
+type floatSum struct {
+	sum uint64 // float64 bits
 }
 
-func (s *subScope) NewCounter(name string) Counter {
-	return s.NewCounterWithTags(name, nil)
+// add adds value to the sum, NaN values are ignored.
+func (s *floatSum) add(value float64) {
+	if math.IsNaN(value) {
+		return
+	}
+	for {
+		cur := atomic.LoadUint64(&s.sum)
+		nxt := math.Float64bits(math.Float64frombits(cur) + value)
+		if atomic.CompareAndSwapUint64(&s.sum, cur, nxt) {
+			return
+		}
+	}
 }
 
-func (s *subScope) NewCounterWithTags(name string, tags map[string]string) Counter {
-	return s.registry.newCounterWithTagSet(joinScopes(s.name, name), s.tags.MergeTags(tags))
+func (s *floatSum) value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&s.sum))
 }
 
-func (s *subScope) NewPerInstanceCounter(name string, tags map[string]string) Counter {
-	return s.registry.newCounterWithTagSet(joinScopes(s.name, name),
-		s.tags.MergePerInstanceTags(tags))
+type histogram struct {
+	bounds      []float64
+	buckets     []counter // buckets[i] counts observations in (bounds[i-1], bounds[i]], the last one those above every bound
+	bucketNames []string
+	count       counter
+	countName   string
+	sum         floatSum
+	sumName     string
 }
 
-func (s *subScope) NewGauge(name string) Gauge {
-	return s.NewGaugeWithTags(name, nil)
+func newHistogram(name string, tags tagspkg.TagSet, bounds []float64) *histogram {
+	h := &histogram{
+		bounds:      bounds,
+		buckets:     make([]counter, len(bounds)+1),
+		bucketNames: make([]string, len(bounds)+1),
+		countName:   tags.Serialize(name + ".count"),
+		sumName:     tags.Serialize(name + ".sum"),
+	}
+	for i, b := range bounds {
+		le := tagspkg.NewTag("le", strconv.FormatFloat(b, 'f', -1, 64))
+		h.bucketNames[i] = tags.Insert(le).Serialize(name + ".bucket")
+	}
+	h.bucketNames[len(bounds)] = tags.Insert(tagspkg.NewTag("le", "+Inf")).Serialize(name + ".bucket")
+	return h
 }
 
-func (s *subScope) NewGaugeWithTags(name string, tags map[string]string) Gauge {
-	return s.registry.newGaugeWithTagSet(joinScopes(s.name, name), s.tags.MergeTags(tags))
+func (h *histogram) RecordValue(value float64) {
+	h.buckets[sort.SearchFloat64s(h.bounds, value)].Inc()
+	h.count.Inc()
+	h.sum.add(value)
 }
 
-func (s *subScope) NewPerInstanceGauge(name string, tags map[string]string) Gauge {
-	return s.registry.newGaugeWithTagSet(joinScopes(s.name, name),
-		s.tags.MergePerInstanceTags(tags))
+func (h *histogram) flush(sink Sink) {
+	var cumulative uint64
+	for i := range h.buckets {
+		cumulative += h.buckets[i].latch()
+		sink.FlushCounter(h.bucketNames[i], cumulative)
+	}
+	sink.FlushCounter(h.countName, h.count.latch())
+	newFloatGaugeSink(sink).FlushFloatGauge(h.sumName, h.sum.value())
 }
 
-func (s *subScope) NewTimer(name string) Timer {
-	return s.NewTimerWithTags(name, nil)
-}
+const (
+	defaultSummaryMaxAge     = 10 * time.Minute
+	defaultSummaryMaxSamples = 1024
+)
 
-func (s *subScope) NewTimerWithTags(name string, tags map[string]string) Timer {
+var defaultSummaryQuantiles = []float64{0.5, 0.9, 0.99}
+
+// SummaryOptions configures a Summary.
+type SummaryOptions struct {
+	// Quantiles are the quantiles, in the range [0, 1], computed by the
+	// Summary. If empty the 0.5, 0.9 and 0.99 quantiles are computed.
+	// Quantiles outside of the range [0, 1] are ignored.
+	Quantiles []float64
+
+	// MaxAge is the duration for which observations are kept, older
+	// observations are evicted and no longer used to compute quantiles.
+	// If zero observations are kept for 10 minutes.
+	MaxAge time.Duration
+
+	// MaxSamples is the maximum number of observations kept, once it is
+	// reached each new observation evicts the oldest one. If zero at most
+	// 1024 observations are kept.
+	MaxSamples int
+}
+
+type summarySample struct {
+	value float64
+	time  time.Time
+}
+
+// summaryRing is a ring buffer of samples ordered by time, it grows up to
+// its capacity.
+type summaryRing struct {
+	samples []summarySample
+	head    int // index of the oldest sample
+	n       int // number of samples
+	max     int
+}
+
+func (r *summaryRing) push(p summarySample) {
+	if r.n < len(r.samples) {
+		r.samples[(r.head+r.n)%len(r.samples)] = p
+		r.n++
+		return
+	}
+	if len(r.samples) == r.max {
+		// overwrite the oldest sample
+		r.samples[r.head] = p
+		r.head = (r.head + 1) % len(r.samples)
+		return
+	}
+	size := 2 * len(r.samples)
+	if size == 0 {
+		size = 16
+	}
+	if size > r.max {
+		size = r.max
+	}
+	samples := make([]summarySample, size)
+	for i := 0; i < r.n; i++ {
+		samples[i] = r.samples[(r.head+i)%len(r.samples)]
+	}
+	samples[r.n] = p
+	r.samples, r.head = samples, 0
+	r.n++
+}
+
+func (r *summaryRing) at(i int) summarySample {
+	return r.samples[(r.head+i)%len(r.samples)]
+}
+
+// evict removes the samples recorded at or before cutoff.
+func (r *summaryRing) evict(cutoff time.Time) {
+	for r.n > 0 && !r.samples[r.head].time.After(cutoff) {
+		r.head = (r.head + 1) % len(r.samples)
+		r.n--
+	}
+}
+
+type summary struct {
+	quantiles     []float64
+	quantileNames []string
+	maxAge        time.Duration
+	count         counter
+	countName     string
+	sum           floatSum
+	sumName       string
+	clock         Clock
+
+	mu      sync.Mutex
+	samples summaryRing
+}
+
+func newSummary(name string, tags tagspkg.TagSet, opts SummaryOptions) *summary {
+	quantiles := opts.Quantiles
+	if len(quantiles) == 0 {
+		quantiles = defaultSummaryQuantiles
+	}
+	maxAge := opts.MaxAge
+	if maxAge <= 0 {
+		maxAge = defaultSummaryMaxAge
+	}
+	maxSamples := opts.MaxSamples
+	if maxSamples <= 0 {
+		maxSamples = defaultSummaryMaxSamples
+	}
+	s := &summary{
+		samples:   summaryRing{max: maxSamples},
+		maxAge:    maxAge,
+		countName: tags.Serialize(name + ".count"),
+		sumName:   tags.Serialize(name + ".sum"),
+	}
+	for _, q := range quantiles {
+		if q < 0 || q > 1 {
+			continue
+		}
+		tag := tagspkg.NewTag("quantile", strconv.FormatFloat(q, 'f', -1, 64))
+		s.quantiles = append(s.quantiles, q)
+		s.quantileNames = append(s.quantileNames, tags.Insert(tag).Serialize(name))
+	}
+	return s
+}
+
+func (s *summary) RecordValue(value float64) {
+	s.count.Inc()
+	s.sum.add(value)
+	s.mu.Lock()
+	s.samples.push(summarySample{value: value, time: clockNow(s.clock)})
+	s.mu.Unlock()
+}
+
+// summaryValuesPool holds the slices the summaries sort their values in
+// while flushing.
+var summaryValuesPool = sync.Pool{
+	New: func() interface{} {
+		return new([]float64)
+	},
+}
+
+// values evicts expired samples and appends the remaining values to buf in
+// ascending order.
+func (s *summary) values(buf []float64) []float64 {
+	cutoff := clockNow(s.clock).Add(-s.maxAge)
+
+	s.mu.Lock()
+	s.samples.evict(cutoff)
+	values := buf[:0]
+	for i := 0; i < s.samples.n; i++ {
+		values = append(values, s.samples.at(i).value)
+	}
+	s.mu.Unlock()
+
+	sort.Float64s(values)
+	return values
+}
+
+func (s *summary) flush(sink Sink) {
+	buf := summaryValuesPool.Get().(*[]float64)
+	if values := s.values(*buf); len(values) != 0 {
+		fs := newFloatGaugeSink(sink)
+		for i, q := range s.quantiles {
+			// nearest-rank quantile
+			n := int(math.Ceil(q*float64(len(values)))) - 1
+			if n < 0 {
+				n = 0
+			}
+			fs.FlushFloatGauge(s.quantileNames[i], values[n])
+		}
+		*buf = values
+	}
+	summaryValuesPool.Put(buf)
+	sink.FlushCounter(s.countName, s.count.latch())
+	newFloatGaugeSink(sink).FlushFloatGauge(s.sumName, s.sum.value())
+}
+
+type statStore struct {
+	flushes uint64 // number of calls to Flush, first for 64-bit alignment
+
+	counters      sync.Map
+	gauges        sync.Map
+	timers        sync.Map
+	histograms    sync.Map
+	distributions sync.Map
+	summaries     sync.Map
+	minGauges     sync.Map
+	maxGauges     sync.Map
+	rateGauges    sync.Map
+	upDowns       sync.Map
+	floatGauges   sync.Map
+	windowed      sync.Map
+	ewmaGauges    sync.Map
+
+	genMtx         sync.RWMutex
+	statGenerators []*generatorEntry
+	genConcurrency int32         // see SetStatGeneratorConcurrency
+	genTimeout     time.Duration // per generator, zero for no timeout
+
+	tagsFromContext atomic.Value // TagsFromContext
+
+	watch watchers
+
+	intervals sync.Map // time.Duration => *intervalFlusher
+
+	help sync.Map // stat name => help text
+
+	flushMu sync.Mutex // held while flushing
+	dedup   bool       // see StoreOptions.DeduplicateFlushes
+
+	doneOnce  sync.Once
+	done      chan struct{} // closed by Close, see doneChan
+	closeOnce sync.Once
+
+	state        int32        // atomic, a storeState
+	drainTimeout time.Duration
+	loopMu       sync.RWMutex // read locked by the running flush loops
+
+	clock Clock // nil for the system clock
+
+	onError func(error) // errors of the flushes started by Start
+
+	perInstance string // default value of the per-instance tag, "i" if empty
+
+	onCreate func(kind, name string, tags map[string]string)
+
+	limiter atomic.Value // *CardinalityLimiter, see NewCardinalityLimiter
+
+	normalize  func(key, value string) string // see StoreOptions.NormalizeTagValue
+	tagAliases map[string]string              // see StoreOptions.TagKeyAliases
+	renamed    sync.Map                       // stat name + "\x00" + old tag key => struct{}
+
+	sink Sink
+}
+
+// storeState is the shutdown state of a statStore, it only moves forward:
+// storeRunning -> storeDraining -> storeClosed.
+type storeState int32
+
+const (
+	storeRunning  storeState = iota
+	storeDraining            // Close is waiting for the flush loops
+	storeClosed              // the final flush has started
+)
+
+func (s *statStore) setState(state storeState) {
+	atomic.StoreInt32(&s.state, int32(state))
+}
+
+// closed reports if the Store is closed, the stats created by a closed Store
+// are not registered and never flushed. It is called every time a stat is
+// created, so it does not lock.
+func (s *statStore) closed() bool {
+	return storeState(atomic.LoadInt32(&s.state)) == storeClosed
+}
+
+func (s *statStore) Flush() {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	if s.closed() { // the Sink may be closed
+		return
+	}
+	s.flushStats()
+	if flushableSink, ok := s.sink.(FlushableSink); ok {
+		flushableSink.Flush()
+	}
+}
+
+func (s *statStore) FlushAll() error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	if s.closed() { // the Sink may be closed
+		return nil
+	}
+	return s.flushAllLocked()
+}
+
+// flushAllLocked flushes the stats and syncs the sink, s.flushMu must be
+// held.
+func (s *statStore) flushAllLocked() error {
+	s.flushStats()
+	return syncSink(s.sink)
+}
+
+func (s *statStore) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		if s.drainTimeout > 0 {
+			s.setState(storeDraining)
+		}
+		close(s.doneChan()) // stops the flush loops
+		if s.drainTimeout > 0 {
+			s.waitFlushLoops(s.drainTimeout)
+		}
+		s.setState(storeClosed)
+		s.flushMu.Lock()
+		err = s.flushAllLocked()
+		s.flushMu.Unlock()
+		if c, ok := s.sink.(io.Closer); ok {
+			if cerr := c.Close(); err == nil {
+				err = cerr
+			}
+		}
+	})
+	return err
+}
+
+// waitFlushLoops waits for the flush loops, of Start and the interval
+// flushers, to return after doneChan is closed, so that the flushes they
+// have in progress are done. It waits at most timeout.
+func (s *statStore) waitFlushLoops(timeout time.Duration) {
+	stopped := make(chan struct{})
+	go func() {
+		s.loopMu.Lock()
+		s.loopMu.Unlock()
+		close(stopped)
+	}()
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-stopped:
+	case <-t.C:
+	}
+}
+
+// doneChan returns the channel closed by Close.
+func (s *statStore) doneChan() chan struct{} {
+	s.doneOnce.Do(func() { s.done = make(chan struct{}) })
+	return s.done
+}
+
+// flushStats flushes the stats to the sink, without flushing the sink.
+func (s *statStore) flushStats() {
+	atomic.AddUint64(&s.flushes, 1)
+
+	s.generateStats()
+
+	watched := watchedSink{FloatGaugeSink: newFloatGaugeSink(s.sink), watch: &s.watch}
+	var sink FloatGaugeSink = watched
+	flushCounter, flushGauge, flushFloat := watched.FlushCounter, watched.FlushGauge, watched.FlushFloatGauge
+	var dedup *dedupSink
+	if s.dedup {
+		dedup = newDedupSink(s.sink)
+		sink = dedup
+		flushCounter, flushGauge, flushFloat = dedup.FlushCounter, dedup.FlushGauge, dedup.FlushFloatGauge
+	}
+
+	s.counters.Range(func(key, v interface{}) bool {
+		flushCounter(key.(string), v.(*counter).latch())
+		return true
+	})
+
+	s.gauges.Range(func(key, v interface{}) bool {
+		flushGauge(key.(string), v.(*gauge).Value())
+		return true
+	})
+
+	upDownSink := newUpDownCounterSink(s.sink)
+	s.upDowns.Range(func(key, v interface{}) bool {
+		name, value := key.(string), v.(*upDownCounter).Value()
+		s.watch.send(MetricTypeGauge, name, float64(value))
+		upDownSink.FlushUpDownCounter(name, value)
+		return true
+	})
+
+	s.floatGauges.Range(func(key, v interface{}) bool {
+		flushFloat(key.(string), v.(*floatGauge).Value())
+		return true
+	})
+
+	s.windowed.Range(func(_, v interface{}) bool {
+		c := v.(*windowedCounter)
+		total := c.Total()
+		rate := float64(total) / float64(len(c.buckets))
+		flushFloat(c.rateName, rate)
+		flushGauge(c.totalName, total)
+		return true
+	})
+
+	s.minGauges.Range(func(key, v interface{}) bool {
+		if u := atomic.LoadUint64(&v.(*minGauge).value); u != math.MaxUint64 {
+			flushGauge(key.(string), u)
+		}
+		return true
+	})
+
+	s.maxGauges.Range(func(key, v interface{}) bool {
+		flushGauge(key.(string), v.(*maxGauge).Value())
+		return true
+	})
+
+	s.ewmaGauges.Range(func(key, v interface{}) bool {
+		flushGauge(key.(string), v.(*ewmaGauge).Value())
+		return true
+	})
+
+	now := clockNow(s.clock)
+	s.rateGauges.Range(func(key, v interface{}) bool {
+		flushGauge(key.(string), v.(*rateGauge).rate(now))
+		return true
+	})
+
+	s.histograms.Range(func(_, v interface{}) bool {
+		v.(*histogram).flush(sink)
+		return true
+	})
+
+	s.summaries.Range(func(_, v interface{}) bool {
+		v.(*summary).flush(sink)
+		return true
+	})
+
+	if dedup != nil {
+		dedup.emit(watched.FlushCounter, watched.FlushGauge, watched.FlushFloatGauge)
+	}
+}
+
+func (s *statStore) Start(ticker *time.Ticker) {
+	s.run(ticker)
+}
+
+func (s *statStore) AddStatGenerator(statGenerator StatGenerator) {
+	s.AddStatGeneratorWithPriority(statGenerator, 0)
+}
+
+func (s *statStore) SetTagsFromContext(fn TagsFromContext) {
+	s.tagsFromContext.Store(fn)
+}
+
+// contextTags returns the union of the Tags carried by ctx and tags. If any
+// keys overlap the values from tags are used.
+func (s *statStore) contextTags(ctx context.Context, tags map[string]string) map[string]string {
+	fn, _ := s.tagsFromContext.Load().(TagsFromContext)
+	return mergeContextTags(fn, ctx, tags)
+}
+
+func mergeContextTags(fn TagsFromContext, ctx context.Context, tags map[string]string) map[string]string {
+	if fn == nil || ctx == nil {
+		return tags
+	}
+	ctxTags := fn(ctx)
+	if len(ctxTags) == 0 {
+		return tags
+	}
+	if len(tags) == 0 {
+		return ctxTags
+	}
+	merged := make(map[string]string, len(ctxTags)+len(tags))
+	for k, v := range ctxTags {
+		merged[k] = v
+	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+	return merged
+}
+
+// registries returns all the stat registries of the store.
+func (s *statStore) registries() []*sync.Map {
+	return []*sync.Map{
+		&s.counters,
+		&s.gauges,
+		&s.timers,
+		&s.histograms,
+		&s.distributions,
+		&s.summaries,
+		&s.minGauges,
+		&s.maxGauges,
+		&s.rateGauges,
+		&s.upDowns,
+		&s.floatGauges,
+		&s.windowed,
+		&s.ewmaGauges,
+	}
+}
+
+func (s *statStore) Unregister(name string) bool {
+	found := false
+	for _, m := range s.registries() {
+		if _, ok := m.Load(name); ok {
+			m.Delete(name)
+			found = true
+		}
+	}
+	if l, _ := s.limiter.Load().(*CardinalityLimiter); l != nil {
+		l.forget(name)
+	}
+	return found
+}
+
+func (s *statStore) UnregisterWithTags(name string, tags map[string]string) bool {
+	return s.Unregister(tagspkg.SerializeTags(name, tags))
+}
+
+func (s *statStore) UnregisterPrefix(prefix string) int {
+	n := 0
+	for _, m := range s.registries() {
+		m.Range(func(key, _ interface{}) bool {
+			if strings.HasPrefix(key.(string), prefix) {
+				m.Delete(key)
+				n++
+			}
+			return true
+		})
+	}
+	if l, _ := s.limiter.Load().(*CardinalityLimiter); l != nil {
+		l.forgetPrefix(prefix)
+	}
+	return n
+}
+
+// sortedKeys returns the sorted keys of registry m.
+func sortedKeys(m *sync.Map) []string {
+	var a []string
+	m.Range(func(key, _ interface{}) bool {
+		a = append(a, key.(string))
+		return true
+	})
+	sort.Strings(a)
+	return a
+}
+
+func (s *statStore) ListCounters() []string { return sortedKeys(&s.counters) }
+
+func (s *statStore) ListGauges() []string { return sortedKeys(&s.gauges) }
+
+func (s *statStore) ListTimers() []string { return sortedKeys(&s.timers) }
+
+// describe sets the help text of the stat name.
+func (s *statStore) describe(name, help string) {
+	if v, ok := s.help.Load(name); ok && v.(string) == help {
+		return
+	}
+	s.help.Store(name, help)
+	if hs, ok := s.sink.(HelpSink); ok {
+		hs.SetHelp(name, help)
+	}
+}
+
+func (s *statStore) ListMetricDescriptions() []MetricDescription {
+	var descs []MetricDescription
+	s.help.Range(func(key, v interface{}) bool {
+		descs = append(descs, MetricDescription{Name: key.(string), Help: v.(string)})
+		return true
+	})
+	sort.Slice(descs, func(i, j int) bool { return descs[i].Name < descs[j].Name })
+	return descs
+}
+
+func (s *statStore) Snapshot() Snapshot {
+	snap := Snapshot{
+		Counters: make(map[string]uint64),
+		Gauges:   make(map[string]uint64),
+	}
+	s.counters.Range(func(key, v interface{}) bool {
+		snap.Counters[key.(string)] = v.(*counter).Value()
+		return true
+	})
+	s.gauges.Range(func(key, v interface{}) bool {
+		snap.Gauges[key.(string)] = v.(*gauge).Value()
+		return true
+	})
+	return snap
+}
+
+func (s *statStore) SnapshotHandler() http.Handler {
+	return snapshotHandler{store: s}
+}
+
+func (s *statStore) Watch(ctx context.Context) <-chan MetricEvent {
+	return s.watch.watch(ctx, s.newCounter(WatchDroppedStatName, WatchDroppedStatName))
+}
+
+func (s *statStore) flushCounter(name string, value uint64) {
+	s.watch.send(MetricTypeCounter, name, float64(value))
+	s.sink.FlushCounter(name, value)
+}
+
+func (s *statStore) flushGauge(name string, value uint64) {
+	s.watch.send(MetricTypeGauge, name, float64(value))
+	s.sink.FlushGauge(name, value)
+}
+
+func (s *statStore) run(ticker *time.Ticker) {
+	s.loopMu.RLock()
+	defer s.loopMu.RUnlock()
+
+	done := s.doneChan()
+	for {
+		select {
+		case <-ticker.C:
+			s.flushTick()
+		case <-done:
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+// flushTick flushes the Store for Start, errors are passed to onError.
+func (s *statStore) flushTick() {
+	if s.onError == nil {
+		s.Flush()
+		return
+	}
+	if err := s.FlushAll(); err != nil {
+		s.onError(err)
+	}
+}
+
+func (s *statStore) Store() Store {
+	return s
+}
+
+func (s *statStore) WithOptions(opts ...StatOption) Scope {
+	return newOptionScope(s, opts)
+}
+
+func (s *statStore) WithTag(key, value string) Scope {
+	return newSubScope(s, "", map[string]string{key: value})
+}
+
+func (s *statStore) Scope(name string) Scope {
+	return s.ScopeWithOptions(name, ScopeOptions{})
+}
+
+func (s *statStore) ScopeWithTags(name string, tags map[string]string) Scope {
+	return s.ScopeWithOptions(name, ScopeOptions{Tags: tags})
+}
+
+func (s *statStore) ScopeWithOptions(name string, opts ScopeOptions) Scope {
+	scope := newSubScope(s, name, nil)
+	scope.tags = mergeScopeTags(nil, opts.Tags)
+	scope.perInstance = opts.PerInstanceSuffix
+	scope.parent = s
+	return scope
+}
+
+func (s *statStore) Parent() Scope {
+	return nil
+}
+
+func (s *statStore) Name() string {
+	return ""
+}
+
+func (s *statStore) FullName() string {
+	return ""
+}
+
+func (s *statStore) AsStore() Store {
+	return s
+}
+
+func (s *statStore) NewChildStore(prefix string) Store {
+	return s.Scope(prefix).AsStore()
+}
+
+func (s *statStore) NewAliasedCounter(names ...string) Counter {
+	return newAliasedCounter(s, names)
+}
+
+func (s *statStore) NewAliasedGauge(names ...string) Gauge {
+	return newAliasedGauge(s, names)
+}
+
+func (s *statStore) NewAliasedTimer(names ...string) Timer {
+	return newAliasedTimer(s, names)
+}
+
+func (s *statStore) MustNewCounter(name string) Counter {
+	return mustNewCounter(s, name)
+}
+
+func (s *statStore) MustNewGauge(name string) Gauge {
+	return mustNewGauge(s, name)
+}
+
+func (s *statStore) MustNewTimer(name string) Timer {
+	return mustNewTimer(s, name)
+}
+
+func (s *statStore) NewLazyCounter(name string) Counter {
+	return newLazyCounter(s, name)
+}
+
+func (s *statStore) NewCounterVec(name string, labelNames []string) CounterVec {
+	return newCounterVec(s, name, labelNames)
+}
+
+func (s *statStore) NewGaugeVec(name string, labelNames []string) GaugeVec {
+	return newGaugeVec(s, name, labelNames)
+}
+
+func (s *statStore) NewTimerVec(name string, labelNames []string) TimerVec {
+	return newTimerVec(s, name, labelNames)
+}
+
+func (s *statStore) RecordBatch(batch []MetricRecord) {
+	recordBatch(s, batch)
+}
+
+func (s *statStore) newCounter(name, serializedName string) *counter {
+	serializedName = s.normalizeName(serializedName)
+	if s.closed() {
+		return new(counter)
+	}
+	if v, ok := s.counters.Load(serializedName); ok {
+		return v.(*counter)
+	}
+	if !s.admit(name, serializedName) {
+		return new(counter)
+	}
+	c := new(counter)
+	if v, loaded := s.counters.LoadOrStore(serializedName, c); loaded {
+		return v.(*counter)
+	}
+	s.created("counter", serializedName)
+	return c
+}
+
+func (s *statStore) NewCounter(name string) Counter {
+	return s.newCounter(name, name)
+}
+
+func (s *statStore) NewCounterWithTags(name string, tags map[string]string) Counter {
+	return s.newCounter(name, tagspkg.SerializeTags(name, tags))
+}
+
+func (s *statStore) newCounterWithTagSet(name string, tags tagspkg.TagSet) Counter {
+	return s.newCounter(name, tags.Serialize(name))
+}
+
+// normalizeName returns serializedName with its tags rewritten by the
+// TagKeyAliases and the NormalizeTagValue hook.
+func (s *statStore) normalizeName(serializedName string) string {
+	if s.normalize == nil && len(s.tagAliases) == 0 {
+		return serializedName
+	}
+	name, tags := tagspkg.ParseTagSet(serializedName)
+	if tags == nil {
+		return serializedName
+	}
+	return s.rewriteTags(name, tags).Serialize(name)
+}
+
+// normalizeTagSet is like normalizeName for the tags of stat name.
+func (s *statStore) normalizeTagSet(name string, tags tagspkg.TagSet) tagspkg.TagSet {
+	if (s.normalize == nil && len(s.tagAliases) == 0) || len(tags) == 0 {
+		return tags
+	}
+	return s.rewriteTags(name, tags)
+}
+
+// rewriteTags renames the keys of tags that have an alias and normalizes
+// their values. If a tag is renamed to a key that is already set the value
+// of the existing tag is kept. Tags normalized to an empty value are removed.
+func (s *statStore) rewriteTags(name string, tags tagspkg.TagSet) tagspkg.TagSet {
+	var renamed map[string]string // old key => new key
+	for _, t := range tags {
+		if key, ok := s.tagAliases[t.Key]; ok && key != t.Key {
+			if renamed == nil {
+				renamed = make(map[string]string)
+			}
+			renamed[t.Key] = key
+		}
+	}
+	if renamed != nil {
+		m := make(map[string]string, len(tags))
+		for _, t := range tags {
+			if key, ok := renamed[t.Key]; ok {
+				if _, exists := m[key]; !exists {
+					m[key] = t.Value
+				}
+			} else {
+				m[t.Key] = t.Value
+			}
+		}
+		tags = tagspkg.NewTagSet(m)
+		for old, key := range renamed {
+			if _, warned := s.renamed.LoadOrStore(name+"\x00"+old, struct{}{}); !warned {
+				logger.Warnf("[gostats] stat %q: renamed tag %q to %q", name, old, key)
+			}
+		}
+	}
+	if s.normalize == nil {
+		return tags
+	}
+	a := make(tagspkg.TagSet, 0, len(tags))
+	for _, t := range tags {
+		if v := s.normalize(t.Key, t.Value); v != "" {
+			a = append(a, tagspkg.NewTag(t.Key, v))
+		}
+	}
+	return a
+}
+
+// admit reports if the stat name may be registered as serializedName, it is
+// false if the CardinalityLimiter of the store limits it.
+func (s *statStore) admit(name, serializedName string) bool {
+	l, _ := s.limiter.Load().(*CardinalityLimiter)
+	return l == nil || l.allow(name, serializedName)
+}
+
+// created calls the OnMetricCreate hook with a newly registered stat.
+func (s *statStore) created(kind, serializedName string) {
+	if s.onCreate != nil {
+		name, tags := tagspkg.ParseTags(serializedName)
+		s.onCreate(kind, name, tags)
+	}
+}
+
+var emptyPerInstanceTags = map[string]string{"_f": "i"}
+
+// perInstanceValue returns the default value of the per-instance tag.
+func (s *statStore) perInstanceValue() string {
+	if s.perInstance == "" {
+		return "i"
+	}
+	return s.perInstance
+}
+
+func (s *statStore) emptyPerInstanceTags() map[string]string {
+	if s.perInstance == "" {
+		return emptyPerInstanceTags
+	}
+	return map[string]string{"_f": s.perInstance}
+}
+
+func (s *statStore) NewPerInstanceCounter(name string, tags map[string]string) Counter {
+	if len(tags) == 0 {
+		return s.NewCounterWithTags(name, s.emptyPerInstanceTags())
+	}
+	if _, found := tags["_f"]; found {
+		return s.NewCounterWithTags(name, tags)
+	}
+	return s.newCounterWithTagSet(name, tagspkg.TagSet(nil).MergePerInstanceTagsValue(tags, s.perInstanceValue()))
+}
+
+func (s *statStore) newGauge(name, serializedName string) *gauge {
+	serializedName = s.normalizeName(serializedName)
+	if s.closed() {
+		return new(gauge)
+	}
+	if v, ok := s.gauges.Load(serializedName); ok {
+		return v.(*gauge)
+	}
+	if !s.admit(name, serializedName) {
+		return new(gauge)
+	}
+	g := new(gauge)
+	if v, loaded := s.gauges.LoadOrStore(serializedName, g); loaded {
+		return v.(*gauge)
+	}
+	s.created("gauge", serializedName)
+	return g
+}
+
+func (s *statStore) NewGauge(name string) Gauge {
+	return s.newGauge(name, name)
+}
+
+func (s *statStore) NewGaugeWithTags(name string, tags map[string]string) Gauge {
+	return s.newGauge(name, tagspkg.SerializeTags(name, tags))
+}
+
+func (s *statStore) newGaugeWithTagSet(name string, tags tagspkg.TagSet) Gauge {
+	return s.newGauge(name, tags.Serialize(name))
+}
+
+func (s *statStore) NewPerInstanceGauge(name string, tags map[string]string) Gauge {
+	if len(tags) == 0 {
+		return s.NewGaugeWithTags(name, s.emptyPerInstanceTags())
+	}
+	if _, found := tags["_f"]; found {
+		return s.NewGaugeWithTags(name, tags)
+	}
+	return s.newGaugeWithTagSet(name, tagspkg.TagSet(nil).MergePerInstanceTagsValue(tags, s.perInstanceValue()))
+}
+
+func (s *statStore) newTimer(name, serializedName string) *timer {
+	serializedName = s.normalizeName(serializedName)
+	if s.closed() {
+		return &timer{name: serializedName, sink: nullSink{}}
+	}
+	if v, ok := s.timers.Load(serializedName); ok {
+		return v.(*timer)
+	}
+	if !s.admit(name, serializedName) {
+		return &timer{name: serializedName, sink: nullSink{}}
+	}
+	t := &timer{name: serializedName, sink: s.sink, watch: &s.watch, clock: s.clock}
+	if v, loaded := s.timers.LoadOrStore(serializedName, t); loaded {
+		return v.(*timer)
+	}
+	s.created("timer", serializedName)
+	return t
+}
+
+func (s *statStore) NewTimer(name string) Timer {
+	return s.newTimer(name, name)
+}
+
+func (s *statStore) NewTimerWithTags(name string, tags map[string]string) Timer {
+	return s.newTimer(name, tagspkg.SerializeTags(name, tags))
+}
+
+func (s *statStore) newTimerWithTagSet(name string, tags tagspkg.TagSet) Timer {
+	return s.newTimer(name, tags.Serialize(name))
+}
+
+func (s *statStore) NewPerInstanceTimer(name string, tags map[string]string) Timer {
+	if len(tags) == 0 {
+		return s.NewTimerWithTags(name, s.emptyPerInstanceTags())
+	}
+	if _, found := tags["_f"]; found {
+		return s.NewTimerWithTags(name, tags)
+	}
+	return s.newTimerWithTagSet(name, tagspkg.TagSet(nil).MergePerInstanceTagsValue(tags, s.perInstanceValue()))
+}
+
+func (s *statStore) NewCounterCtx(ctx context.Context, name string) Counter {
+	return s.NewCounterWithTags(name, s.contextTags(ctx, nil))
+}
+
+func (s *statStore) NewCounterWithTagsCtx(ctx context.Context, name string, tags map[string]string) Counter {
+	return s.NewCounterWithTags(name, s.contextTags(ctx, tags))
+}
+
+func (s *statStore) NewGaugeCtx(ctx context.Context, name string) Gauge {
+	return s.NewGaugeWithTags(name, s.contextTags(ctx, nil))
+}
+
+func (s *statStore) NewGaugeWithTagsCtx(ctx context.Context, name string, tags map[string]string) Gauge {
+	return s.NewGaugeWithTags(name, s.contextTags(ctx, tags))
+}
+
+func (s *statStore) NewTimerCtx(ctx context.Context, name string) Timer {
+	return s.NewTimerWithTags(name, s.contextTags(ctx, nil))
+}
+
+func (s *statStore) NewTimerWithTagsCtx(ctx context.Context, name string, tags map[string]string) Timer {
+	return s.NewTimerWithTags(name, s.contextTags(ctx, tags))
+}
+
+func (s *statStore) newHistogramWithTagSet(name string, tags tagspkg.TagSet, opts HistogramOptions) Histogram {
+	tags = s.normalizeTagSet(name, tags)
+	if s.closed() {
+		return newHistogram(name, tags, opts.bounds())
+	}
+	serializedName := tags.Serialize(name)
+	if v, ok := s.histograms.Load(serializedName); ok {
+		return v.(*histogram)
+	}
+	if !s.admit(name, serializedName) {
+		return newHistogram(name, tags, opts.bounds())
+	}
+	h := newHistogram(name, tags, opts.bounds())
+	if v, loaded := s.histograms.LoadOrStore(serializedName, h); loaded {
+		return v.(*histogram)
+	}
+	s.created("histogram", serializedName)
+	return h
+}
+
+func (s *statStore) NewHistogram(name string) Histogram {
+	return s.newHistogramWithTagSet(name, nil, HistogramOptions{})
+}
+
+func (s *statStore) NewHistogramWithTags(name string, tags map[string]string) Histogram {
+	return s.newHistogramWithTagSet(name, tagspkg.NewTagSet(tags), HistogramOptions{})
+}
+
+func (s *statStore) NewHistogramWithOptions(name string, tags map[string]string, opts HistogramOptions) Histogram {
+	return s.newHistogramWithTagSet(name, tagspkg.NewTagSet(tags), opts)
+}
+
+func (s *statStore) newDistribution(name, serializedName string) *distribution {
+	serializedName = s.normalizeName(serializedName)
+	if s.closed() {
+		return &distribution{name: serializedName, sink: nullSink{}}
+	}
+	if v, ok := s.distributions.Load(serializedName); ok {
+		return v.(*distribution)
+	}
+	if !s.admit(name, serializedName) {
+		return &distribution{name: serializedName, sink: nullSink{}}
+	}
+	d := &distribution{name: serializedName, sink: newDistributionSink(s.sink), watch: &s.watch}
+	if v, loaded := s.distributions.LoadOrStore(serializedName, d); loaded {
+		return v.(*distribution)
+	}
+	s.created("distribution", serializedName)
+	return d
+}
+
+func (s *statStore) NewDistribution(name string) Distribution {
+	return s.newDistribution(name, name)
+}
+
+func (s *statStore) NewDistributionWithTags(name string, tags map[string]string) Distribution {
+	return s.newDistribution(name, tagspkg.SerializeTags(name, tags))
+}
+
+func (s *statStore) newDistributionWithTagSet(name string, tags tagspkg.TagSet) Distribution {
+	return s.newDistribution(name, tags.Serialize(name))
+}
+
+func (s *statStore) NewPerInstanceDistribution(name string, tags map[string]string) Distribution {
+	if len(tags) == 0 {
+		return s.NewDistributionWithTags(name, s.emptyPerInstanceTags())
+	}
+	if _, found := tags["_f"]; found {
+		return s.NewDistributionWithTags(name, tags)
+	}
+	return s.newDistributionWithTagSet(name, tagspkg.TagSet(nil).MergePerInstanceTagsValue(tags, s.perInstanceValue()))
+}
+
+func (s *statStore) newSummaryWithTagSet(name string, tags tagspkg.TagSet, opts SummaryOptions) Summary {
+	tags = s.normalizeTagSet(name, tags)
+	if s.closed() {
+		return newSummary(name, tags, opts)
+	}
+	serializedName := tags.Serialize(name)
+	if v, ok := s.summaries.Load(serializedName); ok {
+		return v.(*summary)
+	}
+	if !s.admit(name, serializedName) {
+		return newSummary(name, tags, opts)
+	}
+	sm := newSummary(name, tags, opts)
+	sm.clock = s.clock
+	if v, loaded := s.summaries.LoadOrStore(serializedName, sm); loaded {
+		return v.(*summary)
+	}
+	s.created("summary", serializedName)
+	return sm
+}
+
+func (s *statStore) NewSummary(name string, opts SummaryOptions) Summary {
+	return s.newSummaryWithTagSet(name, nil, opts)
+}
+
+func (s *statStore) NewSummaryWithTags(name string, tags map[string]string, opts SummaryOptions) Summary {
+	return s.newSummaryWithTagSet(name, tagspkg.NewTagSet(tags), opts)
+}
+
+func (s *statStore) newMinGauge(name, serializedName string) *minGauge {
+	serializedName = s.normalizeName(serializedName)
+	if s.closed() {
+		return newMinGauge()
+	}
+	if v, ok := s.minGauges.Load(serializedName); ok {
+		return v.(*minGauge)
+	}
+	if !s.admit(name, serializedName) {
+		return newMinGauge()
+	}
+	g := newMinGauge()
+	if v, loaded := s.minGauges.LoadOrStore(serializedName, g); loaded {
+		return v.(*minGauge)
+	}
+	s.created("gauge_min", serializedName)
+	return g
+}
+
+func (s *statStore) NewMinGauge(name string) GaugeMin {
+	return s.newMinGauge(name, name)
+}
+
+func (s *statStore) NewMinGaugeWithTags(name string, tags map[string]string) GaugeMin {
+	return s.newMinGauge(name, tagspkg.SerializeTags(name, tags))
+}
+
+func (s *statStore) newMinGaugeWithTagSet(name string, tags tagspkg.TagSet) GaugeMin {
+	return s.newMinGauge(name, tags.Serialize(name))
+}
+
+func (s *statStore) newMaxGauge(name, serializedName string) *maxGauge {
+	serializedName = s.normalizeName(serializedName)
+	if s.closed() {
+		return new(maxGauge)
+	}
+	if v, ok := s.maxGauges.Load(serializedName); ok {
+		return v.(*maxGauge)
+	}
+	if !s.admit(name, serializedName) {
+		return new(maxGauge)
+	}
+	g := new(maxGauge)
+	if v, loaded := s.maxGauges.LoadOrStore(serializedName, g); loaded {
+		return v.(*maxGauge)
+	}
+	s.created("gauge_max", serializedName)
+	return g
+}
+
+func (s *statStore) NewMaxGauge(name string) GaugeMax {
+	return s.newMaxGauge(name, name)
+}
+
+func (s *statStore) NewMaxGaugeWithTags(name string, tags map[string]string) GaugeMax {
+	return s.newMaxGauge(name, tagspkg.SerializeTags(name, tags))
+}
+
+func (s *statStore) newMaxGaugeWithTagSet(name string, tags tagspkg.TagSet) GaugeMax {
+	return s.newMaxGauge(name, tags.Serialize(name))
+}
+
+func (s *statStore) newRateGauge(name, serializedName string, opts RateGaugeOptions) *rateGauge {
+	serializedName = s.normalizeName(serializedName)
+	if s.closed() {
+		return newRateGauge(opts)
+	}
+	if v, ok := s.rateGauges.Load(serializedName); ok {
+		return v.(*rateGauge)
+	}
+	if !s.admit(name, serializedName) {
+		return newRateGauge(opts)
+	}
+	g := newRateGauge(opts)
+	if v, loaded := s.rateGauges.LoadOrStore(serializedName, g); loaded {
+		return v.(*rateGauge)
+	}
+	s.created("rate_gauge", serializedName)
+	return g
+}
+
+func (s *statStore) NewRateGauge(name string, opts RateGaugeOptions) RateGauge {
+	return s.newRateGauge(name, name, opts)
+}
+
+func (s *statStore) NewRateGaugeWithTags(name string, tags map[string]string, opts RateGaugeOptions) RateGauge {
+	return s.newRateGauge(name, tagspkg.SerializeTags(name, tags), opts)
+}
+
+func (s *statStore) newRateGaugeWithTagSet(name string, tags tagspkg.TagSet, opts RateGaugeOptions) RateGauge {
+	return s.newRateGauge(name, tags.Serialize(name), opts)
+}
+
+func (s *statStore) newUpDownCounter(name, serializedName string) *upDownCounter {
+	serializedName = s.normalizeName(serializedName)
+	if s.closed() {
+		return new(upDownCounter)
+	}
+	if v, ok := s.upDowns.Load(serializedName); ok {
+		return v.(*upDownCounter)
+	}
+	if !s.admit(name, serializedName) {
+		return new(upDownCounter)
+	}
+	c := new(upDownCounter)
+	if v, loaded := s.upDowns.LoadOrStore(serializedName, c); loaded {
+		return v.(*upDownCounter)
+	}
+	s.created("updown_counter", serializedName)
+	return c
+}
+
+func (s *statStore) NewUpDownCounter(name string) UpDownCounter {
+	return s.newUpDownCounter(name, name)
+}
+
+func (s *statStore) NewUpDownCounterWithTags(name string, tags map[string]string) UpDownCounter {
+	return s.newUpDownCounter(name, tagspkg.SerializeTags(name, tags))
+}
+
+func (s *statStore) newUpDownCounterWithTagSet(name string, tags tagspkg.TagSet) UpDownCounter {
+	return s.newUpDownCounter(name, tags.Serialize(name))
+}
+
+func (s *statStore) NewSequenceCounter(name string) SequenceCounter {
+	return sequenceCounter{s.newCounter(name, name)}
+}
+
+func (s *statStore) NewSequenceCounterWithTags(name string, tags map[string]string) SequenceCounter {
+	return sequenceCounter{s.newCounter(name, tagspkg.SerializeTags(name, tags))}
+}
+
+func (s *statStore) newWindowedCounter(name string, tags tagspkg.TagSet, opts WindowedCounterOptions) *windowedCounter {
+	tags = s.normalizeTagSet(name, tags)
+	if s.closed() {
+		return newWindowedCounter(tags.Serialize(name+"_rate"), tags.Serialize(name+"_total"), opts)
+	}
+	serializedName := tags.Serialize(name)
+	if v, ok := s.windowed.Load(serializedName); ok {
+		return v.(*windowedCounter)
+	}
+	if !s.admit(name, serializedName) {
+		return newWindowedCounter(tags.Serialize(name+"_rate"), tags.Serialize(name+"_total"), opts)
+	}
+	c := newWindowedCounter(tags.Serialize(name+"_rate"), tags.Serialize(name+"_total"), opts)
+	if s.clock != nil {
+		c.now = s.clock.Now
+	}
+	if v, loaded := s.windowed.LoadOrStore(serializedName, c); loaded {
+		return v.(*windowedCounter)
+	}
+	s.created("windowed_counter", serializedName)
+	return c
+}
+
+func (s *statStore) NewWindowedCounter(name string, opts WindowedCounterOptions) WindowedCounter {
+	return s.newWindowedCounter(name, nil, opts)
+}
+
+func (s *statStore) NewWindowedCounterWithTags(name string, tags map[string]string, opts WindowedCounterOptions) WindowedCounter {
+	return s.newWindowedCounter(name, tagspkg.NewTagSet(tags), opts)
+}
+
+func (s *statStore) newEWMAGauge(name, serializedName string, alpha float64) *ewmaGauge {
+	serializedName = s.normalizeName(serializedName)
+	if s.closed() {
+		return newEWMAGauge(alpha)
+	}
+	if v, ok := s.ewmaGauges.Load(serializedName); ok {
+		checkEWMAAlpha(alpha)
+		return v.(*ewmaGauge)
+	}
+	if !s.admit(name, serializedName) {
+		return newEWMAGauge(alpha)
+	}
+	g := newEWMAGauge(alpha)
+	if v, loaded := s.ewmaGauges.LoadOrStore(serializedName, g); loaded {
+		return v.(*ewmaGauge)
+	}
+	s.created("ewma_gauge", serializedName)
+	return g
+}
+
+func (s *statStore) NewEWMAGauge(name string, alpha float64) Gauge {
+	return s.newEWMAGauge(name, name, alpha)
+}
+
+func (s *statStore) NewEWMAGaugeWithTags(name string, tags map[string]string, alpha float64) Gauge {
+	return s.newEWMAGauge(name, tagspkg.SerializeTags(name, tags), alpha)
+}
+
+func (s *statStore) newEWMAGaugeWithTagSet(name string, tags tagspkg.TagSet, alpha float64) Gauge {
+	return s.newEWMAGauge(name, tags.Serialize(name), alpha)
+}
+
+func (s *statStore) newFloatGauge(name, serializedName string) *floatGauge {
+	serializedName = s.normalizeName(serializedName)
+	if s.closed() {
+		return new(floatGauge)
+	}
+	if v, ok := s.floatGauges.Load(serializedName); ok {
+		return v.(*floatGauge)
+	}
+	if !s.admit(name, serializedName) {
+		return new(floatGauge)
+	}
+	g := new(floatGauge)
+	if v, loaded := s.floatGauges.LoadOrStore(serializedName, g); loaded {
+		return v.(*floatGauge)
+	}
+	s.created("float_gauge", serializedName)
+	return g
+}
+
+func (s *statStore) NewFloatGauge(name string) FloatGauge {
+	return s.newFloatGauge(name, name)
+}
+
+func (s *statStore) NewFloatGaugeWithTags(name string, tags map[string]string) FloatGauge {
+	return s.newFloatGauge(name, tagspkg.SerializeTags(name, tags))
+}
+
+func (s *statStore) newFloatGaugeWithTagSet(name string, tags tagspkg.TagSet) FloatGauge {
+	return s.newFloatGauge(name, tags.Serialize(name))
+}
+
+func (s *statStore) NewPerInstanceFloatGauge(name string, tags map[string]string) FloatGauge {
+	if len(tags) == 0 {
+		return s.NewFloatGaugeWithTags(name, s.emptyPerInstanceTags())
+	}
+	if _, found := tags["_f"]; found {
+		return s.NewFloatGaugeWithTags(name, tags)
+	}
+	return s.newFloatGaugeWithTagSet(name, tagspkg.TagSet(nil).MergePerInstanceTagsValue(tags, s.perInstanceValue()))
+}
+
+type subScope struct {
+	registry    *statStore
+	name        string         // full name
+	local       string         // name passed when the scope was created
+	tags        tagspkg.TagSet // read-only and may be shared by multiple subScopes
+	perInstance string         // overrides the per-instance value of registry
+	parent      Scope          // nil for the root scope of a scopedStore
+	store       Store          // returned by Store, registry if nil
+}
+
+func newSubScope(registry *statStore, name string, tags map[string]string) *subScope {
+	return &subScope{registry: registry, name: name, local: name, tags: tagspkg.NewTagSet(tags)}
+}
+
+// mergeScopeTags returns the tags of a scope created with tags in a scope
+// with the tags parent. The tags with the value ExcludedTag are removed.
+func mergeScopeTags(parent tagspkg.TagSet, tags map[string]string) tagspkg.TagSet {
+	excluded := 0
+	for _, v := range tags {
+		if v == ExcludedTag {
+			excluded++
+		}
+	}
+	if excluded == 0 {
+		return parent.MergeTags(tags)
+	}
+	m := make(map[string]string, len(tags)-excluded)
+	for k, v := range tags {
+		if v != ExcludedTag {
+			m[k] = v
+		}
+	}
+	set := parent.MergeTags(m)
+	for k, v := range tags {
+		if v == ExcludedTag {
+			set = set.Remove(k)
+		}
+	}
+	return set
+}
+
+func (s *subScope) Scope(name string) Scope {
+	return s.ScopeWithTags(name, nil)
+}
+
+func (s *subScope) ScopeWithTags(name string, tags map[string]string) Scope {
+	return s.ScopeWithOptions(name, ScopeOptions{Tags: tags})
+}
+
+func (s *subScope) ScopeWithOptions(name string, opts ScopeOptions) Scope {
+	perInstance := opts.PerInstanceSuffix
+	if perInstance == "" {
+		perInstance = s.perInstance
+	}
+	return &subScope{
+		registry:    s.registry,
+		name:        joinScopes(s.name, name),
+		local:       name,
+		tags:        mergeScopeTags(s.tags, opts.Tags),
+		perInstance: perInstance,
+		parent:      s,
+		store:       s.store,
+	}
+}
+
+func (s *subScope) Parent() Scope {
+	return s.parent
+}
+
+func (s *subScope) Name() string {
+	return s.local
+}
+
+func (s *subScope) FullName() string {
+	return s.name
+}
+
+func (s *subScope) AsStore() Store {
+	return newScopedStore(s, s.registry)
+}
+
+func (s *subScope) perInstanceValue() string {
+	if s.perInstance != "" {
+		return s.perInstance
+	}
+	return s.registry.perInstanceValue()
+}
+
+func (s *subScope) Store() Store {
+	if s.store != nil {
+		return s.store
+	}
+	return s.registry
+}
+
+func (s *subScope) WithOptions(opts ...StatOption) Scope {
+	return newOptionScope(s, opts)
+}
+
+func (s *subScope) WithTag(key, value string) Scope {
+	c := *s
+	c.tags = s.tags.MergeTags(map[string]string{key: value})
+	return &c
+}
+
+func (s *subScope) NewCounter(name string) Counter {
+	return s.NewCounterWithTags(name, nil)
+}
+
+func (s *subScope) NewCounterWithTags(name string, tags map[string]string) Counter {
+	return s.registry.newCounterWithTagSet(joinScopes(s.name, name), s.tags.MergeTags(tags))
+}
+
+func (s *subScope) NewPerInstanceCounter(name string, tags map[string]string) Counter {
+	return s.registry.newCounterWithTagSet(joinScopes(s.name, name),
+		s.tags.MergePerInstanceTagsValue(tags, s.perInstanceValue()))
+}
+
+func (s *subScope) NewGauge(name string) Gauge {
+	return s.NewGaugeWithTags(name, nil)
+}
+
+func (s *subScope) NewGaugeWithTags(name string, tags map[string]string) Gauge {
+	return s.registry.newGaugeWithTagSet(joinScopes(s.name, name), s.tags.MergeTags(tags))
+}
+
+func (s *subScope) NewPerInstanceGauge(name string, tags map[string]string) Gauge {
+	return s.registry.newGaugeWithTagSet(joinScopes(s.name, name),
+		s.tags.MergePerInstanceTagsValue(tags, s.perInstanceValue()))
+}
+
+func (s *subScope) NewTimer(name string) Timer {
+	return s.NewTimerWithTags(name, nil)
+}
+
+func (s *subScope) NewTimerWithTags(name string, tags map[string]string) Timer {
 	return s.registry.newTimerWithTagSet(joinScopes(s.name, name), s.tags.MergeTags(tags))
 }
 
 func (s *subScope) NewPerInstanceTimer(name string, tags map[string]string) Timer {
 	return s.registry.newTimerWithTagSet(joinScopes(s.name, name),
-		s.tags.MergePerInstanceTags(tags))
+		s.tags.MergePerInstanceTagsValue(tags, s.perInstanceValue()))
+}
+
+func (s *subScope) NewCounterCtx(ctx context.Context, name string) Counter {
+	return s.NewCounterWithTags(name, s.registry.contextTags(ctx, nil))
+}
+
+func (s *subScope) NewCounterWithTagsCtx(ctx context.Context, name string, tags map[string]string) Counter {
+	return s.NewCounterWithTags(name, s.registry.contextTags(ctx, tags))
+}
+
+func (s *subScope) NewGaugeCtx(ctx context.Context, name string) Gauge {
+	return s.NewGaugeWithTags(name, s.registry.contextTags(ctx, nil))
+}
+
+func (s *subScope) NewGaugeWithTagsCtx(ctx context.Context, name string, tags map[string]string) Gauge {
+	return s.NewGaugeWithTags(name, s.registry.contextTags(ctx, tags))
+}
+
+func (s *subScope) NewTimerCtx(ctx context.Context, name string) Timer {
+	return s.NewTimerWithTags(name, s.registry.contextTags(ctx, nil))
+}
+
+func (s *subScope) NewTimerWithTagsCtx(ctx context.Context, name string, tags map[string]string) Timer {
+	return s.NewTimerWithTags(name, s.registry.contextTags(ctx, tags))
+}
+
+func (s *subScope) NewHistogram(name string) Histogram {
+	return s.NewHistogramWithTags(name, nil)
+}
+
+func (s *subScope) NewHistogramWithTags(name string, tags map[string]string) Histogram {
+	return s.NewHistogramWithOptions(name, tags, HistogramOptions{})
+}
+
+func (s *subScope) NewHistogramWithOptions(name string, tags map[string]string, opts HistogramOptions) Histogram {
+	return s.registry.newHistogramWithTagSet(joinScopes(s.name, name), s.tags.MergeTags(tags), opts)
+}
+
+func (s *subScope) NewDistribution(name string) Distribution {
+	return s.NewDistributionWithTags(name, nil)
+}
+
+func (s *subScope) NewDistributionWithTags(name string, tags map[string]string) Distribution {
+	return s.registry.newDistributionWithTagSet(joinScopes(s.name, name), s.tags.MergeTags(tags))
+}
+
+func (s *subScope) NewPerInstanceDistribution(name string, tags map[string]string) Distribution {
+	return s.registry.newDistributionWithTagSet(joinScopes(s.name, name),
+		s.tags.MergePerInstanceTagsValue(tags, s.perInstanceValue()))
+}
+
+func (s *subScope) NewSummary(name string, opts SummaryOptions) Summary {
+	return s.NewSummaryWithTags(name, nil, opts)
+}
+
+func (s *subScope) NewSummaryWithTags(name string, tags map[string]string, opts SummaryOptions) Summary {
+	return s.registry.newSummaryWithTagSet(joinScopes(s.name, name), s.tags.MergeTags(tags), opts)
+}
+
+func (s *subScope) NewMinGauge(name string) GaugeMin {
+	return s.NewMinGaugeWithTags(name, nil)
+}
+
+func (s *subScope) NewMinGaugeWithTags(name string, tags map[string]string) GaugeMin {
+	return s.registry.newMinGaugeWithTagSet(joinScopes(s.name, name), s.tags.MergeTags(tags))
+}
+
+func (s *subScope) NewMaxGauge(name string) GaugeMax {
+	return s.NewMaxGaugeWithTags(name, nil)
+}
+
+func (s *subScope) NewMaxGaugeWithTags(name string, tags map[string]string) GaugeMax {
+	return s.registry.newMaxGaugeWithTagSet(joinScopes(s.name, name), s.tags.MergeTags(tags))
+}
+
+func (s *subScope) NewRateGauge(name string, opts RateGaugeOptions) RateGauge {
+	return s.NewRateGaugeWithTags(name, nil, opts)
+}
+
+func (s *subScope) NewRateGaugeWithTags(name string, tags map[string]string, opts RateGaugeOptions) RateGauge {
+	return s.registry.newRateGaugeWithTagSet(joinScopes(s.name, name), s.tags.MergeTags(tags), opts)
+}
+
+func (s *subScope) NewUpDownCounter(name string) UpDownCounter {
+	return s.NewUpDownCounterWithTags(name, nil)
+}
+
+func (s *subScope) NewUpDownCounterWithTags(name string, tags map[string]string) UpDownCounter {
+	return s.registry.newUpDownCounterWithTagSet(joinScopes(s.name, name), s.tags.MergeTags(tags))
+}
+
+func (s *subScope) NewSequenceCounter(name string) SequenceCounter {
+	return s.NewSequenceCounterWithTags(name, nil)
+}
+
+func (s *subScope) NewSequenceCounterWithTags(name string, tags map[string]string) SequenceCounter {
+	name = joinScopes(s.name, name)
+	return sequenceCounter{s.registry.newCounter(name, s.tags.MergeTags(tags).Serialize(name))}
+}
+
+func (s *subScope) NewWindowedCounter(name string, opts WindowedCounterOptions) WindowedCounter {
+	return s.NewWindowedCounterWithTags(name, nil, opts)
+}
+
+func (s *subScope) NewWindowedCounterWithTags(name string, tags map[string]string, opts WindowedCounterOptions) WindowedCounter {
+	return s.registry.newWindowedCounter(joinScopes(s.name, name), s.tags.MergeTags(tags), opts)
+}
+
+func (s *subScope) NewEWMAGauge(name string, alpha float64) Gauge {
+	return s.NewEWMAGaugeWithTags(name, nil, alpha)
+}
+
+func (s *subScope) NewEWMAGaugeWithTags(name string, tags map[string]string, alpha float64) Gauge {
+	return s.registry.newEWMAGaugeWithTagSet(joinScopes(s.name, name), s.tags.MergeTags(tags), alpha)
+}
+
+func (s *subScope) NewFloatGauge(name string) FloatGauge {
+	return s.NewFloatGaugeWithTags(name, nil)
+}
+
+func (s *subScope) NewFloatGaugeWithTags(name string, tags map[string]string) FloatGauge {
+	return s.registry.newFloatGaugeWithTagSet(joinScopes(s.name, name), s.tags.MergeTags(tags))
+}
+
+func (s *subScope) NewPerInstanceFloatGauge(name string, tags map[string]string) FloatGauge {
+	return s.registry.newFloatGaugeWithTagSet(joinScopes(s.name, name),
+		s.tags.MergePerInstanceTagsValue(tags, s.perInstanceValue()))
 }
 
 func joinScopes(parent, child string) string {
-	return parent + "." + child
+	if parent == "" { // root scope, see scopedStore
+		return child
+	}
+	if len(parent)+1+len(child) > len(joinBuffer{}) {
+		return parent + "." + child
+	}
+	// the name is built on the stack, so looking up an interned name
+	// does not allocate
+	var buf joinBuffer
+	b := append(append(append(buf[:0], parent...), '.'), child...)
+	return scopeNames.Bytes(b)
 }
+
+// joinBuffer is the buffer of joinScopes, longer names are not interned.
+type joinBuffer [128]byte
+
+// scopeNames interns the names joined by joinScopes, which are rebuilt each
+// time a stat is looked up through a scope.
+var scopeNames intern.Pool
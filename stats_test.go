@@ -1,14 +1,18 @@
 package stats
 
 import (
+	"context"
 	crand "crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"math"
 	"math/rand"
 	"reflect"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -58,6 +62,508 @@ func TestTimer(t *testing.T) {
 	}
 }
 
+func TestTimerRecordDuration(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStore(sink, false)
+	timer := store.NewTimer("test")
+	timer.RecordDuration(9800 * time.Microsecond)
+	sink.AssertTimerEquals(t, "test", 9800)
+
+	called := false
+	if timer.Time(func() { called = true }) != timer {
+		t.Error("Time should return the Timer")
+	}
+	if !called {
+		t.Error("Time did not call the function")
+	}
+	sink.AssertTimerCallCount(t, "test", 2)
+}
+
+func TestTimerContext(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStore(sink, false)
+
+	const sleep = time.Millisecond
+	d := func() time.Duration {
+		c := store.NewTimer("test").Start()
+		time.Sleep(sleep)
+		return c.Stop()
+	}()
+	if d < sleep {
+		t.Errorf("Stop: got: %s want: >= %s", d, sleep)
+	}
+	sink.AssertTimerEquals(t, "test", float64(d/time.Microsecond))
+
+	var zero TimerContext
+	if d := zero.Stop(); d != 0 {
+		t.Errorf("zero TimerContext: got: %s want: 0", d)
+	}
+	sink.AssertTimerCallCount(t, "test", 1)
+}
+
+func TestMinMaxGauge(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStore(sink, false)
+	min := store.Scope("s").NewMinGauge("min")
+	max := store.Scope("s").NewMaxGaugeWithTags("max", map[string]string{"k": "v"})
+
+	// unobserved min gauges are not flushed
+	store.Flush()
+	sink.AssertGaugeNotExists(t, "s.min")
+	sink.AssertGaugeEquals(t, "s.max.__k=v", 0)
+	if v := min.Value(); v != 0 {
+		t.Errorf("unobserved min gauge: got: %d want: 0", v)
+	}
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 100; i++ {
+		wg.Add(1)
+		go func(v uint64) {
+			defer wg.Done()
+			min.UpdateMin(v)
+			max.UpdateMax(v)
+		}(uint64(i))
+	}
+	wg.Wait()
+	if min.Value() != 1 || max.Value() != 100 {
+		t.Errorf("got: min %d max %d want: min 1 max 100", min.Value(), max.Value())
+	}
+
+	sink.Reset()
+	store.Flush()
+	sink.AssertGaugeEquals(t, "s.min", 1)
+	sink.AssertGaugeEquals(t, "s.max.__k=v", 100)
+
+	if store.NewMinGauge("s.min") != min {
+		t.Error("NewMinGauge should return the existing GaugeMin")
+	}
+}
+
+func TestRateGauge(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStore(sink, false)
+	g := store.Scope("s").NewRateGauge("rate", RateGaugeOptions{})
+	g.Add(100)
+	store.Flush()
+	sink.AssertGaugeEquals(t, "s.rate", 0) // no previous value
+
+	r := g.(*rateGauge)
+	start := time.Now()
+	r.rate(start)
+
+	g.Add(50)
+	if v := r.rate(start.Add(2 * time.Second)); v != 25 {
+		t.Errorf("rate: got: %d want: %d", v, 25)
+	}
+	// counter reset
+	g.Set(30)
+	if v := r.rate(start.Add(3 * time.Second)); v != 30 {
+		t.Errorf("rate after reset: got: %d want: %d", v, 30)
+	}
+	if v := r.rate(start.Add(3 * time.Second)); v != 0 {
+		t.Errorf("rate with no elapsed time: got: %d want: %d", v, 0)
+	}
+
+	perMinute := store.NewRateGauge("per_minute", RateGaugeOptions{Per: time.Minute}).(*rateGauge)
+	perMinute.rate(start)
+	perMinute.Add(10)
+	if v := perMinute.rate(start.Add(30 * time.Second)); v != 20 {
+		t.Errorf("per minute rate: got: %d want: %d", v, 20)
+	}
+}
+
+func TestUpDownCounter(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStore(sink, false)
+	c := store.Scope("s").NewUpDownCounterWithTags("c", map[string]string{"k": "v"})
+	c.Add(5)
+	c.Add(-10)
+	c.Inc()
+	c.Dec()
+	c.Dec()
+	if v := c.Value(); v != -6 {
+		t.Errorf("Value: got: %d want: %d", v, -6)
+	}
+	store.Flush()
+	sink.AssertUpDownCounterEquals(t, "s.c.__k=v", -6)
+
+	// sinks that do not support negative values receive gauges
+	gaugeSink := &testStatSink{}
+	store = NewStore(gaugeSink, false)
+	store.NewUpDownCounter("neg").Add(-1)
+	store.NewUpDownCounter("pos").Add(2)
+	store.Flush()
+	for _, exp := range []string{"neg:0|g\n", "pos:2|g\n"} {
+		if !strings.Contains(gaugeSink.record, exp) {
+			t.Errorf("got: %q want to contain: %q", gaugeSink.record, exp)
+		}
+	}
+}
+
+func TestFlushAll(t *testing.T) {
+	errSync := errors.New("sync failed")
+	sink := &syncSinkStub{Sink: mock.NewSink(), err: errSync}
+	store := NewStore(sink, false)
+	store.NewCounter("c").Add(2)
+
+	if err := store.FlushAll(); err != errSync {
+		t.Errorf("FlushAll: got: %v want: %v", err, errSync)
+	}
+	if sink.synced != 1 {
+		t.Errorf("synced %d times; want: 1", sink.synced)
+	}
+	sink.AssertCounterEquals(t, "c", 2)
+
+	// sinks that are not Syncers are flushed
+	store = NewStore(mock.NewSink(), false)
+	if err := store.FlushAll(); err != nil {
+		t.Errorf("FlushAll: unexpected error: %v", err)
+	}
+	if err := (NullStore{}).FlushAll(); err != nil {
+		t.Errorf("NullStore.FlushAll: unexpected error: %v", err)
+	}
+}
+
+type closeSinkStub struct {
+	*mock.Sink
+	closed int
+}
+
+func (s *closeSinkStub) Close() error {
+	s.closed++
+	return nil
+}
+
+func TestClose(t *testing.T) {
+	sink := &closeSinkStub{Sink: mock.NewSink()}
+	store := NewStore(sink, false)
+
+	done := make(chan struct{})
+	go func() {
+		store.Start(time.NewTicker(time.Hour))
+		close(done)
+	}()
+
+	// stats written just before Close are not lost
+	store.NewCounter("c").Add(3)
+	store.NewGauge("g").Set(4)
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close: unexpected error: %v", err)
+	}
+	sink.AssertCounterEquals(t, "c", 3)
+	sink.AssertGaugeEquals(t, "g", 4)
+	if sink.closed != 1 {
+		t.Errorf("sink closed %d times; want: 1", sink.closed)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Start did not return after Close")
+	}
+
+	// Close is idempotent
+	if err := store.Close(); err != nil {
+		t.Errorf("Close: unexpected error: %v", err)
+	}
+	if sink.closed != 1 {
+		t.Errorf("sink closed %d times; want: 1", sink.closed)
+	}
+	if err := (NullStore{}).Close(); err != nil {
+		t.Errorf("NullStore.Close: unexpected error: %v", err)
+	}
+}
+
+func TestFlushAfterClose(t *testing.T) {
+	sink := &closeSinkStub{Sink: mock.NewSink()}
+	store := NewStore(sink, false)
+	c := store.NewCounter("c")
+	c.Inc()
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the Sink is closed, so nothing is written to it
+	c.Inc()
+	store.Flush()
+	if err := store.FlushAll(); err != nil {
+		t.Errorf("FlushAll: unexpected error: %v", err)
+	}
+	sink.AssertCounterCallCount(t, "c", 1)
+}
+
+// blockingStatGenerator blocks its first call to GenerateStats until
+// release is closed.
+type blockingStatGenerator struct {
+	once    sync.Once
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (g *blockingStatGenerator) GenerateStats() {
+	g.once.Do(func() {
+		close(g.entered)
+		<-g.release
+	})
+}
+
+func TestCloseDrainTimeout(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStoreWithOptions(sink, StoreOptions{DrainTimeout: time.Minute})
+	c := store.NewCounter("c")
+	c.Inc()
+
+	// Close waits for the flush of Start in progress
+	gen := &blockingStatGenerator{entered: make(chan struct{}), release: make(chan struct{})}
+	store.AddStatGenerator(gen)
+	go store.Start(time.NewTicker(time.Millisecond))
+	<-gen.entered
+
+	closed := make(chan struct{})
+	go func() {
+		store.Close()
+		close(closed)
+	}()
+	draining := func() bool {
+		s := store.(*statStore)
+		return storeState(atomic.LoadInt32(&s.state)) == storeDraining
+	}
+	for !draining() {
+		time.Sleep(time.Millisecond)
+	}
+
+	// stats created and updated while draining are flushed
+	c.Inc()
+	store.Scope("s").NewCounter("n").Add(2)
+	close(gen.release)
+	select {
+	case <-closed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Close did not return after the flush in progress")
+	}
+	sink.AssertCounterEquals(t, "c", 2)
+	sink.AssertCounterEquals(t, "s.n", 2)
+
+	// stats created once closed are not registered
+	store.NewCounter("late").Inc()
+	store.Scope("s").NewTimer("late").AddValue(1)
+	if _, ok := store.(*statStore).counters.Load("late"); ok {
+		t.Error("counter created after Close was registered")
+	}
+	store.Flush()
+	sink.AssertCounterNotExists(t, "late")
+	sink.AssertTimerNotExists(t, "s.late")
+}
+
+func TestCloseDrainTimeoutIdle(t *testing.T) {
+	store := NewStoreWithOptions(mock.NewSink(), StoreOptions{DrainTimeout: time.Hour})
+	go store.Start(time.NewTicker(time.Hour))
+
+	// nothing is in progress, so Close does not wait for the timeout
+	closed := make(chan struct{})
+	go func() {
+		store.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Close waited for the drain timeout")
+	}
+}
+
+func TestSequenceCounter(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStore(sink, false)
+	seq := store.Scope("s").NewSequenceCounterWithTags("seq", map[string]string{"k": "v"})
+
+	const n = 100
+	var wg sync.WaitGroup
+	seen := make([]uint32, n+1)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			atomic.AddUint32(&seen[seq.Next()], 1)
+		}()
+	}
+	wg.Wait()
+	for i := 1; i <= n; i++ {
+		if seen[i] != 1 {
+			t.Fatalf("Next returned %d %d times; want: 1", i, seen[i])
+		}
+	}
+	if v := seq.Value(); v != n {
+		t.Errorf("Value: got: %d want: %d", v, n)
+	}
+	store.Flush()
+	sink.AssertCounterEquals(t, "s.seq.__k=v", n)
+
+	if v := (NullScope{}).NewSequenceCounter("seq").Next(); v != 1 {
+		t.Errorf("NullScope: Next: got: %d want: %d", v, 1)
+	}
+}
+
+func TestFloatGauge(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStore(sink, false)
+	g := store.Scope("s").NewFloatGaugeWithTags("g", map[string]string{"k": "v"})
+	g.Set(1.5)
+	g.Add(2.25)
+	g.Sub(5)
+	if v := g.Value(); v != -1.25 {
+		t.Errorf("Value: got: %f want: %f", v, -1.25)
+	}
+	store.Flush()
+	sink.AssertFloatGaugeEquals(t, "s.g.__k=v", -1.25)
+
+	// sinks that do not support float values receive truncated gauges
+	gaugeSink := &testStatSink{}
+	store = NewStore(gaugeSink, false)
+	store.NewFloatGauge("neg").Set(-1.5)
+	store.NewFloatGauge("pos").Set(2.75)
+	store.Flush()
+	for _, exp := range []string{"neg:0|g\n", "pos:2|g\n"} {
+		if !strings.Contains(gaugeSink.record, exp) {
+			t.Errorf("got: %q want to contain: %q", gaugeSink.record, exp)
+		}
+	}
+}
+
+func TestUnregister(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStore(sink, false)
+	tags := map[string]string{"id": "1"}
+	scope := store.Scope("s")
+	scope.NewCounter("c").Inc()
+	scope.NewGauge("c").Set(1)
+	scope.NewCounterWithTags("tagged", tags).Inc()
+	scope.NewHistogram("h").RecordValue(1)
+	scope.NewCounter("keep").Inc()
+
+	if !store.Unregister("s.c") {
+		t.Error("Unregister: expected stat to exist")
+	}
+	if !store.UnregisterWithTags("s.tagged", tags) {
+		t.Error("UnregisterWithTags: expected stat to exist")
+	}
+	if !store.Unregister("s.h") {
+		t.Error("Unregister: expected histogram to exist")
+	}
+	if store.Unregister("s.c") {
+		t.Error("Unregister: stat should not exist after being unregistered")
+	}
+	store.Flush()
+
+	sink.AssertNoUnexpectedCounters(t, "s.keep")
+	sink.AssertGaugeNotExists(t, "s.c")
+
+	// the stat is flushed again once it is re-created
+	scope.NewCounter("c").Inc()
+	store.Flush()
+	sink.AssertCounterEquals(t, "s.c", 1)
+}
+
+func TestUnregisterPrefix(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStore(sink, false)
+	sub := store.Scope("sub")
+	sub.NewCounter("c").Inc()
+	sub.NewGaugeWithTags("g", map[string]string{"k": "v"}).Set(1)
+	sub.Scope("nested").NewTimer("t")
+	store.NewCounter("subsystem").Inc()
+	store.NewCounter("other").Inc()
+
+	if n := store.UnregisterPrefix("sub."); n != 3 {
+		t.Errorf("UnregisterPrefix: got: %d want: %d", n, 3)
+	}
+	if n := store.UnregisterPrefix("missing"); n != 0 {
+		t.Errorf("UnregisterPrefix: got: %d want: %d", n, 0)
+	}
+	store.Flush()
+	sink.AssertNoUnexpectedCounters(t, "subsystem", "other")
+	sink.AssertNoUnexpectedGauges(t)
+}
+
+func TestUnregisterPrefixConcurrent(t *testing.T) {
+	store := NewStore(NewNullSink(), false)
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					store.NewCounter("prefix." + strconv.Itoa(i)).Inc()
+					store.Flush()
+				}
+			}
+		}(i)
+	}
+	for i := 0; i < 100; i++ {
+		store.UnregisterPrefix("prefix.")
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestListStats(t *testing.T) {
+	store := NewStore(NewNullSink(), false)
+	scope := store.ScopeWithTags("s", map[string]string{"k": "v"})
+	scope.NewCounter("b")
+	scope.NewCounter("a")
+	store.NewCounter("c")
+	scope.NewGauge("g")
+	store.NewTimer("t")
+	store.NewHistogram("h") // not a counter, gauge or timer
+
+	tests := []struct {
+		name string
+		got  []string
+		want []string
+	}{
+		{"ListCounters", store.ListCounters(), []string{"c", "s.a.__k=v", "s.b.__k=v"}},
+		{"ListGauges", store.ListGauges(), []string{"s.g.__k=v"}},
+		{"ListTimers", store.ListTimers(), []string{"t"}},
+	}
+	for _, test := range tests {
+		if !reflect.DeepEqual(test.got, test.want) {
+			t.Errorf("%s: got: %q want: %q", test.name, test.got, test.want)
+		}
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStore(sink, false)
+	store.NewCounter("c").Add(2)
+	store.NewGaugeWithTags("g", map[string]string{"k": "v"}).Set(3)
+	store.NewTimer("t").AddValue(4)
+
+	want := Snapshot{
+		Counters: map[string]uint64{"c": 2},
+		Gauges:   map[string]uint64{"g.__k=v": 3},
+	}
+	snap := store.Snapshot()
+	if !reflect.DeepEqual(snap, want) {
+		t.Errorf("Snapshot: got: %+v want: %+v", snap, want)
+	}
+	// taking a snapshot does not flush or latch counters
+	sink.AssertCounterNotExists(t, "c")
+	store.Flush()
+	sink.AssertCounterEquals(t, "c", 2)
+
+	// the snapshot is a copy
+	store.NewCounter("c").Add(1)
+	if snap.Counters["c"] != 2 {
+		t.Errorf("Snapshot modified: got: %d want: %d", snap.Counters["c"], 2)
+	}
+}
+
 // Ensure 0 counters are flushed
 func TestZeroCounters(t *testing.T) {
 	sink := &testStatSink{}
@@ -72,6 +578,238 @@ func TestZeroCounters(t *testing.T) {
 	}
 }
 
+func TestCounterAddAllocs(t *testing.T) {
+	store := NewStore(nullSink{}, false)
+	counter := store.NewCounterWithTags("c", map[string]string{"k": "v"})
+	allocs := testing.AllocsPerRun(100, func() {
+		counter.Add(2)
+		counter.Inc()
+	})
+	if allocs != 0 {
+		t.Errorf("allocs: got: %.1f want: 0", allocs)
+	}
+	if v := counter.Value(); v != 303 {
+		t.Errorf("value: got: %d want: %d", v, 303)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStore(sink, true)
+	tags := map[string]string{"tag1": "val1"}
+	h := store.Scope("scope").NewHistogramWithTags("hist", tags)
+	if h != store.Scope("scope").NewHistogramWithTags("hist", tags) {
+		t.Error("A new histogram with the same name was returned")
+	}
+
+	for _, v := range []float64{0.5, 1, 3, 3.75, 100000} {
+		h.RecordValue(v)
+	}
+	store.Flush()
+
+	bucket := func(le string) string {
+		return mock.SerializeTags("scope.hist.bucket", map[string]string{"tag1": "val1", "le": le})
+	}
+	sink.AssertCounterEquals(t, bucket("1"), 2)
+	sink.AssertCounterEquals(t, bucket("2"), 2)
+	sink.AssertCounterEquals(t, bucket("4"), 4)
+	sink.AssertCounterEquals(t, bucket("32768"), 4)
+	sink.AssertCounterEquals(t, bucket("+Inf"), 5)
+	sink.AssertCounterEquals(t, mock.SerializeTags("scope.hist.count", tags), 5)
+	sink.AssertFloatGaugeEquals(t, mock.SerializeTags("scope.hist.sum", tags), 100008.25)
+
+	// Only new observations are counted, the sum is the total
+	sink.Reset()
+	h.RecordValue(0.5)
+	store.Flush()
+	sink.AssertCounterEquals(t, bucket("1"), 1)
+	sink.AssertCounterEquals(t, bucket("32768"), 1)
+	sink.AssertCounterEquals(t, bucket("+Inf"), 1)
+	sink.AssertCounterEquals(t, mock.SerializeTags("scope.hist.count", tags), 1)
+	sink.AssertFloatGaugeEquals(t, mock.SerializeTags("scope.hist.sum", tags), 100008.75)
+}
+
+func TestHistogramOptions(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStore(sink, true)
+	opts := HistogramOptions{Buckets: []float64{0.5, 0.125, 0.5, math.Inf(1)}}
+	h := store.NewHistogramWithOptions("hist", nil, opts)
+
+	for _, v := range []float64{0, 0.125, 0.25, 2, -1} {
+		h.RecordValue(v)
+	}
+	store.Flush()
+
+	bucket := func(le string) string {
+		return mock.SerializeTags("hist.bucket", map[string]string{"le": le})
+	}
+	sink.AssertCounterEquals(t, bucket("0.125"), 3)
+	sink.AssertCounterEquals(t, bucket("0.5"), 4)
+	sink.AssertCounterEquals(t, bucket("+Inf"), 5)
+	sink.AssertCounterNotExists(t, bucket("1"))
+	sink.AssertFloatGaugeEquals(t, "hist.sum", 1.375)
+}
+
+func TestDistribution(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStore(sink, true)
+	d := store.Scope("scope").NewDistributionWithTags("dist", map[string]string{"tag1": "val1"})
+	d.RecordValue(1.5)
+	d.RecordValue(2)
+
+	name := mock.SerializeTags("scope.dist", map[string]string{"tag1": "val1"})
+	sink.AssertDistributionEquals(t, name, 3.5)
+	sink.AssertDistributionCallCount(t, name, 2)
+	sink.AssertGaugeNotExists(t, name)
+}
+
+// Ensure distributions are flushed as gauges to sinks that do not support them
+func TestDistributionGaugeFallback(t *testing.T) {
+	sink := &testStatSink{}
+	store := NewStore(sink, true)
+	store.NewDistribution("test").RecordValue(5.7)
+
+	expected := "test:5|g\n"
+	if sink.record != expected {
+		t.Errorf("wanted %q got %q", expected, sink.record)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStore(sink, true)
+	tags := map[string]string{"tag1": "val1"}
+	opts := SummaryOptions{Quantiles: []float64{0.5, 0.9, 0.99}}
+	s := store.Scope("scope").NewSummaryWithTags("summary", tags, opts)
+
+	for _, i := range rand.Perm(100) {
+		s.RecordValue(float64(i + 1))
+	}
+	store.Flush()
+
+	quantile := func(q string) string {
+		return mock.SerializeTags("scope.summary", map[string]string{"tag1": "val1", "quantile": q})
+	}
+	sink.AssertFloatGaugeEquals(t, quantile("0.5"), 50)
+	sink.AssertFloatGaugeEquals(t, quantile("0.9"), 90)
+	sink.AssertFloatGaugeEquals(t, quantile("0.99"), 99)
+	sink.AssertCounterEquals(t, mock.SerializeTags("scope.summary.count", tags), 100)
+	sink.AssertFloatGaugeEquals(t, mock.SerializeTags("scope.summary.sum", tags), 5050)
+}
+
+func TestSummaryMaxAge(t *testing.T) {
+	sink := mock.NewSink()
+	clock := mock.NewFakeClock(time.Unix(1000, 0))
+	store := NewStoreWithOptions(sink, StoreOptions{Clock: clock})
+	s := store.NewSummary("summary", SummaryOptions{MaxAge: time.Millisecond})
+	s.RecordValue(1)
+	clock.Advance(time.Millisecond * 5)
+	store.Flush()
+
+	sink.AssertFloatGaugeNotExists(t, mock.SerializeTags("summary", map[string]string{"quantile": "0.5"}))
+	sink.AssertCounterEquals(t, "summary.count", 1)
+}
+
+func TestSummaryRing(t *testing.T) {
+	r := summaryRing{max: 40}
+	start := time.Unix(1000, 0)
+	for i := 0; i < 100; i++ {
+		r.push(summarySample{value: float64(i), time: start.Add(time.Duration(i) * time.Second)})
+		if i == 69 {
+			// the oldest sample is no longer at the start of the ring
+			r.evict(start.Add(64 * time.Second))
+		}
+	}
+	r.evict(start.Add(70 * time.Second))
+	if r.n != 29 {
+		t.Fatalf("samples: got: %d want: %d", r.n, 29)
+	}
+	for i := 0; i < r.n; i++ {
+		if v := r.at(i).value; v != float64(71+i) {
+			t.Errorf("sample %d: got: %g want: %d", i, v, 71+i)
+		}
+	}
+}
+
+func TestSummaryMaxSamples(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStore(sink, true)
+	s := store.NewSummary("summary", SummaryOptions{Quantiles: []float64{0, 1}, MaxSamples: 20})
+	for i := 0; i < 100; i++ {
+		s.RecordValue(float64(i) / 100)
+	}
+	store.Flush()
+
+	// only the 20 most recent observations are kept
+	quantile := func(q string) string {
+		return mock.SerializeTags("summary", map[string]string{"quantile": q})
+	}
+	sink.AssertFloatGaugeEquals(t, quantile("0"), 0.8)
+	sink.AssertFloatGaugeEquals(t, quantile("1"), 0.99)
+	sink.AssertCounterEquals(t, "summary.count", 100)
+}
+
+func TestStoreClock(t *testing.T) {
+	sink := mock.NewSink()
+	clock := mock.NewFakeClock(time.Unix(1000, 0))
+	store := NewStoreWithOptions(sink, StoreOptions{Clock: clock})
+
+	timer := store.Scope("s").NewTimer("t")
+	tc := timer.Start()
+	clock.Advance(2 * time.Millisecond)
+	if d := tc.Stop(); d != 2*time.Millisecond {
+		t.Errorf("Stop: got: %s want: %s", d, 2*time.Millisecond)
+	}
+	span := timer.AllocateSpan()
+	clock.Advance(3 * time.Millisecond)
+	span.Complete()
+	timer.Time(func() { clock.Advance(time.Millisecond) })
+	sink.AssertTimerCallCount(t, "s.t", 3)
+
+	// wrapped Timers use the same clock
+	timer = store.WithOptions(WithPredicate(func() bool { return true })).NewTimer("p")
+	tc = timer.Start()
+	clock.Advance(time.Millisecond)
+	if d := tc.Stop(); d != time.Millisecond {
+		t.Errorf("Stop: got: %s want: %s", d, time.Millisecond)
+	}
+
+	g := store.NewRateGauge("rate", RateGaugeOptions{})
+	store.Flush()
+	g.Add(10)
+	clock.Advance(2 * time.Second)
+	store.Flush()
+	sink.AssertGaugeEquals(t, "rate", 5)
+}
+
+type requestIDKey struct{}
+
+func TestContextTags(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStore(sink, true)
+	store.SetTagsFromContext(func(ctx context.Context) map[string]string {
+		if id, ok := ctx.Value(requestIDKey{}).(string); ok {
+			return map[string]string{"request_id": id, "tag1": "ctx"}
+		}
+		return nil
+	})
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "abc")
+
+	store.NewCounterCtx(ctx, "counter").Inc()
+	store.NewCounterCtx(context.Background(), "no_tags").Inc()
+	store.Scope("scope").NewGaugeWithTagsCtx(ctx, "gauge", map[string]string{"tag1": "val1"}).Set(2)
+	store.NewTimerCtx(ctx, "timer").AddValue(3)
+	store.Flush()
+
+	sink.AssertCounterEquals(t, mock.SerializeTags("counter",
+		map[string]string{"request_id": "abc", "tag1": "ctx"}), 1)
+	sink.AssertCounterEquals(t, "no_tags", 1)
+	sink.AssertGaugeEquals(t, mock.SerializeTags("scope.gauge",
+		map[string]string{"request_id": "abc", "tag1": "val1"}), 2)
+	sink.AssertTimerEquals(t, mock.SerializeTags("timer",
+		map[string]string{"request_id": "abc", "tag1": "ctx"}), 3)
+}
+
 func randomString(tb testing.TB, size int) string {
 	b := make([]byte, hex.DecodedLen(size))
 	if _, err := crand.Read(b); err != nil {
@@ -119,6 +857,193 @@ func TestNewSubScope(t *testing.T) {
 	}
 }
 
+func TestScopeTagInheritance(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStore(sink, false)
+
+	parent := store.ScopeWithTags("parent", map[string]string{"a": "1", "b": "1"})
+	child := parent.Scope("child")
+	child.NewCounter("c").Inc()
+	child.NewCounterWithTags("override", map[string]string{"b": "2"}).Inc()
+	child.Scope("grandchild").NewGauge("g").Set(1)
+	store.Flush()
+
+	sink.AssertCounterEquals(t, "parent.child.c.__a=1.__b=1", 1)
+	sink.AssertCounterEquals(t, "parent.child.override.__a=1.__b=2", 1)
+	sink.AssertGaugeEquals(t, "parent.child.grandchild.g.__a=1.__b=1", 1)
+}
+
+func TestScopeParent(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStore(sink, false)
+	stores := map[string]Store{
+		"statStore":          store,
+		"CardinalityLimiter": NewCardinalityLimiter(NewStore(sink, false), 0),
+		"DefaultTags":        NewStoreWithOptions(sink, StoreOptions{DefaultTags: map[string]string{"k": "v"}}),
+	}
+	for name, store := range stores {
+		if p := store.Parent(); p != nil {
+			t.Errorf("%s: root Parent: got: %v want: nil", name, p)
+		}
+		foo := store.Scope("foo")
+		bar := foo.ScopeWithTags("bar", map[string]string{"a": "1"})
+		if p := bar.Parent(); p != foo {
+			t.Errorf("%s: Parent: got: %v want: %v", name, p, foo)
+		}
+		if p := foo.Parent(); p == nil || p.Parent() != nil {
+			t.Errorf("%s: Parent of the first scope should be the root", name)
+		}
+	}
+
+	// the parent of a scope created by WithOptions keeps the options
+	scope := store.Scope("a").WithOptions(WithPredicate(func() bool { return false }))
+	child := scope.Scope("b")
+	if p := child.Parent(); p != scope {
+		t.Errorf("Parent: got: %v want: %v", p, scope)
+	}
+	child.Parent().NewCounter("c").Inc()
+	store.Flush()
+	sink.AssertCounterEquals(t, "a.c", 0)
+
+	if p := (NullScope{}).Parent(); p != nil {
+		t.Errorf("NullScope.Parent: got: %v want: nil", p)
+	}
+}
+
+func TestScopeName(t *testing.T) {
+	stores := map[string]Store{
+		"statStore":          NewStore(mock.NewSink(), false),
+		"CardinalityLimiter": NewCardinalityLimiter(NewStore(mock.NewSink(), false), 0),
+		"DefaultTags":        NewStoreWithOptions(mock.NewSink(), StoreOptions{DefaultTags: map[string]string{"k": "v"}}),
+	}
+	for name, store := range stores {
+		if store.Name() != "" || store.FullName() != "" {
+			t.Errorf("%s: root: got: %q, %q want empty names", name, store.Name(), store.FullName())
+		}
+		scope := store.Scope("foo").ScopeWithTags("bar", map[string]string{"a": "1"}).
+			WithOptions(WithPredicate(func() bool { return true })).
+			ScopeWithOptions("baz.qux", ScopeOptions{})
+		if v := scope.Name(); v != "baz.qux" {
+			t.Errorf("%s: Name: got: %q want: %q", name, v, "baz.qux")
+		}
+		if v := scope.FullName(); v != "foo.bar.baz.qux" {
+			t.Errorf("%s: FullName: got: %q want: %q", name, v, "foo.bar.baz.qux")
+		}
+		if v := scope.Parent().Name(); v != "bar" {
+			t.Errorf("%s: Parent().Name: got: %q want: %q", name, v, "bar")
+		}
+	}
+}
+
+func TestScopeAsStore(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStore(sink, false)
+	if store.AsStore() != store {
+		t.Error("AsStore of a Store should return the Store")
+	}
+
+	flushCounter := func(store Store, name string) {
+		store.NewCounter(name).Inc()
+		store.Flush()
+	}
+	scoped := store.Scope("foo").ScopeWithTags("bar", map[string]string{"a": "1"}).AsStore()
+	flushCounter(scoped, "c")
+	sink.AssertCounterEquals(t, "foo.bar.c.__a=1", 1)
+	flushCounter(scoped.Scope("baz").AsStore(), "c")
+	sink.AssertCounterEquals(t, "foo.bar.baz.c.__a=1", 1)
+	if scoped.AsStore() != scoped {
+		t.Error("AsStore of a scoped Store should return the Store")
+	}
+
+	limiter := NewCardinalityLimiter(store, 1)
+	limited := limiter.Scope("l").AsStore()
+	limited.NewCounterWithTags("c", map[string]string{"k": "1"}).Inc()
+	limited.NewCounterWithTags("c", map[string]string{"k": "2"}).Inc() // limited
+	limited.Flush()
+	sink.AssertCounterEquals(t, "l.c.__k=1", 1)
+	sink.AssertCounterNotExists(t, "l.c.__k=2")
+
+	flushCounter(store.Scope("o").WithOptions(WithPredicate(func() bool { return true })).AsStore(), "c")
+	sink.AssertCounterEquals(t, "o.c", 1)
+
+	if _, ok := (NullScope{}).AsStore().(NullStore); !ok {
+		t.Error("NullScope.AsStore should return a NullStore")
+	}
+}
+
+func TestNewChildStore(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStoreWithOptions(sink, StoreOptions{DefaultTags: map[string]string{"k": "v"}})
+	child := store.NewChildStore("child")
+	child.NewCounter("c").Inc()
+	child.NewChildStore("grandchild").NewGauge("g").Set(2)
+	child.Flush()
+	sink.AssertCounterEquals(t, "child.c.__k=v", 1)
+	sink.AssertGaugeEquals(t, "child.grandchild.g.__k=v", 2)
+
+	limiter := NewCardinalityLimiter(NewStore(sink, false), 0)
+	limiter.NewChildStore("l").NewCounter("c").Inc()
+	limiter.Flush()
+	sink.AssertCounterEquals(t, "l.c", 1)
+
+	if _, ok := (NullStore{}).NewChildStore("n").(NullStore); !ok {
+		t.Error("NullStore.NewChildStore should return a NullStore")
+	}
+}
+
+func TestScopeWithTagsMerge(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStore(sink, false)
+
+	parentTags := map[string]string{"a": "1", "b": "1"}
+	parent := store.ScopeWithTags("parent", parentTags)
+	child := parent.ScopeWithTags("child", map[string]string{"b": "2", "c": "2"})
+	child.NewCounter("c").Inc()
+	child.ScopeWithTags("grandchild", map[string]string{"a": "3"}).NewCounter("c").Inc()
+	parent.NewCounter("c").Inc()
+	store.Flush()
+
+	sink.AssertCounterEquals(t, "parent.child.c.__a=1.__b=2.__c=2", 1)
+	sink.AssertCounterEquals(t, "parent.child.grandchild.c.__a=3.__b=2.__c=2", 1)
+	// the parent scope is not modified by its children
+	sink.AssertCounterEquals(t, "parent.c.__a=1.__b=1", 1)
+}
+
+func TestScopeWithTag(t *testing.T) {
+	sink := mock.NewSink()
+	stores := map[string]Store{
+		"Store":              NewStore(sink, false),
+		"CardinalityLimiter": NewCardinalityLimiter(NewStore(sink, false), 100),
+	}
+	for name, store := range stores {
+		sink.Reset()
+		http := store.Scope("http")
+		get := http.WithTag("method", "GET")
+		get.NewCounter("requests").Inc()
+		get.WithTag("code", "200").WithTag("method", "POST").NewCounter("requests").Inc()
+		get.Scope("client").NewCounter("requests").Inc()
+		http.NewCounter("requests").Inc()
+		store.WithTag("k", "v").NewCounter("c").Inc()
+		store.WithOptions().WithTag("k", "v").NewGauge("g").Set(1)
+		store.Flush()
+
+		sink.AssertCounterEquals(t, "http.requests.__method=GET", 1)
+		sink.AssertCounterEquals(t, "http.requests.__code=200.__method=POST", 1)
+		sink.AssertCounterEquals(t, "http.client.requests.__method=GET", 1)
+		sink.AssertCounterEquals(t, "http.requests", 1)
+		sink.AssertCounterEquals(t, "c.__k=v", 1)
+		sink.AssertGaugeEquals(t, "g.__k=v", 1)
+
+		if get.Name() != "http" || get.FullName() != "http" || get.Parent() != http.Parent() {
+			t.Errorf("%s: WithTag: got: name %q full name %q want: the name and parent of the scope",
+				name, get.Name(), get.FullName())
+		}
+	}
+	if _, ok := (NullScope{}).WithTag("k", "v").(NullScope); !ok {
+		t.Error("NullScope.WithTag should return a NullScope")
+	}
+}
+
 // Test that we never modify the tags map that is passed in
 func TestTagMapNotModified(t *testing.T) {
 	type TagMethod func(scope Scope, name string, tags map[string]string)
@@ -140,6 +1065,9 @@ func TestTagMapNotModified(t *testing.T) {
 		"ScopeWithTags": func(scope Scope, name string, tags map[string]string) {
 			scope.ScopeWithTags(name, tags)
 		},
+		"ScopeWithOptions": func(scope Scope, name string, tags map[string]string) {
+			scope.ScopeWithOptions(name, ScopeOptions{Tags: tags})
+		},
 		"NewCounterWithTags": func(scope Scope, name string, tags map[string]string) {
 			scope.NewCounterWithTags(name, tags)
 		},
@@ -158,6 +1086,45 @@ func TestTagMapNotModified(t *testing.T) {
 		"NewPerInstanceTimer": func(scope Scope, name string, tags map[string]string) {
 			scope.NewPerInstanceTimer(name, tags)
 		},
+		"NewHistogramWithTags": func(scope Scope, name string, tags map[string]string) {
+			scope.NewHistogramWithTags(name, tags)
+		},
+		"NewDistributionWithTags": func(scope Scope, name string, tags map[string]string) {
+			scope.NewDistributionWithTags(name, tags)
+		},
+		"NewPerInstanceDistribution": func(scope Scope, name string, tags map[string]string) {
+			scope.NewPerInstanceDistribution(name, tags)
+		},
+		"NewSummaryWithTags": func(scope Scope, name string, tags map[string]string) {
+			scope.NewSummaryWithTags(name, tags, SummaryOptions{})
+		},
+		"NewMinGaugeWithTags": func(scope Scope, name string, tags map[string]string) {
+			scope.NewMinGaugeWithTags(name, tags)
+		},
+		"NewMaxGaugeWithTags": func(scope Scope, name string, tags map[string]string) {
+			scope.NewMaxGaugeWithTags(name, tags)
+		},
+		"NewRateGaugeWithTags": func(scope Scope, name string, tags map[string]string) {
+			scope.NewRateGaugeWithTags(name, tags, RateGaugeOptions{})
+		},
+		"NewUpDownCounterWithTags": func(scope Scope, name string, tags map[string]string) {
+			scope.NewUpDownCounterWithTags(name, tags)
+		},
+		"NewSequenceCounterWithTags": func(scope Scope, name string, tags map[string]string) {
+			scope.NewSequenceCounterWithTags(name, tags)
+		},
+		"NewWindowedCounterWithTags": func(scope Scope, name string, tags map[string]string) {
+			scope.NewWindowedCounterWithTags(name, tags, WindowedCounterOptions{})
+		},
+		"NewEWMAGaugeWithTags": func(scope Scope, name string, tags map[string]string) {
+			scope.NewEWMAGaugeWithTags(name, tags, 0.5)
+		},
+		"NewFloatGaugeWithTags": func(scope Scope, name string, tags map[string]string) {
+			scope.NewFloatGaugeWithTags(name, tags)
+		},
+		"NewPerInstanceFloatGauge": func(scope Scope, name string, tags map[string]string) {
+			scope.NewPerInstanceFloatGauge(name, tags)
+		},
 	}
 
 	tagsTestCases := []map[string]string{
@@ -405,6 +1372,24 @@ func BenchmarkStore_ScopeNoTags(b *testing.B) {
 	}
 }
 
+var benchmarkString string
+
+func BenchmarkJoinScopes(b *testing.B) {
+	parent, child := "service.handler", "requests"
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		benchmarkString = joinScopes(parent, child)
+	}
+}
+
+func BenchmarkJoinScopes_Reference(b *testing.B) {
+	parent, child := "service.handler", "requests"
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		benchmarkString = parent + "." + child
+	}
+}
+
 func BenchmarkParallelCounter(b *testing.B) {
 	const N = 1000
 	keys := make([]string, N)
@@ -422,10 +1407,33 @@ func BenchmarkParallelCounter(b *testing.B) {
 		n := 0
 		for pb.Next() {
 			s.NewCounter(keys[n%N]).Inc()
+			n++
 		}
 	})
 }
 
+func BenchmarkCounterAdd(b *testing.B) {
+	s := NewStore(nullSink{}, false)
+	c := s.NewCounterWithTags("counter_name", map[string]string{"k": "v"})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Add(1)
+	}
+}
+
+func BenchmarkSummaryFlush(b *testing.B) {
+	s := newSummary("summary", nil, SummaryOptions{})
+	for i := 0; i < 1000; i++ {
+		s.RecordValue(float64(i))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.flush(nullSink{})
+	}
+}
+
 func BenchmarkStoreNewPerInstanceCounter(b *testing.B) {
 	b.Run("HasTag", func(b *testing.B) {
 		var store statStore
@@ -0,0 +1,195 @@
+package stats
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+// StoreOptions configures a Store created with NewStoreWithOptions. The zero
+// value is the configuration of NewStore.
+type StoreOptions struct {
+	// DefaultTags are added to the Tags of every stat of the Store,
+	// including the stats created by its scopes and by the Store returned
+	// by their Store method. Tags passed when creating a stat or a scope
+	// take precedence.
+	DefaultTags map[string]string
+
+	// EnvTags are the names of environment variables that are read when the
+	// Store is created and added to the DefaultTags, the key of each tag is
+	// the lowercase variable name and its value the value of the variable.
+	// Variables that are not set or empty are skipped and DefaultTags take
+	// precedence. See WithEnvTags.
+	EnvTags []string
+
+	// FlushInterval starts flushing the Store at this interval, as if by
+	// Start, if it is greater than zero. Close stops the flushes.
+	FlushInterval time.Duration
+
+	// DrainTimeout is the maximum time Close waits for the flushes in
+	// progress, started by Start, FlushInterval or WithFlushInterval, to
+	// finish before the final flush. Close keeps accepting new stats while
+	// it waits. Stats created or updated before the final flush are
+	// flushed, stats created after it are not registered and updates to
+	// existing stats are dropped. Zero closes the Store without waiting.
+	DrainTimeout time.Duration
+
+	// Clock is used by the time dependent stats, if nil the system clock
+	// is used.
+	Clock Clock
+
+	// CardinalityLimit wraps the Store with a CardinalityLimiter with this
+	// limit if it is greater than zero.
+	CardinalityLimit int
+
+	// PerInstanceSuffix is the default value of the per-instance tag ("_f")
+	// added by the NewPerInstance methods, if empty "i" is used. It can be
+	// overridden per scope with ScopeOptions.
+	PerInstanceSuffix string
+
+	// OnMetricCreate is called synchronously when a stat is registered with
+	// the Store, that is the first time it is created or the first time
+	// after it was unregistered, with its kind, name and tags. The name
+	// includes any scopes. The kind is one of
+	// "counter", "gauge", "timer", "histogram", "distribution", "summary",
+	// "gauge_min", "gauge_max", "rate_gauge", "updown_counter",
+	// "windowed_counter", "ewma_gauge" or "float_gauge".
+	//
+	// It may be called concurrently by multiple goroutines.
+	OnMetricCreate func(kind, name string, tags map[string]string)
+
+	// StatGeneratorTimeout is how long a flush waits for each StatGenerator
+	// to return, if it is greater than zero. A generator that times out
+	// keeps running in the background and is skipped by the flushes until it
+	// returns, the stats it generates are flushed by the next flush.
+	StatGeneratorTimeout time.Duration
+
+	// OnError is called with the errors of the Sink, see Syncer, during the
+	// flushes started by Start or FlushInterval, and with the errors of the
+	// ErrorAwareStatGenerators during all flushes. If nil the errors of the
+	// Sink are ignored and the errors of the generators are logged.
+	OnError func(error)
+
+	// DeduplicateFlushes merges the Counters, Gauges and FloatGauges
+	// flushed with the same name and Tags during a flush before passing
+	// them to the Sink, so that backends that do not aggregate do not count
+	// them twice. This happens when stats of different types resolve to the
+	// same name, for example a Counter "latency.count" and the count of a
+	// Histogram "latency". Counters are summed and the last value of a
+	// Gauge or FloatGauge wins.
+	DeduplicateFlushes bool
+
+	// NormalizeTagValue, if not nil, is called with the key and value of
+	// each tag of a stat when the stat is created and returns the value to
+	// use, for example to lowercase or truncate values so that the same
+	// dimension written differently maps to one stat. The value passed has
+	// its invalid chars already replaced, any invalid chars in the returned
+	// value are replaced and tags with an empty returned value are removed.
+	//
+	// It must be deterministic and may be called concurrently by multiple
+	// goroutines.
+	NormalizeTagValue func(key, value string) string
+
+	// TagKeyAliases renames tag keys when a stat is created, it maps old
+	// keys to new keys, for example {"host": "hostname"}, to migrate a tag
+	// without updating all the call sites at once. A warning is logged the
+	// first time a stat name is used with an old key. If a stat has both the
+	// old and the new key the value of the new key is kept. Renamed tags
+	// are passed to NormalizeTagValue with their new key.
+	TagKeyAliases map[string]string
+}
+
+// NewStoreWithOptions returns an Empty store that flushes to Sink passed as
+// an argument, configured with opts.
+func NewStoreWithOptions(sink Sink, opts StoreOptions) Store {
+	s := &statStore{
+		sink:         sink,
+		drainTimeout: opts.DrainTimeout,
+		clock:        opts.Clock,
+		onError:      opts.OnError,
+		perInstance:  opts.PerInstanceSuffix,
+		onCreate:     opts.OnMetricCreate,
+		genTimeout:   opts.StatGeneratorTimeout,
+		dedup:        opts.DeduplicateFlushes,
+		normalize:    opts.NormalizeTagValue,
+	}
+	if len(opts.TagKeyAliases) != 0 {
+		s.tagAliases = make(map[string]string, len(opts.TagKeyAliases))
+		for old, key := range opts.TagKeyAliases {
+			s.tagAliases[old] = key
+		}
+	}
+	var store Store = s
+	if defaultTags := envTags(opts.EnvTags, opts.DefaultTags); len(defaultTags) != 0 {
+		root := newSubScope(s, "", defaultTags)
+		store = newScopedStore(root, s)
+		root.store = store // so the stats created with Scope.Store have the tags
+	}
+	if opts.CardinalityLimit > 0 {
+		store = NewCardinalityLimiter(store, opts.CardinalityLimit)
+	}
+	if opts.FlushInterval > 0 {
+		go s.Start(time.NewTicker(opts.FlushInterval))
+	}
+	return store
+}
+
+// WithEnvTags returns a copy of o that adds the environment variables
+// envVars to EnvTags, for example to tag all the stats with the deployment
+// metadata of the process:
+//
+//	stats.NewStoreWithOptions(sink, stats.StoreOptions{}.WithEnvTags("POD_NAME", "REGION"))
+func (o StoreOptions) WithEnvTags(envVars ...string) StoreOptions {
+	o.EnvTags = append(o.EnvTags[:len(o.EnvTags):len(o.EnvTags)], envVars...)
+	return o
+}
+
+// envTags returns tags with the tags of the environment variables envVars
+// added, see StoreOptions.EnvTags.
+func envTags(envVars []string, tags map[string]string) map[string]string {
+	if len(envVars) == 0 {
+		return tags
+	}
+	m := make(map[string]string, len(envVars)+len(tags))
+	for _, name := range envVars {
+		if v, ok := os.LookupEnv(name); ok && v != "" {
+			m[strings.ToLower(name)] = v
+		}
+	}
+	for k, v := range tags {
+		m[k] = v
+	}
+	return m
+}
+
+// ScopeOptions configures a Scope created with ScopeWithOptions.
+type ScopeOptions struct {
+	// Tags of the scope, as passed to ScopeWithTags. A tag with the value
+	// ExcludedTag is not inherited from the parent scope.
+	Tags map[string]string
+
+	// PerInstanceSuffix overrides StoreOptions.PerInstanceSuffix for the
+	// scope and its subscopes. If empty the value of the parent is used.
+	PerInstanceSuffix string
+}
+
+// ExcludedTag is a sentinel tag value. A tag with this value in the tags of
+// a scope, passed to ScopeWithTags or ScopeWithOptions, removes the tag with
+// the same key inherited from the parent scope or the DefaultTags of the
+// Store. It is only valid in the tags of a scope, not of a stat.
+const ExcludedTag = "\x00excluded"
+
+// ExcludeTag returns a copy of o whose scope does not inherit the tag key
+// from its parent, for example a per-request scope that should not have the
+// "version" tag of its parent:
+//
+//	scope.ScopeWithOptions("request", stats.ScopeOptions{}.ExcludeTag("version"))
+func (o ScopeOptions) ExcludeTag(key string) ScopeOptions {
+	tags := make(map[string]string, len(o.Tags)+1)
+	for k, v := range o.Tags {
+		tags[k] = v
+	}
+	tags[key] = ExcludedTag
+	o.Tags = tags
+	return o
+}
@@ -0,0 +1,347 @@
+package stats
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lyft/gostats/mock"
+	logger "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestNewStoreWithOptions(t *testing.T) {
+	if _, ok := NewStoreWithOptions(mock.NewSink(), StoreOptions{}).(*statStore); !ok {
+		t.Error("the zero StoreOptions should return a *statStore")
+	}
+
+	sink := mock.NewSink()
+	store := NewStoreWithOptions(sink, StoreOptions{
+		DefaultTags:      map[string]string{"env": "test", "k": "default"},
+		CardinalityLimit: 1,
+	})
+	store.NewCounter("c").Inc()
+	store.NewCounterWithTags("c", map[string]string{"k": "v"}).Inc() // limited
+	store.ScopeWithTags("s", map[string]string{"k": "scope"}).NewGauge("g").Set(2)
+	store.Flush()
+
+	sink.AssertCounterEquals(t, "c.__env=test.__k=default", 1)
+	sink.AssertCounterNotExists(t, "c.__env=test.__k=v")
+	sink.AssertGaugeEquals(t, "s.g.__env=test.__k=scope", 2)
+	sink.AssertCounterEquals(t, CardinalityLimitedStatName+".__env=test.__k=default", 1)
+}
+
+func TestStoreOptionsFlushInterval(t *testing.T) {
+	errSync := errors.New("sync failed")
+	sink := &syncSinkStub{Sink: mock.NewSink(), err: errSync}
+	errs := make(chan error, 1)
+	store := NewStoreWithOptions(sink, StoreOptions{
+		FlushInterval: time.Millisecond,
+		OnError: func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		},
+	})
+	store.NewCounter("c").Inc()
+
+	select {
+	case err := <-errs:
+		if err != errSync {
+			t.Errorf("OnError: got: %v want: %v", err, errSync)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the store was not flushed")
+	}
+	store.Close()
+	sink.AssertCounterEquals(t, "c", 1)
+}
+
+func TestPerInstanceSuffix(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStoreWithOptions(sink, StoreOptions{PerInstanceSuffix: "instance"})
+	store.NewPerInstanceCounter("c", nil).Inc()
+	store.NewPerInstanceGauge("g", map[string]string{"k": "v"}).Set(1)
+	store.Scope("s").NewPerInstanceCounter("c", nil).Inc()
+
+	scope := store.ScopeWithOptions("o", ScopeOptions{
+		Tags:              map[string]string{"k": "v"},
+		PerInstanceSuffix: "per_instance",
+	})
+	scope.NewPerInstanceCounter("c", nil).Inc()
+	scope.Scope("child").NewPerInstanceCounter("c", nil).Inc() // inherited
+	scope.NewPerInstanceCounter("f", map[string]string{"_f": "x"}).Inc()
+	store.Flush()
+
+	sink.AssertCounterEquals(t, "c.___f=instance", 1)
+	sink.AssertGaugeEquals(t, "g.___f=instance.__k=v", 1)
+	sink.AssertCounterEquals(t, "s.c.___f=instance", 1)
+	sink.AssertCounterEquals(t, "o.c.___f=per_instance.__k=v", 1)
+	sink.AssertCounterEquals(t, "o.child.c.___f=per_instance.__k=v", 1)
+	sink.AssertCounterEquals(t, "o.f.___f=x.__k=v", 1)
+}
+
+func TestDefaultTags(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStoreWithOptions(sink, StoreOptions{DefaultTags: map[string]string{"env": "prod"}})
+	store.NewCounter("a").Inc()
+	store.Scope("s").NewCounter("b").Inc()
+	store.Scope("s").Store().NewCounter("c").Inc()
+	store.Scope("s").Scope("t").Store().NewGauge("d").Set(1)
+	store.ScopeWithTags("o", map[string]string{"env": "dev"}).NewCounter("e").Inc()
+	store.NewCounterWithTags("f", map[string]string{"env": "dev"}).Inc()
+	store.Flush()
+
+	sink.AssertCounterEquals(t, "a.__env=prod", 1)
+	sink.AssertCounterEquals(t, "s.b.__env=prod", 1)
+	sink.AssertCounterEquals(t, "c.__env=prod", 1)
+	sink.AssertGaugeEquals(t, "d.__env=prod", 1)
+	sink.AssertCounterEquals(t, "o.e.__env=dev", 1)
+	sink.AssertCounterEquals(t, "f.__env=dev", 1)
+}
+
+func TestEnvTags(t *testing.T) {
+	reset := testSetenv(t,
+		"GOSTATS_TEST_REGION", "us-east-1",
+		"GOSTATS_TEST_POD", "api-1",
+	)
+	defer reset()
+	// testSetenv unsets empty values
+	prev, exists := os.LookupEnv("GOSTATS_TEST_EMPTY")
+	if err := os.Setenv("GOSTATS_TEST_EMPTY", ""); err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		defer os.Setenv("GOSTATS_TEST_EMPTY", prev)
+	} else {
+		defer os.Unsetenv("GOSTATS_TEST_EMPTY")
+	}
+
+	sink := mock.NewSink()
+	opts := StoreOptions{DefaultTags: map[string]string{"gostats_test_pod": "default"}}
+	store := NewStoreWithOptions(sink, opts.WithEnvTags(
+		"GOSTATS_TEST_REGION", "GOSTATS_TEST_POD", "GOSTATS_TEST_EMPTY", "GOSTATS_TEST_UNSET"))
+	store.NewCounter("c").Inc()
+	store.Flush()
+
+	sink.AssertCounterEquals(t, "c.__gostats_test_pod=default.__gostats_test_region=us-east-1", 1)
+	if len(opts.EnvTags) != 0 {
+		t.Errorf("WithEnvTags modified the options: %v", opts.EnvTags)
+	}
+}
+
+func TestExcludeTag(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStoreWithOptions(sink, StoreOptions{DefaultTags: map[string]string{"env": "prod"}})
+	service := store.ScopeWithTags("svc", map[string]string{"version": "1", "region": "us"})
+	request := service.ScopeWithOptions("req", ScopeOptions{}.ExcludeTag("version"))
+	request.NewCounter("c").Inc()
+	request.Scope("child").NewCounter("c").Inc() // excluded from the child too
+	service.ScopeWithTags("nodefault", map[string]string{"env": ExcludedTag}).NewCounter("c").Inc()
+	store.ScopeWithOptions("root", ScopeOptions{}.ExcludeTag("missing")).NewCounter("c").Inc()
+	service.NewCounter("c").Inc()
+	store.Flush()
+
+	sink.AssertCounterEquals(t, "svc.req.c.__env=prod.__region=us", 1)
+	sink.AssertCounterEquals(t, "svc.req.child.c.__env=prod.__region=us", 1)
+	sink.AssertCounterEquals(t, "svc.nodefault.c.__region=us.__version=1", 1)
+	sink.AssertCounterEquals(t, "root.c.__env=prod", 1)
+	sink.AssertCounterEquals(t, "svc.c.__env=prod.__region=us.__version=1", 1)
+
+	// ExcludeTag does not modify the options it is called on
+	opts := ScopeOptions{Tags: map[string]string{"k": "v"}}
+	if opts.ExcludeTag("x"); len(opts.Tags) != 1 {
+		t.Errorf("ExcludeTag modified the tags: %v", opts.Tags)
+	}
+}
+
+func TestOnMetricCreate(t *testing.T) {
+	type created struct {
+		kind, name string
+		tags       map[string]string
+	}
+	var got []created
+	store := NewStoreWithOptions(mock.NewSink(), StoreOptions{
+		OnMetricCreate: func(kind, name string, tags map[string]string) {
+			got = append(got, created{kind, name, tags})
+		},
+	})
+	scope := store.ScopeWithTags("s", map[string]string{"k": "v"})
+	scope.NewCounter("c")
+	scope.NewCounter("c") // already registered
+	store.NewGauge("g")
+	store.NewTimerWithTags("t", map[string]string{"a": "1"})
+	scope.NewHistogram("h")
+	store.NewFloatGauge("f")
+
+	expected := []created{
+		{"counter", "s.c", map[string]string{"k": "v"}},
+		{"gauge", "g", nil},
+		{"timer", "t", map[string]string{"a": "1"}},
+		{"histogram", "s.h", map[string]string{"k": "v"}},
+		{"float_gauge", "f", nil},
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("got: %v want: %v", got, expected)
+	}
+	for i, exp := range expected {
+		g := got[i]
+		if g.kind != exp.kind || g.name != exp.name || len(g.tags) != len(exp.tags) {
+			t.Errorf("%d: got: %v want: %v", i, g, exp)
+			continue
+		}
+		for k, v := range exp.tags {
+			if g.tags[k] != v {
+				t.Errorf("%d: got: %v want: %v", i, g, exp)
+			}
+		}
+	}
+
+	// stats are reported again after they are unregistered
+	store.Unregister("g")
+	store.NewGauge("g")
+	if n := len(got); n != len(expected)+1 || got[n-1].name != "g" {
+		t.Errorf("re-registered gauge was not reported: %v", got)
+	}
+}
+
+func TestDeduplicateFlushes(t *testing.T) {
+	for _, dedup := range []bool{false, true} {
+		sink := &recordingSink{}
+		store := NewStoreWithOptions(sink, StoreOptions{DeduplicateFlushes: dedup})
+		store.NewCounter("latency.count").Add(2)
+		store.NewHistogram("latency").RecordValue(1) // flushes latency.count
+		store.NewGauge("g").Set(1)
+		store.NewMaxGauge("g").UpdateMax(3)
+		store.Flush()
+
+		counts := 0
+		for _, c := range sink.counters {
+			if c.name == "latency.count" {
+				counts++
+			}
+		}
+		var gauges []recordedStat
+		for _, g := range sink.gauges {
+			if g.name == "g" {
+				gauges = append(gauges, g)
+			}
+		}
+		if !dedup {
+			if counts != 2 || len(gauges) != 2 {
+				t.Errorf("without deduplication: got: %d counter and %d gauge flushes want: 2 and 2",
+					counts, len(gauges))
+			}
+			continue
+		}
+		if counts != 1 {
+			t.Errorf("counter flushes: got: %d want: %d", counts, 1)
+		}
+		for _, c := range sink.counters {
+			if c.name == "latency.count" && c.value != 3 {
+				t.Errorf("counter: got: %d want: %d", c.value, 3)
+			}
+		}
+		if len(gauges) != 1 || gauges[0].value != 3 {
+			t.Errorf("gauges: got: %v want: [{g 3}]", gauges)
+		}
+	}
+}
+
+func TestDeduplicateFlushesFloatGauge(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStoreWithOptions(sink, StoreOptions{DeduplicateFlushes: true})
+	store.NewFloatGauge("latency.sum").Set(1)
+	h := store.NewHistogram("latency")
+	h.RecordValue(1.25)
+	h.RecordValue(1.5)
+	store.Flush()
+
+	sink.AssertFloatGaugeCallCount(t, "latency.sum", 1)
+	sink.AssertFloatGaugeEquals(t, "latency.sum", 2.75)
+	sink.AssertGaugeNotExists(t, "latency.sum")
+}
+
+type recordedStat struct {
+	name  string
+	value uint64
+}
+
+type recordingSink struct {
+	counters, gauges []recordedStat
+}
+
+func (s *recordingSink) FlushCounter(name string, value uint64) {
+	s.counters = append(s.counters, recordedStat{name, value})
+}
+
+func (s *recordingSink) FlushGauge(name string, value uint64) {
+	s.gauges = append(s.gauges, recordedStat{name, value})
+}
+
+func (s *recordingSink) FlushTimer(name string, value float64) {}
+
+func TestNormalizeTagValue(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStoreWithOptions(sink, StoreOptions{
+		NormalizeTagValue: func(key, value string) string {
+			if key == "drop" {
+				return ""
+			}
+			return strings.ToLower(value)
+		},
+	})
+	store.NewCounterWithTags("c", map[string]string{"method": "GET"}).Inc()
+	store.NewCounterWithTags("c", map[string]string{"method": "get"}).Inc()
+	store.ScopeWithTags("s", map[string]string{"method": "Get"}).NewCounter("c").Inc()
+	store.NewCounterWithTags("c", map[string]string{"method": "Get", "drop": "x"}).Inc()
+	store.NewHistogramWithTags("h", map[string]string{"method": "GET"}).RecordValue(1)
+	store.NewHistogramWithTags("h", map[string]string{"method": "get"}).RecordValue(1)
+	store.Flush()
+
+	sink.AssertCounterEquals(t, "c.__method=get", 3)
+	sink.AssertCounterEquals(t, "s.c.__method=get", 1)
+	sink.AssertCounterEquals(t, "h.count.__method=get", 2)
+	for _, name := range sink.ListCounters() {
+		if strings.Contains(name, "GET") || strings.Contains(name, "Get") || strings.Contains(name, "drop") {
+			t.Errorf("counter %q was not normalized", name)
+		}
+	}
+}
+
+func TestTagKeyAliases(t *testing.T) {
+	hook := logtest.NewGlobal()
+	defer func() { logger.StandardLogger().Hooks = make(logger.LevelHooks) }()
+
+	sink := mock.NewSink()
+	store := NewStoreWithOptions(sink, StoreOptions{
+		TagKeyAliases: map[string]string{"host": "hostname"},
+	})
+	store.NewCounterWithTags("c", map[string]string{"host": "a"}).Inc()
+	store.NewCounterWithTags("c", map[string]string{"host": "a"}).Inc()
+	store.NewCounterWithTags("c", map[string]string{"hostname": "a"}).Inc()
+	store.NewCounterWithTags("c", map[string]string{"host": "b", "hostname": "a"}).Inc()
+	store.ScopeWithTags("s", map[string]string{"host": "a"}).NewHistogram("h").RecordValue(1)
+	store.Flush()
+
+	sink.AssertCounterEquals(t, "c.__hostname=a", 4)
+	sink.AssertCounterEquals(t, "s.h.count.__hostname=a", 1)
+	for _, name := range sink.ListCounters() {
+		if strings.Contains(name, "__host=") {
+			t.Errorf("counter %q was not renamed", name)
+		}
+	}
+	// one warning per stat name used with the old key
+	var warnings int
+	for _, e := range hook.AllEntries() {
+		if e.Level == logger.WarnLevel && strings.Contains(e.Message, `renamed tag "host" to "hostname"`) {
+			warnings++
+		}
+	}
+	if warnings != 2 {
+		t.Errorf("warnings: got: %d want: %d", warnings, 2)
+	}
+}
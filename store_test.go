@@ -0,0 +1,226 @@
+package stats
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lyft/gostats/mock"
+)
+
+func TestStatStoreConcurrency(t *testing.T) {
+	const (
+		goroutines = 200
+		iterations = 100
+		names      = 10 // names are shared by all goroutines
+		flushEvery = 25
+	)
+	tests := []struct {
+		name     string
+		scope    func(Store) Scope
+		expected func(name string) string // serialized name of counter name
+	}{
+		{
+			name:     "Store",
+			scope:    func(s Store) Scope { return s },
+			expected: func(name string) string { return name },
+		},
+		{
+			name:     "Scope",
+			scope:    func(s Store) Scope { return s.Scope("a").Scope("b") },
+			expected: func(name string) string { return "a.b." + name },
+		},
+		{
+			name: "ScopeWithTags",
+			scope: func(s Store) Scope {
+				return s.ScopeWithTags("a", map[string]string{"k": "v"})
+			},
+			expected: func(name string) string { return "a." + name + ".__k=v" },
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sink := mock.NewSink()
+			store := NewStore(sink, false)
+
+			start := make(chan struct{})
+			var wg sync.WaitGroup
+			for g := 0; g < goroutines; g++ {
+				wg.Add(1)
+				go func(g int) {
+					defer wg.Done()
+					<-start
+					for i := 0; i < iterations; i++ {
+						name := "c" + strconv.Itoa((g+i)%names)
+						// scopes are created on each iteration to contend on
+						// registration as well as on updates
+						test.scope(store).NewCounter(name).Add(1)
+						if i%flushEvery == 0 {
+							store.Flush()
+						}
+					}
+				}(g)
+			}
+			close(start)
+
+			done := make(chan struct{})
+			go func() {
+				wg.Wait()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(30 * time.Second):
+				t.Fatal("timed out: possible deadlock")
+			}
+
+			store.Flush()
+			for i := 0; i < names; i++ {
+				name := test.expected("c" + strconv.Itoa(i))
+				sink.AssertCounterEquals(t, name, goroutines*iterations/names)
+			}
+		})
+	}
+}
+
+func BenchmarkStatStoreConcurrency(b *testing.B) {
+	const names = 100
+	keys := make([]string, names)
+	for i := range keys {
+		keys[i] = "c" + strconv.Itoa(i)
+	}
+	store := NewStore(nullSink{}, false)
+	ticker := time.NewTicker(time.Millisecond) // flush while updating
+	defer ticker.Stop()
+	go store.Start(ticker)
+	defer store.Close()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		scope := store.ScopeWithTags("scope", map[string]string{"k": "v"})
+		n := 0
+		for pb.Next() {
+			scope.NewCounter(keys[n%names]).Add(1)
+			n++
+		}
+	})
+}
+
+// rwMutexRegistry is a registry of Counters guarded by a single RWMutex, it
+// is the baseline of BenchmarkStatStoreRegistry.
+type rwMutexRegistry struct {
+	mu       sync.RWMutex
+	counters map[string]*counter
+}
+
+func (r *rwMutexRegistry) newCounter(name string) *counter {
+	r.mu.RLock()
+	c := r.counters[name]
+	r.mu.RUnlock()
+	if c != nil {
+		return c
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c = r.counters[name]; c == nil {
+		c = new(counter)
+		r.counters[name] = c
+	}
+	return c
+}
+
+// BenchmarkStatStoreRegistry compares the lookup and creation of Counters by
+// the registry of the statStore, which has a sync.Map per stat type, to a
+// map guarded by a single RWMutex.
+func BenchmarkStatStoreRegistry(b *testing.B) {
+	const names = 10000
+	keys := make([]string, names)
+	for i := range keys {
+		keys[i] = "c" + strconv.Itoa(i)
+	}
+	registries := []struct {
+		name       string
+		newCounter func() func(string) *counter
+	}{
+		{"statStore", func() func(string) *counter {
+			s := NewStore(nullSink{}, false).(*statStore)
+			return func(name string) *counter { return s.newCounter(name, name) }
+		}},
+		{"RWMutex", func() func(string) *counter {
+			return (&rwMutexRegistry{counters: make(map[string]*counter)}).newCounter
+		}},
+	}
+	for _, r := range registries {
+		// Existing looks up Counters created before the benchmark, New
+		// creates a Counter per iteration.
+		b.Run(r.name+"/Existing", func(b *testing.B) {
+			newCounter := r.newCounter()
+			for _, k := range keys {
+				newCounter(k)
+			}
+			var goroutine uint64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				n := atomic.AddUint64(&goroutine, 1) * 7919 // spread the goroutines over the keys
+				for pb.Next() {
+					newCounter(keys[n%names]).Inc()
+					n++
+				}
+			})
+		})
+		b.Run(r.name+"/New", func(b *testing.B) {
+			newCounter := r.newCounter()
+			var goroutine uint64
+			b.RunParallel(func(pb *testing.PB) {
+				prefix := "g" + strconv.FormatUint(atomic.AddUint64(&goroutine, 1), 10) + "_"
+				n := 0
+				for pb.Next() {
+					newCounter(prefix + strconv.Itoa(n)).Inc()
+					n++
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkStatStoreHotPath measures the lookup of existing Counters and
+// their updates by many goroutines, run it with -cpu 8 (GOMAXPROCS=8) or more
+// to measure the contention.
+func BenchmarkStatStoreHotPath(b *testing.B) {
+	const names = 1000
+	keys := make([]string, names)
+	for i := range keys {
+		keys[i] = "c" + strconv.Itoa(i)
+	}
+	tags := map[string]string{"k1": "v1", "k2": "v2"}
+	store := NewStore(nullSink{}, false)
+	counters := make([]Counter, names)
+	for i, k := range keys {
+		counters[i] = store.NewCounter(k)
+		store.NewCounterWithTags(k, tags)
+	}
+
+	run := func(b *testing.B, fn func(i int)) {
+		b.SetParallelism(64) // hundreds of goroutines with -cpu 8
+		var goroutine uint64
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			n := int(atomic.AddUint64(&goroutine, 1)) * 7919
+			for pb.Next() {
+				fn(n % names)
+				n++
+			}
+		})
+	}
+	b.Run("NewCounter", func(b *testing.B) {
+		run(b, func(i int) { store.NewCounter(keys[i]).Inc() })
+	})
+	b.Run("NewCounterWithTags", func(b *testing.B) {
+		run(b, func(i int) { store.NewCounterWithTags(keys[i], tags).Inc() })
+	})
+	b.Run("Add", func(b *testing.B) {
+		run(b, func(i int) { counters[i].Add(1) })
+	})
+}
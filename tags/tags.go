@@ -0,0 +1,69 @@
+// Package tags provides a builder for the tag maps accepted by the
+// stats.Scope methods.
+package tags
+
+import (
+	"fmt"
+
+	tagspkg "github.com/lyft/gostats/internal/tags"
+)
+
+// A TagKey is the key of a tag. Declaring keys as TagKey constants keeps
+// them consistent across call sites.
+type TagKey string
+
+// Well-known tag keys.
+const (
+	TagEnvironment TagKey = "env"
+	TagHost        TagKey = "host"
+	TagRegion      TagKey = "region"
+	TagService     TagKey = "service"
+	TagVersion     TagKey = "version"
+)
+
+// validate returns an error if k is empty, contains chars that are not
+// printable, non-whitespace ASCII, or contains chars that are replaced in
+// serialized stat names ([.:|]).
+func (k TagKey) validate() error {
+	s := string(k)
+	if err := tagspkg.ValidateName(s); err != nil {
+		return fmt.Errorf("tags: invalid tag key: %w", err)
+	}
+	if tagspkg.ReplaceChars(s) != s {
+		return fmt.Errorf("tags: invalid tag key: %q contains one of [.:|]", s)
+	}
+	return nil
+}
+
+// A TagBuilder builds a tag map. The zero value is ready to use.
+type TagBuilder struct {
+	tags map[string]string
+}
+
+// New returns a new TagBuilder.
+func New() *TagBuilder {
+	return &TagBuilder{}
+}
+
+// Set sets the value of key and returns the TagBuilder. Set panics if key
+// is not a valid tag key, keys are expected to be constants so this is a
+// programming error.
+func (b *TagBuilder) Set(key TagKey, value string) *TagBuilder {
+	if err := key.validate(); err != nil {
+		panic(err)
+	}
+	if b.tags == nil {
+		b.tags = make(map[string]string)
+	}
+	b.tags[string(key)] = value
+	return b
+}
+
+// Build returns a copy of the tags, so the TagBuilder may be reused.
+func (b *TagBuilder) Build() map[string]string {
+	m := make(map[string]string, len(b.tags))
+	for k, v := range b.tags {
+		m[k] = v
+	}
+	return m
+}
@@ -0,0 +1,43 @@
+package tags
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTagBuilder(t *testing.T) {
+	b := New().
+		Set(TagEnvironment, "prod").
+		Set(TagRegion, "us-east-1").
+		Set(TagRegion, "us-west-2")
+	m := b.Build()
+
+	want := map[string]string{"env": "prod", "region": "us-west-2"}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("got: %v want: %v", m, want)
+	}
+
+	// the returned map is a copy
+	m["env"] = "dev"
+	if v := b.Build()["env"]; v != "prod" {
+		t.Errorf("Build returned the builder's map: got: %q want: %q", v, "prod")
+	}
+
+	var zero TagBuilder
+	if m := zero.Set(TagVersion, "1").Build(); m["version"] != "1" {
+		t.Errorf("zero value: got: %v", m)
+	}
+}
+
+func TestTagBuilderInvalidKey(t *testing.T) {
+	for _, key := range []TagKey{"", "a b", "a.b", "a:b", "a|b", "\n"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Set(%q): expected a panic", key)
+				}
+			}()
+			New().Set(key, "v")
+		}()
+	}
+}
@@ -0,0 +1,117 @@
+package stats
+
+import (
+	"fmt"
+	"strings"
+
+	tagspkg "github.com/lyft/gostats/internal/tags"
+)
+
+// A CounterVec creates the Counters of a stat whose tag keys, the label
+// names, are declared when the CounterVec is created, like the CounterVec
+// of Prometheus:
+//
+//	requests := store.NewCounterVec("requests", []string{"method", "code"})
+//	requests.With("GET", "200").Inc()
+type CounterVec interface {
+	// With returns the Counter with the label values labelValues, in the
+	// order of the label names. It panics if the number of values is not
+	// the number of label names.
+	With(labelValues ...string) Counter
+}
+
+// A GaugeVec is like a CounterVec, but for Gauges.
+type GaugeVec interface {
+	// With returns the Gauge with the label values labelValues, see
+	// CounterVec.With.
+	With(labelValues ...string) Gauge
+}
+
+// A TimerVec is like a CounterVec, but for Timers.
+type TimerVec interface {
+	// With returns the Timer with the label values labelValues, see
+	// CounterVec.With.
+	With(labelValues ...string) Timer
+}
+
+// labelledVec validates the label values of a Vec, its stats are looked up
+// in the Store each time.
+type labelledVec struct {
+	name       string
+	labelNames []string
+	create     func(tags map[string]string) interface{}
+}
+
+// newLabelledVec returns a labelledVec whose stats are created by create, it
+// panics if the label names are not valid and distinct tag keys.
+func newLabelledVec(name string, labelNames []string, create func(tags map[string]string) interface{}) *labelledVec {
+	for i, label := range labelNames {
+		if err := tagspkg.ValidateName(label); err != nil {
+			panic(fmt.Sprintf("gostats: stat %q: invalid label name: %v", name, err))
+		}
+		for _, prev := range labelNames[:i] {
+			if label == prev {
+				panic(fmt.Sprintf("gostats: stat %q: duplicate label name: %q", name, label))
+			}
+		}
+	}
+	return &labelledVec{
+		name:       name,
+		labelNames: append([]string(nil), labelNames...),
+		create:     create,
+	}
+}
+
+func (v *labelledVec) with(labelValues []string) interface{} {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("gostats: stat %q: got %d label values want %d (%s)",
+			v.name, len(labelValues), len(v.labelNames), strings.Join(v.labelNames, ", ")))
+	}
+	tags := make(map[string]string, len(labelValues))
+	for i, label := range v.labelNames {
+		tags[label] = labelValues[i]
+	}
+	return v.create(tags)
+}
+
+type counterVec struct {
+	vec *labelledVec
+}
+
+func newCounterVec(scope Scope, name string, labelNames []string) CounterVec {
+	return counterVec{newLabelledVec(name, labelNames, func(tags map[string]string) interface{} {
+		return scope.NewCounterWithTags(name, tags)
+	})}
+}
+
+func (v counterVec) With(labelValues ...string) Counter {
+	return v.vec.with(labelValues).(Counter)
+}
+
+type gaugeVec struct {
+	vec *labelledVec
+}
+
+func newGaugeVec(scope Scope, name string, labelNames []string) GaugeVec {
+	return gaugeVec{newLabelledVec(name, labelNames, func(tags map[string]string) interface{} {
+		return scope.NewGaugeWithTags(name, tags)
+	})}
+}
+
+func (v gaugeVec) With(labelValues ...string) Gauge {
+	return v.vec.with(labelValues).(Gauge)
+}
+
+type timerVec struct {
+	vec *labelledVec
+}
+
+func newTimerVec(scope Scope, name string, labelNames []string) TimerVec {
+	return timerVec{newLabelledVec(name, labelNames, func(tags map[string]string) interface{} {
+		return scope.NewTimerWithTags(name, tags)
+	})}
+}
+
+func (v timerVec) With(labelValues ...string) Timer {
+	return v.vec.with(labelValues).(Timer)
+}
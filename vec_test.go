@@ -0,0 +1,161 @@
+package stats
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/lyft/gostats/mock"
+)
+
+// expectPanic calls fn and fails the test if it does not panic with a
+// message that starts with prefix.
+func expectPanic(t *testing.T, prefix string, fn func()) {
+	t.Helper()
+	defer func() {
+		t.Helper()
+		e := recover()
+		if e == nil {
+			t.Errorf("expected a panic with prefix %q", prefix)
+		} else if msg, _ := e.(string); !strings.HasPrefix(msg, prefix) {
+			t.Errorf("unexpected panic: %v", e)
+		}
+	}()
+	fn()
+}
+
+func TestCounterVec(t *testing.T) {
+	sink := mock.NewSink()
+	stores := map[string]Store{
+		"statStore":          NewStore(sink, false),
+		"CardinalityLimiter": NewCardinalityLimiter(NewStore(sink, false), 0),
+		"ChildStore":         NewStore(sink, false).NewChildStore("child"),
+		"NullStore":          NullStore{},
+	}
+	for name, store := range stores {
+		sink.Reset()
+		vec := store.NewCounterVec("requests", []string{"method", "code"})
+		vec.With("GET", "200").Inc()
+		vec.With("GET", "200").Add(2)
+		vec.With("POST", "500").Inc()
+		store.Flush()
+
+		prefix := ""
+		if name == "ChildStore" {
+			prefix = "child."
+		}
+		if name != "NullStore" {
+			sink.AssertCounterEquals(t, prefix+"requests.__code=200.__method=GET", 3)
+			sink.AssertCounterEquals(t, prefix+"requests.__code=500.__method=POST", 1)
+		}
+		if c1, c2 := vec.With("GET", "200"), vec.With("GET", "200"); c1 != c2 {
+			t.Errorf("%s: With returned different Counters for the same values", name)
+		}
+
+		expectPanic(t, "gostats: stat \"requests\": got 1 label values want 2", func() {
+			vec.With("GET")
+		})
+		expectPanic(t, "gostats: stat \"requests\": got 3 label values want 2", func() {
+			vec.With("GET", "200", "x")
+		})
+	}
+
+	store := NewStore(sink, false)
+	expectPanic(t, "gostats: stat \"c\": duplicate label name", func() {
+		store.NewCounterVec("c", []string{"a", "a"})
+	})
+	expectPanic(t, "gostats: stat \"c\": invalid label name", func() {
+		store.NewCounterVec("c", []string{"a b"})
+	})
+}
+
+func TestGaugeAndTimerVec(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStore(sink, false)
+
+	gauges := store.NewGaugeVec("conns", []string{"pool"})
+	gauges.With("db").Set(3)
+	gauges.With("cache").Set(1)
+	timers := store.NewTimerVec("latency", []string{"route"})
+	timers.With("/users").AddValue(1.5)
+	store.Flush()
+
+	sink.AssertGaugeEquals(t, "conns.__pool=db", 3)
+	sink.AssertGaugeEquals(t, "conns.__pool=cache", 1)
+	sink.AssertTimerEquals(t, "latency.__route=/users", 1.5)
+
+	expectPanic(t, "gostats: stat \"conns\": got 0 label values want 1", func() {
+		gauges.With()
+	})
+	expectPanic(t, "gostats: stat \"latency\": got 2 label values want 1", func() {
+		timers.With("a", "b")
+	})
+}
+
+func TestVecConcurrentWith(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStore(sink, false)
+	counters := store.NewCounterVec("c", []string{"k"})
+	gauges := store.NewGaugeVec("g", []string{"k"})
+	timers := store.NewTimerVec("t", []string{"k"})
+
+	const goroutines = 16
+	const iterations = 1000
+	values := []string{"a", "b", "c", "d"}
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				v := values[j%len(values)]
+				counters.With(v).Inc()
+				gauges.With(v).Inc()
+				timers.With(v).AddValue(1)
+			}
+		}()
+	}
+	wg.Wait()
+	store.Flush()
+
+	for _, v := range values {
+		const expected = goroutines * iterations / 4
+		sink.AssertCounterEquals(t, "c.__k="+v, expected)
+		sink.AssertGaugeEquals(t, "g.__k="+v, expected)
+		sink.AssertTimerCallCount(t, "t.__k="+v, expected)
+	}
+}
+
+func TestVecUnregister(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStore(sink, false)
+	counters := store.NewCounterVec("c", []string{"k"})
+	gauges := store.NewGaugeVec("g", []string{"k"})
+	timers := store.NewTimerVec("t", []string{"k"})
+	counters.With("a").Inc()
+	gauges.With("a").Set(1)
+	timers.With("a").AddValue(1)
+
+	for _, name := range []string{"c.__k=a", "g.__k=a", "t.__k=a"} {
+		if !store.Unregister(name) {
+			t.Errorf("Unregister(%q) = false", name)
+		}
+	}
+	counters.With("a").Inc()
+	gauges.With("a").Set(2)
+	timers.With("a").AddValue(2)
+	store.Flush()
+
+	sink.AssertCounterEquals(t, "c.__k=a", 1)
+	sink.AssertGaugeEquals(t, "g.__k=a", 2)
+	if exp := []string{"c.__k=a"}; !reflect.DeepEqual(store.ListCounters(), exp) {
+		t.Errorf("ListCounters: got: %q want: %q", store.ListCounters(), exp)
+	}
+	if exp := []string{"g.__k=a"}; !reflect.DeepEqual(store.ListGauges(), exp) {
+		t.Errorf("ListGauges: got: %q want: %q", store.ListGauges(), exp)
+	}
+	if exp := []string{"t.__k=a"}; !reflect.DeepEqual(store.ListTimers(), exp) {
+		t.Errorf("ListTimers: got: %q want: %q", store.ListTimers(), exp)
+	}
+}
@@ -0,0 +1,131 @@
+package stats
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	tagspkg "github.com/lyft/gostats/internal/tags"
+)
+
+// WatchDroppedStatName is the name of the counter that a Store increments
+// each time a MetricEvent is dropped because a Watch channel is full.
+const WatchDroppedStatName = "gostats.watch.dropped"
+
+// watchBufferSize is the capacity of the channels returned by Store.Watch.
+const watchBufferSize = 1024
+
+// MetricType is the type of the stat that produced a MetricEvent.
+type MetricType uint8
+
+const (
+	// MetricTypeCounter is the type of Counters.
+	MetricTypeCounter MetricType = iota
+	// MetricTypeGauge is the type of Gauges, including min, max, rate,
+	// float and up/down gauges.
+	MetricTypeGauge
+	// MetricTypeTimer is the type of Timers.
+	MetricTypeTimer
+	// MetricTypeDistribution is the type of Distributions.
+	MetricTypeDistribution
+)
+
+func (t MetricType) String() string {
+	switch t {
+	case MetricTypeCounter:
+		return "counter"
+	case MetricTypeGauge:
+		return "gauge"
+	case MetricTypeTimer:
+		return "timer"
+	case MetricTypeDistribution:
+		return "distribution"
+	default:
+		return "unknown"
+	}
+}
+
+// A MetricEvent describes a single value written to the Sink of a Store.
+type MetricEvent struct {
+	// Name is the fully qualified name of the stat without its tags.
+	Name string
+	Type MetricType
+	// Value is the value passed to the Sink.
+	Value float64
+	// Tags are the tags of the stat, nil if it has none.
+	Tags map[string]string
+}
+
+// watchers is the set of channels returned by Store.Watch. The zero value
+// has no watchers and is ready to use.
+type watchers struct {
+	n       int32 // number of registered channels, read without the lock
+	mu      sync.RWMutex
+	chans   map[chan MetricEvent]struct{}
+	dropped *counter
+}
+
+func (w *watchers) watch(ctx context.Context, dropped *counter) <-chan MetricEvent {
+	ch := make(chan MetricEvent, watchBufferSize)
+	w.mu.Lock()
+	if w.chans == nil {
+		w.chans = make(map[chan MetricEvent]struct{})
+	}
+	w.chans[ch] = struct{}{}
+	w.dropped = dropped
+	atomic.AddInt32(&w.n, 1)
+	w.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		w.mu.Lock()
+		delete(w.chans, ch)
+		atomic.AddInt32(&w.n, -1)
+		w.mu.Unlock()
+		close(ch)
+	}()
+	return ch
+}
+
+// send sends an event for the stat with the serialized name to all
+// watchers. It never blocks: if a channel is full the event is dropped for
+// that channel.
+func (w *watchers) send(typ MetricType, serializedName string, value float64) {
+	if w == nil || atomic.LoadInt32(&w.n) == 0 {
+		return
+	}
+	name, tags := tagspkg.ParseTags(serializedName)
+	ev := MetricEvent{Name: name, Type: typ, Value: value, Tags: tags}
+
+	w.mu.RLock()
+	for ch := range w.chans {
+		select {
+		case ch <- ev:
+		default:
+			w.dropped.Inc()
+		}
+	}
+	w.mu.RUnlock()
+}
+
+// A watchedSink sends a MetricEvent to the watchers of a Store for each
+// Counter, Gauge and FloatGauge value flushed to its Sink.
+type watchedSink struct {
+	FloatGaugeSink
+	watch *watchers
+}
+
+func (w watchedSink) FlushCounter(name string, value uint64) {
+	w.watch.send(MetricTypeCounter, name, float64(value))
+	w.FloatGaugeSink.FlushCounter(name, value)
+}
+
+func (w watchedSink) FlushGauge(name string, value uint64) {
+	w.watch.send(MetricTypeGauge, name, float64(value))
+	w.FloatGaugeSink.FlushGauge(name, value)
+}
+
+func (w watchedSink) FlushFloatGauge(name string, value float64) {
+	w.watch.send(MetricTypeGauge, name, value)
+	w.FloatGaugeSink.FlushFloatGauge(name, value)
+}
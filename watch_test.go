@@ -0,0 +1,134 @@
+package stats
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/lyft/gostats/mock"
+)
+
+func TestWatch(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStore(sink, false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := store.Watch(ctx)
+
+	store.NewCounterWithTags("c", map[string]string{"k": "v"}).Add(2)
+	store.NewGauge("g").Set(3)
+	store.Flush()
+	store.NewTimer("t").AddValue(4)
+
+	want := []MetricEvent{
+		{Name: "c", Type: MetricTypeCounter, Value: 2, Tags: map[string]string{"k": "v"}},
+		{Name: "g", Type: MetricTypeGauge, Value: 3},
+		{Name: "t", Type: MetricTypeTimer, Value: 4},
+	}
+	var got []MetricEvent
+	for len(got) < len(want) {
+		ev := <-events
+		if ev.Name == WatchDroppedStatName {
+			continue
+		}
+		got = append(got, ev)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %+v; want: %+v", got, want)
+	}
+
+	cancel()
+	for range events {
+	}
+	store.NewCounter("c").Inc()
+	store.Flush() // must not panic
+}
+
+// watchEvents returns the events received by events until it is empty, by
+// serialized name, without the WatchDroppedStatName events.
+func watchEvents(events <-chan MetricEvent) map[string]MetricEvent {
+	got := make(map[string]MetricEvent)
+	for {
+		select {
+		case ev := <-events:
+			if ev.Name != WatchDroppedStatName {
+				got[mock.SerializeTags(ev.Name, ev.Tags)] = ev
+			}
+		default:
+			return got
+		}
+	}
+}
+
+func TestWatchStatTypes(t *testing.T) {
+	for _, dedup := range []bool{false, true} {
+		sink := mock.NewSink()
+		store := NewStoreWithOptions(sink, StoreOptions{DeduplicateFlushes: dedup})
+		ctx, cancel := context.WithCancel(context.Background())
+		events := store.Watch(ctx)
+
+		store.NewHistogramWithOptions("h", nil, HistogramOptions{Buckets: []float64{1}}).RecordValue(0.5)
+		store.NewSummary("s", SummaryOptions{Quantiles: []float64{0.5}}).RecordValue(1.5)
+		store.NewDistribution("d").RecordValue(2.5)
+		store.NewFloatGauge("f").Set(3.5)
+		store.NewUpDownCounter("u").Add(4)
+		store.Flush()
+
+		want := map[string]MetricEvent{
+			"h.bucket.__le=1":    {Type: MetricTypeCounter, Value: 1},
+			"h.bucket.__le=+Inf": {Type: MetricTypeCounter, Value: 1},
+			"h.count":            {Type: MetricTypeCounter, Value: 1},
+			"h.sum":              {Type: MetricTypeGauge, Value: 0.5},
+			"s.__quantile=0_5":   {Type: MetricTypeGauge, Value: 1.5},
+			"s.count":            {Type: MetricTypeCounter, Value: 1},
+			"s.sum":              {Type: MetricTypeGauge, Value: 1.5},
+			"d":                  {Type: MetricTypeDistribution, Value: 2.5},
+			"f":                  {Type: MetricTypeGauge, Value: 3.5},
+			"u":                  {Type: MetricTypeGauge, Value: 4},
+		}
+		got := watchEvents(events)
+		for name, ev := range want {
+			if g, ok := got[name]; !ok || g.Type != ev.Type || g.Value != ev.Value {
+				t.Errorf("dedup=%t: event %q: got: %+v want: %+v", dedup, name, g, ev)
+			}
+		}
+		cancel()
+	}
+}
+
+func TestWatchDropped(t *testing.T) {
+	sink := mock.NewSink()
+	store := NewStore(sink, false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store.Watch(ctx)
+
+	timer := store.NewTimer("t")
+	for i := 0; i < watchBufferSize+3; i++ {
+		timer.AddValue(1)
+	}
+	store.Flush()
+	sink.AssertCounterEquals(t, WatchDroppedStatName, 3)
+	sink.AssertTimerCallCount(t, "t", watchBufferSize+3)
+}
+
+func TestNullStoreWatch(t *testing.T) {
+	if _, ok := <-(NullStore{}).Watch(context.Background()); ok {
+		t.Error("NullStore.Watch: expected a closed channel")
+	}
+}
+
+func TestMetricTypeString(t *testing.T) {
+	for typ, want := range map[MetricType]string{
+		MetricTypeCounter:      "counter",
+		MetricTypeGauge:        "gauge",
+		MetricTypeTimer:        "timer",
+		MetricTypeDistribution: "distribution",
+		MetricType(255):        "unknown",
+	} {
+		if s := typ.String(); s != want {
+			t.Errorf("MetricType(%d).String() = %q; want: %q", typ, s, want)
+		}
+	}
+}
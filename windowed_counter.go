@@ -0,0 +1,97 @@
+package stats
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultCounterWindow is the window of a WindowedCounter whose
+// WindowedCounterOptions.Window is zero.
+const defaultCounterWindow = time.Minute
+
+// WindowedCounterOptions configures a WindowedCounter.
+type WindowedCounterOptions struct {
+	// Window is the length of the sliding window, rounded up to a whole
+	// number of seconds. If zero the window is one minute.
+	Window time.Duration
+}
+
+// A WindowedCounter counts the observations made during a sliding window of
+// time, with a resolution of one second. Unlike a Counter its values can be
+// read to make decisions, for example for admission control. It is flushed
+// as two gauges, the total suffixed with "_total" and the rate suffixed with
+// "_rate".
+type WindowedCounter interface {
+	// Add adds delta observations.
+	Add(delta uint64)
+
+	// Inc adds 1 observation.
+	Inc()
+
+	// Rate returns the number of observations per second in the window.
+	Rate() float64
+
+	// Total returns the number of observations in the window.
+	Total() uint64
+}
+
+type windowBucket struct {
+	sec   int64 // unix second of count
+	count uint64
+}
+
+type windowedCounter struct {
+	rateName  string
+	totalName string
+	now       func() time.Time
+
+	mu      sync.Mutex
+	buckets []windowBucket // indexed by unix second modulo len(buckets)
+}
+
+func newWindowedCounter(rateName, totalName string, opts WindowedCounterOptions) *windowedCounter {
+	window := opts.Window
+	if window <= 0 {
+		window = defaultCounterWindow
+	}
+	n := (window + time.Second - 1) / time.Second
+	return &windowedCounter{
+		rateName:  rateName,
+		totalName: totalName,
+		now:       time.Now,
+		buckets:   make([]windowBucket, n),
+	}
+}
+
+func (c *windowedCounter) Add(delta uint64) {
+	sec := c.now().Unix()
+	c.mu.Lock()
+	b := &c.buckets[uint64(sec)%uint64(len(c.buckets))]
+	if b.sec != sec {
+		b.sec = sec
+		b.count = 0
+	}
+	b.count += delta
+	c.mu.Unlock()
+}
+
+func (c *windowedCounter) Inc() {
+	c.Add(1)
+}
+
+func (c *windowedCounter) Total() uint64 {
+	oldest := c.now().Unix() - int64(len(c.buckets))
+	var total uint64
+	c.mu.Lock()
+	for _, b := range c.buckets {
+		if b.sec > oldest {
+			total += b.count
+		}
+	}
+	c.mu.Unlock()
+	return total
+}
+
+func (c *windowedCounter) Rate() float64 {
+	return float64(c.Total()) / float64(len(c.buckets))
+}
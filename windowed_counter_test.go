@@ -0,0 +1,55 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lyft/gostats/mock"
+)
+
+func TestWindowedCounter(t *testing.T) {
+	sink := mock.NewSink()
+	clock := mock.NewFakeClock(time.Unix(1000, 0))
+	store := NewStoreWithOptions(sink, StoreOptions{Clock: clock})
+	c := store.Scope("s").NewWindowedCounterWithTags("c", map[string]string{"k": "v"},
+		WindowedCounterOptions{Window: 2500 * time.Millisecond}).(*windowedCounter)
+
+	if n := len(c.buckets); n != 3 {
+		t.Fatalf("buckets: got: %d want: %d", n, 3)
+	}
+	c.Add(3)
+	clock.Advance(time.Second)
+	c.Inc()
+	if v := c.Total(); v != 4 {
+		t.Errorf("Total: got: %d want: %d", v, 4)
+	}
+	store.Flush()
+	sink.AssertGaugeEquals(t, "s.c_total.__k=v", 4)
+	sink.AssertFloatGaugeEquals(t, "s.c_rate.__k=v", 4.0/3)
+
+	// the first observations leave the window
+	clock.Advance(2 * time.Second)
+	if v := c.Total(); v != 1 {
+		t.Errorf("Total: got: %d want: %d", v, 1)
+	}
+	// and their bucket is reused
+	c.Add(5)
+	if v := c.Total(); v != 6 {
+		t.Errorf("Total: got: %d want: %d", v, 6)
+	}
+	if v := c.Rate(); v != 2 {
+		t.Errorf("Rate: got: %f want: %f", v, 2.0)
+	}
+
+	clock.Advance(time.Hour)
+	if v := c.Total(); v != 0 {
+		t.Errorf("Total after the window: got: %d want: %d", v, 0)
+	}
+}
+
+func TestWindowedCounterDefaultWindow(t *testing.T) {
+	c := newWindowedCounter("r", "t", WindowedCounterOptions{})
+	if n := len(c.buckets); n != 60 {
+		t.Errorf("buckets: got: %d want: %d", n, 60)
+	}
+}